@@ -1,9 +1,7 @@
 package main
 
 import (
-	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -20,13 +18,9 @@ func main() {
 	path := "cmd/io/memtableflush/sstables"
 
 	for i := 0; i < 64; i++ {
-
-		isFull := mt.Put(fmt.Sprintf("key-%.4d", i), fmt.Sprintf("my-value-%.8d", i))
-		if isFull {
-			WriteSSTable(path, mt)
-		}
-
+		mt.Put(fmt.Sprintf("key-%.4d", i), fmt.Sprintf("my-value-%.8d", i))
 	}
+	WriteSSTable(path, mt)
 
 	ReadSSTable(path)
 
@@ -35,90 +29,40 @@ func main() {
 func WriteSSTable(path string, mt *lsm.Memtable) {
 	log.Println("Writing SSTable...")
 	name := MakeFileNameFromID(1)
-	fd, err := os.Create(filepath.Join(path, name))
-	// fd, err := CreateFileFromID(path, 1)
+	sst, err := lsm.OpenSSTable(filepath.Join(path, name))
 	if err != nil {
-		log.Panicf("error creating file: %s\n", err)
+		log.Panicf("error creating sstable: %s\n", err)
 	}
-	defer func(fd *os.File) {
-		err := fd.Close()
+	defer func(sst *lsm.SSTable) {
+		err := sst.Close()
 		if err != nil {
 			log.Panicf("error closing: %s\n", err)
 		}
-	}(fd)
-	offs, err := mt.Flush(io.NewOffsetWriter(fd, 0))
+	}(sst)
+	err = mt.Flush(sst)
 	if err != nil {
 		log.Panicf("error flushing: %s\n", err)
 	}
-	fmt.Printf("offset is now: %d\n", offs)
+	fmt.Printf("size is now: %d\n", sst.Size())
 }
 
 func ReadSSTable(path string) {
 	log.Println("Reading SSTable...")
 	name := MakeFileNameFromID(1)
-	fd, err := os.Open(filepath.Join(path, name))
-	if err != nil {
-		log.Panicf("error opening file: %s", err)
-	}
-	readSSTable(fd)
-
-}
-
-func readKey(r io.ReaderAt, off int) (key string, ptr uint16, offs int) {
-	buf := make([]byte, 2)
-	n, err := r.ReadAt(buf, off)
+	sst, err := lsm.OpenSSTable(filepath.Join(path, name))
 	if err != nil {
-		log.Panicf("error reading key size: %s", err)
+		log.Panicf("error opening sstable: %s", err)
 	}
-	off += n
-
-	size := binary.BigEndian.Uint16(buf)
-	buf = make([]byte, size)
-
-	n, err = r.ReadAt(buf, off)
+	defer sst.Close()
+	err = sst.Scan(
+		func(e *lsm.Entry) bool {
+			fmt.Printf("%s: %s\n", e.Key, e.Val)
+			return true
+		},
+	)
 	if err != nil {
-		log.Panicf("error reading key: %s", err)
+		log.Panicf("error reading sstable: %s", err)
 	}
-	key = string(buf)
-	off += n
-
-	n, err = r.ReadAt(buf[0:2], off)
-	if err != nil {
-		log.Panicf("error reading key: %s", err)
-	}
-	ptr = binary.BigEndian.Uint16(buf[0:2])
-	off += n
-
-	return key, ptr, off
-}
-
-func readSSTable(at io.ReaderAt) {
-	// decode key
-	var keys []string
-	var values []string
-
-	var offs int
-	szBuf := make([]byte, 2)
-	var size uint16
-	buf := make([]byte, 512)
-
-	// read key
-	key, ptr, off := readKey(at, offs)
-	n, err := at.ReadAt(szBuf, int64(offs))
-	if err != nil {
-		log.Panicf("error reading at offset: %d\n", offs)
-	}
-	offs += n
-	size = binary.BigEndian.Uint16(szBuf)
-
-	// read key
-	if int(size) > len(buf) {
-		// grow slice
-		buf = append(buf, make([]byte, int(size)-len(buf))...)
-	}
-	n, err = at.ReadAt(buf[:size], int64(offs))
-	offs += n
-
 }
 
 const (
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cagnosolutions/go-data/pkg/tree/lsm"
+)
+
+// dump checks and prints out the layout of the sstables in the provided
+// directories, and optionally all the entries in them.
+//
+//	lsm dump [-entries] <table dir> ...
+func dump(args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ExitOnError)
+	entries := fs.Bool("entries", false, "print every entry in the tables")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: lsm dump [-entries] <table dir> ...\n")
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	for _, path := range fs.Args() {
+		err := dumpTable(path, *entries)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// dumpTable prints out a single table
+func dumpTable(path string, entries bool) error {
+	fmt.Printf("table %s:\n", path)
+	info, err := lsm.InspectTable(path)
+	switch {
+	case os.IsNotExist(err):
+		// opening a table that is not there creates it, so
+		// make sure it really is a table in the older format
+		_, err = os.Stat(filepath.Join(path, "index.sst"))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("  format: older (index and data files)\n")
+	case err != nil:
+		return err
+	default:
+		fmt.Printf("  format: block, version %d\n", info.Version)
+		fmt.Printf("  size: %d bytes, %d entries\n", info.Size, info.Entries)
+		fmt.Printf("  filter: offset %d, %d bytes\n", info.FilterOffset, info.FilterSize)
+		fmt.Printf("  index: offset %d, %d bytes\n", info.IndexOffset, info.IndexSize)
+		for i, b := range info.Blocks {
+			fmt.Printf(
+				"  block %d: offset %d, %d bytes, %d entries, %d restarts, keys %q to %q\n",
+				i, b.Offset, b.Size, b.Entries, b.Restarts, b.First, b.Last,
+			)
+		}
+	}
+	if !entries {
+		return nil
+	}
+	sst, err := lsm.OpenSSTable(path)
+	if err != nil {
+		return err
+	}
+	defer sst.Close()
+	return sst.Scan(
+		func(e *lsm.Entry) bool {
+			if e.Tombstone {
+				fmt.Printf("  %q (deleted)\n", e.Key)
+			} else {
+				fmt.Printf("  %q: %q\n", e.Key, e.Val)
+			}
+			return true
+		},
+	)
+}
@@ -8,6 +8,15 @@ import (
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "dump" {
+		err := dump(os.Args[2:])
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// index := ReadIndex("cmd/lsm/data/0004")
 	// for _, i := range index {
 	//	fmt.Printf("%#v\n", i)
@@ -9,6 +9,17 @@ import (
 type Config struct {
 	BasePath   string
 	PageFrames uint16
+	// PageSize is the page size used when the data file is created. If it is
+	// zero, the default page size is used (or, for an existing data file, the
+	// page size it was created with.)
+	PageSize int
+	// DirectIO opens the data file for direct IO, so the pages are only cached
+	// by the buffer pool, and not by the operating system as well.
+	DirectIO bool
+	// Mmap maps the data file into memory, so pages are read and written using
+	// plain copies, which suits read-mostly workloads. Pages are only durable
+	// once they are flushed (or the storage engine is closed.)
+	Mmap bool
 }
 
 var defaultConfig = &Config{
@@ -26,7 +37,13 @@ func OpenStorageEngine(conf *Config) (*StorageEngine, error) {
 	if conf == nil {
 		conf = defaultConfig
 	}
-	store, err := storage.Open(conf.BasePath)
+	store, err := storage.OpenWithConfig(
+		conf.BasePath, &storage.DiskStoreConfig{
+			PageSize: conf.PageSize,
+			DirectIO: conf.DirectIO,
+			Mmap:     conf.Mmap,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -54,7 +71,20 @@ func (s *StorageEngine) Fetch(pid page.PageID) (page.Page, error) {
 
 // Flush flushes a page to disk using the page ID
 func (s *StorageEngine) Flush(pid page.PageID) error {
-	return s.pool.FlushPage(pid)
+	err := s.pool.FlushPage(pid)
+	if err != nil {
+		return err
+	}
+	return s.store.Sync()
+}
+
+// Compact vacuums fragmented pages, merges sparse pages into the page before
+// them, and frees the pages that end up empty. Records that are moved into
+// another page are passed to the moved callback (which may be nil) along with
+// their new record IDs. It returns the number of bytes reclaimed, along with
+// the rest of the stats.
+func (s *StorageEngine) Compact(moved func(from, to *page.RecordID)) (*buffer.CompactStats, error) {
+	return s.pool.Compact(&buffer.CompactOptions{Moved: moved})
 }
 
 func (s *StorageEngine) Close() error {
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cagnosolutions/go-data/pkg/engine/logging"
@@ -20,22 +21,48 @@ type BufferPoolManager struct {
 	store     storage.Storer          // underlying current manager
 	freeList  []FrameID               // list of frames that are free to use
 	pageTable map[page.PageID]FrameID // table of the current page to frame mappings
+	log       *logging.LogManager     // write-ahead log manager (optional)
+	freed     []page.PageID           // pages deleted by the active transaction
+	pageSize  int                     // size of the pages held by the storage layer
 
-	hits   uint64 // number of times a page was found in the buffer pool
-	misses uint64 // number of times a page was not found (had to be paged in)
+	hits      uint64 // number of times a page was found in the buffer pool
+	misses    uint64 // number of times a page was not found (had to be paged in)
+	evictions uint64 // number of times a page was victimized to make room
+	flushes   uint64 // number of dirty pages written out (updated atomically)
+	pinWaits  uint64 // number of times a frame latch had to be waited on (updated atomically)
 }
 
-// New initializes and returns a new buffer cache manager instance.
+// New initializes and returns a new buffer cache manager instance using the
+// clock replacement policy.
 func New(storage storage.Storer, size uint16) (*BufferPoolManager, error) {
-	// Create a new replacer instance
-	replacer := NewClockReplacer(size)
+	return NewWithConfig(
+		&Config{
+			PageCount: size,
+			Replacer:  NewClockReplacer(size),
+			Storer:    storage,
+		},
+	)
+}
+
+// NewWithConfig initializes and returns a new buffer cache manager instance
+// using the provided config. The replacer in the config should be sized to
+// hold the configured page count.
+func NewWithConfig(conf *Config) (*BufferPoolManager, error) {
+	// Check the config
+	err := checkConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+	size := conf.PageCount
 	// Create buffer manager instance
 	bm := &BufferPoolManager{
 		pool:      make([]Frame, size, size),
-		replacer:  replacer,
-		store:     storage,
+		replacer:  conf.Replacer,
+		store:     conf.Storer,
 		freeList:  make([]FrameID, size),
 		pageTable: make(map[page.PageID]FrameID),
+		log:       conf.LogManager,
+		pageSize:  conf.Storer.PageSize(),
 	}
 	// initialize the pool in the buffer manager
 	for i := uint16(0); i < size; i++ {
@@ -52,9 +79,15 @@ func New(storage storage.Storer, size uint16) (*BufferPoolManager, error) {
 	return bm, nil
 }
 
+// PageSize returns the size of the pages held by the storage layer.
+func (bm *BufferPoolManager) PageSize() int {
+	return bm.pageSize
+}
+
 // AllocatePage simply returns the next sequential page id, but it does not
-// initialize, return, or allocate any pages on the disk.
-func (bm *BufferPoolManager) AllocatePage() page.PageID {
+// initialize, return, or allocate any pages on the disk. It returns an error
+// if the storage layer cannot allocate any more pages.
+func (bm *BufferPoolManager) AllocatePage() (page.PageID, error) {
 	// latch
 	bm.latch.Lock()
 	defer bm.latch.Unlock()
@@ -73,36 +106,79 @@ func (bm *BufferPoolManager) NewPage() (page.Page, error) {
 	// our replacement policy is used to locate a victimized one Frame.
 	fid, err := bm.getUsableFrameID()
 	if err != nil {
-		// This can happen when the BufferPoolManager is full, so let's make sure that
-		// it's something like that, and not something more sinister.
-		// if len(bm.freeList) == 0 && bm.replacer.Size() == 0 {
-		// 	return nil
-		// }
-		if err != ErrUsableFrameNotFound {
-			// Something went terribly wrong if this happens.
-			logging.DefaultLogger.Panic("new page: %s", err)
-		}
-		// Nope, it's something more sinister... shoot.
-		// logging.DefaultLogger.Panic("{!!!} %s", err)
+		// This can happen when the BufferPoolManager is full, or when the page
+		// held by the victimized Frame could not be written.
 		return nil, err
 	}
 	// Allocate (get the next sequential PageID) so we can use it to initialize
-	// the next page we will use.
-	pid := bm.store.AllocatePage()
-	// Create a new Frame initialized with our PageID and Page.
-	pf := newFrame(pid, *fid, page.PageSize)
-	pg := page.NewPage(pid, page.P_USED)
+	// the next page we will use. If that fails (the table space is full, for
+	// instance) the Frame goes back on the freeList.
+	pid, err := bm.store.AllocatePage()
+	if err != nil {
+		bm.addFrameID(*fid)
+		return nil, err
+	}
+	// Create a new Frame in the pool initialized with our PageID and Page.
+	bm.pool[*fid] = newFrame(pid, *fid, bm.pageSize)
+	pf := &bm.pool[*fid]
+	pg := page.NewPageSize(pid, page.P_USED, bm.pageSize)
 	copy(pf.Page, pg)
 	// Add an entry to our pageTable
 	bm.pageTable[pid] = *fid
-	// And update the pool
-	bm.pool[*fid] = pf
 	// Finally, return our Page for use
 	return pf.Page, nil
 }
 
 // FetchPage retrieves specific page from the pool, or storage medium by the page ID.
+// The page is pinned, but the frame latch is not taken, so the caller is responsible
+// for making sure the page is not accessed by more than one thread at a time. Use
+// FetchPageRead or FetchPageWrite if the page may be shared.
 func (bm *BufferPoolManager) FetchPage(pid page.PageID) (page.Page, error) {
+	pf, err := bm.fetchFrame(pid)
+	if err != nil {
+		return nil, err
+	}
+	return pf.Page, nil
+}
+
+// FetchPageRead retrieves specific page from the pool, or storage medium by the page
+// ID, and takes the frame latch in shared mode, so any number of readers may use the
+// page at the same time. The page must not be modified, and it must be released by
+// calling UnpinPageRead.
+func (bm *BufferPoolManager) FetchPageRead(pid page.PageID) (page.Page, error) {
+	pf, err := bm.fetchFrame(pid)
+	if err != nil {
+		return nil, err
+	}
+	// The frame is pinned, so it cannot be victimized while we wait on the latch
+	if !pf.latch.TryRLock() {
+		atomic.AddUint64(&bm.pinWaits, 1)
+		pf.latch.RLock()
+	}
+	return pf.Page, nil
+}
+
+// FetchPageWrite retrieves specific page from the pool, or storage medium by the page
+// ID, and takes the frame latch in exclusive mode, so the page may be modified. It
+// must be released by calling UnpinPageWrite.
+func (bm *BufferPoolManager) FetchPageWrite(pid page.PageID) (page.Page, error) {
+	pf, err := bm.fetchFrame(pid)
+	if err != nil {
+		return nil, err
+	}
+	// The frame is pinned, so it cannot be victimized while we wait on the latch
+	if !pf.latch.TryLock() {
+		atomic.AddUint64(&bm.pinWaits, 1)
+		pf.latch.Lock()
+	}
+	return pf.Page, nil
+}
+
+// fetchFrame locates the frame holding the page matching the provided page ID,
+// swapping it in from the storage medium if it is not in the pool, and pins it.
+// The BufferPoolManager latch is only held while the frame is being located, and
+// never while waiting on a frame latch.
+func (bm *BufferPoolManager) fetchFrame(pid page.PageID) (*Frame, error) {
 	// latch
 	bm.latch.Lock()
 	defer bm.latch.Unlock()
@@ -110,13 +186,13 @@ func (bm *BufferPoolManager) FetchPage(pid page.PageID) (page.Page, error) {
 	if fid, found := bm.pageTable[pid]; found {
 		// We located it, so now we access the Frame and ensure that it will
 		// not be a victim candidate by our replacement policy.
-		pf := bm.pool[fid]
-		pf.pinCount++
+		pf := &bm.pool[fid]
+		pf.incrPinCount()
 		bm.replacer.Pin(fid)
 		// We have a page hit, so we can increase our hit counter
 		bm.hits++
-		// And now, we can safely return our Page.
-		return pf.Page, nil
+		// And now, we can safely return our Frame.
+		return pf, nil
 	}
 	// A match was not found in our pageTable, so now we must swap the Page in
 	// from disk. But first, we must get a Frame to hold our Page. We will
@@ -124,36 +200,37 @@ func (bm *BufferPoolManager) FetchPage(pid page.PageID) (page.Page, error) {
 	// return a victimized Frame if we need to.
 	fid, err := bm.getUsableFrameID()
 	if err != nil {
-		// TODO: think about a more graceful way of handling this whole situation
-		// Check the EXACT error
-		if err != ErrUsableFrameNotFound {
-			// Something went terribly wrong if this happens.
-			logging.DefaultLogger.Panic("fetch page: %s", err)
-		}
+		// This can happen when the BufferPoolManager is full, or when the page
+		// held by the victimized Frame could not be written.
 		return nil, err
 	}
-	// Now, we will swap the Page in from the disk using the DiskStore.
-	data := make([]byte, page.PageSize)
-	err = bm.store.ReadPage(pid, data)
+	// Create a new frame in the pool, and swap the Page in from the disk using
+	// the DiskStore, straight into the (aligned) page held by the frame.
+	bm.pool[*fid] = newFrame(pid, *fid, bm.pageSize)
+	pf := &bm.pool[*fid]
+	err = bm.store.ReadPage(pid, pf.Page)
 	if err != nil {
-		// Something went terribly wrong if this happens.
-		logging.DefaultLogger.Panic("%s", err)
+		// The page could not be read (it may be corrupt, or it may not have
+		// been allocated) so the Frame goes back on the freeList, and the
+		// error (a *storage.CorruptPageError, for instance) is returned.
+		bm.addFrameID(*fid)
+		return nil, err
+	}
+	if bm.log != nil {
+		// keep a copy of the page, so we know what to log later on
+		pf.image = make(page.Page, bm.pageSize)
+		copy(pf.image, pf.Page)
 	}
-	// Create a new frame, so we can copy the page data we just swapped
-	// in from off the disk and add the Frame to the pageTable.
-	pf := newFrame(pid, *fid, page.PageSize)
-	copy(pf.Page, data)
 	// Add the entry to our pageTable
 	bm.pageTable[pid] = *fid
-	// And update the pool
-	bm.pool[*fid] = pf
 	// We had to swap a page in, so we can update our page miss counter
 	bm.misses++
-	// Finally, return our Page for use
-	return pf.Page, nil
+	// Finally, return our Frame for use
+	return pf, nil
 }
 
 // UnpinPage allows for manual unpinning of a specific page from the pool by the page ID.
+// It should only be used to release a page fetched using FetchPage or NewPage.
 func (bm *BufferPoolManager) UnpinPage(pid page.PageID, isDirty bool) error {
 	// latch
 	bm.latch.Lock()
@@ -166,25 +243,100 @@ func (bm *BufferPoolManager) UnpinPage(pid page.PageID, isDirty bool) error {
 	}
 	// Otherwise, we located it in the pageTable. Now we access the Frame and
 	// ensure that it can be used as a victim candidate by our replacement policy.
-	pf := bm.pool[fid]
+	pf := &bm.pool[fid]
+	if isDirty && bm.log != nil {
+		// The page has been modified, so we must log the changes before
+		// anything else happens to it.
+		err := bm.logFrame(pf)
+		if err != nil {
+			return err
+		}
+	}
+	bm.unpinFrame(pf, isDirty)
+	return nil
+}
+
+// UnpinPageRead releases the shared frame latch taken by FetchPageRead, and unpins
+// the page, potentially enabling the frame to be reused.
+func (bm *BufferPoolManager) UnpinPageRead(pid page.PageID) error {
+	pf, err := bm.pinnedFrame(pid)
+	if err != nil {
+		return err
+	}
+	// Release the frame latch before we latch the BufferPoolManager again
+	pf.latch.RUnlock()
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	bm.unpinFrame(pf, false)
+	return nil
+}
+
+// UnpinPageWrite releases the exclusive frame latch taken by FetchPageWrite, and
+// unpins the page, potentially enabling the frame to be reused. If the page has been
+// modified, isDirty must be true, in which case the changes are logged (if logging)
+// before the frame latch is released.
+func (bm *BufferPoolManager) UnpinPageWrite(pid page.PageID, isDirty bool) error {
+	pf, err := bm.pinnedFrame(pid)
+	if err != nil {
+		return err
+	}
+	if isDirty && bm.log != nil {
+		// The page has been modified, so we must log the changes while we
+		// still hold the latch, so nobody sees changes that are not logged.
+		err = bm.logFrame(pf)
+		if err != nil {
+			pf.latch.Unlock()
+			return err
+		}
+	}
+	// Release the frame latch before we latch the BufferPoolManager again
+	pf.latch.Unlock()
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	bm.unpinFrame(pf, isDirty)
+	return nil
+}
+
+// pinnedFrame returns the frame holding the page matching the provided page ID. It
+// should only be used for pages the caller has pinned, because a frame that is not
+// pinned can be victimized as soon as the BufferPoolManager latch is released.
+func (bm *BufferPoolManager) pinnedFrame(pid page.PageID) (*Frame, error) {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	// Check to see if the PageID is located in the pageTable.
+	fid, found := bm.pageTable[pid]
+	if !found {
+		// We have not located it, we will return an error.
+		return nil, page.ErrPageNotFound
+	}
+	return &bm.pool[fid], nil
+}
+
+// unpinFrame decrements the pin count on the frame, handing it to our replacement
+// policy if it is no longer pinned, and sets the dirty bit if the page has been
+// modified. The BufferPoolManager latch must be held by the caller.
+func (bm *BufferPoolManager) unpinFrame(pf *Frame, isDirty bool) {
 	pf.decrPinCount()
 	if pf.pinCount <= 0 {
 		// After we decrement the pin count, check to see if it is low enough to
 		// completely unpin it, and if so, unpin it.
-		bm.replacer.Unpin(fid)
+		bm.replacer.Unpin(pf.fid)
 	}
-	// Now, check to see if the dirty bit needs to be set.
-	if pf.isDirty || isDirty {
+	// Now, check to see if the dirty bit needs to be set. If not, we leave
+	// it alone, it will be unset once the page has been flushed.
+	if isDirty {
 		pf.isDirty = true
-		return nil
 	}
-	// If not, we can make sure to unset the dirty bit.
-	pf.isDirty = false
-	return nil
 }
 
-// FlushPage forces a page to be written onto the storage medium, and decrements the
-// pin count on the frame potentially enabling the frame to be reused.
+// FlushPage forces a page to be written onto the storage medium. The pin count is
+// left alone, so pages should be unpinned by the caller as usual. If the page is
+// pinned, it is written while holding the frame latch in exclusive mode, so any thread
+// currently using the page will finish first. It must not be called while holding the
+// frame latch of the page being flushed.
 func (bm *BufferPoolManager) FlushPage(pid page.PageID) error {
 	// latch
 	bm.latch.Lock()
@@ -195,22 +347,64 @@ func (bm *BufferPoolManager) FlushPage(pid page.PageID) error {
 		// We have not located it, we will return an error.
 		return page.ErrPageNotFound
 	}
-	// Otherwise, we located it in the pageTable. Now we access the Frame and
-	// ensure that it can be used as a victim candidate by our replacement policy.
-	pf := bm.pool[fid]
-	pf.decrPinCount()
-	// Now, we can make sure we flush it to the disk using the DiskStore.
-	err := bm.store.WritePage(pf.pid, pf.Page)
+	// Otherwise, we located it in the pageTable. Now we access the Frame.
+	pf := &bm.pool[fid]
+	if pf.pinCount > 0 {
+		// The page is in use, so we take another pin, which keeps the frame from
+		// being victimized, and wait on the frame latch without holding ours. The
+		// dirty bit is unset up front, so a writer unpinning the page while we are
+		// flushing it will set it again.
+		pf.incrPinCount()
+		wasDirty := pf.isDirty
+		pf.isDirty = false
+		bm.latch.Unlock()
+		if !pf.latch.TryLock() {
+			atomic.AddUint64(&bm.pinWaits, 1)
+			pf.latch.Lock()
+		}
+		err := bm.writePage(pf)
+		pf.latch.Unlock()
+		bm.latch.Lock()
+		if err != nil {
+			pf.isDirty = wasDirty
+		} else if wasDirty {
+			atomic.AddUint64(&bm.flushes, 1)
+		}
+		// Drop our pin, and make sure the frame can be used as a victim candidate
+		// by our replacement policy if it was unpinned while we were flushing.
+		pf.decrPinCount()
+		if pf.pinCount <= 0 {
+			bm.replacer.Unpin(fid)
+		}
+		return err
+	}
+	// Nobody is using the page, which means nobody is holding the frame latch.
+	err := bm.writePage(pf)
 	if err != nil {
-		// Something went terribly wrong if this happens.
-		logging.DefaultLogger.Panic("%s", err)
+		return err
 	}
 	// Finally, since we have just flushed the Page to the underlying current, we
 	// can proceed with unsetting the dirty bit.
+	if pf.isDirty {
+		atomic.AddUint64(&bm.flushes, 1)
+	}
 	pf.isDirty = false
 	return nil
 }
 
+// writePage makes sure the log is written ahead of the page held in the frame,
+// and writes the page to the storage medium. The caller must make sure the page
+// is not being modified, and is responsible for unsetting the dirty bit.
+func (bm *BufferPoolManager) writePage(pf *Frame) error {
+	// Make sure the log is written ahead of the page data.
+	err := bm.writeAhead(pf)
+	if err != nil {
+		return err
+	}
+	// Now, we can make sure we flush it to the disk using the DiskStore.
+	return bm.store.WritePage(pf.pid, pf.Page)
+}
+
 // DeletePage removes the page from the buffer pool, and decrements the pin count on the
 // frame potentially enabling the frame to be reused.
 func (bm *BufferPoolManager) DeletePage(pid page.PageID) error {
@@ -226,7 +420,7 @@ func (bm *BufferPoolManager) DeletePage(pid page.PageID) error {
 	// Otherwise, we located it in the pageTable. Now we access the Frame and
 	// check to see if it is currently pinned (indicating it is currently in use
 	// elsewhere) and should therefore not be removed just yet.
-	pf := bm.pool[fid]
+	pf := &bm.pool[fid]
 	if pf.pinCount > 0 {
 		// Page must be in use elsewhere (or has otherwise not been properly
 		// unpinned) so we'll return an error for now.
@@ -238,6 +432,13 @@ func (bm *BufferPoolManager) DeletePage(pid page.PageID) error {
 	// Next, we pin it, so it will not be marked as a potential victim--because we
 	// are in the process of remove it altogether.
 	bm.replacer.Pin(fid)
+	if bm.log != nil {
+		// If we are logging, the page is not deallocated until the transaction
+		// commits, otherwise it could be reused before the deletion is durable.
+		bm.freed = append(bm.freed, pid)
+		bm.addFrameID(fid)
+		return nil
+	}
 	// After it is pinned, we will deallocate the Page on disk (which will make
 	// it free to use again in a pinch.)
 	if err := bm.store.DeallocatePage(pid); err != nil {
@@ -284,16 +485,25 @@ func (bm *BufferPoolManager) getUsableFrameID() (*FrameID, error) {
 	// been marked dirty, otherwise we must flush the contents to disk before reusing
 	// the FrameID; so let us check on that.
 	if !foundInFreeList {
-		cf := bm.pool[*fid]
-		if &cf != nil {
+		bm.evictions++
+		cf := &bm.pool[*fid]
+		if cf != nil {
 			// We've located the correct Frame in the pool.
 			if cf.isDirty {
 				// And it appears that it is in fact holding a dirty Page. We must
-				// flush the dirty Page to disk before recycling this Frame.
-				err := bm.store.WritePage(cf.pid, cf.Page)
+				// flush the dirty Page to disk before recycling this Frame, making
+				// sure the log is written ahead of it.
+				err := bm.writeAhead(cf)
+				if err == nil {
+					err = bm.store.WritePage(cf.pid, cf.Page)
+				}
 				if err != nil {
+					// The Frame still holds the dirty Page, so it has to be
+					// made a victim candidate again.
+					bm.replacer.Unpin(*fid)
 					return nil, err
 				}
+				atomic.AddUint64(&bm.flushes, 1)
 			}
 			// In either case, we will now be able to remove this pageTable mapping
 			// because it is no longer valid, and the caller should be creating a new
@@ -307,13 +517,34 @@ func (bm *BufferPoolManager) getUsableFrameID() (*FrameID, error) {
 
 // FlushAll attempts to flush any dirty page data.
 func (bm *BufferPoolManager) FlushAll() error {
-	// We will range all the entries in the pageTable and call Flush on each one.
+	// We will take a copy of the page IDs in the pageTable, so it can still be
+	// used while we are flushing, and call Flush on each one.
+	bm.latch.Lock()
+	pids := make([]page.PageID, 0, len(bm.pageTable))
 	for pid := range bm.pageTable {
+		pids = append(pids, pid)
+	}
+	bm.latch.Unlock()
+	for _, pid := range pids {
 		err := bm.FlushPage(pid)
 		if err != nil {
+			if err == page.ErrPageNotFound {
+				// The page was removed from the pool in the meantime
+				continue
+			}
 			return err
 		}
 	}
+	// Make sure the pages are durable, in case the storage layer does not
+	// sync them as they are written
+	return bm.syncStore()
+}
+
+// syncStore syncs the storage layer, if it is a storage.Syncer.
+func (bm *BufferPoolManager) syncStore() error {
+	if s, ok := bm.store.(storage.Syncer); ok {
+		return s.Sync()
+	}
 	return nil
 }
 
@@ -321,11 +552,27 @@ func (bm *BufferPoolManager) FlushAll() error {
 // and associated dependencies. Close makes sure to flush any dirty page data
 // before closing everything down.
 func (bm *BufferPoolManager) Close() error {
+	// If we are logging, commit anything that is still outstanding
+	err := bm.Commit()
+	if err != nil {
+		return err
+	}
 	// Make sure all dirty Page data is written
-	err := bm.FlushAll()
+	err = bm.FlushAll()
 	if err != nil {
 		return err
 	}
+	if bm.log != nil {
+		// Everything has been written, so we can checkpoint and close the log
+		err = bm.Checkpoint()
+		if err != nil {
+			return err
+		}
+		err = bm.log.Close()
+		if err != nil {
+			return err
+		}
+	}
 	// close the DiskStore
 	err = bm.store.Close()
 	if err != nil {
@@ -338,12 +585,10 @@ func (bm *BufferPoolManager) monitor() {
 	for {
 		time.Sleep(500 * time.Millisecond)
 		// Check to see if the hit rate is below 80%
-		bm.latch.Lock()
-		hitRate := float64(bm.hits) / float64(bm.misses)
-		bm.latch.Unlock()
+		hitRate := bm.Stats().HitRate()
 		if hitRate < 0.8 {
 			// We should consider increasing the pool size
-			log.Printf("page cache: hit rate is at %f%%, consider increasing pool size.\n", hitRate)
+			log.Printf("page cache: hit rate is at %.2f%%, consider increasing pool size.\n", hitRate*100)
 		}
 	}
 }
@@ -1,8 +1,10 @@
 package buffer
 
 import (
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
@@ -257,4 +259,360 @@ func TestPageCache_HitRate(t *testing.T) {
 		t.Error(err)
 	}
 
+	// Compare the replacement policies using a scan heavy workload and a point
+	// heavy workload. Both workloads share a small set of hot pages, and the
+	// rest of the pages are cold.
+	const numPages, numHot = 256, 8
+	policies := []struct {
+		name string
+		new  func(size uint16) Replacer
+	}{
+		{"clock", func(size uint16) Replacer { return NewClockReplacer(size) }},
+		{"lru-k", func(size uint16) Replacer { return NewLRUKReplacer(size, 2) }},
+		{"2q", func(size uint16) Replacer { return NewTwoQueueReplacer(size) }},
+	}
+	workloads := []struct {
+		name string
+		run  func(fetch func(pid page.PageID))
+	}{
+		{
+			"scan-heavy", func(fetch func(pid page.PageID)) {
+				// every round does a few lookups on the hot pages and
+				// then scans a long run of cold pages
+				for round := 0; round < 16; round++ {
+					for i := 0; i < 4; i++ {
+						for pid := page.PageID(0); pid < numHot; pid++ {
+							fetch(pid)
+						}
+					}
+					for pid := page.PageID(numHot); pid < numPages; pid++ {
+						fetch(pid)
+					}
+				}
+			},
+		},
+		{
+			"point-heavy", func(fetch func(pid page.PageID)) {
+				// nine out of ten lookups hit the hot pages, and the
+				// rest are spread over all the cold pages
+				rng := rand.New(rand.NewSource(42))
+				for i := 0; i < 4096; i++ {
+					if rng.Intn(10) < 9 {
+						fetch(page.PageID(rng.Intn(numHot)))
+						continue
+					}
+					fetch(page.PageID(numHot + rng.Intn(numPages-numHot)))
+				}
+			},
+		},
+	}
+	for _, w := range workloads {
+		rates := make(map[string]float64)
+		for _, p := range policies {
+			rate := replacerHitRate(t, p.new(pageCount), pageCount, numPages, w.run)
+			log.Printf("%s workload: %s replacer hit rate is %.2f%%\n", w.name, p.name, rate*100)
+			rates[p.name] = rate
+		}
+		if w.name == "scan-heavy" {
+			// Scans should not push the hot pages out of the lru-k and 2q
+			// replacers, but they will push them out of the clock replacer.
+			if rates["lru-k"] <= rates["clock"] || rates["2q"] <= rates["clock"] {
+				t.Errorf("%s workload: expected lru-k and 2q to beat clock: %v", w.name, rates)
+			}
+		}
+	}
+}
+
+// replacerHitRate creates a buffer pool using the provided replacer, writes
+// the requested number of pages, and then runs the workload against the pool.
+// It returns the hit rate of the pool while running the workload.
+func replacerHitRate(
+	t *testing.T, r Replacer, pageCount uint16, numPages int, workload func(fetch func(pid page.PageID)),
+) float64 {
+	testDir := "testing"
+	testFile := "page_cache_hit_rate_test.txt"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	pc, err := NewWithConfig(
+		&Config{
+			PageCount: pageCount,
+			Replacer:  r,
+			Storer:    ds,
+		},
+	)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+	for i := 0; i < numPages; i++ {
+		p, err := pc.NewPage()
+		if err != nil {
+			t.Fatalf("new page (page%d): %s", i, err)
+		}
+		err = pc.UnpinPage(p.GetPageID(), true)
+		if err != nil {
+			t.Fatalf("unpinning page %d failed: %s", p.GetPageID(), err)
+		}
+	}
+	err = pc.FlushAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.hits, pc.misses = 0, 0
+	workload(
+		func(pid page.PageID) {
+			p, err := pc.FetchPage(pid)
+			if err != nil || p == nil {
+				t.Fatalf("fetch page (page%d): %s", pid, err)
+			}
+			err = pc.UnpinPage(pid, false)
+			if err != nil {
+				t.Fatalf("unpinning page %d failed: %s", pid, err)
+			}
+		},
+	)
+	rate := float64(pc.hits) / float64(pc.hits+pc.misses)
+	err = pc.Close()
+	if err != nil {
+		t.Error(err)
+	}
+	return rate
+}
+
+func TestPageCache_PageSize(t *testing.T) {
+	testDir := "testing"
+	defer os.RemoveAll(testDir)
+
+	for _, size := range []int{page.MinPageSize, page.MaxPageSize} {
+		path := filepath.Join(testDir, fmt.Sprintf("pagesize-%d.db", size))
+		ds, err := storage.OpenWithPageSize(path, size)
+		if err != nil {
+			t.Fatalf("[size=%d] opening disk store: %s", size, err)
+		}
+		// only a few frames, so pages have to be evicted and read back in
+		bm, err := New(ds, 4)
+		if err != nil {
+			t.Fatalf("[size=%d] opening buffer manager: %s", size, err)
+		}
+		if bm.PageSize() != size {
+			t.Errorf("[size=%d] expected page size %d, got %d", size, size, bm.PageSize())
+		}
+		// the value spans a few overflow pages
+		val := make([]byte, 3*size)
+		for i := range val {
+			val[i] = byte(i)
+		}
+		ids := make(map[page.PageID]*page.RecordID)
+		var ovf *page.RecordID
+		for i := 0; i < 8; i++ {
+			pg, err := bm.NewPage()
+			if err != nil {
+				t.Fatalf("[size=%d] new page: %s", size, err)
+			}
+			if len(pg) != size {
+				t.Errorf("[size=%d] new page: got a page of %d bytes", size, len(pg))
+			}
+			pid := pg.GetPageID()
+			rec, err := bm.NewRecord(page.R_NUM, page.R_STR, []byte{byte(i)}, []byte(fmt.Sprintf("page #%d", pid)))
+			if err == nil {
+				ids[pid], err = pg.AddRecord(rec)
+			}
+			if err != nil {
+				t.Fatalf("[size=%d] add record: %s", size, err)
+			}
+			if i == 0 {
+				rec, err = bm.NewRecord(page.R_NUM, page.R_STR, []byte{0xff}, val)
+				if err == nil {
+					ovf, err = pg.AddRecord(rec)
+				}
+				if err != nil {
+					t.Fatalf("[size=%d] add record: %s", size, err)
+				}
+			}
+			err = bm.UnpinPage(pid, true)
+			if err != nil {
+				t.Fatalf("[size=%d] unpin page: %s", size, err)
+			}
+		}
+		err = bm.Close()
+		if err != nil {
+			t.Fatalf("[size=%d] close: %s", size, err)
+		}
+
+		// reopen without asking for a page size, it should be picked up
+		ds, err = storage.Open(path)
+		if err != nil {
+			t.Fatalf("[size=%d] reopening disk store: %s", size, err)
+		}
+		bm, err = New(ds, 4)
+		if err != nil {
+			t.Fatalf("[size=%d] reopening buffer manager: %s", size, err)
+		}
+		if bm.PageSize() != size {
+			t.Errorf("[size=%d] expected page size %d, got %d", size, size, bm.PageSize())
+		}
+		for pid, rid := range ids {
+			rec, err := bm.GetRecord(rid)
+			if err != nil {
+				t.Fatalf("[size=%d] get record: %s", size, err)
+			}
+			if want := fmt.Sprintf("page #%d", pid); string(rec.Val()) != want {
+				t.Errorf("[size=%d] get record: got %q, expected %q", size, rec.Val(), want)
+			}
+		}
+		rec, err := bm.GetRecord(ovf)
+		if err != nil {
+			t.Fatalf("[size=%d] get record: %s", size, err)
+		}
+		if string(rec.Val()) != string(val) {
+			t.Errorf("[size=%d] get record: overflow value does not match", size)
+		}
+		err = bm.Close()
+		if err != nil {
+			t.Fatalf("[size=%d] close: %s", size, err)
+		}
+	}
+}
+
+func TestPageCache_AllocateError(t *testing.T) {
+	testDir := t.TempDir()
+	path := filepath.Join(testDir, "full.db")
+
+	// every segment holds 4 pages, and the second segment cannot be created
+	ts, err := storage.OpenTableSpace(path, &storage.TableSpaceConfig{
+		MaxFileSize: 5 * page.MinPageSize,
+		PageSize:    page.MinPageSize,
+	})
+	if err != nil {
+		t.Fatalf("opening table space: %s", err)
+	}
+	err = os.Mkdir(path+".0001", 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %s", err)
+	}
+	bm, err := New(ts, 8)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+	for i := 0; i < 4; i++ {
+		pg, err := bm.NewPage()
+		if err != nil {
+			t.Fatalf("new page: %s", err)
+		}
+		err = bm.UnpinPage(pg.GetPageID(), true)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+	// the error should make it back to us, rather than a bad page ID
+	for i := 0; i < 8; i++ {
+		_, err = bm.NewPage()
+		if err == nil {
+			t.Fatalf("new page: expected an error allocating past the first segment")
+		}
+	}
+	// and once the segment can be created, the frames are still usable
+	err = os.Remove(path + ".0001")
+	if err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	pg, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	if pid := pg.GetPageID(); pid != 4 {
+		t.Errorf("expected page 4, got %d", pid)
+	}
+	err = bm.UnpinPage(pg.GetPageID(), true)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
+
+func TestPageCache_ReadError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "corrupt.db")
+
+	ds, err := storage.Open(path)
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	bm, err := New(ds, 2)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		pg, err := bm.NewPage()
+		if err != nil {
+			t.Fatalf("new page: %s", err)
+		}
+		err = bm.UnpinPage(pg.GetPageID(), true)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// corrupt page 1 (the file header takes up the first page)
+	fp, err := os.OpenFile(path, os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	_, err = fp.WriteAt([]byte("torn"), 2*page.PageSize+100)
+	if err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	err = fp.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	ds, err = storage.Open(path)
+	if err != nil {
+		t.Fatalf("reopening disk store: %s", err)
+	}
+	bm, err = New(ds, 2)
+	if err != nil {
+		t.Fatalf("reopening buffer manager: %s", err)
+	}
+	// the errors should make it back to us, without using up any frames
+	for i := 0; i < 4; i++ {
+		_, err = bm.FetchPage(1)
+		var cpe *storage.CorruptPageError
+		if !errors.As(err, &cpe) || cpe.PageID != 1 {
+			t.Errorf("fetch page: expected a corrupt page error for page 1, got %v", err)
+		}
+		_, err = bm.FetchPage(500)
+		if err == nil {
+			t.Errorf("fetch page: expected an error for a page that was never allocated")
+		}
+	}
+	// so both of the frames can still be pinned at the same time
+	_, err = bm.FetchPage(0)
+	if err != nil {
+		t.Fatalf("fetch page: %s", err)
+	}
+	pg, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	for _, pid := range []page.PageID{0, pg.GetPageID()} {
+		err = bm.UnpinPage(pid, false)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
 }
@@ -0,0 +1,177 @@
+package buffer
+
+import (
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+// CompactOptions holds the settings used when compacting the pages.
+type CompactOptions struct {
+	// MergeThreshold is the number of bytes a page may use for its records and
+	// still be considered sparse. Sparse pages are merged into the previous
+	// page if it has room. If it is zero, a quarter of a page is used, and if
+	// it is negative, pages are never merged.
+	MergeThreshold int
+	// Keep, if set, is called for every page that could be merged away or
+	// freed, and should return true if the page must be kept around (for
+	// example, because it is the page currently being inserted into.)
+	Keep func(pid page.PageID) bool
+	// Moved, if set, is called for every record that is moved into another
+	// page during a merge, with the old and new record IDs.
+	Moved func(from, to *page.RecordID)
+}
+
+// CompactStats holds the results of a compaction.
+type CompactStats struct {
+	PagesScanned   int   `json:"pages_scanned"`   // number of pages looked at
+	PagesVacuumed  int   `json:"pages_vacuumed"`  // number of fragmented pages that were vacuumed
+	PagesMerged    int   `json:"pages_merged"`    // number of sparse pages merged into the previous page
+	PagesFreed     int   `json:"pages_freed"`     // number of empty pages that were freed
+	BytesReclaimed int64 `json:"bytes_reclaimed"` // bytes of free space won back within pages and by freeing pages
+}
+
+// Compact walks every page in the storage layer, vacuuming fragmented pages,
+// merging sparse pages into the page before them, and freeing pages that end
+// up empty. Only plain data pages (ones that are not part of an index, or of a
+// chain of overflow pages) are touched. Each page is latched exclusively while
+// it is being worked on, so readers can keep using the rest of the pages while
+// the compaction is running. Records that are moved during a merge get a new
+// record ID, which is passed to the Moved callback.
+//
+// If the BufferPoolManager is logging, the freed pages are not released until
+// the active transaction is committed.
+func (bm *BufferPoolManager) Compact(opts *CompactOptions) (*CompactStats, error) {
+	if opts == nil {
+		opts = new(CompactOptions)
+	}
+	threshold := opts.MergeThreshold
+	if threshold == 0 {
+		threshold = bm.pageSize / 4
+	}
+	keep := func(pid page.PageID) bool {
+		return opts.Keep != nil && opts.Keep(pid)
+	}
+	stats := new(CompactStats)
+	var prev page.PageID
+	var hasPrev bool
+	count := bm.store.PageCount()
+	for pid := page.PageID(0); pid < count; pid++ {
+		if bm.isFreed(pid) {
+			// deleted by the active transaction, so it is as good as free
+			continue
+		}
+		pg, err := bm.FetchPageWrite(pid)
+		if err != nil {
+			return stats, err
+		}
+		stats.PagesScanned++
+		if !isDataPage(pg) {
+			// free pages, overflow pages and index pages are left alone
+			err = bm.UnpinPageWrite(pid, false)
+			if err != nil {
+				return stats, err
+			}
+			continue
+		}
+		// First, vacuum the page if it is fragmented
+		var dirty bool
+		reclaimed := pg.FragmentedSpace()
+		if reclaimed > 0 {
+			pg.Vacuum()
+			stats.PagesVacuumed++
+			dirty = true
+		}
+		// Then, see if it is sparse enough to be merged into the previous page
+		if hasPrev && pg.Len() > 0 && pg.UsedSpace() < threshold && !keep(pid) {
+			merged, err := bm.mergePage(prev, pg, opts.Moved)
+			if err != nil {
+				_ = bm.UnpinPageWrite(pid, true)
+				return stats, err
+			}
+			if merged {
+				stats.PagesMerged++
+				dirty = true
+			}
+		}
+		empty := pg.Len() == 0
+		err = bm.UnpinPageWrite(pid, dirty)
+		if err != nil {
+			return stats, err
+		}
+		// Finally, free the page if there is nothing left in it
+		if empty && !keep(pid) {
+			err = bm.DeletePage(pid)
+			if err == nil {
+				stats.PagesFreed++
+				stats.BytesReclaimed += int64(bm.pageSize)
+				continue
+			}
+			if err != page.ErrPageInUse {
+				return stats, err
+			}
+			// Somebody is reading it, so we will leave it for next time
+		}
+		stats.BytesReclaimed += int64(reclaimed)
+		prev, hasPrev = pid, true
+	}
+	return stats, nil
+}
+
+// isFreed returns a boolean indicating true if the page has been deleted by the
+// active transaction, but not released yet.
+func (bm *BufferPoolManager) isFreed(pid page.PageID) bool {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	for _, fpid := range bm.freed {
+		if fpid == pid {
+			return true
+		}
+	}
+	return false
+}
+
+// isDataPage returns a boolean indicating true if the page is a plain data page.
+func isDataPage(pg page.Page) bool {
+	return pg.GetFlags() == page.P_USED && pg.GetPrev() == 0 && pg.GetNext() == 0
+}
+
+// mergePage moves all the records in the provided page into the page matching
+// the provided page ID, as long as they all fit. It returns a boolean indicating
+// true if the records were moved. The provided page must be latched exclusively
+// by the caller.
+func (bm *BufferPoolManager) mergePage(dst page.PageID, src page.Page, moved func(from, to *page.RecordID)) (bool, error) {
+	dp, err := bm.FetchPageWrite(dst)
+	if err != nil {
+		return false, err
+	}
+	if !isDataPage(dp) || dp.FreeSpace() < src.UsedSpace() {
+		// It does not fit (or something else happened to the page)
+		return false, bm.UnpinPageWrite(dst, false)
+	}
+	// Make a list of the records first, because they move around as they are
+	// being removed.
+	var ids []*page.RecordID
+	var recs []page.Record
+	for pos := 0; pos < src.Len(); pos++ {
+		id, rec, err := src.GetRecordAt(pos)
+		if err != nil {
+			_ = bm.UnpinPageWrite(dst, false)
+			return false, err
+		}
+		ids, recs = append(ids, id), append(recs, rec)
+	}
+	for i := range recs {
+		to, err := dp.AddRecord(recs[i])
+		if err == nil {
+			err = src.DelRecord(ids[i])
+		}
+		if err != nil {
+			_ = bm.UnpinPageWrite(dst, true)
+			return false, err
+		}
+		if moved != nil {
+			moved(ids[i], to)
+		}
+	}
+	return true, bm.UnpinPageWrite(dst, true)
+}
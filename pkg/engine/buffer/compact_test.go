@@ -0,0 +1,153 @@
+package buffer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+)
+
+func TestBufferPoolManager_Compact(t *testing.T) {
+	testDir := "testing"
+	testFile := "compact_test.db"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	bm, err := New(ds, 16)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+
+	// fill up a few pages, and then delete all but a couple of the records in
+	// each one of them
+	const pages, perPage, kept = 6, 40, 2
+	vals := make(map[page.RecordID][]byte)
+	for i := 0; i < pages; i++ {
+		pg, err := bm.NewPage()
+		if err != nil {
+			t.Fatalf("new page: %s", err)
+		}
+		pid := pg.GetPageID()
+		var rids []*page.RecordID
+		for j := 0; j < perPage; j++ {
+			key := []byte(fmt.Sprintf("key-%d-%d", i, j))
+			val := bytes.Repeat([]byte{byte(j)}, 200)
+			rid, err := pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, key, val))
+			if err != nil {
+				t.Fatalf("add record: %s", err)
+			}
+			rids = append(rids, rid)
+			vals[*rid] = val
+		}
+		for _, rid := range rids[kept:] {
+			err = pg.DelRecord(rid)
+			if err != nil {
+				t.Fatalf("del record: %s", err)
+			}
+			delete(vals, *rid)
+		}
+		err = bm.UnpinPage(pid, true)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+
+	// keep reading the records on the first page (which stays put) while the
+	// pages are being compacted
+	var first []page.RecordID
+	for rid := range vals {
+		if rid.PageID == 0 {
+			first = append(first, rid)
+		}
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				for i := range first {
+					rec, err := bm.GetRecord(&first[i])
+					if err != nil || !bytes.Equal(rec.Val(), vals[first[i]]) {
+						t.Errorf("get record %v while compacting: %v", first[i], err)
+						return
+					}
+				}
+			}
+		}()
+	}
+	moved := make(map[page.RecordID]page.RecordID)
+	stats, err := bm.Compact(
+		&CompactOptions{
+			Moved: func(from, to *page.RecordID) {
+				moved[*from] = *to
+			},
+		},
+	)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		t.Fatalf("compact: %s", err)
+	}
+
+	// every page was fragmented, and all but the first one were merged into it
+	if stats.PagesScanned != pages {
+		t.Errorf("pages scanned: expected %d, got %d", pages, stats.PagesScanned)
+	}
+	if stats.PagesVacuumed != pages {
+		t.Errorf("pages vacuumed: expected %d, got %d", pages, stats.PagesVacuumed)
+	}
+	if stats.PagesMerged != pages-1 || stats.PagesFreed != pages-1 {
+		t.Errorf("pages merged and freed: expected %d, got %d and %d", pages-1, stats.PagesMerged, stats.PagesFreed)
+	}
+	if stats.BytesReclaimed < int64((pages-1)*page.PageSize) {
+		t.Errorf("bytes reclaimed: expected at least %d, got %d", (pages-1)*page.PageSize, stats.BytesReclaimed)
+	}
+	if len(moved) != (pages-1)*kept {
+		t.Errorf("moved: expected %d records, got %d", (pages-1)*kept, len(moved))
+	}
+
+	// the records should all be there, the moved ones using their new ids
+	for rid, val := range vals {
+		if to, found := moved[rid]; found {
+			if to.PageID != 0 {
+				t.Errorf("record %v was moved to page %d, expected page 0", rid, to.PageID)
+			}
+			rid = to
+		}
+		rec, err := bm.GetRecord(&rid)
+		if err != nil {
+			t.Fatalf("get record %v: %s", rid, err)
+		}
+		if !bytes.Equal(rec.Val(), val) {
+			t.Errorf("get record %v: value does not match", rid)
+		}
+	}
+
+	// a second pass has nothing left to do
+	stats, err = bm.Compact(nil)
+	if err != nil {
+		t.Fatalf("compact: %s", err)
+	}
+	if stats.PagesVacuumed != 0 || stats.PagesMerged != 0 || stats.PagesFreed != 0 || stats.BytesReclaimed != 0 {
+		t.Errorf("second compact: expected no changes, got %+v", stats)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
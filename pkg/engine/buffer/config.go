@@ -3,6 +3,7 @@ package buffer
 import (
 	"errors"
 
+	"github.com/cagnosolutions/go-data/pkg/engine/logging"
 	"github.com/cagnosolutions/go-data/pkg/engine/storage"
 )
 
@@ -15,6 +16,9 @@ type Config struct {
 	PageCount uint16
 	Replacer
 	storage.Storer
+	// LogManager is optional. If it is provided, every page mutation is
+	// logged before the page is written to the storage layer.
+	*logging.LogManager
 }
 
 func checkConfig(conf *Config) error {
@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/file/dio"
 )
 
 var (
@@ -17,13 +19,17 @@ type FrameID = uint32
 
 // Frame is a page frame that is used by the BufferPoolManager to
 // hold and cache a page that may be written to disk, or that has
-// been read from disk.
+// been read from disk. The pin count and the dirty bit are guarded
+// by the BufferPoolManager latch, while the page data is guarded by
+// the frame latch.
 type Frame struct {
-	pid       page.PageID // id of this page
-	fid       FrameID     // id or index of this Frame
-	pinCount  uint32      // how many threads are mutating the Frame
-	isDirty   bool        // page data has been modified and not flushed
-	page.Page             // actual page data
+	latch     sync.RWMutex // guards the page data (shared for readers, exclusive for writers)
+	pid       page.PageID  // id of this page
+	fid       FrameID      // id or index of this Frame
+	pinCount  uint32       // how many threads are using the Frame
+	isDirty   bool         // page data has been modified and not flushed
+	image     page.Page    // copy of the page data as of the last log record (logging only)
+	page.Page              // actual page data
 }
 
 func (f *Frame) MarshalJSON() ([]byte, error) {
@@ -45,17 +51,23 @@ func (f *Frame) MarshalJSON() ([]byte, error) {
 }
 
 // newFrame takes a page id, a frame id along with a page size
-// and allocates and returns a new Frame instance.
-func newFrame(pid page.PageID, fid FrameID, pageSize uint16) Frame {
+// and allocates and returns a new Frame instance. The page is
+// aligned in memory, so it can be used for direct IO.
+func newFrame(pid page.PageID, fid FrameID, pageSize int) Frame {
 	return Frame{
 		pid:      pid,
 		fid:      fid,
 		pinCount: 1,
 		isDirty:  false,
-		Page:     make([]byte, pageSize),
+		Page:     dio.AlignedBlock(pageSize),
 	}
 }
 
+// incrPinCount increments the pin count on the frame by one.
+func (f *Frame) incrPinCount() {
+	f.pinCount++
+}
+
 // decrPinCount decrements the pin count on the frame by one. If the
 // pin count is at zero, it should not decrement lower than zero. The
 // pin count represents the number of processes that may currently be
@@ -66,17 +78,19 @@ func (f *Frame) decrPinCount() {
 	}
 }
 
-// resetFrame resets all the values of this frame to their zero values.
+// resetFrame resets all the values of this frame to their zero values. The
+// frame latch is left alone, it is never held when a frame is reset.
 func (f *Frame) resetFrame() {
 	f.pid = page.PageID(0)
 	f.fid = FrameID(0)
 	f.pinCount = 0
 	f.isDirty = false
+	f.image = nil
 	f.Page = nil
 }
 
 // String implements the Stringer interface on the frame.
-func (f Frame) String() string {
+func (f *Frame) String() string {
 	return fmt.Sprintf(
 		"{ pid: %d, fid: %d, pinCount: %d, dirty: %v, page: %v }",
 		f.pid, f.fid, f.pinCount, f.isDirty, f.Page.Size(),
@@ -15,7 +15,6 @@ func TestNewFrame(t *testing.T) {
 	if f.fid == 0 || f.pid == 0 || f.Page == nil {
 		t.Errorf("new frame: frame should not be nil")
 	}
-	_ = f
 }
 
 func TestFrame_DecrPinCount(t *testing.T) {
@@ -33,7 +32,6 @@ func TestFrame_DecrPinCount(t *testing.T) {
 	if f.pinCount != 0 {
 		t.Errorf("decr pin count: bad pin count, should be 0, got %d", f.pinCount)
 	}
-	_ = f
 }
 
 func TestFrame_Reset(t *testing.T) {
@@ -45,5 +43,4 @@ func TestFrame_Reset(t *testing.T) {
 	if f.fid > 0 || f.pid > 0 || f.Page != nil {
 		t.Errorf("reset: frame should be nil")
 	}
-	_ = f
 }
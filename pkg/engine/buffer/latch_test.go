@@ -0,0 +1,134 @@
+package buffer
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+)
+
+func TestBufferPoolManager_ConcurrentLatching(t *testing.T) {
+	testDir := "testing"
+	testFile := "latch_test.db"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	bm, err := New(ds, 16)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+
+	// create a few pages, each one holding a single counter record
+	const pages, workers, rounds = 4, 8, 200
+	var pids []page.PageID
+	var rids []*page.RecordID
+	for i := 0; i < pages; i++ {
+		pg, err := bm.NewPage()
+		if err != nil {
+			t.Fatalf("new page: %s", err)
+		}
+		rid, err := pg.AddRecord(page.NewRecord(page.R_NUM, page.R_NUM, []byte{byte(i)}, make([]byte, 8)))
+		if err != nil {
+			t.Fatalf("add record: %s", err)
+		}
+		err = bm.UnpinPage(pg.GetPageID(), true)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+		pids = append(pids, pg.GetPageID())
+		rids = append(rids, rid)
+	}
+
+	// increment the counters from a bunch of writers, while a bunch of readers
+	// make sure they never see a torn counter, and the pages get flushed
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				n := (w + i) % pages
+				pg, err := bm.FetchPageWrite(pids[n])
+				if err != nil {
+					t.Errorf("fetch page write: %s", err)
+					return
+				}
+				// the record is rewritten in place, so the page stays the same size
+				rec, err := pg.GetRecord(rids[n])
+				if err == nil {
+					cnt := binary.LittleEndian.Uint64(rec.Val()) + 1
+					err = pg.DelRecord(rids[n])
+					if err == nil {
+						binary.LittleEndian.PutUint64(rec.Val(), cnt)
+						rids[n], err = pg.AddRecord(rec)
+					}
+				}
+				uerr := bm.UnpinPageWrite(pids[n], true)
+				if err != nil || uerr != nil {
+					t.Errorf("update counter: %v, %v", err, uerr)
+					return
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				n := (w + i) % pages
+				pg, err := bm.FetchPageRead(pids[n])
+				if err != nil {
+					t.Errorf("fetch page read: %s", err)
+					return
+				}
+				_, err = pg.GetRecord(rids[n])
+				uerr := bm.UnpinPageRead(pids[n])
+				if err != nil || uerr != nil {
+					t.Errorf("read counter: %v, %v", err, uerr)
+					return
+				}
+				if i%50 == 0 {
+					err = bm.FlushPage(pids[n])
+					if err != nil {
+						t.Errorf("flush page: %s", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// every increment should have made it, and nothing should be left pinned
+	var total uint64
+	for n, pid := range pids {
+		pg, err := bm.FetchPageRead(pid)
+		if err != nil {
+			t.Fatalf("fetch page read: %s", err)
+		}
+		rec, err := pg.GetRecord(rids[n])
+		if err != nil {
+			t.Fatalf("get record: %s", err)
+		}
+		total += binary.LittleEndian.Uint64(rec.Val())
+		err = bm.UnpinPageRead(pid)
+		if err != nil {
+			t.Fatalf("unpin page read: %s", err)
+		}
+		if pc := bm.pool[bm.pageTable[pid]].pinCount; pc != 0 {
+			t.Errorf("page %d: pin count should be 0, got %d", pid, pc)
+		}
+	}
+	if total != workers*rounds {
+		t.Errorf("expected counters to add up to %d, got %d", workers*rounds, total)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
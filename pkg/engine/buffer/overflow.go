@@ -0,0 +1,198 @@
+package buffer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+var ErrBadOverflowChain = errors.New("buffer: bad overflow page chain")
+
+// NewRecord creates and returns a new record using the provided flags, key and
+// value. If the value is larger than the overflow threshold, the value is
+// written out to a chain of overflow pages allocated through the pool, and an
+// overflow record pointing to the head of the chain is returned instead. The
+// returned record is the one that should be added to the page.
+func (bm *BufferPoolManager) NewRecord(kflag, vflag uint8, key, val []byte) (page.Record, error) {
+	if len(val) <= page.OverflowThresholdOf(bm.pageSize) {
+		return page.NewRecord(kflag, vflag, key, val), nil
+	}
+	if uint64(len(val)) > uint64(^uint32(0)) {
+		return nil, page.ErrBadRecValLen
+	}
+	head, err := bm.writeOverflow(val)
+	if err != nil {
+		return nil, err
+	}
+	return page.NewOverflowRecord(kflag, key, vflag, uint32(len(val)), head), nil
+}
+
+// GetRecord fetches the page the record ID points to and returns a copy of the
+// record. If the record is an overflow record, the value is read back in from
+// the overflow pages and the full record is returned.
+func (bm *BufferPoolManager) GetRecord(rid *page.RecordID) (page.Record, error) {
+	// fetch the page holding the record (shared)
+	pg, err := bm.FetchPageRead(rid.PageID)
+	if err != nil {
+		return nil, err
+	}
+	// attempt to locate the record
+	rec, err := pg.GetRecord(rid)
+	// unpin the page
+	if uerr := bm.UnpinPageRead(rid.PageID); err == nil {
+		err = uerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !rec.IsOverflow() {
+		return rec, nil
+	}
+	// It is an overflow record, so we must reassemble the value
+	vt, length, head := rec.Overflow()
+	val, err := bm.readOverflow(head, int(length))
+	if err != nil {
+		return nil, err
+	}
+	return page.NewRecord(rec.KeyType(), vt, rec.Key(), val), nil
+}
+
+// DelRecord fetches the page the record ID points to and deletes the record.
+// If the record is an overflow record, the chain of overflow pages is freed.
+func (bm *BufferPoolManager) DelRecord(rid *page.RecordID) error {
+	// fetch the page holding the record (exclusive)
+	pg, err := bm.FetchPageWrite(rid.PageID)
+	if err != nil {
+		return err
+	}
+	// locate the record first, so we know if there is a chain to free
+	rec, err := pg.GetRecord(rid)
+	if err == nil {
+		err = pg.DelRecord(rid)
+	}
+	// unpin the page (make sure to mark dirty if we removed the record)
+	if uerr := bm.UnpinPageWrite(rid.PageID, err == nil); err == nil {
+		err = uerr
+	}
+	if err != nil {
+		return err
+	}
+	if !rec.IsOverflow() {
+		return nil
+	}
+	_, length, head := rec.Overflow()
+	return bm.freeOverflow(head, int(length))
+}
+
+// writeOverflow writes the provided value out to a chain of overflow pages and
+// returns the page ID of the first page in the chain. The chain is written from
+// the tail to the head, so each page knows the page ID of the page that follows
+// it and only one page has to be pinned at a time. If the chain cannot be
+// written in full, the pages allocated for it so far are deleted again.
+func (bm *BufferPoolManager) writeOverflow(val []byte) (head page.PageID, err error) {
+	var pids []page.PageID
+	defer func() {
+		if err != nil {
+			bm.discardPages(pids)
+		}
+	}()
+	var next page.PageID
+	capacity := page.OverflowPageCapacityOf(bm.pageSize)
+	for i := page.NumOverflowPages(len(val), bm.pageSize) - 1; i >= 0; i-- {
+		pg, err := bm.NewPage()
+		if err != nil {
+			return 0, err
+		}
+		pids = append(pids, pg.GetPageID())
+		beg := i * capacity
+		end := beg + capacity
+		if end > len(val) {
+			end = len(val)
+		}
+		pg.WriteOverflow(val[beg:end], next)
+		next = pg.GetPageID()
+		// unpin the page (make sure to mark dirty)
+		err = bm.UnpinPage(next, true)
+		if err != nil {
+			// the page is still pinned, and nothing on it is worth keeping
+			_ = bm.UnpinPage(next, false)
+			return 0, err
+		}
+	}
+	return next, nil
+}
+
+// discardPages deletes the provided pages, which were allocated for something
+// that could not be finished. It does the best it can; a page that cannot be
+// deleted is left allocated, but it is not referenced by anything either.
+func (bm *BufferPoolManager) discardPages(pids []page.PageID) {
+	for _, pid := range pids {
+		// The page must be in the pool in order to be deleted
+		_, err := bm.FetchPage(pid)
+		if err != nil {
+			continue
+		}
+		err = bm.UnpinPage(pid, false)
+		if err != nil {
+			continue
+		}
+		_ = bm.DeletePage(pid)
+	}
+}
+
+// readOverflow reads a value of the provided length from the chain of overflow
+// pages starting at the provided page ID.
+func (bm *BufferPoolManager) readOverflow(head page.PageID, length int) ([]byte, error) {
+	val := make([]byte, 0, length)
+	pid := head
+	for n := page.NumOverflowPages(length, bm.pageSize); n > 0; n-- {
+		pg, err := bm.FetchPageRead(pid)
+		if err != nil {
+			return nil, err
+		}
+		data, next := pg.ReadOverflow()
+		if data == nil {
+			bm.UnpinPageRead(pid)
+			return nil, fmt.Errorf("%w: page %d is not an overflow page", ErrBadOverflowChain, pid)
+		}
+		val = append(val, data...)
+		err = bm.UnpinPageRead(pid)
+		if err != nil {
+			return nil, err
+		}
+		pid = next
+	}
+	if len(val) != length {
+		return nil, fmt.Errorf("%w: read %d bytes, expected %d", ErrBadOverflowChain, len(val), length)
+	}
+	return val, nil
+}
+
+// freeOverflow deletes all the pages in the chain of overflow pages starting
+// at the provided page ID, making them available to be allocated again.
+func (bm *BufferPoolManager) freeOverflow(head page.PageID, length int) error {
+	pid := head
+	for n := page.NumOverflowPages(length, bm.pageSize); n > 0; n-- {
+		// The page must be in the pool in order to be deleted, so we fetch
+		// it first, which also gives us the next page in the chain.
+		pg, err := bm.FetchPageRead(pid)
+		if err != nil {
+			return err
+		}
+		data, next := pg.ReadOverflow()
+		err = bm.UnpinPageRead(pid)
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			return fmt.Errorf("%w: page %d is not an overflow page", ErrBadOverflowChain, pid)
+		}
+		err = bm.DeletePage(pid)
+		if err != nil {
+			return err
+		}
+		pid = next
+	}
+	return nil
+}
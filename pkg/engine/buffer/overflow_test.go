@@ -0,0 +1,179 @@
+package buffer
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+)
+
+func TestBufferPoolManager_OverflowRecord(t *testing.T) {
+	testDir := "testing"
+	testFile := "overflow_test.db"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	bm, err := New(ds, 8)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+
+	// create a data page and add a small record and a large record to it
+	pg, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	pid := pg.GetPageID()
+	small, err := bm.NewRecord(page.R_NUM, page.R_STR, []byte{1}, []byte("a small value"))
+	if err != nil {
+		t.Fatalf("new record: %s", err)
+	}
+	if small.IsOverflow() {
+		t.Errorf("new record: small record should not be an overflow record")
+	}
+	// a value larger than the record header and the pool can hold at once
+	val := bytes.Repeat([]byte("0123456789abcdef"), 10<<10)
+	large, err := bm.NewRecord(page.R_NUM, page.R_STR, []byte{2}, val)
+	if err != nil {
+		t.Fatalf("new record: %s", err)
+	}
+	if !large.IsOverflow() {
+		t.Fatalf("new record: large record should be an overflow record")
+	}
+	sid, err := pg.AddRecord(small)
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	lid, err := pg.AddRecord(large)
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	err = bm.UnpinPage(pid, true)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+
+	// both records should come back out in full
+	rec, err := bm.GetRecord(sid)
+	if err != nil || !bytes.Equal(rec.Val(), []byte("a small value")) {
+		t.Errorf("get record: got %q (%v)", rec.Val(), err)
+	}
+	rec, err = bm.GetRecord(lid)
+	if err != nil {
+		t.Fatalf("get record: %s", err)
+	}
+	if rec.IsOverflow() || rec.ValType() != page.R_VAL&page.R_STR {
+		t.Errorf("get record: got val type 0x%.2x", rec.ValType())
+	}
+	if !bytes.Equal(rec.Key(), []byte{2}) || !bytes.Equal(rec.Val(), val) {
+		t.Errorf("get record: value mismatch (got %d bytes, expected %d)", len(rec.Val()), len(val))
+	}
+
+	// deleting the record should free the chain, and the pages should be
+	// handed out again
+	_, _, head := large.Overflow()
+	err = bm.DelRecord(lid)
+	if err != nil {
+		t.Fatalf("del record: %s", err)
+	}
+	_, err = bm.GetRecord(lid)
+	if err != page.ErrRecordNotFound {
+		t.Errorf("get record: got %v, expected %v", err, page.ErrRecordNotFound)
+	}
+	// the chain is allocated from the tail to the head, so the head holds the
+	// highest page ID in the chain
+	lo := head - page.PageID(page.NumOverflowPages(len(val), bm.PageSize())) + 1
+	pg, err = bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	if pg.GetPageID() < lo || pg.GetPageID() > head {
+		t.Errorf("new page: got pid=%d, expected a freed overflow page", pg.GetPageID())
+	}
+	err = bm.UnpinPage(pg.GetPageID(), false)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
+
+// allocLimitStore is a storage.Storer that can only allocate so many pages, and
+// keeps track of the pages that get deallocated.
+type allocLimitStore struct {
+	storage.Storer
+	left  int
+	freed []page.PageID
+}
+
+var errAllocLimit = errors.New("allocation limit reached")
+
+func (s *allocLimitStore) AllocatePage() (page.PageID, error) {
+	if s.left == 0 {
+		return 0, errAllocLimit
+	}
+	s.left--
+	return s.Storer.AllocatePage()
+}
+
+func (s *allocLimitStore) DeallocatePage(pid page.PageID) error {
+	s.freed = append(s.freed, pid)
+	return s.Storer.DeallocatePage(pid)
+}
+
+func TestBufferPoolManager_OverflowRecordError(t *testing.T) {
+	testDir := "testing"
+	testFile := "overflow_error_test.db"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	store := &allocLimitStore{Storer: ds, left: 3}
+	bm, err := New(store, 2)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+
+	// the value needs more overflow pages than can be allocated, so the
+	// pages allocated for the chain before that should all be deleted
+	val := bytes.Repeat([]byte("0123456789abcdef"), 5*bm.PageSize()/16)
+	if n := page.NumOverflowPages(len(val), bm.PageSize()); n <= store.left {
+		t.Fatalf("value only needs %d overflow pages", n)
+	}
+	_, err = bm.NewRecord(page.R_NUM, page.R_STR, []byte{1}, val)
+	if !errors.Is(err, errAllocLimit) {
+		t.Fatalf("new record: got %v, expected %v", err, errAllocLimit)
+	}
+	if len(store.freed) != 3 {
+		t.Errorf("deallocated pages: got %v, expected 3 of them", store.freed)
+	}
+
+	// and the pages should be handed out again
+	store.left = 1
+	pg, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	if pg.GetPageID() > 2 {
+		t.Errorf("new page: got pid=%d, expected a deleted overflow page", pg.GetPageID())
+	}
+	err = bm.UnpinPage(pg.GetPageID(), false)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
@@ -0,0 +1,224 @@
+package buffer
+
+import (
+	"errors"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/logging"
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+	"github.com/cagnosolutions/go-data/pkg/file/dio"
+)
+
+// Recover replays the log, bringing the pages in the storage layer back to a
+// consistent state after a crash. It works much like ARIES does, in three
+// passes over the log records written since the last checkpoint:
+//
+//   - analysis, which finds the transactions that never committed (losers)
+//   - redo, which repeats history by applying every update that did not make
+//     it onto its page, using the page lsn to tell which ones did
+//   - undo, which rolls back the losers in reverse order, writing a
+//     compensation record for each update it undoes, and an abort record for
+//     each loser once it is done
+//
+// Finally, the pages are written, and a checkpoint is written to the log. The
+// Recover method must be called before any pages are fetched. If the
+// BufferPoolManager is not logging, Recover does nothing.
+func (bm *BufferPoolManager) Recover() error {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	if bm.log == nil {
+		return nil
+	}
+	// Make sure we never hand out a log sequence number that is smaller than
+	// one already found on a page.
+	if s, ok := bm.store.(interface{ LastLSN() uint64 }); ok {
+		bm.log.SetLastLSN(s.LastLSN())
+	}
+	recs, err := bm.log.Records()
+	if err != nil {
+		return err
+	}
+	// Anything before the last checkpoint has already made it onto the disk.
+	for i := len(recs) - 1; i >= 0; i-- {
+		if recs[i].Type == logging.LogCheckpoint {
+			recs = recs[i+1:]
+			break
+		}
+	}
+	if len(recs) == 0 {
+		return nil
+	}
+	r := &recovery{
+		bm:    bm,
+		pages: make(map[page.PageID]page.Page),
+		dirty: make(map[page.PageID]bool),
+	}
+	// Analysis: find the transactions that have not finished, along with the
+	// lsn of the last record each of them wrote.
+	losers := make(map[uint64]uint64)
+	for _, rec := range recs {
+		switch rec.Type {
+		case logging.LogUpdate, logging.LogCompensate:
+			losers[rec.TxID] = rec.LSN
+		case logging.LogCommit, logging.LogAbort:
+			delete(losers, rec.TxID)
+		}
+	}
+	// Redo: repeat history.
+	for _, rec := range recs {
+		if rec.Type != logging.LogUpdate && rec.Type != logging.LogCompensate {
+			continue
+		}
+		err = r.redo(rec)
+		if err != nil {
+			return err
+		}
+	}
+	// Undo: roll back the losers.
+	err = r.undo(recs, losers)
+	if err != nil {
+		return err
+	}
+	// Make sure the log is written ahead of the pages, and write them.
+	err = bm.log.Flush(bm.log.LastLSN())
+	if err != nil {
+		return err
+	}
+	for pid := range r.dirty {
+		err = bm.store.WritePage(pid, r.pages[pid])
+		if err != nil {
+			return err
+		}
+	}
+	// Any pages that were formatted by a loser can be released again.
+	for _, pid := range r.freed {
+		err = bm.store.DeallocatePage(pid)
+		if err != nil {
+			return err
+		}
+	}
+	// The pages must be durable before the checkpoint is written
+	err = bm.syncStore()
+	if err != nil {
+		return err
+	}
+	return bm.log.Checkpoint()
+}
+
+// recovery holds the state used while recovering.
+type recovery struct {
+	bm    *BufferPoolManager
+	pages map[page.PageID]page.Page // the pages touched by the log
+	dirty map[page.PageID]bool      // the pages that have been modified
+	freed []page.PageID             // the pages that were formatted by a loser
+}
+
+// getPage returns the page matching the provided page ID, reading it from the
+// storage layer if it has not been read yet. If the page cannot be read and it
+// is about to be formatted anyway, a zeroed page is returned.
+func (r *recovery) getPage(pid page.PageID, format bool) (page.Page, error) {
+	if p, found := r.pages[pid]; found {
+		return p, nil
+	}
+	p := page.Page(dio.AlignedBlock(r.bm.pageSize))
+	err := r.bm.store.ReadPage(pid, p)
+	if err != nil {
+		if !format || !errors.Is(err, storage.ErrCorruptPage) {
+			return nil, err
+		}
+		p = page.Page(dio.AlignedBlock(r.bm.pageSize))
+	}
+	r.pages[pid] = p
+	return p, nil
+}
+
+// redo applies the log record to its page, unless the page already holds it.
+func (r *recovery) redo(rec *logging.LogRecord) error {
+	p, err := r.getPage(rec.PageID, rec.HasFlag(logging.LogFormat))
+	if err != nil {
+		return err
+	}
+	if p.GetLSN() >= rec.LSN {
+		// The update made it onto the page before the crash
+		return nil
+	}
+	rec.Redo(p)
+	p.SetLSN(rec.LSN)
+	r.dirty[rec.PageID] = true
+	return nil
+}
+
+// undo rolls back the loser transactions. The log records are walked in
+// reverse order, and for each loser only the record matching the next lsn to
+// undo is processed, which makes sure that updates which have already been
+// compensated for (by a previous recovery) are not undone twice.
+func (r *recovery) undo(recs []*logging.LogRecord, losers map[uint64]uint64) error {
+	next := make(map[uint64]uint64, len(losers))
+	last := make(map[uint64]uint64, len(losers))
+	for txid, lsn := range losers {
+		next[txid], last[txid] = lsn, lsn
+	}
+	for i := len(recs) - 1; i >= 0 && len(next) > 0; i-- {
+		rec := recs[i]
+		lsn, found := next[rec.TxID]
+		if !found || rec.LSN != lsn {
+			continue
+		}
+		switch rec.Type {
+		case logging.LogCompensate:
+			// Already undone, skip ahead to whatever is left to undo
+			next[rec.TxID] = rec.UndoNext
+		case logging.LogUpdate:
+			p, err := r.getPage(rec.PageID, false)
+			if err != nil {
+				return err
+			}
+			rec.Undo(p)
+			// Log the compensation. It holds the inverse of the update, so it
+			// can be redone if we crash during recovery.
+			clr := &logging.LogRecord{
+				PrevLSN:  last[rec.TxID],
+				UndoNext: rec.PrevLSN,
+				TxID:     rec.TxID,
+				Type:     logging.LogCompensate,
+				Flags:    rec.Flags,
+				PageID:   rec.PageID,
+			}
+			if !rec.HasFlag(logging.LogFormat) {
+				for _, d := range rec.Deltas {
+					clr.Deltas = append(clr.Deltas, page.Delta{Offset: d.Offset, Before: d.After, After: d.Before})
+				}
+			} else {
+				r.freed = append(r.freed, rec.PageID)
+			}
+			clsn, err := r.bm.log.Append(clr)
+			if err != nil {
+				return err
+			}
+			p.SetLSN(clsn)
+			r.dirty[rec.PageID] = true
+			last[rec.TxID] = clsn
+			next[rec.TxID] = rec.PrevLSN
+		default:
+			next[rec.TxID] = rec.PrevLSN
+		}
+		if next[rec.TxID] == 0 {
+			delete(next, rec.TxID)
+		}
+	}
+	// Every loser has been rolled back, so we can write the abort records.
+	for txid := range losers {
+		_, err := r.bm.log.Append(
+			&logging.LogRecord{
+				PrevLSN: last[txid],
+				TxID:    txid,
+				Type:    logging.LogAbort,
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
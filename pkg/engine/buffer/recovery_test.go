@@ -0,0 +1,123 @@
+package buffer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/logging"
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+)
+
+func openLoggingPool(t *testing.T, dir string) (*BufferPoolManager, *storage.DiskStore, *logging.LogManager) {
+	ds, err := storage.Open(filepath.Join(dir, "recovery.db"))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	lm, err := logging.OpenLogManager(filepath.Join(dir, "recovery.wal"))
+	if err != nil {
+		t.Fatalf("opening log manager: %s", err)
+	}
+	bm, err := NewWithConfig(
+		&Config{
+			PageCount:  8,
+			Replacer:   NewClockReplacer(8),
+			Storer:     ds,
+			LogManager: lm,
+		},
+	)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+	err = bm.Recover()
+	if err != nil {
+		t.Fatalf("recover: %s", err)
+	}
+	return bm, ds, lm
+}
+
+func TestBufferPoolManager_Recover(t *testing.T) {
+	testDir := "testing"
+	defer os.RemoveAll(testDir)
+
+	bm, ds, lm := openLoggingPool(t, testDir)
+	r1 := page.NewRecord(page.R_NUM, page.R_STR, []byte{1}, []byte("committed, never flushed"))
+	r2 := page.NewRecord(page.R_NUM, page.R_STR, []byte{2}, []byte("flushed, never committed"))
+
+	// the first transaction creates two pages, and adds a record to the first
+	p0, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	id1, err := p0.AddRecord(r1)
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	p1, err := bm.NewPage()
+	if err != nil {
+		t.Fatalf("new page: %s", err)
+	}
+	err = bm.UnpinPage(p0.GetPageID(), true)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.UnpinPage(p1.GetPageID(), true)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+
+	// the second transaction adds a record to the second page, which is
+	// flushed before the transaction commits
+	p1, err = bm.FetchPage(p1.GetPageID())
+	if err != nil {
+		t.Fatalf("fetch page: %s", err)
+	}
+	id2, err := p1.AddRecord(r2)
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	err = bm.UnpinPage(p1.GetPageID(), true)
+	if err != nil {
+		t.Fatalf("unpin page: %s", err)
+	}
+	err = bm.FlushPage(p1.GetPageID())
+	if err != nil {
+		t.Fatalf("flush page: %s", err)
+	}
+
+	// crash, without flushing or committing anything else
+	_ = lm.Close()
+	_ = ds.Close()
+
+	bm, _, _ = openLoggingPool(t, testDir)
+	// the committed record should have been redone
+	rec, err := bm.GetRecord(id1)
+	if err != nil || string(rec) != string(r1) {
+		t.Errorf("recover: got %v (%v), expected %v", rec, err, r1)
+	}
+	// the uncommitted record should have been undone
+	_, err = bm.GetRecord(id2)
+	if err == nil {
+		t.Errorf("recover: expected the uncommitted record to be gone")
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+
+	// recovering again should not change anything
+	bm, _, _ = openLoggingPool(t, testDir)
+	rec, err = bm.GetRecord(id1)
+	if err != nil || string(rec) != string(r1) {
+		t.Errorf("reopen: got %v (%v), expected %v", rec, err, r1)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
@@ -0,0 +1,106 @@
+package buffer
+
+// queue identifiers used by the TwoQueueReplacer
+const (
+	queueA1 uint8 = iota + 1 // frames that have been accessed once
+	queueAm                  // frames that have been accessed more than once
+)
+
+// TwoQueueReplacer represents a (simplified) 2Q based replacement cache. Frames
+// that have only been accessed once are kept in a FIFO queue (A1), and frames
+// that are accessed again while resident are promoted to an LRU queue (Am). The
+// A1 queue is victimized first whenever it holds more than its share of frames,
+// so a large sequential scan only cycles through the A1 queue and leaves the
+// frequently used frames in the Am queue alone.
+type TwoQueueReplacer struct {
+	a1    *circularList[FrameID, bool] // evictable frames in the A1 queue
+	am    *circularList[FrameID, bool] // evictable frames in the Am queue
+	queue map[FrameID]uint8            // the queue each tracked frame belongs to
+	n1    uint16                       // number of tracked frames in the A1 queue
+	kin   uint16                       // max number of frames the A1 queue should hold
+}
+
+// NewTwoQueueReplacer instantiates and returns a new TwoQueueReplacer. The A1
+// queue is given a quarter of the frames.
+func NewTwoQueueReplacer(size uint16) *TwoQueueReplacer {
+	kin := size / 4
+	if kin < 1 {
+		kin = 1
+	}
+	return &TwoQueueReplacer{
+		a1:    newCircularList[FrameID, bool](size),
+		am:    newCircularList[FrameID, bool](size),
+		queue: make(map[FrameID]uint8, size),
+		kin:   kin,
+	}
+}
+
+// Pin takes a frame ID and "pins" it, indicating that the caller is now
+// using it. The frame is removed from the evictable set, and if it was in the
+// A1 queue, it is promoted to the Am queue because it has now been accessed
+// more than once.
+func (r *TwoQueueReplacer) Pin(fid FrameID) {
+	q, found := r.queue[fid]
+	if !found {
+		r.queue[fid] = queueA1
+		r.n1++
+		return
+	}
+	if q == queueA1 {
+		r.a1.remove(fid)
+		r.queue[fid] = queueAm
+		r.n1--
+		return
+	}
+	r.am.remove(fid)
+}
+
+// Unpin takes a frame ID and "unpins" it, indicating that the caller is no
+// longer using it. The frame is added to the tail of the queue it belongs to,
+// making it available for victimization.
+func (r *TwoQueueReplacer) Unpin(fid FrameID) {
+	q, found := r.queue[fid]
+	if !found {
+		q = queueA1
+		r.queue[fid] = q
+		r.n1++
+	}
+	list := r.a1
+	if q == queueAm {
+		list = r.am
+	}
+	if !list.hasKey(fid) {
+		if err := list.insert(fid, true); err != nil {
+			panic("replacer.unpin: failed on insert: " + err.Error())
+		}
+	}
+}
+
+// Victim searches for a frame ID in the replacer that it can victimize and
+// return to the caller. The oldest frame in the A1 queue is chosen if the A1
+// queue holds more than its share of frames, otherwise the least recently used
+// frame in the Am queue is chosen. If there are no frame IDs to victimize, it
+// will simply return nil.
+func (r *TwoQueueReplacer) Victim() *FrameID {
+	var list *circularList[FrameID, bool]
+	switch {
+	case r.a1.size > 0 && (r.n1 > r.kin || r.am.size == 0):
+		list = r.a1
+	case r.am.size > 0:
+		list = r.am
+	default:
+		return nil
+	}
+	fid := list.head.key
+	list.remove(fid)
+	if r.queue[fid] == queueA1 {
+		r.n1--
+	}
+	delete(r.queue, fid)
+	return &fid
+}
+
+// Size returns the number of elements currently in the replacer.
+func (r *TwoQueueReplacer) Size() uint16 {
+	return r.a1.size + r.am.size
+}
@@ -0,0 +1,71 @@
+package buffer
+
+import (
+	"testing"
+)
+
+func TestTwoQueueReplacer_Size(t *testing.T) {
+	r := NewTwoQueueReplacer(8)
+	if sz := r.Size(); sz != 0 {
+		t.Errorf("got %d, want %d", sz, 0)
+	}
+	r.Unpin(1)
+	r.Unpin(2)
+	r.Unpin(1)
+	if sz := r.Size(); sz != 2 {
+		t.Errorf("got %d, want %d", sz, 2)
+	}
+	r.Pin(1)
+	if sz := r.Size(); sz != 1 {
+		t.Errorf("got %d, want %d", sz, 1)
+	}
+}
+
+func TestTwoQueueReplacer_All(t *testing.T) {
+	// with 8 frames the A1 queue gets 2 of them
+	r := NewTwoQueueReplacer(8)
+
+	// frames 1-6 are accessed once
+	for fid := FrameID(1); fid <= 6; fid++ {
+		r.Unpin(fid)
+	}
+	// frames 1 and 2 are accessed again, and are promoted to Am
+	r.Pin(1)
+	r.Unpin(1)
+	r.Pin(2)
+	r.Unpin(2)
+
+	// A1 holds frames 3-6, which is more than its share, so it should be
+	// victimized in fifo order until it is back down to its share
+	for _, want := range []FrameID{3, 4} {
+		val := r.Victim()
+		if val == nil || *val != want {
+			t.Errorf("got %v, want %d", val, want)
+		}
+	}
+
+	// now Am should be victimized in lru order, so touch frame 1 again
+	r.Pin(1)
+	r.Unpin(1)
+	for _, want := range []FrameID{2, 1} {
+		val := r.Victim()
+		if val == nil || *val != want {
+			t.Errorf("got %v, want %d", val, want)
+		}
+	}
+
+	// once Am is empty, A1 is used regardless of its share
+	r.Pin(5)
+	val := r.Victim()
+	if val == nil || *val != 6 {
+		t.Errorf("got %v, want %d", val, 6)
+	}
+	if val = r.Victim(); val != nil {
+		t.Errorf("got %d, want %v", *val, nil)
+	}
+	r.Unpin(5)
+	val = r.Victim()
+	if val == nil || *val != 5 {
+		t.Errorf("got %v, want %d", val, 5)
+	}
+}
@@ -0,0 +1,120 @@
+package buffer
+
+// lrukFrame holds the access history for a single frame.
+type lrukFrame struct {
+	history   []uint64 // logical timestamps of the last k accesses (oldest first)
+	evictable bool     // true if the frame is currently unpinned
+}
+
+// LRUKReplacer represents an LRU-K based replacement cache. The victim is the
+// evictable frame with the largest backward k-distance, which is the time since
+// its k-th most recent access. Frames with fewer than k accesses have an
+// infinite backward k-distance, and among those the frame with the earliest
+// recorded access is chosen. This keeps frames that are only touched once (such
+// as the ones filled by a large sequential scan) from pushing out frequently
+// used frames.
+type LRUKReplacer struct {
+	k      int
+	clock  uint64
+	size   uint16
+	frames map[FrameID]*lrukFrame
+}
+
+// NewLRUKReplacer instantiates and returns a new LRUKReplacer that tracks the
+// last k accesses of every frame. A k of less than one is treated as one, which
+// makes the replacer behave just like a plain LRU replacer.
+func NewLRUKReplacer(size uint16, k int) *LRUKReplacer {
+	if k < 1 {
+		k = 1
+	}
+	return &LRUKReplacer{
+		k:      k,
+		frames: make(map[FrameID]*lrukFrame, size),
+	}
+}
+
+// access records an access for the frame matching the supplied frame ID and
+// returns the frame.
+func (r *LRUKReplacer) access(fid FrameID) *lrukFrame {
+	f, found := r.frames[fid]
+	if !found {
+		f = &lrukFrame{
+			history: make([]uint64, 0, r.k),
+		}
+		r.frames[fid] = f
+	}
+	r.clock++
+	if len(f.history) == r.k {
+		copy(f.history, f.history[1:])
+		f.history = f.history[:r.k-1]
+	}
+	f.history = append(f.history, r.clock)
+	return f
+}
+
+// Pin takes a frame ID and "pins" it, indicating that the caller is now
+// using it. Pinning a frame counts as an access, and the frame will not be
+// available for victimization until it is unpinned.
+func (r *LRUKReplacer) Pin(fid FrameID) {
+	f := r.access(fid)
+	if f.evictable {
+		f.evictable = false
+		r.size--
+	}
+}
+
+// Unpin takes a frame ID and "unpins" it, indicating that the caller is no
+// longer using it, making it available for victimization. If the replacer has
+// not seen the frame before, the unpin counts as the first access.
+func (r *LRUKReplacer) Unpin(fid FrameID) {
+	f, found := r.frames[fid]
+	if !found {
+		f = r.access(fid)
+	}
+	if !f.evictable {
+		f.evictable = true
+		r.size++
+	}
+}
+
+// Victim searches for a frame ID in the replacer that it can victimize and
+// return to the caller. It locates and removes the frame with the largest
+// backward k-distance. If there are no frame IDs to victimize, it will
+// simply return nil.
+func (r *LRUKReplacer) Victim() *FrameID {
+	if r.size == 0 {
+		return nil
+	}
+	var victim *FrameID
+	var vf *lrukFrame
+	for fid, f := range r.frames {
+		if !f.evictable {
+			continue
+		}
+		if vf == nil || r.before(f, vf) {
+			id := fid
+			victim, vf = &id, f
+		}
+	}
+	delete(r.frames, *victim)
+	r.size--
+	return victim
+}
+
+// before returns true if frame a should be victimized before frame b.
+func (r *LRUKReplacer) before(a, b *lrukFrame) bool {
+	aInf, bInf := len(a.history) < r.k, len(b.history) < r.k
+	if aInf != bInf {
+		// Only one of the frames has an infinite backward k-distance
+		return aInf
+	}
+	// Both are equal in that regard, so compare the oldest timestamp in the
+	// history. When both have k accesses this is the k-th most recent access,
+	// otherwise it is the earliest access we know of.
+	return a.history[0] < b.history[0]
+}
+
+// Size returns the number of elements currently in the replacer.
+func (r *LRUKReplacer) Size() uint16 {
+	return r.size
+}
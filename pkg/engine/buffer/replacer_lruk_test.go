@@ -0,0 +1,75 @@
+package buffer
+
+import (
+	"testing"
+)
+
+func TestLRUKReplacer_Size(t *testing.T) {
+	r := NewLRUKReplacer(10, 2)
+	if sz := r.Size(); sz != 0 {
+		t.Errorf("got %d, want %d", sz, 0)
+	}
+	r.Unpin(1)
+	r.Unpin(2)
+	r.Unpin(1)
+	if sz := r.Size(); sz != 2 {
+		t.Errorf("got %d, want %d", sz, 2)
+	}
+	r.Pin(1)
+	if sz := r.Size(); sz != 1 {
+		t.Errorf("got %d, want %d", sz, 1)
+	}
+}
+
+func TestLRUKReplacer_All(t *testing.T) {
+	r := NewLRUKReplacer(10, 2)
+
+	// frames 1-6 are accessed once
+	for fid := FrameID(1); fid <= 6; fid++ {
+		r.Unpin(fid)
+	}
+	// frames 1 and 2 get accessed a second time
+	r.Pin(1)
+	r.Unpin(1)
+	r.Pin(2)
+	r.Unpin(2)
+
+	// frames with a single access have an infinite backward k-distance, so
+	// they should be victimized first, in the order they were accessed
+	for _, want := range []FrameID{3, 4} {
+		val := r.Victim()
+		if val == nil || *val != want {
+			t.Errorf("got %v, want %d", val, want)
+		}
+	}
+
+	// pinned frames must not be victimized
+	r.Pin(5)
+	r.Pin(6)
+	for _, want := range []FrameID{1, 2} {
+		val := r.Victim()
+		if val == nil || *val != want {
+			t.Errorf("got %v, want %d", val, want)
+		}
+	}
+	if val := r.Victim(); val != nil {
+		t.Errorf("got %d, want %v", *val, nil)
+	}
+
+	// frame 5 now has two accesses, frame 6 has three, so frame 5 has the
+	// larger backward k-distance
+	r.Unpin(5)
+	r.Pin(6)
+	r.Unpin(6)
+	val := r.Victim()
+	if val == nil || *val != 5 {
+		t.Errorf("got %v, want %d", val, 5)
+	}
+	val = r.Victim()
+	if val == nil || *val != 6 {
+		t.Errorf("got %v, want %d", val, 6)
+	}
+	if sz := r.Size(); sz != 0 {
+		t.Errorf("got %d, want %d", sz, 0)
+	}
+}
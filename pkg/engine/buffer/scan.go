@@ -0,0 +1,51 @@
+package buffer
+
+import (
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+// Scan calls the provided function for every record found on the plain data
+// pages (ones that are not part of an index, or of a chain of overflow pages)
+// in page order. Overflow records are reassembled before they are passed on,
+// so the function always sees the full record. If the function returns an
+// error, the scan is stopped and the error is returned.
+func (bm *BufferPoolManager) Scan(fn func(rid *page.RecordID, rec page.Record) error) error {
+	count := bm.store.PageCount()
+	for pid := page.PageID(0); pid < count; pid++ {
+		if bm.isFreed(pid) {
+			continue
+		}
+		// Make a list of the record IDs first, so we do not hold on to the page
+		// while the function is being called.
+		pg, err := bm.FetchPageRead(pid)
+		if err != nil {
+			return err
+		}
+		var rids []*page.RecordID
+		if isDataPage(pg) {
+			for pos := 0; pos < pg.Len(); pos++ {
+				rid, _, err := pg.GetRecordAt(pos)
+				if err != nil {
+					_ = bm.UnpinPageRead(pid)
+					return err
+				}
+				rids = append(rids, rid)
+			}
+		}
+		err = bm.UnpinPageRead(pid)
+		if err != nil {
+			return err
+		}
+		for _, rid := range rids {
+			rec, err := bm.GetRecord(rid)
+			if err != nil {
+				return err
+			}
+			err = fn(rid, rec)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package buffer
+
+import (
+	"sync/atomic"
+)
+
+// Stats holds a snapshot of the counters kept by the BufferPoolManager, along
+// with the current state of the frames in the pool.
+type Stats struct {
+	Frames       int    `json:"frames"`        // number of frames in the pool
+	Resident     int    `json:"resident"`      // number of frames holding a page
+	Pinned       int    `json:"pinned"`        // number of frames holding a pinned page
+	Dirty        int    `json:"dirty"`         // number of frames holding a dirty page
+	Hits         uint64 `json:"hits"`          // number of times a page was found in the pool
+	Misses       uint64 `json:"misses"`        // number of times a page had to be read in
+	Evictions    uint64 `json:"evictions"`     // number of times a page was victimized to make room
+	DirtyFlushes uint64 `json:"dirty_flushes"` // number of dirty pages written out
+	PinWaits     uint64 `json:"pin_waits"`     // number of times a frame latch had to be waited on
+}
+
+// HitRate returns the ratio of page fetches that were found in the pool. If
+// nothing has been fetched yet, it returns one.
+func (s *Stats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 1
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// Stats returns a snapshot of the counters kept by the BufferPoolManager, along
+// with the current state of the frames in the pool.
+func (bm *BufferPoolManager) Stats() *Stats {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	s := &Stats{
+		Frames:       len(bm.pool),
+		Resident:     len(bm.pageTable),
+		Hits:         bm.hits,
+		Misses:       bm.misses,
+		Evictions:    bm.evictions,
+		DirtyFlushes: atomic.LoadUint64(&bm.flushes),
+		PinWaits:     atomic.LoadUint64(&bm.pinWaits),
+	}
+	for _, fid := range bm.pageTable {
+		pf := &bm.pool[fid]
+		if pf.pinCount > 0 {
+			s.Pinned++
+		}
+		if pf.isDirty {
+			s.Dirty++
+		}
+	}
+	return s
+}
@@ -0,0 +1,106 @@
+package buffer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
+)
+
+func TestBufferPoolManager_Stats(t *testing.T) {
+	testDir := "testing"
+	testFile := "stats_test.db"
+	defer os.RemoveAll(testDir)
+
+	ds, err := storage.Open(filepath.Join(testDir, testFile))
+	if err != nil {
+		t.Fatalf("opening disk store: %s", err)
+	}
+	bm, err := New(ds, 4)
+	if err != nil {
+		t.Fatalf("opening buffer manager: %s", err)
+	}
+	if s := bm.Stats(); s.Frames != 4 || s.Resident != 0 || s.HitRate() != 1 {
+		t.Errorf("fresh pool: got %+v", s)
+	}
+
+	// create twice as many (dirty) pages as there are frames, which evicts
+	// and writes out the first half of them
+	var pids []page.PageID
+	for i := 0; i < 8; i++ {
+		pg, err := bm.NewPage()
+		if err != nil {
+			t.Fatalf("new page: %s", err)
+		}
+		pids = append(pids, pg.GetPageID())
+		err = bm.UnpinPage(pg.GetPageID(), true)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+	s := bm.Stats()
+	if s.Resident != 4 || s.Dirty != 4 || s.Evictions != 4 || s.DirtyFlushes != 4 {
+		t.Errorf("after new pages: got %+v", s)
+	}
+
+	// one hit, and one miss
+	for _, pid := range []page.PageID{pids[7], pids[0]} {
+		_, err = bm.FetchPage(pid)
+		if err != nil {
+			t.Fatalf("fetch page: %s", err)
+		}
+		err = bm.UnpinPage(pid, false)
+		if err != nil {
+			t.Fatalf("unpin page: %s", err)
+		}
+	}
+	s = bm.Stats()
+	if s.Hits != 1 || s.Misses != 1 || s.HitRate() != 0.5 {
+		t.Errorf("after fetching: got %+v", s)
+	}
+
+	// a reader has to wait on a writer holding the page
+	_, err = bm.FetchPageWrite(pids[0])
+	if err != nil {
+		t.Fatalf("fetch page write: %s", err)
+	}
+	if s = bm.Stats(); s.Pinned != 1 {
+		t.Errorf("pinned: expected 1, got %d", s.Pinned)
+	}
+	done := make(chan error)
+	go func() {
+		_, err := bm.FetchPageRead(pids[0])
+		if err == nil {
+			err = bm.UnpinPageRead(pids[0])
+		}
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	err = bm.UnpinPageWrite(pids[0], false)
+	if err != nil {
+		t.Fatalf("unpin page write: %s", err)
+	}
+	if err = <-done; err != nil {
+		t.Fatalf("reader: %s", err)
+	}
+	if s = bm.Stats(); s.PinWaits != 1 || s.Pinned != 0 {
+		t.Errorf("after waiting: got %+v", s)
+	}
+
+	// flushing writes out the rest of the dirty pages, so every page has been
+	// written out once
+	err = bm.FlushAll()
+	if err != nil {
+		t.Fatalf("flush all: %s", err)
+	}
+	if s = bm.Stats(); s.Dirty != 0 || s.DirtyFlushes != 8 {
+		t.Errorf("after flushing: got %+v", s)
+	}
+	err = bm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
@@ -0,0 +1,94 @@
+package buffer
+
+import (
+	"github.com/cagnosolutions/go-data/pkg/engine/logging"
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+// logFrame compares the page held in the frame against the copy taken when it
+// was last logged, and writes any changes to the log as an update record. The
+// page is then stamped with the log sequence number of the update record. A
+// frame holding a new page has no copy, so the changes are logged against a
+// zeroed page, and the update record is marked as a page format.
+func (bm *BufferPoolManager) logFrame(pf *Frame) error {
+	var flags uint8
+	if pf.image == nil {
+		flags = logging.LogFormat
+	}
+	deltas := pf.Page.Diff(pf.image)
+	if len(deltas) == 0 && flags == 0 {
+		// Nothing has changed since the last time it was logged
+		return nil
+	}
+	if flags == logging.LogFormat {
+		// There is nothing to undo, other than the format itself
+		for i := range deltas {
+			deltas[i].Before = nil
+		}
+	}
+	lsn, err := bm.log.LogUpdate(pf.pid, flags, deltas)
+	if err != nil {
+		return err
+	}
+	pf.Page.SetLSN(lsn)
+	if pf.image == nil {
+		pf.image = make(page.Page, len(pf.Page))
+	}
+	copy(pf.image, pf.Page)
+	return nil
+}
+
+// writeAhead makes sure that any changes made to the page held in the frame
+// have been logged, and that the log has been flushed up to the log sequence
+// number of the page, so that it can safely be written to the storage layer.
+func (bm *BufferPoolManager) writeAhead(pf *Frame) error {
+	if bm.log == nil {
+		return nil
+	}
+	err := bm.logFrame(pf)
+	if err != nil {
+		return err
+	}
+	return bm.log.Flush(pf.Page.GetLSN())
+}
+
+// Commit commits the active transaction. A commit record is written and the
+// log is flushed, which makes all the changes made by the transaction durable
+// even though the pages may not have been written yet. Any pages that were
+// deleted by the transaction are deallocated. If the BufferPoolManager is not
+// logging, Commit does nothing.
+func (bm *BufferPoolManager) Commit() error {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	if bm.log == nil {
+		return nil
+	}
+	err := bm.log.Commit()
+	if err != nil {
+		return err
+	}
+	// The deletions are durable now, so we can release the pages
+	for _, pid := range bm.freed {
+		err = bm.store.DeallocatePage(pid)
+		if err != nil {
+			return err
+		}
+	}
+	bm.freed = nil
+	return nil
+}
+
+// Checkpoint writes a checkpoint record to the log. It should be called right
+// after all the pages have been flushed, so recovery does not need to look at
+// anything written before it. If the BufferPoolManager is not logging,
+// Checkpoint does nothing.
+func (bm *BufferPoolManager) Checkpoint() error {
+	// latch
+	bm.latch.Lock()
+	defer bm.latch.Unlock()
+	if bm.log == nil {
+		return nil
+	}
+	return bm.log.Checkpoint()
+}
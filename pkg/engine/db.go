@@ -5,10 +5,13 @@ import (
 	"errors"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"sync"
 	"sync/atomic"
 
 	"github.com/cagnosolutions/go-data/pkg/engine/buffer"
+	"github.com/cagnosolutions/go-data/pkg/engine/logging"
 	"github.com/cagnosolutions/go-data/pkg/engine/page"
 	"github.com/cagnosolutions/go-data/pkg/engine/storage"
 )
@@ -16,6 +19,8 @@ import (
 const (
 	defaultFrameCount = 64
 	badID             = ^uint64(0) - 1
+	noPID             = ^uint32(0)
+	walSuffix         = ".wal"
 )
 
 var (
@@ -25,13 +30,41 @@ var (
 
 type DB struct {
 	base string
+	conf *storage.TableSpaceConfig
 	data sync.Map
 }
 
+// OpenDB opens the database located at the provided base path, storing each
+// namespace in a table space using the default table space config.
 func OpenDB(base string) (*DB, error) {
-	return &DB{
+	return OpenDBWithConfig(base, storage.DefaultTableSpaceConfig)
+}
+
+// OpenDBWithConfig opens the database located at the provided base path, storing
+// each namespace in a table space using the provided table space config. The
+// config is only used for new namespaces; existing ones keep their layout. If
+// the config sets a page size, existing namespaces must use the same one.
+func OpenDBWithConfig(base string, conf *storage.TableSpaceConfig) (*DB, error) {
+	db := &DB{
 		base: filepath.ToSlash(base),
-	}, nil
+		conf: conf,
+	}
+	// open any existing collections, which will replay their logs
+	entries, err := os.ReadDir(base)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !e.IsDir() || !strings.HasSuffix(e.Name(), walSuffix) {
+			continue
+		}
+		c, err := openCollection(db.base, strings.TrimSuffix(e.Name(), walSuffix), db.conf)
+		if err != nil {
+			return nil, err
+		}
+		db.data.Store(c.path, c)
+	}
+	return db, nil
 }
 
 func (db *DB) getNS(base, name string) (Namespace, error) {
@@ -68,7 +101,7 @@ func (db *DB) Create(name string) (Namespace, error) {
 	}
 	// otherwise, namespace does not exist, so we will need
 	// to create a new one.
-	c, err := openCollection(db.base, name)
+	c, err := openCollection(db.base, name, db.conf)
 	if err != nil {
 		return nil, err
 	}
@@ -114,40 +147,84 @@ func (db *DB) Close() error {
 }
 
 type Collection struct {
-	path   string
-	pool   *buffer.BufferPoolManager
-	curr   uint32
-	maxPID uint32
+	latch   sync.Mutex // serializes the writers
+	path    string
+	pool    *buffer.BufferPoolManager
+	indexes sync.Map // name -> *secondaryIndex
+	curr    uint32
+	maxPID  uint32
+
+	mvcc      sync.Mutex // guards the fields below
+	clockFile *os.File
+	clock     uint64         // the last timestamp handed out
+	reserved  uint64         // the last timestamp reserved on disk
+	committed uint64         // the timestamp of the last commit
+	active    map[uint64]*Tx // the active transactions
+	garbage   []garbage      // the versions waiting to be removed
+}
+
+// VacuumStats holds the results of vacuuming a collection. Vacuum does not merge
+// any pages, so PagesMerged is always zero.
+type VacuumStats struct {
+	buffer.CompactStats
 }
 
 func nsPath(base, name string) string {
 	return filepath.ToSlash(filepath.Join(base, name))
 }
 
-func openCollection(base, name string) (*Collection, error) {
+func openCollection(base, name string, conf *storage.TableSpaceConfig) (*Collection, error) {
 	path := nsPath(base, name)
-	fp, err := storage.Open(path)
+	fp, err := storage.OpenTableSpace(path, conf)
+	if err != nil {
+		return nil, err
+	}
+	lm, err := logging.OpenLogManager(path + walSuffix)
 	if err != nil {
 		return nil, err
 	}
-	bp, err := buffer.New(fp, defaultFrameCount)
+	bp, err := buffer.NewWithConfig(
+		&buffer.Config{
+			PageCount:  defaultFrameCount,
+			Replacer:   buffer.NewClockReplacer(defaultFrameCount),
+			Storer:     fp,
+			LogManager: lm,
+		},
+	)
 	if err != nil {
 		return nil, err
 	}
-	return &Collection{
+	// replay the log, in case we did not shut down cleanly
+	err = bp.Recover()
+	if err != nil {
+		return nil, err
+	}
+	c := &Collection{
 		path: path,
 		pool: bp,
-	}, nil
+		curr: noPID,
+	}
+	// open the clock, and any existing secondary indexes
+	err = c.openClock()
+	if err != nil {
+		return nil, err
+	}
+	err = c.openIndexes()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
 }
 
-func encRecord(data Record) (page.Record, error) {
-	id := make([]byte, 4)
-	binary.LittleEndian.PutUint32(id, data.GetID())
+// encRecord encodes the data record, using the provided version as the key.
+// Large data records are written out to overflow pages by the buffer pool, in
+// which case the returned record is an overflow record.
+func (c *Collection) encRecord(v *version, data Record) (page.Record, error) {
 	rec, err := data.MarshalBinary()
 	if err != nil {
 		return nil, err
 	}
-	return page.NewRecord(page.R_NUM, page.R_STR, id, rec), nil
+	return c.pool.NewRecord(page.R_NUM, page.R_STR, v.key(), rec)
 }
 
 func decRecord(r page.Record, ptr Record) error {
@@ -159,105 +236,121 @@ func decRecord(r page.Record, ptr Record) error {
 	return nil
 }
 
-func (c *Collection) Find(f func(rec Record) bool) ([]Record, error) {
-	// fi, err := os.Stat(c.path)
-	// if err != nil {
-	// 	return nil, err
-	// }
-	// var pg page.Page
-	// var err error
-	// maxPID := uint32(fi.Size()) / page.PageSize
-	//
-	// fn := func(r *page.Record) error {
-	//
-	// 	recr.Val()
-	// }
-	//
-	// for pid := uint32(0); pid < maxPID; pid++ {
-	// 	// fetch the proper page
-	// 	pg, err = c.pool.FetchPage(pid)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	err = pg.RangeRecords(fn)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// }
-	return nil, nil
-}
-
-func (c *Collection) FindOne(id uint64, ptr Record) error {
-	if id == badID {
-		return ErrBadID
-	}
-	// decode id to locate the page
-	rid := page.DecodeRecordID(id)
-	// fetch the proper page
-	pg, err := c.pool.FetchPage(rid.PageID)
+// Find returns the records, as seen by the last commit, that the provided
+// function returns true for. Every record is decoded into a new value of the
+// same type as the provided pointer. The records are read from a snapshot, so
+// the changes committed while Find is running are not seen.
+func (c *Collection) Find(ptr Record, fn func(rec Record) bool) ([]Record, error) {
+	// read using a transaction of its own, so the versions seen by the
+	// snapshot are held on to until we are done
+	tx, err := c.Begin()
 	if err != nil {
-		return err
-	}
-	// attempt to locate the record
-	rec, err := pg.GetRecord(rid)
-	if err != nil {
-		return err
+		return nil, err
 	}
-	// decode into the pointer provided
-	err = decRecord(rec, ptr)
-	// err = ptr.UnmarshalBinary(rec.Val())
-	if err != nil {
-		return err
+	typ := reflect.TypeOf(ptr).Elem()
+	var recs []Record
+	err = tx.scan(
+		func(_ uint64, r page.Record) error {
+			rec := reflect.New(typ).Interface().(Record)
+			err := decRecord(r, rec)
+			if err != nil {
+				return err
+			}
+			if fn == nil || fn(rec) {
+				recs = append(recs, rec)
+			}
+			return nil
+		},
+	)
+	if rerr := tx.Rollback(); err == nil {
+		err = rerr
 	}
-	// unpin the page (not dirty)
-	err = c.pool.UnpinPage(rid.PageID, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+	return recs, nil
+}
+
+// FindOne locates the record matching the provided id, as seen by the last
+// commit, and decodes it into the provided pointer.
+func (c *Collection) FindOne(id uint64, ptr Record) error {
+	return c.findOne(id, ptr, c.snapshot(), 0)
 }
 
-func (c *Collection) FindAll() ([]Record, error) {
-	return nil, nil
+// FindAll returns all the records, as seen by the last commit, each of them
+// decoded into a new value of the same type as the provided pointer.
+func (c *Collection) FindAll(ptr Record) ([]Record, error) {
+	return c.Find(ptr, nil)
 }
 
+// Insert adds the provided record in a transaction of its own, returning its
+// id.
 func (c *Collection) Insert(data Record) (uint64, error) {
-	if c.curr == 0 {
+	id := badID
+	err := c.autoCommit(
+		func(tx *Tx) error {
+			var err error
+			id, err = tx.Insert(data)
+			return err
+		},
+	)
+	if err != nil {
+		return badID, err
+	}
+	return id, nil
+}
+
+// addRecord adds the encoded record to the current page, starting a fresh page
+// if it does not have room, and returns the id of the record. The caller must
+// hold the writer latch.
+func (c *Collection) addRecord(rec page.Record) (uint64, error) {
+	if c.curr == noPID {
+		// we do not have a current page yet, so we will start a fresh one
 		pg, err := c.pool.NewPage()
 		if err != nil {
 			return badID, err
 		}
-		err = c.pool.FlushPage(pg.GetPageID())
+		err = c.pool.UnpinPage(pg.GetPageID(), true)
 		if err != nil {
 			return badID, err
 		}
+		atomic.StoreUint32(&c.curr, pg.GetPageID())
 	}
 fetch:
-	// fetch the current page
-	pg, err := c.pool.FetchPage(c.curr)
-	if err != nil {
-		return badID, err
-	}
-	// encode the data record
-	rec, err := encRecord(data)
+	// fetch the current page (exclusive)
+	pg, err := c.pool.FetchPageWrite(c.curr)
 	if err != nil {
 		return badID, err
 	}
 	// check to ensure it has room
 	if !page.HasRoom(pg, rec) {
-		// no room, so update the current page
-		// and go back to fetch
-		// update the current page
-		atomic.SwapUint32(&c.curr, c.curr+1)
+		// no room, so unpin the current page
+		err = c.pool.UnpinPageWrite(c.curr, false)
+		if err != nil {
+			return badID, err
+		}
+		// allocate a fresh page, because the page following the current
+		// one may very well be an overflow page
+		pg, err = c.pool.NewPage()
+		if err != nil {
+			return badID, err
+		}
+		err = c.pool.UnpinPage(pg.GetPageID(), true)
+		if err != nil {
+			return badID, err
+		}
+		// update the current page and go back to fetch
+		atomic.StoreUint32(&c.curr, pg.GetPageID())
 		goto fetch
 	}
 	// add the encoded record to the page
 	rid, err := pg.AddRecord(rec)
 	if err != nil {
+		c.pool.UnpinPageWrite(c.curr, false)
 		return badID, err
 	}
 	// unpin the page (make sure to mark dirty)
-	err = c.pool.UnpinPage(c.curr, true)
+	err = c.pool.UnpinPageWrite(c.curr, true)
 	if err != nil {
 		return badID, err
 	}
@@ -265,80 +358,141 @@ fetch:
 	return page.EncodeRecordID(rid), nil
 }
 
+// Update replaces the record matching the provided id with the provided record
+// in a transaction of its own, returning the id of the new version.
 func (c *Collection) Update(id uint64, data Record) (uint64, error) {
 	if id == badID {
 		return badID, ErrBadID
 	}
-fetch:
-	// decode id
-	rid := page.DecodeRecordID(id)
-	// fetch the correct page
-	pg, err := c.pool.FetchPage(rid.PageID)
+	nid := badID
+	err := c.autoCommit(
+		func(tx *Tx) error {
+			var err error
+			nid, err = tx.Update(id, data)
+			return err
+		},
+	)
 	if err != nil {
 		return badID, err
 	}
-	// encode the data record
-	rec, err := encRecord(data)
+	return nid, nil
+}
+
+// Delete deletes the record matching the provided id in a transaction of its
+// own.
+func (c *Collection) Delete(id uint64) error {
+	if id == badID {
+		return ErrBadID
+	}
+	return c.autoCommit(
+		func(tx *Tx) error {
+			return tx.Delete(id)
+		},
+	)
+}
+
+// Commit makes everything written to the collection durable, flushing the pages
+// and writing a checkpoint, so the log can be truncated.
+func (c *Collection) Commit() error {
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	return c.commit()
+}
+
+// commit does the work of Commit. The caller must hold the writer latch, so no
+// transaction can commit between the pages being flushed and the checkpoint
+// being written, which would leave pages the log no longer covers unwritten.
+func (c *Collection) commit() error {
+	// commit the transaction, which makes the changes durable in the log
+	err := c.pool.Commit()
 	if err != nil {
-		return badID, err
+		return err
 	}
-	// check to ensure it has room
-	if !page.HasRoom(pg, rec) {
-		// no room, so update the current page
-		// and go back to fetch
-		// update the current page
-		atomic.SwapUint32(&c.curr, c.curr+1)
-		goto fetch
+	// flush the pages, and checkpoint so recovery can skip over them
+	err = c.pool.FlushAll()
+	if err != nil {
+		return err
 	}
-	// delete the current data record
-	err = pg.DelRecord(rid)
+	err = c.pool.Checkpoint()
 	if err != nil {
-		return badID, err
+		return err
 	}
-	// add the encoded record to the page
-	rid, err = pg.AddRecord(rec)
+	// flush the secondary indexes, so they match what was committed
+	return c.rangeIndexes(
+		func(si *secondaryIndex) error {
+			return si.tree.Flush()
+		},
+	)
+}
+
+// Vacuum compacts the collection. It first removes the old record versions that
+// cannot be seen by any snapshot anymore. Then, it walks every page, vacuuming
+// fragmented pages, and freeing the pages that end up empty.
+//
+// Unlike StorageEngine.Compact, Vacuum does not merge sparse pages into their
+// neighbours. A record id is the page and cell the record is stored in, and the
+// ids that have been handed out have to keep working, so a record can never move
+// to another page. A page that still holds a single live record is kept, so a
+// collection can still end up with a lot of sparsely filled pages after heavy
+// deletes. PagesMerged is always zero.
+//
+// Readers can keep using the collection while it runs, but writers have to wait
+// for it to finish. Once it is done, the vacuum (along with anything else
+// outstanding) is committed.
+func (c *Collection) Vacuum() (*VacuumStats, error) {
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	vs := new(VacuumStats)
+	err := c.collectAll()
 	if err != nil {
-		return badID, err
+		return vs, err
+	}
+	stats, err := c.pool.Compact(
+		&buffer.CompactOptions{
+			// records must not move into another page
+			MergeThreshold: -1,
+			Keep: func(pid page.PageID) bool {
+				// the current page is the one being inserted into
+				return pid == c.curr
+			},
+		},
+	)
+	if stats != nil {
+		vs.CompactStats = *stats
 	}
-	// unpin the page (make sure to mark dirty)
-	err = c.pool.UnpinPage(c.curr, true)
 	if err != nil {
-		return badID, err
+		return vs, err
 	}
-	// return the encoded record id
-	return badID, nil
+	return vs, c.commit()
 }
 
-func (c *Collection) Delete(id uint64) error {
-	if id == badID {
-		return ErrBadID
-	}
-	rid := page.DecodeRecordID(id)
-	pg, err := c.pool.FetchPage(rid.PageID)
+// Stats returns the stats of the buffer pool used by the collection.
+func (c *Collection) Stats() *buffer.Stats {
+	return c.pool.Stats()
+}
+
+func (c *Collection) destroy() error {
+	err := c.close()
 	if err != nil {
 		return err
 	}
-	err = pg.DelRecord(rid)
+	err = c.rangeIndexes(
+		func(si *secondaryIndex) error {
+			return os.Remove(idxPath(c.path, si.name))
+		},
+	)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (c *Collection) Commit() error {
-	err := c.pool.FlushAll()
+	err = storage.RemoveTableSpace(c.path)
 	if err != nil {
 		return err
 	}
-	return nil
-}
-
-func (c *Collection) destroy() error {
-	err := c.pool.Close()
+	err = os.Remove(c.path + clockSuffix)
 	if err != nil {
 		return err
 	}
-	err = os.Remove(c.path)
+	err = os.RemoveAll(c.path + walSuffix)
 	if err != nil {
 		return err
 	}
@@ -346,5 +500,17 @@ func (c *Collection) destroy() error {
 }
 
 func (c *Collection) close() error {
+	err := c.rangeIndexes(
+		func(si *secondaryIndex) error {
+			return si.tree.Close()
+		},
+	)
+	if err != nil {
+		return err
+	}
+	err = c.clockFile.Close()
+	if err != nil {
+		return err
+	}
 	return c.pool.Close()
 }
@@ -2,13 +2,23 @@ package engine
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/engine/storage"
 )
 
 func TestDB(t *testing.T) {
 
-	const path = "my/db"
+	path := t.TempDir()
 
 	// open db
 	db, err := OpenDB(path)
@@ -61,11 +71,867 @@ func TestDB(t *testing.T) {
 	if err != nil {
 		t.Errorf("close: %s\n", err)
 	}
+}
+
+func TestDB_Baseline(t *testing.T) {
+
+	// my/db/users.db was written before data files had a file header, so it is
+	// upgraded when it is opened (a copy of it, so the fixture is left alone)
+	path := t.TempDir()
+	data, err := os.ReadFile("my/db/users.db")
+	if err != nil {
+		t.Fatalf("read fixture: %s\n", err)
+	}
+	err = os.WriteFile(filepath.Join(path, "users.db"), data, 0666)
+	if err != nil {
+		t.Fatalf("write fixture: %s\n", err)
+	}
+	// the users were written to page 0, so their ids match the cell ids
+	expected := map[uint64]User{
+		1: {1, "John Doe", "jdoe@example.com", true},
+		2: {2, "Rick Frost", "rfrost@example.com", true},
+		3: {3, "Jack Miller", "jmiller@example.com", true},
+	}
+	for i := 0; i < 2; i++ {
+		db, err := OpenDB(path)
+		if err != nil {
+			t.Fatalf("open: %s\n", err)
+		}
+		users, err := db.Create("users.db")
+		if err != nil {
+			t.Fatalf("create: %s\n", err)
+		}
+		for id, u := range expected {
+			var found User
+			err = users.FindOne(id, &found)
+			if err != nil || found != u {
+				t.Errorf("find one: got %v (%v), expected %v\n", found, err, u)
+			}
+		}
+		if i == 0 {
+			// and it can be written to
+			u := User{4, "Jane Doe", "jane@example.com", true}
+			id, err := users.Insert(&u)
+			if err != nil {
+				t.Fatalf("insert: %s\n", err)
+			}
+			expected[id] = u
+		}
+		err = db.Close()
+		if err != nil {
+			t.Fatalf("close: %s\n", err)
+		}
+	}
+}
+
+func TestDB_LargeRecord(t *testing.T) {
+
+	path := t.TempDir()
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	docs, err := db.Create("docs.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+
+	// insert a user that encodes to a document larger than 64KB
+	u1 := User{1, strings.Repeat("John Doe ", 10000), "jdoe@example.com", true}
+	id, err := docs.Insert(&u1)
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	var found User
+	err = docs.FindOne(id, &found)
+	if err != nil {
+		t.Fatalf("find one: %s\n", err)
+	}
+	if found != u1 {
+		t.Errorf("find one: got name length %d, expected %d\n", len(found.Name), len(u1.Name))
+	}
+
+	// update it with an even larger document
+	u1.Name = strings.Repeat("Jane Doe ", 20000)
+	id, err = docs.Update(id, &u1)
+	if err != nil {
+		t.Fatalf("update: %s\n", err)
+	}
+	err = docs.FindOne(id, &found)
+	if err != nil || found != u1 {
+		t.Errorf("find one: got name length %d (%v), expected %d\n", len(found.Name), err, len(u1.Name))
+	}
+
+	// and finally delete it
+	err = docs.Delete(id)
+	if err != nil {
+		t.Fatalf("delete: %s\n", err)
+	}
+	err = docs.FindOne(id, &found)
+	if err == nil {
+		t.Errorf("find one: expected an error after delete\n")
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_CorruptPage(t *testing.T) {
+
+	path := t.TempDir()
 
-	// err = os.RemoveAll(path)
-	// if err != nil {
-	// 	t.Errorf("remove all: %s\n", err)
-	// }
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	users, err := db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	id, err := users.Insert(&User{1, "John Doe", "jdoe@example.com", true})
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+
+	// tear the page holding the user (the file header takes up the first page)
+	fp, err := os.OpenFile(filepath.Join(path, "users.db"), os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	pid := page.DecodeRecordID(id).PageID
+	_, err = fp.WriteAt([]byte("torn"), int64(pid+1)*page.PageSize+100)
+	if err != nil {
+		t.Fatalf("write: %s\n", err)
+	}
+	err = fp.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %s\n", err)
+	}
+	users, err = db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	var u User
+	err = users.FindOne(id, &u)
+	var cpe *storage.CorruptPageError
+	if !errors.As(err, &cpe) || cpe.PageID != pid {
+		t.Errorf("find one: expected a corrupt page error for page %d, got %v\n", pid, err)
+	}
+	// a page that has never been allocated is an error, rather than a crash
+	err = users.FindOne(uint64(500)<<32, &u)
+	if err == nil {
+		t.Errorf("find one: expected an error for a page that was never allocated\n")
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_ParallelInsert(t *testing.T) {
+
+	path := t.TempDir()
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+
+	// ingest into a few separate namespaces at the same time
+	const namespaces, count = 4, 500
+	var wg sync.WaitGroup
+	for n := 0; n < namespaces; n++ {
+		ns, err := db.Create(fmt.Sprintf("users-%d.db", n))
+		if err != nil {
+			t.Fatalf("create: %s\n", err)
+		}
+		wg.Add(1)
+		go func(ns Namespace) {
+			defer wg.Done()
+			ids := make([]uint64, count)
+			for i := range ids {
+				id, err := ns.Insert(&User{uint32(i), fmt.Sprintf("user #%d", i), "user@example.com", true})
+				if err != nil {
+					t.Errorf("insert: %s\n", err)
+					return
+				}
+				ids[i] = id
+			}
+			for i, id := range ids {
+				var u User
+				if err := ns.FindOne(id, &u); err != nil || u.ID != uint32(i) {
+					t.Errorf("find one: got user %d (%v), expected %d\n", u.ID, err, i)
+					return
+				}
+			}
+		}(ns)
+	}
+	wg.Wait()
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_TableSpace(t *testing.T) {
+
+	path := t.TempDir()
+
+	// use tiny segment files, so the namespace has to span several of them
+	conf := &storage.TableSpaceConfig{
+		MaxFileSize: 5 * page.PageSize,
+		Stripes:     2,
+	}
+	db, err := OpenDBWithConfig(path, conf)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	users, err := db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	ids := make(map[uint64]User)
+	for i := 0; i < 1000; i++ {
+		u := User{uint32(i), fmt.Sprintf("user #%d", i), strings.Repeat("x", 100), true}
+		id, err := users.Insert(&u)
+		if err != nil {
+			t.Fatalf("insert: %s\n", err)
+		}
+		ids[id] = u
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+	segs, _ := filepath.Glob(filepath.Join(path, "users.db*"))
+	if len(segs) < 4 {
+		t.Errorf("expected the namespace to span several segment files, got %v\n", segs)
+	}
+
+	// reopen, and make sure everything is still there
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %s\n", err)
+	}
+	users, err = db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	for id, u := range ids {
+		var found User
+		err = users.FindOne(id, &found)
+		if err != nil || found != u {
+			t.Fatalf("find one: got %v (%v), expected %v\n", found, err, u)
+		}
+	}
+	err = db.Drop("users.db")
+	if err != nil {
+		t.Fatalf("drop: %s\n", err)
+	}
+	if segs, _ = filepath.Glob(filepath.Join(path, "users.db*")); len(segs) > 0 {
+		t.Errorf("drop: files left behind: %v\n", segs)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_Vacuum(t *testing.T) {
+
+	path := t.TempDir()
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	users, err := db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	ids := make(map[uint64]User)
+	for i := 0; i < 1000; i++ {
+		u := User{uint32(i), fmt.Sprintf("user #%d", i), strings.Repeat("x", 100), true}
+		id, err := users.Insert(&u)
+		if err != nil {
+			t.Fatalf("insert: %s\n", err)
+		}
+		ids[id] = u
+	}
+	// delete most of the users, leaving some pages sparse and others empty
+	for id, u := range ids {
+		if u.ID%10 != 0 || (u.ID >= 200 && u.ID < 800) {
+			err = users.Delete(id)
+			if err != nil {
+				t.Fatalf("delete: %s\n", err)
+			}
+			delete(ids, id)
+		}
+	}
+	err = users.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s\n", err)
+	}
+	// a reader holding on to the ids keeps finding the users
+	tx, err := users.Begin()
+	if err != nil {
+		t.Fatalf("begin: %s\n", err)
+	}
+	stats, err := users.Vacuum()
+	if err != nil {
+		t.Fatalf("vacuum: %s\n", err)
+	}
+	if stats.BytesReclaimed <= 0 || stats.PagesVacuumed <= 0 || stats.PagesFreed <= 0 {
+		t.Errorf("vacuum: expected pages to be vacuumed and freed, got %+v\n", stats.CompactStats)
+	}
+	if stats.PagesMerged != 0 {
+		t.Errorf("vacuum: expected no pages to be merged, got %d\n", stats.PagesMerged)
+	}
+	for id, u := range ids {
+		var found User
+		err = tx.FindOne(id, &found)
+		if err != nil || found != u {
+			t.Fatalf("find one: got %v (%v), expected %v\n", found, err, u)
+		}
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("rollback: %s\n", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+
+	// reopen, and make sure everything is still there
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %s\n", err)
+	}
+	users, err = db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	for id, u := range ids {
+		var found User
+		err = users.FindOne(id, &found)
+		if err != nil || found != u {
+			t.Fatalf("find one: got %v (%v), expected %v\n", found, err, u)
+		}
+	}
+	// and that it can still be written to
+	_, err = users.Insert(&User{1000, "user #1000", "", true})
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_Index(t *testing.T) {
+
+	path := t.TempDir()
+
+	byEmail := func(rec Record) []byte {
+		return []byte(rec.(*User).Email)
+	}
+	// only the active users are indexed by name
+	byName := func(rec Record) []byte {
+		if u := rec.(*User); u.IsActive {
+			return []byte(u.Name)
+		}
+		return nil
+	}
+	email := func(i int) []byte {
+		return []byte(fmt.Sprintf("user-%.4d@example.com", i))
+	}
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	users, err := db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	err = users.CreateIndex("email", new(User), byEmail)
+	if err != nil {
+		t.Fatalf("create index: %s\n", err)
+	}
+	// hang on to the empty index, to stand in for one that missed some
+	// changes later on
+	stale, err := os.ReadFile(filepath.Join(path, "users.db.email.idx"))
+	if err != nil {
+		t.Fatalf("read index: %s\n", err)
+	}
+	ids := make(map[int]uint64)
+	for i := 0; i < 500; i++ {
+		u := User{uint32(i), fmt.Sprintf("user #%.4d", i), string(email(i)), i%2 == 0}
+		id, err := users.Insert(&u)
+		if err != nil {
+			t.Fatalf("insert: %s\n", err)
+		}
+		ids[i] = id
+	}
+	// this one is built using the users that are already there
+	err = users.CreateIndex("name", new(User), byName)
+	if err != nil {
+		t.Fatalf("create index: %s\n", err)
+	}
+
+	findBy := func(index string, key []byte, expected ...uint64) {
+		t.Helper()
+		got, err := users.FindBy(index, key)
+		if err != nil {
+			t.Fatalf("find by %s %q: %s\n", index, key, err)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(expected) {
+			t.Errorf("find by %s %q: expected %v, got %v\n", index, key, expected, got)
+		}
+	}
+	findBy("email", email(42), ids[42])
+	findBy("email", []byte("nobody@example.com"))
+	findBy("name", []byte("user #0042"), ids[42])
+	findBy("name", []byte("user #0043"))
+	got, err := users.FindRange("email", email(100), email(109))
+	if err != nil || len(got) != 10 {
+		t.Fatalf("find range: expected 10 ids, got %d (%v)\n", len(got), err)
+	}
+	for n, id := range got {
+		var u User
+		err = users.FindOne(id, &u)
+		if err != nil || u.ID != uint32(100+n) {
+			t.Errorf("find range: expected user %d, got %v (%v)\n", 100+n, u, err)
+		}
+	}
+	got, err = users.FindRange("name", nil, nil)
+	if err != nil || len(got) != 250 {
+		t.Errorf("find range: expected 250 ids, got %d (%v)\n", len(got), err)
+	}
+	_, err = users.FindBy("phone", nil)
+	if !errors.Is(err, ErrIndexNotFound) {
+		t.Errorf("find by: expected %v, got %v\n", ErrIndexNotFound, err)
+	}
+
+	// several users can share a key, and the indexes follow updates and deletes
+	ids[1], err = users.Update(ids[1], &User{1, "user #0001", string(email(2)), true})
+	if err != nil {
+		t.Fatalf("update: %s\n", err)
+	}
+	findBy("email", email(1))
+	findBy("email", email(2), ids[2], ids[1])
+	findBy("name", []byte("user #0001"), ids[1])
+	err = users.Delete(ids[2])
+	if err != nil {
+		t.Fatalf("delete: %s\n", err)
+	}
+	findBy("email", email(2), ids[1])
+	findBy("name", []byte("user #0002"))
+
+	// keys that are too large to be indexed are turned down, leaving
+	// everything as it was
+	long := strings.Repeat("x", 250) + "@example.com"
+	_, err = users.Insert(&User{600, "user #0600", long, true})
+	if !errors.Is(err, ErrIndexKeyTooLarge) {
+		t.Errorf("insert: expected %v, got %v\n", ErrIndexKeyTooLarge, err)
+	}
+	_, err = users.Update(ids[1], &User{1, "user #0001", long, true})
+	if !errors.Is(err, ErrIndexKeyTooLarge) {
+		t.Errorf("update: expected %v, got %v\n", ErrIndexKeyTooLarge, err)
+	}
+	findBy("email", email(2), ids[1])
+	findBy("name", []byte("user #0001"), ids[1])
+	err = users.CreateIndex("long", new(User), func(Record) []byte { return []byte(long) })
+	if !errors.Is(err, ErrIndexKeyTooLarge) {
+		t.Errorf("create index: expected %v, got %v\n", ErrIndexKeyTooLarge, err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "users.db.long.idx")); !os.IsNotExist(err) {
+		t.Errorf("create index: file was left behind (%v)\n", err)
+	}
+	err = users.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s\n", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+
+	// reopen; the key functions have to be registered again before the
+	// indexes can be used, or anything can be written, and registering
+	// them again rebuilds them, so a stale index is not a problem
+	err = os.WriteFile(filepath.Join(path, "users.db.email.idx"), stale, 0644)
+	if err != nil {
+		t.Fatalf("write index: %s\n", err)
+	}
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %s\n", err)
+	}
+	users, err = db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	_, err = users.FindBy("email", email(42))
+	if !errors.Is(err, ErrIndexNotRegistered) || !strings.Contains(err.Error(), `"email"`) {
+		t.Errorf("find by: expected %v for email, got %v\n", ErrIndexNotRegistered, err)
+	}
+	_, err = users.Insert(&User{500, "user #0500", string(email(500)), true})
+	if !errors.Is(err, ErrIndexNotRegistered) {
+		t.Errorf("insert: expected %v, got %v\n", ErrIndexNotRegistered, err)
+	}
+	for name, fn := range map[string]IndexFunc{"email": byEmail, "name": byName} {
+		err = users.CreateIndex(name, new(User), fn)
+		if err != nil {
+			t.Fatalf("create index: %s\n", err)
+		}
+	}
+	findBy("email", email(42), ids[42])
+	findBy("email", email(2), ids[1])
+	findBy("name", []byte("user #0042"), ids[42])
+	ids[500], err = users.Insert(&User{500, "user #0500", string(email(500)), true})
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	findBy("email", email(500), ids[500])
+
+	// vacuuming leaves the users where they are, so the indexes still match
+	for i := 3; i < 500; i++ {
+		if i%10 != 0 {
+			err = users.Delete(ids[i])
+			if err != nil {
+				t.Fatalf("delete: %s\n", err)
+			}
+			delete(ids, i)
+		}
+	}
+	_, err = users.Vacuum()
+	if err != nil {
+		t.Fatalf("vacuum: %s\n", err)
+	}
+	for i, id := range ids {
+		if i != 1 && i != 2 {
+			findBy("email", email(i), id)
+		}
+	}
+	err = users.DropIndex("name")
+	if err != nil {
+		t.Fatalf("drop index: %s\n", err)
+	}
+	if _, err = os.Stat(filepath.Join(path, "users.db.name.idx")); !os.IsNotExist(err) {
+		t.Errorf("drop index: file was left behind (%v)\n", err)
+	}
+	err = db.Drop("users.db")
+	if err != nil {
+		t.Fatalf("drop: %s\n", err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(path, "users.db*")); len(matches) > 0 {
+		t.Errorf("drop: files left behind: %v\n", matches)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_Tx(t *testing.T) {
+
+	path := t.TempDir()
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	ns, err := db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	users := ns.(*Collection)
+	u1 := User{1, "John Doe", "jdoe@example.com", true}
+	id1, err := users.Insert(&u1)
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	u2 := User{2, "Rick Frost", "rfrost@example.com", true}
+	id2, err := users.Insert(&u2)
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+
+	find := func(name string, find func(uint64, Record) error, id uint64, expected *User) {
+		t.Helper()
+		var u User
+		err := find(id, &u)
+		if expected == nil {
+			if !errors.Is(err, page.ErrRecordNotFound) {
+				t.Errorf("%s: expected %d to be missing, got %v (%v)\n", name, id, u, err)
+			}
+			return
+		}
+		if err != nil || u != *expected {
+			t.Errorf("%s: expected %v, got %v (%v)\n", name, *expected, u, err)
+		}
+	}
+
+	// take a snapshot, and change things from under it
+	snap, err := users.Begin()
+	if err != nil {
+		t.Fatalf("begin: %s\n", err)
+	}
+	tx, err := users.Begin()
+	if err != nil {
+		t.Fatalf("begin: %s\n", err)
+	}
+	u1b := User{1, "John Doe", "john@example.com", false}
+	id1b, err := tx.Update(id1, &u1b)
+	if err != nil {
+		t.Fatalf("update: %s\n", err)
+	}
+	u3 := User{3, "Jack Miller", "jmiller@example.com", true}
+	id3, err := tx.Insert(&u3)
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	// the changes are only seen by the transaction making them
+	find("tx", tx.FindOne, id1, nil)
+	find("tx", tx.FindOne, id1b, &u1b)
+	find("tx", tx.FindOne, id3, &u3)
+	find("ns", users.FindOne, id1, &u1)
+	find("ns", users.FindOne, id1b, nil)
+	find("ns", users.FindOne, id3, nil)
+	err = tx.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s\n", err)
+	}
+	if err = tx.Commit(); err != ErrTxDone {
+		t.Errorf("commit: expected %v, got %v\n", ErrTxDone, err)
+	}
+	// now everybody sees them, except for the snapshot
+	find("ns", users.FindOne, id1, nil)
+	find("ns", users.FindOne, id1b, &u1b)
+	find("ns", users.FindOne, id3, &u3)
+	find("snap", snap.FindOne, id1, &u1)
+	find("snap", snap.FindOne, id1b, nil)
+	find("snap", snap.FindOne, id3, nil)
+
+	// the snapshot keeps seeing the same records while others are inserted
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 4; i < 300; i++ {
+			_, err := users.Insert(&User{uint32(i), fmt.Sprintf("user #%d", i), "", true})
+			if err != nil {
+				t.Errorf("insert: %s\n", err)
+				return
+			}
+		}
+	}()
+	for stop := false; !stop; {
+		select {
+		case <-done:
+			stop = true
+		default:
+		}
+		var seen []uint32
+		err = snap.Range(
+			new(User), func(id uint64, rec Record) bool {
+				seen = append(seen, rec.GetID())
+				return true
+			},
+		)
+		if err != nil {
+			t.Fatalf("range: %s\n", err)
+		}
+		if fmt.Sprint(seen) != "[1 2]" {
+			t.Fatalf("range: expected the snapshot to see users [1 2], got %v\n", seen)
+		}
+	}
+
+	// changes made to the same record by two transactions conflict
+	tx1, _ := users.Begin()
+	tx2, _ := users.Begin()
+	err = tx1.Delete(id2)
+	if err != nil {
+		t.Fatalf("delete: %s\n", err)
+	}
+	if err = tx2.Delete(id2); err != ErrTxConflict {
+		t.Errorf("delete: expected %v, got %v\n", ErrTxConflict, err)
+	}
+	err = tx1.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s\n", err)
+	}
+	if _, err = tx2.Update(id2, &u2); err != ErrTxConflict {
+		t.Errorf("update: expected %v, got %v\n", ErrTxConflict, err)
+	}
+	// and rolling back throws the changes away
+	err = tx2.Rollback()
+	if err != nil {
+		t.Fatalf("rollback: %s\n", err)
+	}
+	tx3, _ := users.Begin()
+	id4, err := tx3.Insert(&User{4, "Nobody", "", false})
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	err = tx3.Delete(id3)
+	if err != nil {
+		t.Fatalf("delete: %s\n", err)
+	}
+	err = tx3.Rollback()
+	if err != nil {
+		t.Fatalf("rollback: %s\n", err)
+	}
+	find("ns", users.FindOne, id3, &u3)
+	if _, err = users.pool.GetRecord(page.DecodeRecordID(id4)); err == nil {
+		t.Errorf("rollback: the inserted record is still there\n")
+	}
+
+	// find only sees what has been committed
+	tx4, _ := users.Begin()
+	_, err = tx4.Insert(&User{5, "Nobody", "", false})
+	if err != nil {
+		t.Fatalf("insert: %s\n", err)
+	}
+	all, err := users.FindAll(new(User))
+	if err != nil || len(all) != 298 {
+		t.Errorf("find all: expected 298 users, got %d (%v)\n", len(all), err)
+	}
+	ids := make(map[uint32]bool)
+	for _, rec := range all {
+		ids[rec.GetID()] = true
+	}
+	if len(ids) != len(all) || !ids[1] || ids[2] || !ids[3] {
+		t.Errorf("find all: expected users 1, 3 and 4 through 299, got %v\n", ids)
+	}
+	inactive, err := users.Find(
+		new(User), func(rec Record) bool {
+			return !rec.(*User).IsActive
+		},
+	)
+	if err != nil || len(inactive) != 1 || *inactive[0].(*User) != u1b {
+		t.Errorf("find: expected [%v], got %v (%v)\n", u1b, inactive, err)
+	}
+	err = tx4.Rollback()
+	if err != nil {
+		t.Fatalf("rollback: %s\n", err)
+	}
+
+	// the old versions stick around until the snapshot is done with them
+	for _, id := range []uint64{id1, id2} {
+		if _, err = users.pool.GetRecord(page.DecodeRecordID(id)); err != nil {
+			t.Errorf("the old version %d is gone before the snapshot is done: %s\n", id, err)
+		}
+	}
+	err = snap.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s\n", err)
+	}
+	for _, id := range []uint64{id1, id2} {
+		if _, err = users.pool.GetRecord(page.DecodeRecordID(id)); err == nil {
+			t.Errorf("the old version %d was not removed\n", id)
+		}
+	}
+
+	// everything committed is there after reopening
+	err = db.Close()
+	if err != nil {
+		t.Fatalf("close: %s\n", err)
+	}
+	db, err = OpenDB(path)
+	if err != nil {
+		t.Fatalf("reopen: %s\n", err)
+	}
+	ns, err = db.Create("users.db")
+	if err != nil {
+		t.Fatalf("create: %s\n", err)
+	}
+	find("reopen", ns.FindOne, id1b, &u1b)
+	find("reopen", ns.FindOne, id2, nil)
+	find("reopen", ns.FindOne, id3, &u3)
+	tx, err = ns.Begin()
+	if err != nil {
+		t.Fatalf("begin: %s\n", err)
+	}
+	var n int
+	err = tx.Range(
+		new(User), func(id uint64, rec Record) bool {
+			n++
+			return true
+		},
+	)
+	if err != nil || n != 298 {
+		t.Errorf("range: expected 298 users, got %d (%v)\n", n, err)
+	}
+	err = tx.Rollback()
+	if err != nil {
+		t.Fatalf("rollback: %s\n", err)
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
+}
+
+func TestDB_HandleMetrics(t *testing.T) {
+
+	path := t.TempDir()
+
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s\n", err)
+	}
+	for _, name := range []string{"users.db", "orders.db"} {
+		ns, err := db.Create(name)
+		if err != nil {
+			t.Fatalf("create: %s\n", err)
+		}
+		id, err := ns.Insert(&User{1, "John Doe", "jdoe@example.com", true})
+		if err != nil {
+			t.Fatalf("insert: %s\n", err)
+		}
+		err = ns.FindOne(id, new(User))
+		if err != nil {
+			t.Fatalf("find one: %s\n", err)
+		}
+	}
+	stats := db.Stats()
+	if len(stats) != 2 || stats["users.db"] == nil || stats["users.db"].Resident == 0 {
+		t.Errorf("stats: got %v\n", stats)
+	}
+
+	rec := httptest.NewRecorder()
+	db.HandleMetrics().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("content type: got %q\n", ct)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE go_data_buffer_hits_total counter",
+		`go_data_buffer_frames{namespace="orders.db"} 64`,
+		`go_data_buffer_frames{namespace="users.db"} 64`,
+		`go_data_buffer_pin_waits_total{namespace="users.db"} 0`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics: missing %q in:\n%s", line, body)
+		}
+	}
+	err = db.Close()
+	if err != nil {
+		t.Errorf("close: %s\n", err)
+	}
 }
 
 type User struct {
@@ -1,11 +1,47 @@
 package index
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+
 	"github.com/cagnosolutions/go-data/pkg/engine/buffer"
 	"github.com/cagnosolutions/go-data/pkg/engine/page"
 	"github.com/cagnosolutions/go-data/pkg/engine/storage"
 )
 
+// index errors
+var (
+	ErrKeyNotFound    = errors.New("index: key not found")
+	ErrRecordTooLarge = errors.New("index: record is too large to fit in a node")
+)
+
+const (
+	// metaPID is the page ID of the meta page. The meta page holds a single
+	// pointer record that points to the current root node. Because the meta
+	// page always occupies page zero, a page pointer of zero can be used to
+	// indicate that a leaf has no sibling.
+	metaPID page.PageID = 0
+
+	// defaultFrameCount is the number of frames used by the buffer pool when
+	// the tree opens its own storage.
+	defaultFrameCount = 16
+
+	// cellPtrSize is the size of the cell pointer each record uses in a page.
+	cellPtrSize = 8
+
+	// minFillFactor is used to determine when a node is considered to be
+	// underfull. A node holding less than 1/minFillFactor of its capacity
+	// will attempt to merge with one of its siblings.
+	minFillFactor = 4
+)
+
+// metaRootKey is the key of the root pointer record in the meta page.
+var metaRootKey = []byte("root")
+
 // node represents a b plus tree node.
 type node struct {
 	page.Page
@@ -20,56 +56,712 @@ func (n *node) numKeys() uint16 {
 	return ph.Cells - ph.Free
 }
 
-// parent returns the parent of the current node along with a
-// boolean indicating true if the node has a parent and false
-// if it does not.
-func (n *node) parent() (ptr, bool) {
-	return 0, false
+// isLeaf returns a boolean indicating true if this node is a leaf node.
+func (n *node) isLeaf() bool {
+	return n.HasFlag(page.P_LEAF)
+}
+
+// isRoot returns a boolean indicating true if this node is the root node.
+func (n *node) isRoot() bool {
+	return n.GetFlags()&page.P_ROOT == page.P_ROOT
+}
+
+// setFlags sets the node type flags in the page header.
+func (n *node) setFlags(leaf, root bool) {
+	flags := page.P_USED
+	if leaf {
+		flags |= page.P_LEAF
+	} else {
+		flags |= page.P_NODE
+	}
+	if root {
+		flags |= page.P_ROOT
+	}
+	h := n.GetPageHeader()
+	h.Flags = flags
+	n.SetPageHeader(h)
+}
+
+// setLinks sets the previous and next sibling pointers in the page header.
+func (n *node) setLinks(prev, next ptr) {
+	h := n.GetPageHeader()
+	h.Prev = prev
+	h.Next = next
+	n.SetPageHeader(h)
+}
+
+// records returns a copy of all the records in the node in sorted order.
+func (n *node) records() []page.Record {
+	recs := make([]page.Record, 0, n.numKeys())
+	_ = n.RangeRecords(
+		func(r *page.Record) error {
+			rc := make(page.Record, len(*r))
+			copy(rc, *r)
+			recs = append(recs, rc)
+			return nil
+		},
+	)
+	return recs
 }
 
-func (n *node) min() uint32 {
-	// if n.n.getPrev()
-	return 0
+// child returns the child pointer of the record at the provided position. It
+// should only be called on internal nodes.
+func (n *node) child(pos int) (ptr, error) {
+	_, r, err := n.GetRecordAt(pos)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(r.Val()), nil
 }
 
-// pageTree is a b plus tree wrapping the page cache.
-type pageTree struct {
-	cache *buffer.BufferPoolManager
-	store storage.Storer
-	root  page.PageID
+// childPos returns the position of the record pointing to the provided child.
+func (n *node) childPos(c ptr) (int, bool) {
+	for pos := 0; pos < int(n.numKeys()); pos++ {
+		cp, err := n.child(pos)
+		if err != nil {
+			break
+		}
+		if cp == c {
+			return pos, true
+		}
+	}
+	return -1, false
 }
 
-// newPageTree initializes and returns a new pageTree instance.
-func newPageTree(path string) (*pageTree, error) {
+// delRecordAt removes the record found at the provided sorted position.
+func (n *node) delRecordAt(pos int) error {
+	rid, _, err := n.GetRecordAt(pos)
+	if err != nil {
+		return err
+	}
+	return n.DelRecord(rid)
+}
+
+// reset wipes the node and fills it with the provided records. The page ID,
+// flags, sibling pointers and log sequence number are retained.
+func (n *node) reset(recs []page.Record) error {
+	h := n.GetPageHeader()
+	copy(n.Page, page.NewPageSize(h.ID, h.Flags, len(n.Page)))
+	n.setLinks(h.Prev, h.Next)
+	n.SetLSN(h.LSN)
+	for _, r := range recs {
+		if _, err := n.AddRecord(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newPtrRecord creates a pointer record using the provided key and page ID. The
+// key type of the pointer record matches the provided key type.
+func newPtrRecord(keyType uint8, key []byte, pid ptr) page.Record {
+	val := make([]byte, 4)
+	binary.LittleEndian.PutUint32(val, pid)
+	if keyType == page.R_NUM&page.R_KEY {
+		return page.NewRecord(page.R_NUM, page.R_PTR, key, val)
+	}
+	return page.NewRecord(page.R_STR, page.R_PTR, key, val)
+}
+
+// sizeOf returns the number of bytes a set of records occupy inside a page.
+func sizeOf(recs []page.Record) int {
+	var size int
+	for _, r := range recs {
+		size += len(r) + cellPtrSize
+	}
+	return size
+}
+
+// insertSorted inserts the provided record into the sorted set of records.
+func insertSorted(recs []page.Record, r page.Record) []page.Record {
+	i := 0
+	for i < len(recs) && bytes.Compare(recs[i].Key(), r.Key()) < 0 {
+		i++
+	}
+	recs = append(recs, nil)
+	copy(recs[i+1:], recs[i:])
+	recs[i] = r
+	return recs
+}
+
+// splitPoint returns the index where the provided set of records should be
+// split in order to divide the occupied bytes as evenly as possible. It will
+// always leave at least one record on each side.
+func splitPoint(recs []page.Record) int {
+	half := sizeOf(recs) / 2
+	var size, i int
+	for i = 0; i < len(recs)-1; i++ {
+		size += len(recs[i]) + cellPtrSize
+		if size >= half {
+			break
+		}
+	}
+	if i < 1 {
+		i = 1
+	}
+	return i
+}
+
+// PageTree is a b plus tree wrapping the page cache. Each node in the tree is
+// a page. Leaf nodes hold the records, and are linked together through the page
+// header, and internal nodes hold pointer records pointing to their children.
+type PageTree struct {
+	latch    sync.RWMutex
+	cache    *buffer.BufferPoolManager
+	root     page.PageID
+	capacity int
+}
+
+// OpenPageTree opens an existing tree located at the provided path, or creates
+// a new one if it does not exist, and returns it.
+func OpenPageTree(path string) (*PageTree, error) {
+	var exists bool
+	fi, err := os.Stat(filepath.ToSlash(path))
+	if err == nil && fi.Size() > 0 {
+		exists = true
+	}
 	st, err := storage.Open(path)
 	if err != nil {
 		return nil, err
 	}
-	pc, err := buffer.New(st, 5)
+	pc, err := buffer.New(st, defaultFrameCount)
+	if err != nil {
+		return nil, err
+	}
+	ep := page.NewPageSize(0, page.P_USED, pc.PageSize())
+	pt := &PageTree{
+		cache:    pc,
+		capacity: int(ep.GetUpper() - ep.GetLower()),
+	}
+	if exists {
+		err = pt.load()
+	} else {
+		err = pt.init()
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &pageTree{
-		cache: pc,
-	}, nil
+	return pt, nil
+}
+
+// init initializes the meta page and an empty root leaf.
+func (pt *PageTree) init() error {
+	meta, err := pt.cache.NewPage()
+	if err != nil {
+		return err
+	}
+	if meta.GetPageID() != metaPID {
+		return page.ErrInvalidPID
+	}
+	pg, err := pt.cache.NewPage()
+	if err != nil {
+		return err
+	}
+	root := &node{pg}
+	root.setFlags(true, true)
+	pt.root = root.GetPageID()
+	_, err = meta.AddRecord(newPtrRecord(page.R_STR&page.R_KEY, metaRootKey, pt.root))
+	if err != nil {
+		return err
+	}
+	err = pt.cache.UnpinPage(pt.root, true)
+	if err != nil {
+		return err
+	}
+	return pt.cache.UnpinPage(metaPID, true)
 }
 
-func encodeNode(n *node) {
-	// newPtrRecord()
+// load reads the meta page and locates the root node.
+func (pt *PageTree) load() error {
+	meta, err := pt.fetch(metaPID)
+	if err != nil {
+		return err
+	}
+	defer pt.cache.UnpinPage(metaPID, false)
+	pos, found := meta.SearchRecords(metaRootKey)
+	if !found {
+		return ErrKeyNotFound
+	}
+	pt.root, err = meta.child(pos)
+	return err
+}
+
+// setRoot updates the root pointer in the meta page.
+func (pt *PageTree) setRoot(pid page.PageID) error {
+	meta, err := pt.fetch(metaPID)
+	if err != nil {
+		return err
+	}
+	if pos, found := meta.SearchRecords(metaRootKey); found {
+		if err = meta.delRecordAt(pos); err != nil {
+			return err
+		}
+	}
+	err = meta.reset(append(meta.records(), newPtrRecord(page.R_STR&page.R_KEY, metaRootKey, pid)))
+	if err != nil {
+		return err
+	}
+	pt.root = pid
+	return pt.cache.UnpinPage(metaPID, true)
+}
+
+// fetch fetches and pins the node for the provided page ID.
+func (pt *PageTree) fetch(pid page.PageID) (*node, error) {
+	pg, err := pt.cache.FetchPage(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &node{pg}, nil
+}
+
+// allocate creates and pins a new node.
+func (pt *PageTree) allocate(leaf bool) (*node, error) {
+	pg, err := pt.cache.NewPage()
+	if err != nil {
+		return nil, err
+	}
+	n := &node{pg}
+	n.setFlags(leaf, false)
+	return n, nil
+}
+
+// addRecord attempts to add the record to the node. If the node does not have
+// enough contiguous free space, but the live records would fit, it compacts the
+// node first. It returns false if the record could not fit.
+func (pt *PageTree) addRecord(n *node, r page.Record) (bool, error) {
+	if page.HasRoom(n.Page, r) {
+		_, err := n.AddRecord(r)
+		return err == nil, err
+	}
+	recs := n.records()
+	if sizeOf(recs)+len(r)+cellPtrSize > pt.capacity {
+		return false, nil
+	}
+	err := n.reset(append(recs, r))
+	return err == nil, err
+}
+
+// Insert takes a record and inserts it into the tree. If the record key already
+// exists within the tree, it will perform an upsert.
+func (pt *PageTree) Insert(r page.Record) error {
+	pt.latch.Lock()
+	defer pt.latch.Unlock()
+	return pt.insert(&r)
 }
 
 // insert takes a record and inserts it into the tree causing the
 // tree to be adjusted according to the value of the key, in order
 // to maintain the tree's properties. If the record key already
 // exists within the tree, it will perform an upsert.
-func (pt *pageTree) insert(r *page.Record) error {
-	// If the root of the tree is
-	return nil
+func (pt *PageTree) insert(r *page.Record) error {
+	// Make sure the record is small enough that a split node can always
+	// accommodate it.
+	if len(*r)+cellPtrSize > pt.capacity/2 {
+		return ErrRecordTooLarge
+	}
+	// Locate the leaf the record belongs in, along with the path to it.
+	pid, path, err := pt.findLeaf(r.Key())
+	if err != nil {
+		return err
+	}
+	n, err := pt.fetch(pid)
+	if err != nil {
+		return err
+	}
+	// If the key already exists, remove the old record first.
+	if pos, found := n.SearchRecords(r.Key()); found {
+		if err = n.delRecordAt(pos); err != nil {
+			pt.cache.UnpinPage(pid, true)
+			return err
+		}
+	}
+	ok, err := pt.addRecord(n, *r)
+	if err != nil || ok {
+		pt.cache.UnpinPage(pid, true)
+		return err
+	}
+	// Otherwise, the leaf is full, and it must be split.
+	sep, right, err := pt.splitLeaf(n, *r)
+	pt.cache.UnpinPage(pid, true)
+	if err != nil {
+		return err
+	}
+	return pt.insertParent(path, r.KeyType(), pid, sep, right)
+}
+
+// splitLeaf splits the provided leaf into two leaves, adding the provided record
+// to the correct one. It returns the separator key and the new right leaf.
+func (pt *PageTree) splitLeaf(n *node, r page.Record) ([]byte, ptr, error) {
+	recs := insertSorted(n.records(), r)
+	i := splitPoint(recs)
+	// Allocate the new right sibling and link it into the leaf chain.
+	right, err := pt.allocate(true)
+	if err != nil {
+		return nil, 0, err
+	}
+	rpid := right.GetPageID()
+	defer pt.cache.UnpinPage(rpid, true)
+	next := n.GetNext()
+	right.setLinks(n.GetPageID(), next)
+	if next != 0 {
+		nn, err := pt.fetch(next)
+		if err != nil {
+			return nil, 0, err
+		}
+		nn.setLinks(rpid, nn.GetNext())
+		pt.cache.UnpinPage(next, true)
+	}
+	n.setLinks(n.GetPrev(), rpid)
+	n.setFlags(true, false)
+	// Distribute the records.
+	if err = n.reset(recs[:i]); err != nil {
+		return nil, 0, err
+	}
+	if err = right.reset(recs[i:]); err != nil {
+		return nil, 0, err
+	}
+	sep := make([]byte, len(recs[i].Key()))
+	copy(sep, recs[i].Key())
+	return sep, rpid, nil
+}
+
+// splitNode splits the provided internal node into two nodes, adding the provided
+// pointer record to the correct one. It returns the separator key that must be
+// pushed up to the parent and the new right node.
+func (pt *PageTree) splitNode(n *node, r page.Record) ([]byte, ptr, error) {
+	recs := insertSorted(n.records(), r)
+	i := splitPoint(recs)
+	right, err := pt.allocate(false)
+	if err != nil {
+		return nil, 0, err
+	}
+	rpid := right.GetPageID()
+	defer pt.cache.UnpinPage(rpid, true)
+	n.setFlags(false, false)
+	// The key of the first record in the right node is pushed up into the
+	// parent, and is replaced with an empty (minimum) key.
+	sep := make([]byte, len(recs[i].Key()))
+	copy(sep, recs[i].Key())
+	first := newPtrRecord(recs[i].KeyType(), nil, binary.LittleEndian.Uint32(recs[i].Val()))
+	if err = n.reset(recs[:i]); err != nil {
+		return nil, 0, err
+	}
+	if err = right.reset(append([]page.Record{first}, recs[i+1:]...)); err != nil {
+		return nil, 0, err
+	}
+	return sep, rpid, nil
+}
+
+// insertParent inserts the separator key and right pointer into the parent of
+// the left node. The parent is the last node in the provided path. If there is
+// no parent, a new root is created.
+func (pt *PageTree) insertParent(path []ptr, kt uint8, left ptr, sep []byte, right ptr) error {
+	if len(path) == 0 {
+		return pt.newRoot(kt, left, sep, right)
+	}
+	pid := path[len(path)-1]
+	n, err := pt.fetch(pid)
+	if err != nil {
+		return err
+	}
+	r := newPtrRecord(kt, sep, right)
+	ok, err := pt.addRecord(n, r)
+	if err != nil || ok {
+		pt.cache.UnpinPage(pid, true)
+		return err
+	}
+	sep, right, err = pt.splitNode(n, r)
+	pt.cache.UnpinPage(pid, true)
+	if err != nil {
+		return err
+	}
+	return pt.insertParent(path[:len(path)-1], kt, pid, sep, right)
+}
+
+// newRoot creates a new root node with the two provided children.
+func (pt *PageTree) newRoot(kt uint8, left ptr, sep []byte, right ptr) error {
+	root, err := pt.allocate(false)
+	if err != nil {
+		return err
+	}
+	pid := root.GetPageID()
+	root.setFlags(false, true)
+	err = root.reset(
+		[]page.Record{
+			newPtrRecord(kt, nil, left),
+			newPtrRecord(kt, sep, right),
+		},
+	)
+	pt.cache.UnpinPage(pid, true)
+	if err != nil {
+		return err
+	}
+	return pt.setRoot(pid)
 }
 
 // findLeaf traces the path from the root node down to a leaf node,
 // searching according to the record key. It returns the leaf node
-// containing the given key.
-func (pt *pageTree) findLeaf(k []byte) uint32 {
-	return 0
+// containing the given key, along with the path of internal nodes
+// that were traversed to get there.
+func (pt *PageTree) findLeaf(k []byte) (ptr, []ptr, error) {
+	var path []ptr
+	pid := pt.root
+	for {
+		n, err := pt.fetch(pid)
+		if err != nil {
+			return 0, nil, err
+		}
+		if n.isLeaf() {
+			pt.cache.UnpinPage(pid, false)
+			return pid, path, nil
+		}
+		pos, found := n.SearchRecords(k)
+		if !found {
+			pos--
+		}
+		child, err := n.child(pos)
+		pt.cache.UnpinPage(pid, false)
+		if err != nil {
+			return 0, nil, err
+		}
+		path = append(path, pid)
+		pid = child
+	}
+}
+
+// Search returns the record matching the provided key. If the key cannot be
+// found, ErrKeyNotFound is returned.
+func (pt *PageTree) Search(k []byte) (page.Record, error) {
+	pt.latch.RLock()
+	defer pt.latch.RUnlock()
+	pid, _, err := pt.findLeaf(k)
+	if err != nil {
+		return nil, err
+	}
+	n, err := pt.fetch(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer pt.cache.UnpinPage(pid, false)
+	pos, found := n.SearchRecords(k)
+	if !found {
+		return nil, ErrKeyNotFound
+	}
+	_, r, err := n.GetRecordAt(pos)
+	return r, err
+}
+
+// Range calls the provided function for each record with a key that falls
+// within the inclusive range lo to hi in sorted key order. A nil lo or hi
+// indicates the range is unbounded on that side. If the provided function
+// returns false, the iteration is stopped.
+func (pt *PageTree) Range(lo, hi []byte, fn func(r page.Record) bool) error {
+	pt.latch.RLock()
+	defer pt.latch.RUnlock()
+	pid, _, err := pt.findLeaf(lo)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := pt.fetch(pid)
+		if err != nil {
+			return err
+		}
+		// Collect the matching records before unpinning the leaf.
+		var recs []page.Record
+		done := false
+		pos, _ := n.SearchRecords(lo)
+		for ; pos < int(n.numKeys()); pos++ {
+			_, r, err := n.GetRecordAt(pos)
+			if err != nil {
+				pt.cache.UnpinPage(pid, false)
+				return err
+			}
+			if hi != nil && bytes.Compare(r.Key(), hi) > 0 {
+				done = true
+				break
+			}
+			recs = append(recs, r)
+		}
+		next := n.GetNext()
+		pt.cache.UnpinPage(pid, false)
+		for _, r := range recs {
+			if !fn(r) {
+				return nil
+			}
+		}
+		if done || next == 0 {
+			return nil
+		}
+		pid = next
+	}
+}
+
+// Delete removes the record matching the provided key. If the key cannot be
+// found, ErrKeyNotFound is returned.
+func (pt *PageTree) Delete(k []byte) error {
+	pt.latch.Lock()
+	defer pt.latch.Unlock()
+	pid, path, err := pt.findLeaf(k)
+	if err != nil {
+		return err
+	}
+	n, err := pt.fetch(pid)
+	if err != nil {
+		return err
+	}
+	pos, found := n.SearchRecords(k)
+	if !found {
+		pt.cache.UnpinPage(pid, false)
+		return ErrKeyNotFound
+	}
+	if err = n.delRecordAt(pos); err != nil {
+		pt.cache.UnpinPage(pid, true)
+		return err
+	}
+	underfull := !n.isRoot() && sizeOf(n.records()) < pt.capacity/minFillFactor
+	pt.cache.UnpinPage(pid, true)
+	if underfull {
+		return pt.merge(path, pid)
+	}
+	return nil
+}
+
+// merge attempts to merge the provided underfull node with one of its siblings.
+// The parent is the last node in the provided path. If the merge leaves the
+// parent underfull, the parent is merged in turn.
+func (pt *PageTree) merge(path []ptr, pid ptr) error {
+	ppid := path[len(path)-1]
+	parent, err := pt.fetch(ppid)
+	if err != nil {
+		return err
+	}
+	// Locate the node in the parent, and pick a sibling to merge with.
+	idx, found := parent.childPos(pid)
+	if !found || parent.numKeys() < 2 {
+		pt.cache.UnpinPage(ppid, false)
+		return nil
+	}
+	sepPos := idx + 1
+	if sepPos >= int(parent.numKeys()) {
+		sepPos = idx
+	}
+	lpid, err := parent.child(sepPos - 1)
+	if err != nil {
+		pt.cache.UnpinPage(ppid, false)
+		return err
+	}
+	rpid, err := parent.child(sepPos)
+	if err != nil {
+		pt.cache.UnpinPage(ppid, false)
+		return err
+	}
+	_, sepRec, err := parent.GetRecordAt(sepPos)
+	if err != nil {
+		pt.cache.UnpinPage(ppid, false)
+		return err
+	}
+	merged, err := pt.mergeNodes(lpid, rpid, sepRec.Key())
+	if err != nil || !merged {
+		pt.cache.UnpinPage(ppid, false)
+		return err
+	}
+	// Remove the pointer to the right node from the parent.
+	if err = parent.delRecordAt(sepPos); err != nil {
+		pt.cache.UnpinPage(ppid, true)
+		return err
+	}
+	if parent.isRoot() {
+		collapse := parent.numKeys() == 1
+		pt.cache.UnpinPage(ppid, true)
+		if collapse {
+			return pt.collapseRoot(lpid)
+		}
+		return nil
+	}
+	underfull := sizeOf(parent.records()) < pt.capacity/minFillFactor
+	pt.cache.UnpinPage(ppid, true)
+	if underfull {
+		return pt.merge(path[:len(path)-1], ppid)
+	}
+	return nil
+}
+
+// mergeNodes moves all the records from the right node into the left node if
+// they will fit, and deletes the right node. It returns false if the records
+// would not fit.
+func (pt *PageTree) mergeNodes(lpid, rpid ptr, sep []byte) (bool, error) {
+	left, err := pt.fetch(lpid)
+	if err != nil {
+		return false, err
+	}
+	right, err := pt.fetch(rpid)
+	if err != nil {
+		pt.cache.UnpinPage(lpid, false)
+		return false, err
+	}
+	lrecs, rrecs := left.records(), right.records()
+	if !left.isLeaf() && len(rrecs) > 0 {
+		// The first record in an internal node has an empty key, so it must
+		// take on the separator key when it is moved.
+		rrecs[0] = newPtrRecord(rrecs[0].KeyType(), sep, binary.LittleEndian.Uint32(rrecs[0].Val()))
+	}
+	recs := append(lrecs, rrecs...)
+	if sizeOf(recs) > pt.capacity {
+		pt.cache.UnpinPage(rpid, false)
+		pt.cache.UnpinPage(lpid, false)
+		return false, nil
+	}
+	if err = left.reset(recs); err != nil {
+		pt.cache.UnpinPage(rpid, false)
+		pt.cache.UnpinPage(lpid, true)
+		return false, err
+	}
+	// Unlink the right node from the leaf chain.
+	next := right.GetNext()
+	if left.isLeaf() {
+		left.setLinks(left.GetPrev(), next)
+		if next != 0 {
+			nn, err := pt.fetch(next)
+			if err != nil {
+				pt.cache.UnpinPage(rpid, false)
+				pt.cache.UnpinPage(lpid, true)
+				return false, err
+			}
+			nn.setLinks(lpid, nn.GetNext())
+			pt.cache.UnpinPage(next, true)
+		}
+	}
+	pt.cache.UnpinPage(lpid, true)
+	pt.cache.UnpinPage(rpid, false)
+	return true, pt.cache.DeletePage(rpid)
+}
+
+// collapseRoot makes the provided node the new root, and deletes the old root.
+func (pt *PageTree) collapseRoot(pid ptr) error {
+	n, err := pt.fetch(pid)
+	if err != nil {
+		return err
+	}
+	n.setFlags(n.isLeaf(), true)
+	pt.cache.UnpinPage(pid, true)
+	old := pt.root
+	if err = pt.setRoot(pid); err != nil {
+		return err
+	}
+	return pt.cache.DeletePage(old)
+}
+
+// Flush writes any dirty nodes out to disk.
+func (pt *PageTree) Flush() error {
+	pt.latch.Lock()
+	defer pt.latch.Unlock()
+	return pt.cache.FlushAll()
+}
+
+// Close flushes any dirty nodes and closes the tree.
+func (pt *PageTree) Close() error {
+	pt.latch.Lock()
+	defer pt.latch.Unlock()
+	return pt.cache.Close()
 }
@@ -0,0 +1,195 @@
+package index
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+func makeKey(i int) []byte {
+	return []byte(fmt.Sprintf("key-%.6d", i))
+}
+
+func makeRecord(i int) page.Record {
+	val := []byte(fmt.Sprintf("this is the value for record #%.6d", i))
+	return page.NewRecord(page.R_STR, page.R_STR, makeKey(i), val)
+}
+
+func TestPageTree_InsertAndSearch(t *testing.T) {
+	path := filepath.Join("testing", "tree_insert.idx")
+	defer os.RemoveAll("testing")
+
+	pt, err := OpenPageTree(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	const N = 5000
+	for _, i := range rand.Perm(N) {
+		err = pt.Insert(makeRecord(i))
+		if err != nil {
+			t.Fatalf("insert %d: %s", i, err)
+		}
+	}
+	if pt.root == 1 {
+		t.Errorf("root: expected the root leaf to have been split")
+	}
+	for i := 0; i < N; i++ {
+		r, err := pt.Search(makeKey(i))
+		if err != nil {
+			t.Fatalf("search %d: %s", i, err)
+		}
+		if !bytes.Equal(r, makeRecord(i)) {
+			t.Errorf("search %d: got %s, expected %s", i, r, makeRecord(i))
+		}
+	}
+	_, err = pt.Search([]byte("missing"))
+	if err != ErrKeyNotFound {
+		t.Errorf("search: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	// upsert a record with a larger value
+	up := page.NewRecord(page.R_STR, page.R_STR, makeKey(42), bytes.Repeat([]byte{'x'}, 128))
+	err = pt.Insert(up)
+	if err != nil {
+		t.Fatalf("upsert: %s", err)
+	}
+	r, err := pt.Search(makeKey(42))
+	if err != nil || !bytes.Equal(r, up) {
+		t.Errorf("upsert: got %v (%v), expected %v", r, err, up)
+	}
+	err = pt.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
+
+func TestPageTree_Range(t *testing.T) {
+	path := filepath.Join("testing", "tree_range.idx")
+	defer os.RemoveAll("testing")
+
+	pt, err := OpenPageTree(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	const N = 3000
+	for _, i := range rand.Perm(N) {
+		err = pt.Insert(makeRecord(i))
+		if err != nil {
+			t.Fatalf("insert %d: %s", i, err)
+		}
+	}
+	// full scan
+	var i int
+	err = pt.Range(
+		nil, nil, func(r page.Record) bool {
+			if !bytes.Equal(r, makeRecord(i)) {
+				t.Errorf("range: got %v, expected %s", r, makeKey(i))
+			}
+			i++
+			return true
+		},
+	)
+	if err != nil || i != N {
+		t.Errorf("range: got %d records (%v), expected %d", i, err, N)
+	}
+	// bounded scan
+	i = 1000
+	err = pt.Range(
+		makeKey(1000), makeKey(1999), func(r page.Record) bool {
+			if !bytes.Equal(r, makeRecord(i)) {
+				t.Errorf("range: got %v, expected %s", r, makeKey(i))
+			}
+			i++
+			return true
+		},
+	)
+	if err != nil || i != 2000 {
+		t.Errorf("range: stopped at %d (%v), expected %d", i, err, 2000)
+	}
+	// early stop
+	i = 0
+	err = pt.Range(
+		nil, nil, func(r page.Record) bool {
+			i++
+			return i < 10
+		},
+	)
+	if err != nil || i != 10 {
+		t.Errorf("range: stopped at %d (%v), expected %d", i, err, 10)
+	}
+	err = pt.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
+
+func TestPageTree_DeleteAndReopen(t *testing.T) {
+	path := filepath.Join("testing", "tree_delete.idx")
+	defer os.RemoveAll("testing")
+
+	pt, err := OpenPageTree(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	const N = 4000
+	for i := 0; i < N; i++ {
+		err = pt.Insert(makeRecord(i))
+		if err != nil {
+			t.Fatalf("insert %d: %s", i, err)
+		}
+	}
+	// delete everything but every 100th record
+	for _, i := range rand.Perm(N) {
+		if i%100 == 0 {
+			continue
+		}
+		err = pt.Delete(makeKey(i))
+		if err != nil {
+			t.Fatalf("delete %d: %s", i, err)
+		}
+	}
+	err = pt.Delete(makeKey(1))
+	if err != ErrKeyNotFound {
+		t.Errorf("delete: got %v, expected %v", err, ErrKeyNotFound)
+	}
+	// the tree should have collapsed back down into a single leaf
+	root, err := pt.fetch(pt.root)
+	if err != nil {
+		t.Fatalf("fetch root: %s", err)
+	}
+	if !root.isLeaf() || !root.isRoot() {
+		t.Errorf("root: expected a root leaf, got flags 0x%x", root.GetFlags())
+	}
+	pt.cache.UnpinPage(pt.root, false)
+	err = pt.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+
+	// reopen, and make sure everything is still there
+	pt, err = OpenPageTree(path)
+	if err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+	var count int
+	err = pt.Range(
+		nil, nil, func(r page.Record) bool {
+			if !bytes.Equal(r, makeRecord(count*100)) {
+				t.Errorf("reopen: got %v, expected %s", r, makeKey(count*100))
+			}
+			count++
+			return true
+		},
+	)
+	if err != nil || count != N/100 {
+		t.Errorf("reopen: got %d records (%v), expected %d", count, err, N/100)
+	}
+	err = pt.Close()
+	if err != nil {
+		t.Errorf("close: %s", err)
+	}
+}
@@ -0,0 +1,357 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/index"
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+const idxSuffix = ".idx"
+
+var (
+	ErrIndexNotFound      = errors.New("index could not be found")
+	ErrIndexNotRegistered = errors.New("index has no key function registered")
+	ErrBadIndexName       = errors.New("bad index name")
+	ErrIndexKeyTooLarge   = errors.New("index key is too large")
+)
+
+// IndexFunc returns the key the provided record should be indexed under. If it
+// returns nil, the record is left out of the index.
+type IndexFunc func(rec Record) []byte
+
+// Every secondary index is a page tree holding two kinds of entries. The key
+// entries map the index key (along with the record id, so that many records can
+// share a key) onto the record id, and the id entries map the record id back
+// onto the index key, so the key entry can be found again when the record is
+// deleted without having to decode the record.
+const (
+	keyEntry = 'k'
+	idEntry  = 'r'
+)
+
+// secondaryIndex is a named secondary index on a collection. The key function
+// is nil for an index that was opened along with the collection, until it is
+// registered again.
+type secondaryIndex struct {
+	name string
+	tree *index.PageTree
+	fn   IndexFunc
+}
+
+// errNotRegistered returns the error used when the index is used before its key
+// function has been registered again.
+func (si *secondaryIndex) errNotRegistered() error {
+	return fmt.Errorf("%w: re-register index %q using CreateIndex", ErrIndexNotRegistered, si.name)
+}
+
+// idxPath returns the path of the file holding the index matching the provided
+// name for the collection located at the provided path.
+func idxPath(path, name string) string {
+	return path + "." + name + idxSuffix
+}
+
+// encIndexKey encodes the index key in such a way that the encoded keys sort
+// the same way the keys do, and that no encoded key is the prefix of another.
+// Every zero byte is escaped using 0x00 0xff, and the key is terminated using
+// 0x00 0x00.
+func encIndexKey(dst, key []byte) []byte {
+	for _, b := range key {
+		dst = append(dst, b)
+		if b == 0x00 {
+			dst = append(dst, 0xff)
+		}
+	}
+	return append(dst, 0x00, 0x00)
+}
+
+// encID encodes the record id. It is written big endian, so the ids sort in
+// order within the key entries.
+func encID(dst []byte, id uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], id)
+	return append(dst, b[:]...)
+}
+
+// keyEntryKey returns the key used by the key entry of the provided index key
+// and record id.
+func keyEntryKey(key []byte, id uint64) []byte {
+	return encID(encIndexKey([]byte{keyEntry}, key), id)
+}
+
+// idEntryKey returns the key used by the id entry of the provided record id.
+func idEntryKey(id uint64) []byte {
+	return encID([]byte{idEntry}, id)
+}
+
+// maxEntryKeyLen is the largest key a record in the index tree can have.
+const maxEntryKeyLen = 0xff
+
+// checkIndexKey returns ErrIndexKeyTooLarge if the provided index key, once it
+// is escaped and the record id is added to it, does not fit in a record key.
+func checkIndexKey(key []byte) error {
+	if len(keyEntryKey(key, 0)) > maxEntryKeyLen {
+		return ErrIndexKeyTooLarge
+	}
+	return nil
+}
+
+// add adds the record id to the index under the provided key.
+func (si *secondaryIndex) add(key []byte, id uint64) error {
+	err := checkIndexKey(key)
+	if err != nil {
+		return err
+	}
+	val := encID(nil, id)
+	err = si.tree.Insert(page.NewRecord(page.R_STR, page.R_NUM, keyEntryKey(key, id), val))
+	if err != nil {
+		return err
+	}
+	return si.tree.Insert(page.NewRecord(page.R_STR, page.R_STR, idEntryKey(id), key))
+}
+
+// remove removes the record id from the index, if it is in there.
+func (si *secondaryIndex) remove(id uint64) error {
+	r, err := si.tree.Search(idEntryKey(id))
+	if err != nil {
+		if errors.Is(err, index.ErrKeyNotFound) {
+			// it was never indexed
+			return nil
+		}
+		return err
+	}
+	err = si.tree.Delete(keyEntryKey(r.Val(), id))
+	if err != nil && !errors.Is(err, index.ErrKeyNotFound) {
+		return err
+	}
+	return si.tree.Delete(idEntryKey(id))
+}
+
+// scan returns the ids of the records with an index key that falls within the
+// inclusive range lo to hi, in key order. A nil lo or hi indicates the range
+// is unbounded on that side.
+func (si *secondaryIndex) scan(lo, hi []byte) ([]uint64, error) {
+	from := []byte{keyEntry}
+	if lo != nil {
+		from = encIndexKey(from, lo)
+	}
+	// one past the key entries
+	to := []byte{keyEntry + 1}
+	if hi != nil {
+		to = encID(encIndexKey([]byte{keyEntry}, hi), ^uint64(0))
+	}
+	var ids []uint64
+	err := si.tree.Range(
+		from, to, func(r page.Record) bool {
+			if r.Key()[0] != keyEntry {
+				return false
+			}
+			ids = append(ids, binary.BigEndian.Uint64(r.Val()))
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// openIndexes opens any existing indexes of the collection. Their key functions
+// are not persisted, so every index has to be registered again using CreateIndex
+// before it can be used, or the collection can be written to.
+func (c *Collection) openIndexes() error {
+	matches, err := filepath.Glob(c.path + ".*" + idxSuffix)
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		name := strings.TrimSuffix(strings.TrimPrefix(filepath.ToSlash(m), c.path+"."), idxSuffix)
+		tree, err := index.OpenPageTree(m)
+		if err != nil {
+			return err
+		}
+		c.indexes.Store(name, &secondaryIndex{name: name, tree: tree})
+	}
+	return nil
+}
+
+// getIndex returns the index matching the provided name.
+func (c *Collection) getIndex(name string) (*secondaryIndex, error) {
+	v, found := c.indexes.Load(name)
+	if !found {
+		return nil, ErrIndexNotFound
+	}
+	return v.(*secondaryIndex), nil
+}
+
+// rangeIndexes calls the provided function for every index of the collection,
+// stopping at the first error.
+func (c *Collection) rangeIndexes(fn func(si *secondaryIndex) error) error {
+	var err error
+	c.indexes.Range(
+		func(_, v any) bool {
+			err = fn(v.(*secondaryIndex))
+			return err == nil
+		},
+	)
+	return err
+}
+
+// indexKeys returns the index keys of the provided record for every index. It
+// returns ErrIndexKeyTooLarge if any of them is too large to be indexed.
+func (c *Collection) indexKeys(data Record) (map[*secondaryIndex][]byte, error) {
+	keys := make(map[*secondaryIndex][]byte)
+	err := c.rangeIndexes(
+		func(si *secondaryIndex) error {
+			if si.fn == nil {
+				return si.errNotRegistered()
+			}
+			key := si.fn(data)
+			if key == nil {
+				return nil
+			}
+			err := checkIndexKey(key)
+			if err != nil {
+				return err
+			}
+			keys[si] = key
+			return nil
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// CreateIndex registers a secondary index using the provided name, indexing every
+// record under the key returned by the provided function. If the index does not
+// exist yet, it is built using the records already in the namespace, which are
+// decoded into the provided pointer. If it does exist, the function replaces the
+// one that was registered before.
+//
+// Indexes are persisted, but their key functions are not, so they have to be
+// registered again after the namespace is reopened; until then, using the index
+// or writing to the namespace fails with ErrIndexNotRegistered. The indexes are
+// flushed whenever the namespace is committed, but they are not logged, so an
+// index may not match the namespace after a crash. That is why an index that is
+// registered again after a reopen is rebuilt from scratch. If any record has a
+// key that is too large to be indexed, ErrIndexKeyTooLarge is returned and the
+// index is not created.
+func (c *Collection) CreateIndex(name string, ptr Record, fn IndexFunc) error {
+	if name == "" || strings.ContainsAny(name, `/\*?[`) {
+		return ErrBadIndexName
+	}
+	if fn == nil {
+		return ErrIndexNotRegistered
+	}
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	if si, err := c.getIndex(name); err == nil {
+		if si.fn != nil {
+			// already registered, so we only have to replace the key function
+			c.indexes.Store(name, &secondaryIndex{name: name, tree: si.tree, fn: fn})
+			return nil
+		}
+		// it was opened along with the namespace, and may not match it, so
+		// we throw it away and build it again
+		c.indexes.Delete(name)
+		err = si.tree.Close()
+		if err != nil {
+			return err
+		}
+		err = os.Remove(idxPath(c.path, name))
+		if err != nil {
+			return err
+		}
+	}
+	tree, err := index.OpenPageTree(idxPath(c.path, name))
+	if err != nil {
+		return err
+	}
+	si := &secondaryIndex{name: name, tree: tree, fn: fn}
+	// build the index using the records already in the namespace
+	err = c.pool.Scan(
+		func(rid *page.RecordID, rec page.Record) error {
+			// skip the versions that have been replaced, and the ones left
+			// behind by transactions that never finished
+			v := decVersion(rec.Key())
+			if v.end != 0 && v.end&pendingStamp == 0 {
+				return nil
+			}
+			if v.begin&pendingStamp != 0 && !c.isActive(v.begin&^pendingStamp) {
+				return nil
+			}
+			err := decRecord(rec, ptr)
+			if err != nil {
+				return err
+			}
+			if key := fn(ptr); key != nil {
+				return si.add(key, page.EncodeRecordID(rid))
+			}
+			return nil
+		},
+	)
+	if err == nil {
+		err = tree.Flush()
+	}
+	if err != nil {
+		_ = tree.Close()
+		_ = os.Remove(idxPath(c.path, name))
+		return err
+	}
+	c.indexes.Store(name, si)
+	return nil
+}
+
+// DropIndex removes the index matching the provided name.
+func (c *Collection) DropIndex(name string) error {
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	si, err := c.getIndex(name)
+	if err != nil {
+		return err
+	}
+	c.indexes.Delete(name)
+	err = si.tree.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(idxPath(c.path, name))
+}
+
+// FindBy returns the ids of the records indexed under the provided key in the
+// index matching the provided name.
+func (c *Collection) FindBy(name string, key []byte) ([]uint64, error) {
+	si, err := c.getIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if si.fn == nil {
+		return nil, si.errNotRegistered()
+	}
+	return si.scan(key, key)
+}
+
+// FindRange returns the ids of the records with an index key that falls within
+// the inclusive range lo to hi in the index matching the provided name, in key
+// order. A nil lo or hi indicates the range is unbounded on that side.
+func (c *Collection) FindRange(name string, lo, hi []byte) ([]uint64, error) {
+	si, err := c.getIndex(name)
+	if err != nil {
+		return nil, err
+	}
+	if si.fn == nil {
+		return nil, si.errNotRegistered()
+	}
+	if lo != nil && hi != nil && bytes.Compare(lo, hi) > 0 {
+		return nil, nil
+	}
+	return si.scan(lo, hi)
+}
@@ -0,0 +1,241 @@
+package logging
+
+import (
+	"sync"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+// LogManager sits on top of the write-ahead log and is responsible for handing
+// out log sequence numbers, writing log records, and keeping track of what has
+// been flushed. It also keeps track of the active transaction. There is only
+// ever one active transaction at a time; it begins with the first update that
+// is logged after the previous transaction has ended.
+type LogManager struct {
+	latch      sync.Mutex
+	wal        *WAL
+	lastLSN    uint64 // lsn of the last record written
+	flushedLSN uint64 // every record up to (and including) this lsn is durable
+	txID       uint64 // id of the active transaction (zero if there is none)
+	txLastLSN  uint64 // lsn of the last record written by the active transaction
+}
+
+// OpenLogManager opens the write-ahead log located at the provided path, and
+// returns a new LogManager that picks up where the log left off.
+func OpenLogManager(path string) (*LogManager, error) {
+	wal, err := OpenWAL(
+		&WALConfig{
+			BasePath:    path,
+			MaxFileSize: defaultMaxFileSize,
+			SyncOnWrite: false,
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	lm := &LogManager{
+		wal: wal,
+	}
+	// find the last lsn in the log
+	lm.lastLSN, err = lm.findLastLSN()
+	if err != nil {
+		_ = wal.Close()
+		return nil, err
+	}
+	lm.flushedLSN = lm.lastLSN
+	return lm, nil
+}
+
+// findLastLSN returns the lsn of the last log record in the log, or zero if
+// there are none. It only reads the end of the log, skipping over any records
+// that cannot be decoded.
+func (lm *LogManager) findLastLSN() (uint64, error) {
+	for i := lm.wal.LastIndex() - 1; i >= lm.wal.FirstIndex(); i-- {
+		e, err := lm.wal.Read(i)
+		if err != nil {
+			return 0, err
+		}
+		r, err := decodeLogRecord(e)
+		if err == nil {
+			return r.LSN, nil
+		}
+	}
+	return 0, nil
+}
+
+// Records reads and returns all the log records in the log. If a record at
+// the end of the log is incomplete (it was being written during a crash), it
+// is left out along with anything following it.
+func (lm *LogManager) Records() ([]*LogRecord, error) {
+	var recs []*LogRecord
+	var torn bool
+	err := lm.wal.Scan(
+		func(e []byte) bool {
+			if torn {
+				return false
+			}
+			r, err := decodeLogRecord(e)
+			if err != nil {
+				torn = true
+				return false
+			}
+			recs = append(recs, r)
+			return true
+		},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return recs, nil
+}
+
+// SetLastLSN makes sure the next log sequence number handed out is larger than
+// the one provided. It is used to keep the log sequence numbers increasing in
+// the event that the log is newer than the pages it is logging.
+func (lm *LogManager) SetLastLSN(lsn uint64) {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	if lsn > lm.lastLSN {
+		lm.lastLSN = lsn
+	}
+}
+
+// Append assigns the next log sequence number to the log record and writes it
+// to the log. It returns the log sequence number that was assigned.
+func (lm *LogManager) Append(r *LogRecord) (uint64, error) {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	lsn, _, err := lm.append(r)
+	return lsn, err
+}
+
+// append writes the log record, and returns the log sequence number it was
+// assigned along with the index of the entry in the write-ahead log.
+func (lm *LogManager) append(r *LogRecord) (uint64, int64, error) {
+	r.LSN = lm.lastLSN + 1
+	index, err := lm.wal.Write(encodeLogRecord(r))
+	if err != nil {
+		return 0, -1, err
+	}
+	lm.lastLSN = r.LSN
+	return r.LSN, index, nil
+}
+
+// LogUpdate writes an update record for the provided page to the log, as part
+// of the active transaction (starting one if there is no active transaction.)
+// It returns the log sequence number of the update record.
+func (lm *LogManager) LogUpdate(pid page.PageID, flags uint8, deltas []page.Delta) (uint64, error) {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	r := &LogRecord{
+		PrevLSN: lm.txLastLSN,
+		TxID:    lm.txID,
+		Type:    LogUpdate,
+		Flags:   flags,
+		PageID:  pid,
+		Deltas:  deltas,
+	}
+	if lm.txID == 0 {
+		// begin a new transaction, the id of the transaction is the lsn of the
+		// first record it writes
+		r.TxID = lm.lastLSN + 1
+	}
+	lsn, _, err := lm.append(r)
+	if err != nil {
+		return 0, err
+	}
+	lm.txID, lm.txLastLSN = r.TxID, lsn
+	return lsn, nil
+}
+
+// Commit writes a commit record for the active transaction and flushes the
+// log. If there is no active transaction, it does nothing.
+func (lm *LogManager) Commit() error {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	if lm.txID == 0 {
+		return nil
+	}
+	lsn, _, err := lm.append(
+		&LogRecord{
+			PrevLSN: lm.txLastLSN,
+			TxID:    lm.txID,
+			Type:    LogCommit,
+		},
+	)
+	if err != nil {
+		return err
+	}
+	lm.txID, lm.txLastLSN = 0, 0
+	return lm.flush(lsn)
+}
+
+// Checkpoint writes a checkpoint record and flushes the log. The caller must
+// make sure every dirty page has been flushed before calling it. A checkpoint
+// record is only written when there is no active transaction, which means
+// recovery never has to look at anything that comes before it, so once the
+// checkpoint record is durable the log is truncated up to it.
+func (lm *LogManager) Checkpoint() error {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	if lm.txID != 0 {
+		return nil
+	}
+	lsn, index, err := lm.append(&LogRecord{Type: LogCheckpoint})
+	if err != nil {
+		return err
+	}
+	err = lm.flush(lsn)
+	if err != nil {
+		return err
+	}
+	return lm.wal.TruncateFront(index)
+}
+
+// Flush makes sure that every log record up to (and including) the provided
+// log sequence number is durable.
+func (lm *LogManager) Flush(lsn uint64) error {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	return lm.flush(lsn)
+}
+
+func (lm *LogManager) flush(lsn uint64) error {
+	if lsn <= lm.flushedLSN {
+		return nil
+	}
+	err := lm.wal.Sync()
+	if err != nil {
+		return err
+	}
+	lm.flushedLSN = lm.lastLSN
+	return nil
+}
+
+// FlushedLSN returns the log sequence number of the last durable log record.
+func (lm *LogManager) FlushedLSN() uint64 {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	return lm.flushedLSN
+}
+
+// LastLSN returns the log sequence number of the last log record written.
+func (lm *LogManager) LastLSN() uint64 {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	return lm.lastLSN
+}
+
+// Close flushes and closes the log.
+func (lm *LogManager) Close() error {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	return lm.wal.Close()
+}
+
+// CloseAndRemove closes the log and removes all of its files.
+func (lm *LogManager) CloseAndRemove() error {
+	lm.latch.Lock()
+	defer lm.latch.Unlock()
+	return lm.wal.CloseAndRemove()
+}
@@ -0,0 +1,196 @@
+package logging
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+func TestLogManager(t *testing.T) {
+	path := "log-manager-testing"
+	defer os.RemoveAll(path)
+
+	lm, err := OpenLogManager(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	deltas := []page.Delta{
+		{Offset: 40, Before: []byte{0, 0, 0}, After: []byte{1, 2, 3}},
+		{Offset: 100, Before: []byte{4}, After: []byte{5}},
+	}
+	lsn1, err := lm.LogUpdate(1, 0, deltas)
+	if err != nil {
+		t.Fatalf("log update: %s", err)
+	}
+	lsn2, err := lm.LogUpdate(2, LogFormat, deltas[:1])
+	if err != nil {
+		t.Fatalf("log update: %s", err)
+	}
+	err = lm.Commit()
+	if err != nil {
+		t.Fatalf("commit: %s", err)
+	}
+	if lm.FlushedLSN() != lm.LastLSN() || lm.LastLSN() != 3 {
+		t.Errorf("commit: flushed=%d, last=%d, expected both to be 3", lm.FlushedLSN(), lm.LastLSN())
+	}
+	// a second transaction, that does not commit
+	lsn4, err := lm.LogUpdate(1, 0, deltas[1:])
+	if err != nil {
+		t.Fatalf("log update: %s", err)
+	}
+	err = lm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// simulate a torn write at the end of the log
+	files, err := filepath.Glob(filepath.Join(path, FilePrefix+"*"+FileSuffix))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("glob: %v (%d files)", err, len(files))
+	}
+	fd, err := os.OpenFile(files[len(files)-1], os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("open segment: %s", err)
+	}
+	_, err = fd.Write([]byte{64, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3})
+	if err != nil {
+		t.Fatalf("write segment: %s", err)
+	}
+	_ = fd.Close()
+
+	// reopen, and make sure we pick up where we left off
+	lm, err = OpenLogManager(path)
+	if err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+	if lm.LastLSN() != lsn4 {
+		t.Errorf("reopen: got last lsn %d, expected %d", lm.LastLSN(), lsn4)
+	}
+	recs, err := lm.Records()
+	if err != nil {
+		t.Fatalf("records: %s", err)
+	}
+	if len(recs) != 4 {
+		t.Fatalf("records: got %d records, expected %d", len(recs), 4)
+	}
+	want := []struct {
+		lsn, prev, txid uint64
+		typ             uint8
+	}{
+		{lsn1, 0, lsn1, LogUpdate},
+		{lsn2, lsn1, lsn1, LogUpdate},
+		{3, lsn2, lsn1, LogCommit},
+		{lsn4, 0, lsn4, LogUpdate},
+	}
+	for i, w := range want {
+		r := recs[i]
+		if r.LSN != w.lsn || r.PrevLSN != w.prev || r.TxID != w.txid || r.Type != w.typ {
+			t.Errorf("records[%d]: got %s", i, r)
+		}
+	}
+	if len(recs[0].Deltas) != 2 || recs[0].Deltas[1].Offset != 100 ||
+		!bytes.Equal(recs[0].Deltas[0].After, []byte{1, 2, 3}) {
+		t.Errorf("records[0]: bad deltas %v", recs[0].Deltas)
+	}
+	if !recs[1].HasFlag(LogFormat) {
+		t.Errorf("records[1]: expected the format flag")
+	}
+	// new records should be appended after the last complete record
+	_, err = lm.Append(&LogRecord{Type: LogCheckpoint})
+	if err != nil {
+		t.Fatalf("append: %s", err)
+	}
+	recs, err = lm.Records()
+	if err != nil || len(recs) != 5 || recs[4].Type != LogCheckpoint {
+		t.Errorf("append: got %d records (%v)", len(recs), err)
+	}
+	err = lm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
+
+func TestLogManager_Checkpoint(t *testing.T) {
+	path := t.TempDir()
+
+	lm, err := OpenLogManager(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	// enough records to span a few segments
+	deltas := []page.Delta{{Offset: 40, Before: make([]byte, 200), After: make([]byte, 200)}}
+	for i := 0; i < 2000; i++ {
+		_, err = lm.LogUpdate(page.PageID(i), 0, deltas)
+		if err == nil && i%10 == 9 {
+			err = lm.Commit()
+		}
+		if err != nil {
+			t.Fatalf("log update: %s", err)
+		}
+	}
+	segs, _ := filepath.Glob(filepath.Join(path, FilePrefix+"*"+FileSuffix))
+	if len(segs) < 2 {
+		t.Fatalf("expected the log to span a few segments, got %d", len(segs))
+	}
+	err = lm.Checkpoint()
+	if err != nil {
+		t.Fatalf("checkpoint: %s", err)
+	}
+	checkpoint := lm.LastLSN()
+	// everything before the checkpoint should be gone
+	segs, _ = filepath.Glob(filepath.Join(path, FilePrefix+"*"+FileSuffix))
+	if len(segs) != 1 {
+		t.Errorf("checkpoint: expected a single segment, got %d", len(segs))
+	}
+	recs, err := lm.Records()
+	if err != nil {
+		t.Fatalf("records: %s", err)
+	}
+	if len(recs) != 1 || recs[0].Type != LogCheckpoint || recs[0].LSN != checkpoint {
+		t.Fatalf("checkpoint: got %d records, expected just the checkpoint", len(recs))
+	}
+	// new records go after the checkpoint, and survive a reopen
+	lsn, err := lm.LogUpdate(1, 0, deltas)
+	if err == nil {
+		err = lm.Commit()
+	}
+	if err != nil {
+		t.Fatalf("log update: %s", err)
+	}
+	err = lm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+	lm, err = OpenLogManager(path)
+	if err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+	if lm.LastLSN() != lsn+1 {
+		t.Errorf("reopen: got last lsn %d, expected %d", lm.LastLSN(), lsn+1)
+	}
+	recs, err = lm.Records()
+	if err != nil {
+		t.Fatalf("records: %s", err)
+	}
+	if len(recs) != 3 || recs[0].LSN != checkpoint || recs[1].LSN != lsn || recs[2].Type != LogCommit {
+		t.Errorf("reopen: got %d records, expected the checkpoint, the update and the commit", len(recs))
+	}
+	// checkpoint again, with the checkpoint record in the first segment
+	err = lm.Checkpoint()
+	if err == nil {
+		recs, err = lm.Records()
+	}
+	if err != nil {
+		t.Fatalf("checkpoint: %s", err)
+	}
+	if len(recs) != 1 || recs[0].LSN != lsn+2 {
+		t.Errorf("checkpoint: got %d records, expected just the checkpoint", len(recs))
+	}
+	err = lm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+}
@@ -0,0 +1,163 @@
+package logging
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+var (
+	ErrBadLogRecord = errors.New("wal: bad log record")
+)
+
+// log record types
+const (
+	LogUpdate     uint8 = iota + 1 // a page was modified by a transaction
+	LogCompensate                  // an update was undone (compensation log record)
+	LogCommit                      // a transaction was committed
+	LogAbort                       // a transaction was rolled back
+	LogCheckpoint                  // all pages have been flushed, and no transactions are active
+)
+
+// log record flags
+const (
+	LogFormat uint8 = 0x01 // the page was formatted, the deltas are against a zeroed page
+)
+
+const (
+	// logRecordHeaderSize is the size of the encoded log record header
+	logRecordHeaderSize = 44
+
+	// offsets to be used for decoding and encoding the log record header
+	offLogCRC      = 0  // crc=uint32		offs=0-4	(4 bytes)
+	offLogType     = 4  // type=uint8		offs=4-5	(1 byte)
+	offLogFlags    = 5  // flags=uint8		offs=5-6	(1 byte)
+	offLogPageID   = 6  // pid=uint32		offs=6-10	(4 bytes)
+	offLogLSN      = 10 // lsn=uint64		offs=10-18	(8 bytes)
+	offLogPrevLSN  = 18 // prevLSN=uint64	offs=18-26	(8 bytes)
+	offLogUndoNext = 26 // undoNext=uint64	offs=26-34	(8 bytes)
+	offLogTxID     = 34 // txid=uint64		offs=34-42	(8 bytes)
+	offLogDeltas   = 42 // deltas=uint16	offs=42-44	(2 bytes)
+)
+
+// crc32cTable is the CRC32C (Castagnoli) table used for the log record checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// LogRecord is a single record in the write-ahead log. Updates are logged
+// physically, as a set of page deltas holding the bytes before and after the
+// update, so they can be redone and undone during recovery.
+type LogRecord struct {
+	LSN      uint64       // log sequence number of this record
+	PrevLSN  uint64       // lsn of the previous record written by the same transaction
+	UndoNext uint64       // lsn of the next record to undo (compensation records only)
+	TxID     uint64       // id of the transaction that wrote this record
+	Type     uint8        // type of log record
+	Flags    uint8        // log record flags
+	PageID   page.PageID  // id of the page that was modified (updates only)
+	Deltas   []page.Delta // the changes that were made to the page (updates only)
+}
+
+// HasFlag returns a boolean indicating if the provided flag is set.
+func (r *LogRecord) HasFlag(flag uint8) bool {
+	return r.Flags&flag != 0
+}
+
+// Redo applies the changes held in the log record to the provided page.
+func (r *LogRecord) Redo(p page.Page) {
+	if r.HasFlag(LogFormat) {
+		copy(p, make([]byte, len(p)))
+	}
+	p.Redo(r.Deltas)
+}
+
+// Undo reverts the changes held in the log record on the provided page. If
+// the page was formatted by the update, the page is zeroed out.
+func (r *LogRecord) Undo(p page.Page) {
+	if r.HasFlag(LogFormat) {
+		copy(p, make([]byte, len(p)))
+		return
+	}
+	p.Undo(r.Deltas)
+}
+
+// String is the stringer method for a log record
+func (r *LogRecord) String() string {
+	return fmt.Sprintf(
+		"{ lsn: %d, prev: %d, undoNext: %d, txid: %d, type: %d, flags: 0x%.2x, pid: %d, deltas: %d }",
+		r.LSN, r.PrevLSN, r.UndoNext, r.TxID, r.Type, r.Flags, r.PageID, len(r.Deltas),
+	)
+}
+
+// encodeLogRecord encodes the log record and returns the encoded bytes. The
+// record is prefixed with a checksum covering the rest of the record.
+func encodeLogRecord(r *LogRecord) []byte {
+	size := logRecordHeaderSize
+	for _, d := range r.Deltas {
+		size += 12 + len(d.Before) + len(d.After)
+	}
+	b := make([]byte, size)
+	b[offLogType] = r.Type
+	b[offLogFlags] = r.Flags
+	binary.LittleEndian.PutUint32(b[offLogPageID:offLogPageID+4], r.PageID)
+	binary.LittleEndian.PutUint64(b[offLogLSN:offLogLSN+8], r.LSN)
+	binary.LittleEndian.PutUint64(b[offLogPrevLSN:offLogPrevLSN+8], r.PrevLSN)
+	binary.LittleEndian.PutUint64(b[offLogUndoNext:offLogUndoNext+8], r.UndoNext)
+	binary.LittleEndian.PutUint64(b[offLogTxID:offLogTxID+8], r.TxID)
+	binary.LittleEndian.PutUint16(b[offLogDeltas:offLogDeltas+2], uint16(len(r.Deltas)))
+	n := logRecordHeaderSize
+	for _, d := range r.Deltas {
+		binary.LittleEndian.PutUint32(b[n:n+4], d.Offset)
+		binary.LittleEndian.PutUint32(b[n+4:n+8], uint32(len(d.Before)))
+		binary.LittleEndian.PutUint32(b[n+8:n+12], uint32(len(d.After)))
+		n += 12
+		n += copy(b[n:], d.Before)
+		n += copy(b[n:], d.After)
+	}
+	binary.LittleEndian.PutUint32(b[offLogCRC:offLogCRC+4], crc32.Checksum(b[offLogType:], crc32cTable))
+	return b
+}
+
+// decodeLogRecord decodes and returns the log record held in the provided bytes.
+func decodeLogRecord(b []byte) (*LogRecord, error) {
+	if len(b) < logRecordHeaderSize {
+		return nil, ErrBadLogRecord
+	}
+	if binary.LittleEndian.Uint32(b[offLogCRC:offLogCRC+4]) != crc32.Checksum(b[offLogType:], crc32cTable) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrBadLogRecord)
+	}
+	r := &LogRecord{
+		Type:     b[offLogType],
+		Flags:    b[offLogFlags],
+		PageID:   binary.LittleEndian.Uint32(b[offLogPageID : offLogPageID+4]),
+		LSN:      binary.LittleEndian.Uint64(b[offLogLSN : offLogLSN+8]),
+		PrevLSN:  binary.LittleEndian.Uint64(b[offLogPrevLSN : offLogPrevLSN+8]),
+		UndoNext: binary.LittleEndian.Uint64(b[offLogUndoNext : offLogUndoNext+8]),
+		TxID:     binary.LittleEndian.Uint64(b[offLogTxID : offLogTxID+8]),
+	}
+	count := int(binary.LittleEndian.Uint16(b[offLogDeltas : offLogDeltas+2]))
+	n := logRecordHeaderSize
+	for i := 0; i < count; i++ {
+		if n+12 > len(b) {
+			return nil, ErrBadLogRecord
+		}
+		off := binary.LittleEndian.Uint32(b[n : n+4])
+		blen := int(binary.LittleEndian.Uint32(b[n+4 : n+8]))
+		alen := int(binary.LittleEndian.Uint32(b[n+8 : n+12]))
+		n += 12
+		if n+blen+alen > len(b) {
+			return nil, ErrBadLogRecord
+		}
+		d := page.Delta{Offset: off}
+		if blen > 0 {
+			d.Before = b[n : n+blen]
+		}
+		n += blen
+		d.After = b[n : n+alen]
+		n += alen
+		r.Deltas = append(r.Deltas, d)
+	}
+	return r, nil
+}
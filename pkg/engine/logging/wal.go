@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -159,7 +158,7 @@ func OpenWAL(c *WALConfig) (*WAL, error) {
 	// sanitize any path separators
 	base = filepath.ToSlash(base)
 	// create any directories if they are not there
-	err = os.MkdirAll(base, os.ModeDir)
+	err = os.MkdirAll(base, os.ModeDir|0755)
 	if err != nil {
 		return nil, err
 	}
@@ -275,8 +274,12 @@ func (l *WAL) loadIndex() error {
 	}
 	// finally, update the firstIndex and lastIndex
 	l.firstIndex = l.segments[0].index
-	// and update last index
+	// and update last index, which (just like when writing) is the index
+	// the next segEntry will get
 	l.lastIndex = l.getLastSegment().getLastIndex()
+	if len(l.getLastSegment().entries) > 0 {
+		l.lastIndex++
+	}
 	return nil
 }
 
@@ -337,12 +340,32 @@ func (l *WAL) loadSegmentFile(path string) (*segment, error) {
 		index++
 	}
 	// make sure to fill out the segment index from the first segEntry index
-	s.index = s.entries[0].index
-	// get the offset of the reader to calculate bytes remaining
-	offset, err := fd.Seek(0, io.SeekCurrent)
+	s.index = index
+	if len(s.entries) > 0 {
+		s.index = s.entries[0].index
+	}
+	// get the offset of the last complete segEntry to calculate bytes remaining
+	var offset int64
+	if len(s.entries) > 0 {
+		last := s.entries[len(s.entries)-1].offset
+		e, err := decodeEntryAt(fd, last)
+		if err != nil {
+			return nil, err
+		}
+		offset = last + 8 + int64(len(e))
+	}
+	// if there is anything following the last complete segEntry, it is a torn
+	// write, so we will drop it before anything is appended after it
+	fi, err := fd.Stat()
 	if err != nil {
 		return nil, err
 	}
+	if fi.Size() > offset {
+		err = os.Truncate(path, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
 	// update the segment remaining bytes
 	s.remaining = l.conf.MaxFileSize - offset
 	return s, nil
@@ -427,36 +450,26 @@ func (l *WAL) Read(index int64) ([]byte, error) {
 	l.lock.RLock()
 	defer l.lock.RUnlock()
 	// error checking
-	if index < l.firstIndex || index > l.lastIndex {
+	if index < l.firstIndex || index >= l.lastIndex {
 		return nil, ErrOutOfBounds
 	}
-	var err error
 	// find the segment containing the provided index
 	s := l.segments[l.findSegmentIndex(index)]
 	// find the offset for the segEntry containing the provided index
 	offset := s.entries[s.findEntryIndex(index)].offset
-	// check to see if we need to be reading from a different file
-	if l.file.Name() == s.path {
-		// different file, let's open it
-		tmpf, err := os.Open(s.path)
-		if err != nil {
-			return nil, err
-		}
-		// read and decode entry at offset
-		e, err := decodeEntryAt(tmpf, offset)
-		if err != nil {
-			return nil, err
-		}
-		// close reader
-		err = tmpf.Close()
-		if err != nil {
-			return nil, err
-		}
-		// return
-		return e, nil
+	// the writer is opened write only, so we always open a reader
+	rd, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
 	}
-	// otherwise, we are reading from the same file, so just decode at
-	e, err := decodeEntryAt(l.file, offset)
+	// read and decode entry at offset
+	e, err := decodeEntryAt(rd, offset)
+	if err != nil {
+		_ = rd.Close()
+		return nil, err
+	}
+	// close reader
+	err = rd.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -606,8 +619,8 @@ func (l *WAL) TruncateFront(index int64) error {
 	defer l.lock.Unlock()
 	// perform bounds check
 	if index == 0 ||
-		l.lastIndex == 0 ||
-		index < l.firstIndex || index > l.lastIndex {
+		len(l.segments) == 0 ||
+		index < l.firstIndex || index >= l.lastIndex {
 		return ErrOutOfBounds
 	}
 	if index == l.firstIndex {
@@ -630,17 +643,8 @@ func (l *WAL) TruncateFront(index int64) error {
 		l.segments[k] = nil // or the zero value of T
 	}
 	l.segments = l.segments[:len(l.segments)-j+i]
-	// update firstIndex
-	l.firstIndex = l.segments[0].index
-	// prepare to re-write partial segment
-	var err error
-	var entries []segEntry
-	tmpfd, err := os.Create(filepath.ToSlash(filepath.Join(l.conf.BasePath, "tmp-partial.seg")))
-	if err != nil {
-		return err
-	}
 	// sync and close current file pointer
-	err = l.file.Sync()
+	err := l.file.Sync()
 	if err != nil {
 		return err
 	}
@@ -651,68 +655,94 @@ func (l *WAL) TruncateFront(index int64) error {
 	// after the segment index cut, segment 0 will
 	// contain the partials that we must re-write
 	if l.segments[0].index < index {
-		// make sure we are reading from the correct path
-		rd, err := os.Open(l.segments[0].path)
+		err = l.rewritePartial(l.segments[0], index)
 		if err != nil {
 			return err
 		}
-		// range the entries within this segment to find
-		// the ones that are greater than the index and
-		// write those to a temporary buffer....
-		for _, ent := range l.segments[0].entries {
-			if ent.index < index {
-				continue // skip
-			}
-			// read segEntry
-			e, err := decodeEntryAt(rd, ent.offset)
-			if err != nil {
-				return err
-			}
-			// write segEntry to temp file
-			ent.offset, err = encodeEntry(tmpfd, e)
-			if err != nil {
-				return err
-			}
-			// sync write
-			err = tmpfd.Sync()
-			if err != nil {
-				return err
-			}
-			// append to a new entries list
-			entries = append(entries, ent)
-		}
+	}
+	// update firstIndex
+	l.firstIndex = l.segments[0].index
+	// re-open file writer associated with active segment
+	l.active = l.getLastSegment()
+	l.file, err = os.OpenFile(l.active.path, os.O_WRONLY|os.O_SYNC, 0644)
+	if err != nil {
+		return err
+	}
+	// don't forget to seek to the end of the file.
+	offset, err := l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	// the active segment may have been re-written
+	l.active.remaining = l.conf.MaxFileSize - offset
+	return nil
+}
 
-		// sync and close reader
-		err = rd.Close()
-		if err != nil {
-			return err
-		}
-		// close temp file
-		err = tmpfd.Close()
-		if err != nil {
-			return err
+// rewritePartial re-writes the provided segment, leaving out the entries before
+// the specified index. The segment file is renamed to match the index of its
+// new first segEntry.
+func (l *WAL) rewritePartial(s *segment, index int64) error {
+	tmpfd, err := os.Create(filepath.ToSlash(filepath.Join(l.conf.BasePath, "tmp-partial.seg")))
+	if err != nil {
+		return err
+	}
+	// make sure we are reading from the correct path
+	rd, err := os.Open(s.path)
+	if err != nil {
+		_ = tmpfd.Close()
+		return err
+	}
+	// range the entries within this segment to find
+	// the ones that are greater than the index and
+	// write those to a temporary file....
+	var entries []segEntry
+	for _, ent := range s.entries {
+		if ent.index < index {
+			continue // skip
 		}
-		// remove partial segment file
-		err = os.Remove(filepath.ToSlash(l.segments[0].path))
-		if err != nil {
-			log.Println("DEBUG")
-			return err
+		// read segEntry
+		e, err := decodeEntryAt(rd, ent.offset)
+		if err == nil {
+			// write segEntry to temp file
+			ent.offset, err = encodeEntry(tmpfd, e)
 		}
-		// change temp file name
-		err = os.Rename(tmpfd.Name(), filepath.ToSlash(l.segments[0].path))
 		if err != nil {
+			_ = rd.Close()
+			_ = tmpfd.Close()
 			return err
 		}
-		// update segment
-		l.segments[0].entries = entries
-		l.segments[0].index = entries[0].index
+		// append to a new entries list
+		entries = append(entries, ent)
 	}
-	// re-open file writer associated with active segment
-	l.active = l.getLastSegment()
-	l.file, err = os.OpenFile(l.active.path, os.O_WRONLY|os.O_SYNC, 0644)
+	// close reader
+	err = rd.Close()
+	if err != nil {
+		return err
+	}
+	// sync and close temp file
+	err = tmpfd.Sync()
+	if err != nil {
+		return err
+	}
+	err = tmpfd.Close()
+	if err != nil {
+		return err
+	}
+	// remove partial segment file
+	err = os.Remove(filepath.ToSlash(s.path))
+	if err != nil {
+		return err
+	}
+	// change temp file name
+	path := filepath.ToSlash(filepath.Join(l.conf.BasePath, MakeFileNameFromIndex(entries[0].index)))
+	err = os.Rename(tmpfd.Name(), path)
 	if err != nil {
 		return err
 	}
+	// update segment
+	s.path = path
+	s.entries = entries
+	s.index = entries[0].index
 	return nil
 }
 
@@ -846,7 +876,7 @@ func decodeEntry(r io.Reader) ([]byte, error) {
 	// make buffer
 	buf := make([]byte, 8)
 	// read entry length
-	_, err := r.Read(buf)
+	_, err := io.ReadFull(r, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -855,7 +885,7 @@ func decodeEntry(r io.Reader) ([]byte, error) {
 	// make entry slice to read data into
 	e := make([]byte, size)
 	// read from data into entry
-	_, err = r.Read(e)
+	_, err = io.ReadFull(r, e)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/buffer"
+)
+
+// metricsContentType is the content type of the Prometheus text format.
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// bufferMetrics lists the buffer pool metrics, in the order they are written.
+var bufferMetrics = []struct {
+	name  string
+	typ   string
+	help  string
+	value func(s *buffer.Stats) float64
+}{
+	{
+		"go_data_buffer_frames", "gauge", "Number of frames in the buffer pool.",
+		func(s *buffer.Stats) float64 { return float64(s.Frames) },
+	},
+	{
+		"go_data_buffer_resident_pages", "gauge", "Number of pages resident in the buffer pool.",
+		func(s *buffer.Stats) float64 { return float64(s.Resident) },
+	},
+	{
+		"go_data_buffer_pinned_pages", "gauge", "Number of pages currently pinned.",
+		func(s *buffer.Stats) float64 { return float64(s.Pinned) },
+	},
+	{
+		"go_data_buffer_dirty_pages", "gauge", "Number of resident pages that have not been written out.",
+		func(s *buffer.Stats) float64 { return float64(s.Dirty) },
+	},
+	{
+		"go_data_buffer_hits_total", "counter", "Number of page fetches found in the buffer pool.",
+		func(s *buffer.Stats) float64 { return float64(s.Hits) },
+	},
+	{
+		"go_data_buffer_misses_total", "counter", "Number of page fetches that had to be read in.",
+		func(s *buffer.Stats) float64 { return float64(s.Misses) },
+	},
+	{
+		"go_data_buffer_hit_ratio", "gauge", "Ratio of page fetches found in the buffer pool.",
+		func(s *buffer.Stats) float64 { return s.HitRate() },
+	},
+	{
+		"go_data_buffer_evictions_total", "counter", "Number of pages victimized to make room.",
+		func(s *buffer.Stats) float64 { return float64(s.Evictions) },
+	},
+	{
+		"go_data_buffer_dirty_flushes_total", "counter", "Number of dirty pages written out.",
+		func(s *buffer.Stats) float64 { return float64(s.DirtyFlushes) },
+	},
+	{
+		"go_data_buffer_pin_waits_total", "counter", "Number of times a page latch had to be waited on.",
+		func(s *buffer.Stats) float64 { return float64(s.PinWaits) },
+	},
+}
+
+// labelEscaper escapes label values in the Prometheus text format.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// Stats returns the buffer pool stats of every namespace, keyed by the name of
+// the namespace.
+func (db *DB) Stats() map[string]*buffer.Stats {
+	stats := make(map[string]*buffer.Stats)
+	db.data.Range(
+		func(path, v any) bool {
+			if ns, ok := v.(Namespace); ok {
+				name := strings.TrimPrefix(path.(string), db.base+"/")
+				stats[name] = ns.Stats()
+			}
+			return true
+		},
+	)
+	return stats
+}
+
+// WriteMetrics writes the buffer pool stats of every namespace to the provided
+// writer in the Prometheus text format. Every sample is labeled using the name
+// of the namespace.
+func (db *DB) WriteMetrics(w io.Writer) error {
+	stats := db.Stats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	bw := bufio.NewWriter(w)
+	for _, m := range bufferMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(bw, "# TYPE %s %s\n", m.name, m.typ)
+		for _, name := range names {
+			fmt.Fprintf(bw, "%s{namespace=\"%s\"} %g\n", m.name, labelEscaper.Replace(name), m.value(stats[name]))
+		}
+	}
+	return bw.Flush()
+}
+
+// HandleMetrics returns an http.Handler serving the buffer pool stats of every
+// namespace in the Prometheus text format, so they can be scraped. It can be
+// mounted next to the handler returned by middleware.HandleMetrics.
+func (db *DB) HandleMetrics() http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metricsContentType)
+		err := db.WriteMetrics(w)
+		if err != nil {
+			code := http.StatusInternalServerError
+			http.Error(w, http.StatusText(code), code)
+			return
+		}
+	}
+	return http.HandlerFunc(fn)
+}
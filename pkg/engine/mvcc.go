@@ -0,0 +1,623 @@
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+const (
+	// versionKeySize is the size of the key of a versioned record. The key holds
+	// the record id, followed by the begin and end stamps of the version.
+	versionKeySize = 4 + 8 + 8
+
+	// pendingStamp is set on stamps written by transactions that have not been
+	// committed yet. The rest of the stamp holds the transaction id.
+	pendingStamp = uint64(1) << 63
+
+	// clockSuffix is the suffix of the file the clock reservations are kept in.
+	clockSuffix = ".clock"
+
+	// clockReserve is the number of timestamps reserved at a time. The clock is
+	// only written to disk once every reservation runs out.
+	clockReserve = 1 << 10
+)
+
+var (
+	ErrTxConflict = errors.New("transaction conflicts with another transaction")
+	ErrTxDone     = errors.New("transaction has already been committed or rolled back")
+)
+
+// version holds the header of a versioned record. Every record version is
+// stamped with the timestamp of the transaction that created it (begin) and
+// the timestamp of the transaction that deleted or replaced it (end). An end
+// stamp of zero means the version is current. Stamps that are still pending
+// hold the id of the transaction that wrote them instead.
+//
+// Records written before versioning was added only hold the record id. They
+// are treated as if they were created at the dawn of time, and they are
+// removed right away when they are deleted (even within a transaction.)
+type version struct {
+	id    uint32
+	begin uint64
+	end   uint64
+}
+
+// decVersion decodes the version from the provided record key.
+func decVersion(key []byte) *version {
+	v := &version{
+		id: binary.LittleEndian.Uint32(key),
+	}
+	if len(key) == versionKeySize {
+		v.begin = binary.LittleEndian.Uint64(key[4:12])
+		v.end = binary.LittleEndian.Uint64(key[12:20])
+	}
+	return v
+}
+
+// key encodes the version as a record key.
+func (v *version) key() []byte {
+	key := make([]byte, versionKeySize)
+	binary.LittleEndian.PutUint32(key, v.id)
+	binary.LittleEndian.PutUint64(key[4:12], v.begin)
+	binary.LittleEndian.PutUint64(key[12:20], v.end)
+	return key
+}
+
+// visible returns a boolean indicating true if the version can be seen using
+// the provided snapshot by the provided transaction. A transaction sees the
+// versions committed up until its snapshot, along with its own changes. The
+// pending stamps of any other transaction are ignored.
+func (v *version) visible(snap, txid uint64) bool {
+	mine := pendingStamp | txid
+	if v.begin != mine && (v.begin&pendingStamp != 0 || v.begin > snap) {
+		// created after the snapshot, or by somebody else who has not
+		// committed yet
+		return false
+	}
+	if v.end == mine {
+		// we deleted it ourselves
+		return false
+	}
+	return v.end == 0 || v.end&pendingStamp != 0 || v.end > snap
+}
+
+// garbage is a record version that has been replaced or deleted, and can be
+// removed once no snapshot can see it anymore.
+type garbage struct {
+	rid uint64
+	end uint64
+}
+
+// Tx is a transaction on a collection. It reads from the snapshot taken when
+// it began, so it never sees the changes committed after that (or the ones
+// that have not been committed yet), along with its own changes. The changes
+// it makes are not seen by anybody else until it is committed, at which point
+// they all become visible at once. If it tries to change a record that was
+// changed by another transaction since the snapshot was taken, the change
+// fails with ErrTxConflict.
+//
+// A Tx must be committed or rolled back once it is no longer needed, so that
+// the record versions held on to by its snapshot can be removed. It should not
+// be used by several goroutines at once.
+type Tx struct {
+	c       *Collection
+	id      uint64               // the transaction id
+	snap    uint64               // the timestamp of the snapshot
+	inserts map[uint64]indexKeys // the versions created by the transaction
+	ended   []uint64             // the versions deleted by the transaction
+	done    bool
+}
+
+// indexKeys holds the secondary index keys of a record version.
+type indexKeys = map[*secondaryIndex][]byte
+
+// openClock reads the clock of the collection, and reserves a fresh batch of
+// timestamps. Everything that was committed before is part of the snapshot.
+func (c *Collection) openClock() error {
+	fp, err := os.OpenFile(c.path+clockSuffix, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	b := make([]byte, 8)
+	_, err = fp.ReadAt(b, 0)
+	if err != nil && err != io.EOF {
+		_ = fp.Close()
+		return err
+	}
+	c.clockFile = fp
+	c.clock = binary.LittleEndian.Uint64(b)
+	c.committed = c.clock
+	c.reserved = c.clock
+	c.active = make(map[uint64]*Tx)
+	return nil
+}
+
+// nextStamp returns the next timestamp. If the reserved timestamps have run
+// out, a new batch is reserved, and written to disk before the timestamp is
+// handed out. The caller must hold the mvcc latch.
+func (c *Collection) nextStamp() (uint64, error) {
+	if c.clock+1 > c.reserved {
+		b := make([]byte, 8)
+		binary.LittleEndian.PutUint64(b, c.reserved+clockReserve)
+		_, err := c.clockFile.WriteAt(b, 0)
+		if err != nil {
+			return 0, err
+		}
+		err = c.clockFile.Sync()
+		if err != nil {
+			return 0, err
+		}
+		c.reserved += clockReserve
+	}
+	c.clock++
+	return c.clock, nil
+}
+
+// isActive returns a boolean indicating true if the transaction matching the
+// provided id is still active.
+func (c *Collection) isActive(txid uint64) bool {
+	c.mvcc.Lock()
+	defer c.mvcc.Unlock()
+	_, found := c.active[txid]
+	return found
+}
+
+// horizon returns the oldest snapshot in use. Versions that were deleted at or
+// before the horizon cannot be seen anymore. The caller must hold the mvcc
+// latch.
+func (c *Collection) horizon() uint64 {
+	h := c.committed
+	for _, tx := range c.active {
+		if tx.snap < h {
+			h = tx.snap
+		}
+	}
+	return h
+}
+
+// Begin starts a new transaction, reading from a snapshot of everything that
+// has been committed so far.
+func (c *Collection) Begin() (*Tx, error) {
+	c.mvcc.Lock()
+	defer c.mvcc.Unlock()
+	txid, err := c.nextStamp()
+	if err != nil {
+		return nil, err
+	}
+	tx := &Tx{
+		c:       c,
+		id:      txid,
+		snap:    c.committed,
+		inserts: make(map[uint64]indexKeys),
+	}
+	c.active[txid] = tx
+	return tx, nil
+}
+
+// autoCommit runs the provided function in a transaction of its own, which is
+// committed if the function succeeds, and rolled back otherwise. The commit is
+// not made durable; that is left up to Commit.
+func (c *Collection) autoCommit(fn func(tx *Tx) error) error {
+	tx, err := c.Begin()
+	if err != nil {
+		return err
+	}
+	err = fn(tx)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.commit(false)
+}
+
+// findOne locates the record version matching the provided id, and decodes it
+// into the provided pointer, as long as it can be seen by the provided snapshot
+// and transaction.
+func (c *Collection) findOne(id uint64, ptr Record, snap, txid uint64) error {
+	if id == badID {
+		return ErrBadID
+	}
+	// decode id to locate the page
+	rid := page.DecodeRecordID(id)
+	// attempt to locate the record (the buffer pool will take care of
+	// fetching and unpinning the page, and reading any overflow pages)
+	rec, err := c.pool.GetRecord(rid)
+	if err != nil {
+		return err
+	}
+	if !decVersion(rec.Key()).visible(snap, txid) {
+		return page.ErrRecordNotFound
+	}
+	// decode into the pointer provided
+	return decRecord(rec, ptr)
+}
+
+// snapshot returns the timestamp of the last commit.
+func (c *Collection) snapshot() uint64 {
+	c.mvcc.Lock()
+	defer c.mvcc.Unlock()
+	return c.committed
+}
+
+// recordKey returns a copy of the key of the record matching the provided
+// record ID. Unlike GetRecord, it does not read any overflow pages.
+func (c *Collection) recordKey(rid *page.RecordID) ([]byte, error) {
+	pg, err := c.pool.FetchPageRead(rid.PageID)
+	if err != nil {
+		return nil, err
+	}
+	rec, err := pg.GetRecord(rid)
+	if uerr := c.pool.UnpinPageRead(rid.PageID); err == nil {
+		err = uerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rec.Key(), nil
+}
+
+// restamp updates the stamps of the record version matching the provided id in
+// place, using the provided function. The caller must hold the writer latch.
+func (c *Collection) restamp(id uint64, fn func(v *version)) error {
+	rid := page.DecodeRecordID(id)
+	pg, err := c.pool.FetchPageWrite(rid.PageID)
+	if err != nil {
+		return err
+	}
+	rec, err := pg.GetRecord(rid)
+	if err == nil {
+		v := decVersion(rec.Key())
+		fn(v)
+		err = pg.SetRecordKey(rid, v.key())
+	}
+	if uerr := c.pool.UnpinPageWrite(rid.PageID, err == nil); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// collect removes the record versions that cannot be seen by any snapshot
+// anymore. The caller must hold the writer latch.
+func (c *Collection) collect() error {
+	c.mvcc.Lock()
+	h := c.horizon()
+	var dead []uint64
+	n := 0
+	for _, g := range c.garbage {
+		if g.end <= h {
+			dead = append(dead, g.rid)
+			continue
+		}
+		c.garbage[n] = g
+		n++
+	}
+	c.garbage = c.garbage[:n]
+	c.mvcc.Unlock()
+	for _, id := range dead {
+		err := c.pool.DelRecord(page.DecodeRecordID(id))
+		if err != nil && !errors.Is(err, page.ErrRecordNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectAll walks all the records, removing the record versions that cannot
+// be seen by any snapshot anymore, along with any versions left behind by a
+// transaction that never finished (because of a crash.) The caller must hold
+// the writer latch.
+func (c *Collection) collectAll() error {
+	err := c.collect()
+	if err != nil {
+		return err
+	}
+	h := func() uint64 {
+		c.mvcc.Lock()
+		defer c.mvcc.Unlock()
+		return c.horizon()
+	}()
+	var dead []*page.RecordID
+	err = c.pool.Scan(
+		func(rid *page.RecordID, rec page.Record) error {
+			v := decVersion(rec.Key())
+			switch {
+			case v.end != 0 && v.end&pendingStamp == 0 && v.end <= h:
+				dead = append(dead, rid)
+			case v.begin&pendingStamp != 0 && !c.isActive(v.begin&^pendingStamp):
+				dead = append(dead, rid)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	for _, rid := range dead {
+		err = c.pool.DelRecord(rid)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FindOne locates the record matching the provided id, as seen by the
+// transaction, and decodes it into the provided pointer.
+func (tx *Tx) FindOne(id uint64, ptr Record) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.c.findOne(id, ptr, tx.snap, tx.id)
+}
+
+// Range calls the provided function for every record seen by the transaction,
+// along with its id, decoding each one of them into the provided pointer. If
+// the provided function returns false, the iteration is stopped.
+func (tx *Tx) Range(ptr Record, fn func(id uint64, rec Record) bool) error {
+	errStop := errors.New("stop")
+	err := tx.scan(
+		func(id uint64, rec page.Record) error {
+			err := decRecord(rec, ptr)
+			if err != nil {
+				return err
+			}
+			if !fn(id, ptr) {
+				return errStop
+			}
+			return nil
+		},
+	)
+	if err == errStop {
+		return nil
+	}
+	return err
+}
+
+// scan calls the provided function for every record version seen by the
+// transaction, along with its id, stopping at the first error.
+func (tx *Tx) scan(fn func(id uint64, rec page.Record) error) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	return tx.c.pool.Scan(
+		func(rid *page.RecordID, rec page.Record) error {
+			if !decVersion(rec.Key()).visible(tx.snap, tx.id) {
+				return nil
+			}
+			return fn(page.EncodeRecordID(rid), rec)
+		},
+	)
+}
+
+// Insert adds the provided record, returning its id. It is not seen by anybody
+// else until the transaction is committed.
+func (tx *Tx) Insert(data Record) (uint64, error) {
+	c := tx.c
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	if tx.done {
+		return badID, ErrTxDone
+	}
+	// extract the index keys, before anything is written
+	keys, err := c.indexKeys(data)
+	if err != nil {
+		return badID, err
+	}
+	// encode the data record, and add it
+	v := &version{
+		id:    data.GetID(),
+		begin: pendingStamp | tx.id,
+	}
+	rec, err := c.encRecord(v, data)
+	if err != nil {
+		return badID, err
+	}
+	id, err := c.addRecord(rec)
+	if err != nil {
+		return badID, err
+	}
+	tx.inserts[id] = keys
+	return id, nil
+}
+
+// Update replaces the record matching the provided id with the provided record,
+// returning the id of the new version.
+func (tx *Tx) Update(id uint64, data Record) (uint64, error) {
+	// make sure the new version can be indexed before the old one is deleted
+	c := tx.c
+	c.latch.Lock()
+	_, err := c.indexKeys(data)
+	c.latch.Unlock()
+	if err != nil {
+		return badID, err
+	}
+	err = tx.Delete(id)
+	if err != nil {
+		return badID, err
+	}
+	return tx.Insert(data)
+}
+
+// Delete deletes the record matching the provided id. It is still seen by
+// everybody else until the transaction is committed.
+func (tx *Tx) Delete(id uint64) error {
+	if id == badID {
+		return ErrBadID
+	}
+	c := tx.c
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	rid := page.DecodeRecordID(id)
+	key, err := c.recordKey(rid)
+	if err != nil {
+		return err
+	}
+	v := decVersion(key)
+	if !v.visible(tx.snap, tx.id) {
+		return page.ErrRecordNotFound
+	}
+	if v.end != 0 {
+		// somebody else deleted it, which is only fine if they never finished
+		if v.end&pendingStamp == 0 || c.isActive(v.end&^pendingStamp) {
+			return ErrTxConflict
+		}
+	}
+	_, mine := tx.inserts[id]
+	if mine || len(key) != versionKeySize {
+		// nobody else has seen it, or it is not versioned, so it can simply
+		// be removed
+		err = c.pool.DelRecord(rid)
+		if err != nil {
+			return err
+		}
+		delete(tx.inserts, id)
+		return c.rangeIndexes(
+			func(si *secondaryIndex) error {
+				return si.remove(id)
+			},
+		)
+	}
+	err = c.restamp(
+		id, func(v *version) {
+			v.end = pendingStamp | tx.id
+		},
+	)
+	if err != nil {
+		return err
+	}
+	tx.ended = append(tx.ended, id)
+	return nil
+}
+
+// Commit commits the transaction, making its changes visible to everybody, and
+// makes them durable.
+func (tx *Tx) Commit() error {
+	return tx.commit(true)
+}
+
+// commit commits the transaction. If durable is true, the changes are made
+// durable in the log before it returns.
+func (tx *Tx) commit(durable bool) error {
+	c := tx.c
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	// Make sure every index key can be added before anything is stamped, so
+	// the commit is not left half done.
+	for _, keys := range tx.inserts {
+		for _, key := range keys {
+			err := checkIndexKey(key)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	c.mvcc.Lock()
+	ts, err := c.nextStamp()
+	c.mvcc.Unlock()
+	if err != nil {
+		return err
+	}
+	// Stamp the changes using the commit timestamp. Nobody can see them yet,
+	// because the timestamp is after every snapshot.
+	for id, keys := range tx.inserts {
+		err = c.restamp(
+			id, func(v *version) {
+				v.begin = ts
+			},
+		)
+		if err != nil {
+			return err
+		}
+		for si, key := range keys {
+			err = si.add(key, id)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, id := range tx.ended {
+		err = c.restamp(
+			id, func(v *version) {
+				v.end = ts
+			},
+		)
+		if err != nil {
+			return err
+		}
+		err = c.rangeIndexes(
+			func(si *secondaryIndex) error {
+				return si.remove(id)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	// Now we can make them visible
+	c.mvcc.Lock()
+	c.committed = ts
+	delete(c.active, tx.id)
+	for _, id := range tx.ended {
+		c.garbage = append(c.garbage, garbage{rid: id, end: ts})
+	}
+	c.mvcc.Unlock()
+	tx.done = true
+	if durable {
+		err = c.pool.Commit()
+		if err != nil {
+			return err
+		}
+	}
+	return c.collect()
+}
+
+// Rollback throws away the changes made by the transaction.
+func (tx *Tx) Rollback() error {
+	c := tx.c
+	c.latch.Lock()
+	defer c.latch.Unlock()
+	if tx.done {
+		return ErrTxDone
+	}
+	// Remove the versions we created (along with any index entries a new index
+	// may have picked up), and undo the deletes.
+	for id := range tx.inserts {
+		err := c.pool.DelRecord(page.DecodeRecordID(id))
+		if err != nil {
+			return err
+		}
+		err = c.rangeIndexes(
+			func(si *secondaryIndex) error {
+				return si.remove(id)
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range tx.ended {
+		err := c.restamp(
+			id, func(v *version) {
+				v.end = 0
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	c.mvcc.Lock()
+	delete(c.active, tx.id)
+	c.mvcc.Unlock()
+	tx.done = true
+	return c.collect()
+}
@@ -0,0 +1,85 @@
+package page
+
+/*
+ * Section containing types and methods for page deltas
+ */
+
+// deltaGap is the number of unchanged bytes allowed between two changed ranges
+// before they are split up into separate deltas.
+const deltaGap = 16
+
+// Delta represents a range of bytes in a page that has changed. It holds the
+// bytes before and after the change, so it can be used to redo or undo it.
+type Delta struct {
+	Offset uint32
+	Before []byte
+	After  []byte
+}
+
+// Diff compares the page against an older copy of the same page and returns
+// the ranges of bytes that have changed. The log sequence number and the
+// checksum in the page header are not compared, because they are maintained
+// separately. If the older copy is nil, the page is compared against a page
+// that is entirely zeroed out.
+func (p *Page) Diff(old Page) []Delta {
+	if old == nil {
+		old = make(Page, len(*p))
+	}
+	var deltas []Delta
+	beg, end := -1, -1
+	for i := 0; i < len(*p); i++ {
+		if i == int(offLSN) {
+			// skip the lsn and the checksum, making sure a delta never spans
+			// across them
+			if beg >= 0 {
+				deltas = append(deltas, newDelta(old, *p, beg, end))
+				beg = -1
+			}
+			i = int(offChecksum) + 3
+			continue
+		}
+		if (*p)[i] == old[i] {
+			continue
+		}
+		if beg >= 0 && i-end > deltaGap {
+			deltas = append(deltas, newDelta(old, *p, beg, end))
+			beg = -1
+		}
+		if beg < 0 {
+			beg = i
+		}
+		end = i + 1
+	}
+	if beg >= 0 {
+		deltas = append(deltas, newDelta(old, *p, beg, end))
+	}
+	return deltas
+}
+
+// newDelta returns a delta holding copies of the before and after bytes found
+// in the provided range.
+func newDelta(before, after Page, beg, end int) Delta {
+	d := Delta{
+		Offset: uint32(beg),
+		Before: make([]byte, end-beg),
+		After:  make([]byte, end-beg),
+	}
+	copy(d.Before, before[beg:end])
+	copy(d.After, after[beg:end])
+	return d
+}
+
+// Redo applies the after bytes of each of the provided deltas to the page.
+func (p *Page) Redo(deltas []Delta) {
+	for _, d := range deltas {
+		copy((*p)[d.Offset:], d.After)
+	}
+}
+
+// Undo applies the before bytes of each of the provided deltas to the page,
+// in reverse order.
+func (p *Page) Undo(deltas []Delta) {
+	for i := len(deltas) - 1; i >= 0; i-- {
+		copy((*p)[deltas[i].Offset:], deltas[i].Before)
+	}
+}
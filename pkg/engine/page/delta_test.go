@@ -0,0 +1,52 @@
+package page
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPage_Diff(t *testing.T) {
+	old := NewPage(1, P_USED)
+	_, err := old.AddRecord(NewRecord(R_STR, R_STR, []byte("key-1"), []byte("value-1")))
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	p := make(Page, PageSize)
+	copy(p, old)
+	_, err = p.AddRecord(NewRecord(R_STR, R_STR, []byte("key-2"), []byte("value-2")))
+	if err != nil {
+		t.Fatalf("add record: %s", err)
+	}
+	// the lsn and checksum should not show up in the deltas
+	p.SetLSN(42)
+	p.SetChecksum()
+	deltas := p.Diff(old)
+	if len(deltas) == 0 {
+		t.Fatalf("diff: expected deltas")
+	}
+	for _, d := range deltas {
+		if d.Offset < uint32(offChecksum)+4 && d.Offset+uint32(len(d.After)) > uint32(offLSN) {
+			t.Errorf("diff: delta at offset %d overlaps the lsn and checksum", d.Offset)
+		}
+	}
+	// redo should bring the old page up to date, and undo should reverse it
+	np := make(Page, PageSize)
+	copy(np, old)
+	np.Redo(deltas)
+	np.SetLSN(42)
+	np.SetChecksum()
+	if !bytes.Equal(np, p) {
+		t.Errorf("redo: pages do not match")
+	}
+	np.Undo(deltas)
+	if len(np.Diff(old)) != 0 {
+		t.Errorf("undo: pages do not match")
+	}
+	// a nil page should be treated as a zeroed page
+	deltas = p.Diff(nil)
+	np = make(Page, PageSize)
+	np.Redo(deltas)
+	if len(p.Diff(np)) != 0 {
+		t.Errorf("redo: pages do not match")
+	}
+}
@@ -0,0 +1,93 @@
+package page
+
+/*
+ * Section containing types and methods for overflow records and overflow pages
+ */
+
+const (
+	// OverflowThreshold is the largest value (in bytes) that should be stored
+	// inline in a page of the default page size. Any larger value should be
+	// written out to a chain of overflow pages, and the record stored in the
+	// page should be an overflow record pointing to the head of the chain
+	// instead.
+	OverflowThreshold = PageSize / 4
+
+	// OverflowPageCapacity is the number of value bytes a single overflow page
+	// of the default page size is able to hold.
+	OverflowPageCapacity = PageSize - pageHeaderSize
+
+	// offsets to be used for decoding and encoding the overflow record value
+	offOvfType   = 0 // valType=uint8		offs=0-1	(1 byte)
+	offOvfLength = 1 // length=uint32		offs=1-5	(4 bytes)
+	offOvfHead   = 5 // head=uint32		offs=5-9	(4 bytes)
+	ovfValSize   = 9
+)
+
+// NewOverflowRecord returns a new overflow record. An overflow record holds the
+// key of the original record, but instead of the value it holds the original
+// value type, the length of the value and the page ID of the first page in the
+// chain of overflow pages the value was written to.
+func NewOverflowRecord(kflag uint8, key []byte, vflag uint8, length uint32, head PageID) Record {
+	val := make([]byte, ovfValSize)
+	val[offOvfType] = vflag & R_VAL
+	encU32(val[offOvfLength:offOvfLength+4], length)
+	encU32(val[offOvfHead:offOvfHead+4], head)
+	return NewRecord(kflag, R_OVF, key, val)
+}
+
+// IsOverflow returns a boolean indicating if the record is an overflow record.
+func (r *Record) IsOverflow() bool {
+	return r.ValType() == R_VAL&R_OVF
+}
+
+// Overflow decodes the overflow record value and returns the original value
+// type, the length of the value and the page ID of the first overflow page.
+func (r *Record) Overflow() (uint8, uint32, PageID) {
+	val := r.Val()
+	if len(val) != ovfValSize {
+		return 0, 0, 0
+	}
+	return val[offOvfType], decU32(val[offOvfLength : offOvfLength+4]), decU32(val[offOvfHead : offOvfHead+4])
+}
+
+// OverflowThresholdOf returns the largest value (in bytes) that should be stored
+// inline in a page of the provided size.
+func OverflowThresholdOf(size int) int {
+	return size / 4
+}
+
+// OverflowPageCapacityOf returns the number of value bytes a single overflow page
+// of the provided size is able to hold. The data ends at the lower bound, which
+// is 16 bits, so a page of MaxPageSize holds a byte less than it could.
+func OverflowPageCapacityOf(size int) int {
+	return int(upperBound(size)) - pageHeaderSize
+}
+
+// NumOverflowPages returns the number of overflow pages of the provided size it
+// takes to hold a value of the provided length.
+func NumOverflowPages(length, size int) int {
+	n := OverflowPageCapacityOf(size)
+	return (length + n - 1) / n
+}
+
+// WriteOverflow marks the page as an overflow page and writes as much of the
+// provided data into the page as it is able to hold. The next page ID is the
+// page holding the rest of the data (if there is any.) It returns the number
+// of bytes that were written.
+func (p *Page) WriteOverflow(b []byte, next PageID) int {
+	n := copy((*p)[pageHeaderSize:upperBound(len(*p))], b)
+	p.setFlags(P_USED | P_OVFL)
+	p.setNext(next)
+	p.setLower(uint16(pageHeaderSize + n))
+	return n
+}
+
+// ReadOverflow returns the data held in an overflow page, along with the page
+// ID of the next page in the chain. If the page is not an overflow page, a nil
+// slice is returned.
+func (p *Page) ReadOverflow() ([]byte, PageID) {
+	if !p.HasFlag(P_OVFL) {
+		return nil, 0
+	}
+	return (*p)[pageHeaderSize:p.GetLower()], p.GetNext()
+}
@@ -6,11 +6,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"reflect"
-	"runtime"
 	"sort"
 	"strings"
-	"sync"
 	"unsafe"
 )
 
@@ -25,12 +24,15 @@ var (
 		return fmt.Errorf("page: The page ID has not been allocated yet (pid=%d)", pid)
 	}
 
+	ErrBadPageSize = fmt.Errorf("page: page size must be a power of two between %d and %d", MinPageSize, MaxPageSize)
+
 	ErrRecordTooSmall = fmt.Errorf("page: record is too small")
 	ErrNoRoom         = fmt.Errorf("page: page is full")
 	ErrEmptyPage      = fmt.Errorf("page: page is empty")
 	ErrInvalidPID     = fmt.Errorf("page: invalid page ID")
 	ErrInvalidSID     = fmt.Errorf("page: page cell pointer ID (slot ID) is invalid")
 	ErrRecordNotFound = fmt.Errorf("page: record not found")
+	ErrLegacyPage     = fmt.Errorf("page: not a valid page in the legacy format")
 
 	ErrBadKeyFlagInRecord    = errors.New("page: record header contained an incorrect flag in the key flag space")
 	ErrBadValFlagInRecord    = errors.New("page: record header contained an incorrect flag in the val flag space")
@@ -50,9 +52,6 @@ var (
  * Section containing types and constants used in the page and associated parts
  */
 
-// Page latch
-var pgLatch sync.Mutex
-
 // PageID represents a page ID
 type PageID = uint32
 
@@ -78,10 +77,24 @@ const (
 	P_NODE uint32 = 0x00000010 // indicates the page is an internal node
 	P_LEAF uint32 = 0x00000020 // indicates the page is a leaf
 	P_ROOT uint32 = 0x00000050 // indicates the page is a root node
-
-	// constants for the page, headers, cellptrs and record sizes
-	PageSize         = 16 << 10
-	pageHeaderSize   = 24
+	P_OVFL uint32 = 0x00000100 // indicates the page is an overflow page
+
+	// PageSize is the default page size. The page size is a property of the
+	// file the pages are stored in, and may be any power of two between
+	// MinPageSize and MaxPageSize.
+	PageSize    = 16 << 10
+	MinPageSize = 4 << 10
+	MaxPageSize = 64 << 10
+
+	// maxUpper is the largest upper bound a page can have. The offsets in the
+	// page are 16 bits, so the last byte of a page of MaxPageSize is not used.
+	maxUpper = 1<<16 - 1
+
+	// constants for the headers, cellptrs and record sizes. The legacy page
+	// header is the one used before the log sequence number, the checksum and
+	// the last cell ID were added to it.
+	pageHeaderSize   = 40
+	legacyHeaderSize = 24
 	pageCellPtrSize  = 8
 	recordHeaderSize = 4
 
@@ -94,8 +107,15 @@ const (
 	offNumFree  uint16 = 18 // numFree=uint16	offs=18-20	(2 bytes)
 	offLower    uint16 = 20 // lower=uint16		offs=20-22 	(2 bytes)
 	offUpper    uint16 = 22 // upper=uint16		offs=22-24	(2 bytes)
+	offLSN      uint16 = 24 // lsn=uint64		offs=24-32	(8 bytes)
+	offChecksum uint16 = 32 // checksum=uint32	offs=32-36	(4 bytes)
+	offLastCell uint16 = 36 // lastCell=uint16	offs=36-38	(2 bytes)
+	// reserved							offs=38-40	(2 bytes)
 )
 
+// crc32cTable is the CRC32C (Castagnoli) table used for the page checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 /*
  * Section containing types and methods for `pageHeader`
  */
@@ -110,6 +130,8 @@ type PageHeader struct {
 	Free  uint16 // number of cells that are free
 	Lower uint16 // lower numFree space bound
 	Upper uint16 // upper numFree space bound
+	LSN   uint64 // log sequence number of the last write
+	CRC   uint32 // checksum of the page data
 }
 
 // Size returns the size of the pageHeader in bytes.
@@ -130,12 +152,23 @@ func (h *PageHeader) String() string {
  * Section containing types and methods for `page`
  */
 
-// Page represents a Page
+// Page represents a Page. A Page does not synchronize access to itself; when
+// a Page is held in a buffer pool frame, the frame latch should be held while
+// reading from or writing to it.
 type Page []byte
 
-// NewPage returns a new Page
+// NewPage returns a new Page of the default page size.
 func NewPage(id uint32, flags uint32) Page {
-	p := make(Page, PageSize, PageSize)
+	return NewPageSize(id, flags, PageSize)
+}
+
+// NewPageSize returns a new Page of the provided size. It panics if the size is
+// not a valid page size.
+func NewPageSize(id uint32, flags uint32, size int) Page {
+	if !ValidPageSize(size) {
+		panic(ErrBadPageSize)
+	}
+	p := make(Page, size, size)
 	p.SetPageHeader(
 		&PageHeader{
 			ID:    id,
@@ -145,12 +178,26 @@ func NewPage(id uint32, flags uint32) Page {
 			Cells: 0,
 			Free:  0,
 			Lower: pageHeaderSize,
-			Upper: PageSize,
+			Upper: upperBound(size),
 		},
 	)
 	return p
 }
 
+// ValidPageSize returns a boolean indicating true if the provided size is a
+// power of two between MinPageSize and MaxPageSize.
+func ValidPageSize(size int) bool {
+	return size >= MinPageSize && size <= MaxPageSize && size&(size-1) == 0
+}
+
+// upperBound returns the upper bound of an empty page of the provided size.
+func upperBound(size int) uint16 {
+	if size > maxUpper {
+		return maxUpper
+	}
+	return uint16(size)
+}
+
 // GetPageHeader decodes and returns a pointer to the PageHeader
 // directly from the Page.
 func (p *Page) GetPageHeader() *PageHeader {
@@ -163,6 +210,8 @@ func (p *Page) GetPageHeader() *PageHeader {
 		Free:  decU16((*p)[offNumFree : offNumFree+2]),
 		Lower: decU16((*p)[offLower : offLower+2]),
 		Upper: decU16((*p)[offUpper : offUpper+2]),
+		LSN:   decU64((*p)[offLSN : offLSN+8]),
+		CRC:   decU32((*p)[offChecksum : offChecksum+4]),
 	}
 }
 
@@ -177,6 +226,8 @@ func (p *Page) SetPageHeader(h *PageHeader) {
 	encU16((*p)[offNumFree:offNumFree+2], h.Free)
 	encU16((*p)[offLower:offLower+2], h.Lower)
 	encU16((*p)[offUpper:offUpper+2], h.Upper)
+	encU64((*p)[offLSN:offLSN+8], h.LSN)
+	encU32((*p)[offChecksum:offChecksum+4], h.CRC)
 }
 
 // getRecordKeyUsingCellPos takes the position of a cellptr and uses it
@@ -233,8 +284,13 @@ func (p *Page) addCell(size uint16) cellptr {
 	p.incrLower(pageCellPtrSize)
 	p.decrUpper(size)
 	// Create a new cell, and promptly re-encode it before returning the cell.
-	c := newCell(p.GetNumCells(), p.GetUpper(), size)
+	c := newCell(p.nextCellID(), p.GetUpper(), size)
 	p.encCell(c, p.GetNumCells()-1)
+	// If there are any free cells, the new cell must be swapped in front of
+	// them, so the used cells stay packed at the front.
+	if p.GetNumFree() > 0 {
+		p.Swap(int(p.GetNumCells()-1), p.Len()-1)
+	}
 	return c
 }
 
@@ -320,6 +376,9 @@ func (p *Page) recycleCell(r Record) cellptr {
 			cp.setFlags(C_USED)
 			cp.setLength(uint16(len(r)))
 			p.encCell(cp, pos)
+			// The used cells must stay packed in front of the free ones, so
+			// we swap the recycled cell into the first free position.
+			p.Swap(int(pos), p.Len())
 			// And decrement the free cell count in the page header.
 			p.decrNumFree(1)
 			// Now, we can return the cell
@@ -356,7 +415,7 @@ func (p *Page) checkRecordID(id *RecordID) error {
 	if id.PageID != PageID(p.GetPageID()) {
 		return ErrInvalidPID
 	}
-	if id.CellID > p.GetNumCells() {
+	if id.CellID > p.GetNumCells() && id.CellID > p.getLastCellID() {
 		return ErrInvalidSID
 	}
 	return nil
@@ -368,9 +427,6 @@ func (p *Page) checkRecordID(id *RecordID) error {
 // The cellptrs are always sorted according to the record key, and the record data
 // is written to the Page last.
 func (p *Page) AddRecord(r Record) (*RecordID, error) {
-	// latch
-	// pgLatch.Lock()
-	// defer pgLatch.Unlock()
 	// Check the record data to ensure it is not empty, and that we have
 	// enough room to add it.
 	err := p.checkRecord(r)
@@ -384,9 +440,7 @@ func (p *Page) AddRecord(r Record) (*RecordID, error) {
 	// Before continuing, we must check to see if we can re-use any cells.
 	if freeCells > 0 {
 		// We do, so let's see if we have any candidates for recycling.
-		pgLatch.Lock()
 		cp = p.recycleCell(r)
-		pgLatch.Unlock()
 		// We will be checking below to see if the cell pointer is valid,
 		// and it will only be valid if we had a successful time recycling
 		// in here, so no need to do anything else, just proceed.
@@ -395,15 +449,11 @@ func (p *Page) AddRecord(r Record) (*RecordID, error) {
 	if !cp.isValid() {
 		// No valid cell pointer found, which means we did not recycle any,
 		// and we are free to allocate a fresh one. So that is what we do.
-		pgLatch.Lock()
 		cp = p.addCell(uint16(len(r)))
-		pgLatch.Unlock()
 	}
 	// We want to ensure we write the record data to the page before we
 	// check or try to sort.
-	pgLatch.Lock()
 	copy((*p)[cp.getOffset():cp.getOffset()+cp.getLength()], r)
-	pgLatch.Unlock()
 	// We will check to see if we need to sort, and if so, we will. Checking
 	// to see if we need to sort is always faster (if a sort does not need
 	// to be done) than performing the actual sort.
@@ -416,9 +466,7 @@ func (p *Page) AddRecord(r Record) (*RecordID, error) {
 		// only O(n*log(n)) calls to data.Less and data.Swap. If your data set
 		// is short, sort.Stable would be very close to as fast, but again,
 		// you should not use it unless it is necessary.
-		pgLatch.Lock()
 		sort.Sort(p)
-		pgLatch.Unlock()
 	}
 	// And finally, return our RecordID
 	return &RecordID{p.GetPageID(), cp.getID()}, nil
@@ -436,22 +484,18 @@ func (p *Page) GetRecord(id *RecordID) (Record, error) {
 	// First, we will attempt to locate the record.
 	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
 		// Check the cell at the provided position.
-		pgLatch.Lock()
 		cp = p.decCell(pos)
-		pgLatch.Unlock()
 		if cp.getID() == id.CellID {
 			// We have located the record. Let's check to make sure it has
 			// not been deleted.
 			if cp.hasFlag(C_FREE) {
 				return nil, ErrRecordNotFound
 			}
-			pgLatch.Lock()
 			// It has not, so we can fetch the record.
 			r := p.getRecordUsingCell(cp)
 			// We should make a copy of it, so we do not mutate the original.
 			rc := make(Record, len(r), len(r))
 			copy(rc, r)
-			pgLatch.Unlock()
 			// Return the record
 			return rc, nil
 		}
@@ -460,6 +504,38 @@ func (p *Page) GetRecord(id *RecordID) (Record, error) {
 	return nil, ErrRecordNotFound
 }
 
+// SetRecordKey overwrites the key of the Record matching the provided Record ID
+// in place. The new key must be the same length as the current one. The Record
+// cellptrs are not re-sorted, so the caller should only use it on pages that do
+// not depend on the sorted order, or in ways that do not change the order.
+func (p *Page) SetRecordKey(id *RecordID, key []byte) error {
+	// Error check the record ID
+	err := p.checkRecordID(id)
+	if err != nil {
+		return err
+	}
+	// Attempt to locate the record.
+	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
+		cp := p.decCell(pos)
+		if cp.getID() != id.CellID {
+			continue
+		}
+		if cp.hasFlag(C_FREE) {
+			return ErrRecordNotFound
+		}
+		// We have located the record, so we can overwrite the key, as long as
+		// it is the same length.
+		r := p.getRecordUsingCell(cp)
+		if len(r.Key()) != len(key) {
+			return ErrBadRecKeyLen
+		}
+		copy(r.Key(), key)
+		return nil
+	}
+	// Otherwise, we did not locate the record
+	return ErrRecordNotFound
+}
+
 // DelRecord attempts to delete a Record using the provided Record ID. The
 // associated cellptr will be marked as free to re-use, and the Record data
 // will be overwritten. Any errors will be returned.
@@ -474,16 +550,13 @@ func (p *Page) DelRecord(id *RecordID) error {
 	// Then, we will attempt to locate the record.
 	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
 		// Check the cell at the provided position
-		pgLatch.Lock()
 		cp = p.decCell(pos)
-		pgLatch.Unlock()
 		if cp.getID() == id.CellID {
 			// We have located the record. Let's check to make sure it has
 			// not been deleted.
 			if cp.hasFlag(C_USED) {
 				// We have located our used record.
 				// First, lock, then we can set it free.
-				pgLatch.Lock()
 				// get our record boundaries
 				beg, end := cp.getBounds()
 				// Overwrite the old record
@@ -499,7 +572,6 @@ func (p *Page) DelRecord(id *RecordID) error {
 				// And increment the free cell count in the page header.
 				p.incrNumFree(1)
 				// Finally, unlock and return
-				pgLatch.Unlock()
 				return nil
 			}
 		}
@@ -514,9 +586,6 @@ func (p *Page) DelRecord(id *RecordID) error {
 // a there is more than one Record in the Page that has the same key then
 // it will return the first one it locates.
 func (p *Page) getRecordByKey(key []byte) *Record {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
 	// First, we will attempt to locate the record.
 	pos := p.findCellPos(key)
 	// Otherwise, we have located it. Let's check to make sure it has
@@ -537,9 +606,6 @@ func (p *Page) getRecordByKey(key []byte) *Record {
 // is found within the current Page, and false if it is not found. The
 // key must be strictly equal.
 func (p *Page) hasKey(k []byte) bool {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
 	// create local cell pointer variable
 	var cp cellptr
 	// loop through the used cells
@@ -555,12 +621,46 @@ func (p *Page) hasKey(k []byte) bool {
 	return false
 }
 
+// SearchRecords performs a binary search through the used cellptrs using the
+// provided Record key. It returns the sorted position of the first Record with
+// a key that is greater than or equal to the provided key, along with a boolean
+// indicating true if the Record at that position is an exact match.
+func (p *Page) SearchRecords(k []byte) (int, bool) {
+	// The invariants here are the same ones as in findCellPos, but we only
+	// search through the used (and sorted) cellptrs.
+	n := p.Len()
+	i, j := 0, n
+	for i < j {
+		h := int(uint(i+j) >> 1) // avoid overflow when computing h
+		// i ≤ h < j
+		if bytes.Compare(k, p.getRecordKeyUsingCellPos(uint16(h))) > 0 {
+			i = h + 1
+		} else {
+			j = h
+		}
+	}
+	return i, i < n && bytes.Equal(k, p.getRecordKeyUsingCellPos(uint16(i)))
+}
+
+// GetRecordAt returns the RecordID along with a copy of the Record found at the
+// provided sorted position. The position must fall within the used cellptrs.
+func (p *Page) GetRecordAt(pos int) (*RecordID, Record, error) {
+	// Error check the position
+	if pos < 0 || pos >= p.Len() {
+		return nil, nil, ErrRecordNotFound
+	}
+	// get the cell, and make a copy of the record, so we do not mutate the
+	// original.
+	c := p.decCell(uint16(pos))
+	r := p.getRecordUsingCell(c)
+	rc := make(Record, len(r), len(r))
+	copy(rc, r)
+	return p.makeRecordID(c), rc, nil
+}
+
 // RangeRecords is an iterator methods that uses a simple callback. It
 // returns any errors encountered.
 func (p *Page) RangeRecords(fn func(r *Record) error) error {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
 	// iterate
 	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
 		c := p.decCell(pos)
@@ -581,9 +681,6 @@ func (p *Page) RangeRecords(fn func(r *Record) error) error {
 // rangeNRecords is a bounded iterator methods that uses a simple callback. It
 // returns any errors encountered.
 func (p *Page) rangeNRecords(beg, end int, fn func(r *Record) error) error {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
 	// error check
 	if beg < 0 {
 		beg = 0
@@ -664,26 +761,30 @@ func (p *Page) findCellPos(k []byte) uint16 {
 	return uint16(i) // , i < n && at == 0
 }
 
-// Clear resets the entire Page. It wipes all the data, but retains the same ID.
+// Clear resets the entire Page. It wipes all the data, but retains the same ID
+// and log sequence number. The page is cleared in place.
 func (p *Page) Clear() {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
 	// clear the page out
-	*p = NewPage(p.GetPageID(), P_FREE)
+	np := NewPageSize(p.GetPageID(), P_FREE, len(*p))
+	np.SetLSN(p.GetLSN())
+	copy(*p, np)
 }
 
 // Vacuum is a method that sucks up any free space within the page, removing any
 // gaps, and essentially compacting the page, so it can be better utilized if it
-// is getting full. This method must be called manually.
+// is getting full. The records keep their record IDs, and the page is compacted
+// in place, so it can be vacuumed while it is held in a buffer pool frame. This
+// method must be called manually.
 func (p *Page) Vacuum() {
-	// latch
-	pgLatch.Lock()
-	defer pgLatch.Unlock()
-	// First, we must allocate a new page to copy data into.
-	np := NewPage(p.GetPageID(), p.GetFlags())
+	// First, we must allocate a new page to copy data into, carrying over
+	// everything in the header that is not about the cells.
+	np := NewPageSize(p.GetPageID(), p.GetFlags(), len(*p))
+	h := np.GetPageHeader()
+	h.Prev, h.Next, h.LSN = p.GetPrev(), p.GetNext(), p.GetLSN()
+	np.SetPageHeader(h)
+	np.setLastCellID(p.getLastCellID())
 	// We will initialize local states here, so we only have to set them once.
-	numCells, lowerBound, upperBound := uint16(0), uint16(pageHeaderSize), uint16(PageSize)
+	numCells, lowerBound, upperBound := uint16(0), uint16(pageHeaderSize), upperBound(len(*p))
 	// Next we iterate the current non-free cells and add the records to the new page.
 	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
 		// get the cell for the current position.
@@ -716,11 +817,156 @@ func (p *Page) Vacuum() {
 	// Then, we will sort the cells according to their key, just one time
 	// at the very end.
 	sort.Sort(&np)
-	// And now we are finished compacting everything, so we will swap the page
-	// pointers.
-	*p = np
-	// We will call the GC directly here, and return
-	runtime.GC()
+	// The cell IDs we carried over may be larger than the number of cells now,
+	// so make sure they are not handed out again.
+	for pos := uint16(0); pos < numCells; pos++ {
+		c := np.decCell(pos)
+		if c.getID() > np.getLastCellID() {
+			np.setLastCellID(c.getID())
+		}
+	}
+	// And now we are finished compacting everything, so we will copy the new
+	// page over the old one.
+	copy(*p, np)
+}
+
+// UpgradeLegacyPage converts a page using the legacy page format, which has a
+// smaller page header without a log sequence number or checksum, into the current
+// page format, in place. The records keep their record IDs, but the page is
+// compacted, much like it is by Vacuum, to make room for the larger header. A
+// page that is entirely zeroed out has never been written, and is left alone.
+// If the page is not a valid legacy page, ErrLegacyPage is returned, and if the
+// records do not fit once the header is larger, ErrNoRoom is returned.
+func UpgradeLegacyPage(p Page) error {
+	if !ValidPageSize(len(p)) {
+		return ErrBadPageSize
+	}
+	zero := true
+	for i := range p {
+		if p[i] != 0 {
+			zero = false
+			break
+		}
+	}
+	if zero {
+		return nil
+	}
+	// The legacy page header is the first part of the current one, so it can
+	// be decoded as usual, as long as we leave the rest of it alone.
+	h := p.GetPageHeader()
+	if h.Lower != legacyHeaderSize+h.Cells*pageCellPtrSize || h.Lower > h.Upper ||
+		h.Upper > upperBound(len(p)) || h.Free > h.Cells {
+		return ErrLegacyPage
+	}
+	np := NewPageSize(h.ID, h.Flags, len(p))
+	nh := np.GetPageHeader()
+	nh.Prev, nh.Next = h.Prev, h.Next
+	np.SetPageHeader(nh)
+	numCells, lowerBound, upperBound := uint16(0), uint16(pageHeaderSize), np.GetUpper()
+	var lastCellID uint16
+	for pos := uint16(0); pos < h.Cells; pos++ {
+		off := legacyHeaderSize + pos*pageCellPtrSize
+		c := cellptr(decU64(p[off : off+8]))
+		if !c.isValid() {
+			return ErrLegacyPage
+		}
+		if c.getID() > lastCellID {
+			lastCellID = c.getID()
+		}
+		if !c.hasFlag(C_USED) {
+			continue
+		}
+		beg, end := c.getBounds()
+		if beg < h.Upper || end < beg || int(end) > len(p) {
+			return ErrLegacyPage
+		}
+		if int(lowerBound)+pageCellPtrSize > int(upperBound)-int(end-beg) {
+			return ErrNoRoom
+		}
+		// Copy the record over, keeping the cell ID
+		numCells++
+		lowerBound += pageCellPtrSize
+		upperBound -= end - beg
+		nc := newCell(c.getID(), upperBound, end-beg)
+		encU64(np[lowerBound-pageCellPtrSize:lowerBound], uint64(nc))
+		copy(np[upperBound:upperBound+(end-beg)], p[beg:end])
+	}
+	np.setNumCells(numCells)
+	np.setLower(lowerBound)
+	np.setUpper(upperBound)
+	np.setLastCellID(lastCellID)
+	// The cells were sorted in the legacy page, but sorting again costs next to
+	// nothing if they still are.
+	if !sort.IsSorted(&np) {
+		sort.Sort(&np)
+	}
+	copy(p, np)
+	return nil
+}
+
+// FreeSpace returns the number of bytes of contiguous free space in the Page.
+func (p *Page) FreeSpace() int {
+	return int(p.GetUpper() - p.GetLower())
+}
+
+// FragmentedSpace returns the number of bytes held by the free cells (and the
+// records they used to point to) in the Page, which can be reclaimed by calling
+// Vacuum.
+func (p *Page) FragmentedSpace() int {
+	var n int
+	for pos := uint16(0); pos < p.GetNumCells(); pos++ {
+		if c := p.decCell(pos); c.hasFlag(C_FREE) {
+			n += pageCellPtrSize + int(c.getLength())
+		}
+	}
+	return n
+}
+
+// UsedSpace returns the number of bytes used by the records (and their cells)
+// that are still in use in the Page.
+func (p *Page) UsedSpace() int {
+	return int(upperBound(len(*p))) - pageHeaderSize - p.FreeSpace() - p.FragmentedSpace()
+}
+
+// nextCellID returns the ID to be used for a new cell, and records it as the
+// last cell ID handed out. Cell IDs that are still in use are never handed out
+// again, even after the page has been vacuumed.
+func (p *Page) nextCellID() uint16 {
+	id := p.GetNumCells()
+	if last := p.getLastCellID(); last >= id {
+		id = last + 1
+		if id == 0 {
+			// We ran out of cell IDs, so we look for one that is not in use
+			id = p.unusedCellID()
+		}
+	}
+	p.setLastCellID(id)
+	return id
+}
+
+// unusedCellID returns the smallest cell ID that is not in use by any cell.
+func (p *Page) unusedCellID() uint16 {
+	used := make(map[uint16]bool, p.GetNumCells())
+	for pos := uint16(0); pos < p.GetNumCells()-1; pos++ {
+		c := p.decCell(pos)
+		used[c.getID()] = true
+	}
+	id := uint16(1)
+	for used[id] {
+		id++
+	}
+	return id
+}
+
+// getLastCellID decodes and returns the last cell ID handed out, directly from
+// the encoded PageHeader.
+func (p *Page) getLastCellID() uint16 {
+	return decU16((*p)[offLastCell : offLastCell+2])
+}
+
+// setLastCellID encodes the last cell ID handed out directly into the PageHeader.
+func (p *Page) setLastCellID(id uint16) {
+	encU16((*p)[offLastCell:offLastCell+2], id)
 }
 
 // GetPageID decodes and returns the Page ID directly from the encoded PageHeader.
@@ -768,6 +1014,42 @@ func (p *Page) GetUpper() uint16 {
 	return decU16((*p)[offUpper : offUpper+2])
 }
 
+// GetLSN decodes and returns the log sequence number directly from the encoded PageHeader.
+func (p *Page) GetLSN() uint64 {
+	return decU64((*p)[offLSN : offLSN+8])
+}
+
+// SetLSN encodes the provided log sequence number directly into the PageHeader.
+func (p *Page) SetLSN(n uint64) {
+	encU64((*p)[offLSN:offLSN+8], n)
+}
+
+// GetChecksum decodes and returns the stored checksum directly from the encoded PageHeader.
+func (p *Page) GetChecksum() uint32 {
+	return decU32((*p)[offChecksum : offChecksum+4])
+}
+
+// CalcChecksum calculates and returns the CRC32C checksum of the Page. The
+// checksum field in the PageHeader is treated as if it were zero.
+func (p *Page) CalcChecksum() uint32 {
+	var zero [4]byte
+	crc := crc32.Update(0, crc32cTable, (*p)[:offChecksum])
+	crc = crc32.Update(crc, crc32cTable, zero[:])
+	return crc32.Update(crc, crc32cTable, (*p)[offChecksum+4:])
+}
+
+// SetChecksum calculates the checksum of the Page and encodes it directly into
+// the PageHeader.
+func (p *Page) SetChecksum() {
+	encU32((*p)[offChecksum:offChecksum+4], p.CalcChecksum())
+}
+
+// VerifyChecksum returns a boolean indicating true if the stored checksum matches
+// the calculated checksum of the Page.
+func (p *Page) VerifyChecksum() bool {
+	return p.GetChecksum() == p.CalcChecksum()
+}
+
 // setPageID encodes the provided value directly into the PageHeader.
 func (p *Page) setPageID(n uint32) {
 	encU32((*p)[offPID:offPID+4], n)
@@ -959,7 +1241,7 @@ func newCell(id, offset, length uint16) cellptr {
 // the Record could fit in the current offset. It is used when attempting
 // to recycle a cell pointer.
 func (c *cellptr) canFit(length uint16) bool {
-	return uint16(*c>>shift6B) <= length
+	return length <= uint16(*c>>shift6B)
 }
 
 // isValid returns true if the cell pointer is valid (contains the magic flag)
@@ -70,6 +70,63 @@ func TestPage_NewEmptyPage(t *testing.T) {
 	}
 }
 
+func TestPage_NewPageSize(t *testing.T) {
+	for _, size := range []int{MinPageSize, 8 << 10, MaxPageSize} {
+		p := NewPageSize(3, P_USED, size)
+		if len(p) != size {
+			t.Errorf("got %v, expected %v\n", len(p), size)
+		}
+		// the offsets are 16 bits, so a page of the max size gives up a byte
+		upper := size
+		if upper > 1<<16-1 {
+			upper = 1<<16 - 1
+		}
+		if int(p.GetUpper()) != upper {
+			t.Errorf("got %v, expected %v\n", p.GetUpper(), upper)
+		}
+		// fill the page up, and make sure the last record ends at the upper bound
+		var ids []*RecordID
+		for i := 0; ; i++ {
+			id, err := p.AddRecord(NewRecord(R_NUM, R_STR, []byte(fmt.Sprintf("%.6d", i)), []byte("value")))
+			if err == ErrNoRoom {
+				break
+			}
+			if err != nil {
+				t.Fatalf("[size=%d] %s", size, err)
+			}
+			ids = append(ids, id)
+		}
+		r, err := p.GetRecord(ids[0])
+		if err != nil {
+			t.Fatalf("[size=%d] %s", size, err)
+		}
+		if !bytes.Equal(r.Key(), []byte("000000")) || !bytes.Equal(r.Val(), []byte("value")) {
+			t.Errorf("[size=%d] got %q=%q, expected %q=%q\n", size, r.Key(), r.Val(), "000000", "value")
+		}
+		// free every other record, and vacuum
+		for i := 0; i < len(ids); i += 2 {
+			err = p.DelRecord(ids[i])
+			if err != nil {
+				t.Fatalf("[size=%d] %s", size, err)
+			}
+		}
+		used := p.UsedSpace()
+		p.Vacuum()
+		if p.UsedSpace() != used || p.FragmentedSpace() != 0 {
+			t.Errorf("[size=%d] got %d used (%d fragmented), expected %d used\n", size, p.UsedSpace(), p.FragmentedSpace(), used)
+		}
+		if got := pageHeaderSize + p.UsedSpace() + p.FreeSpace(); got != upper {
+			t.Errorf("[size=%d] got %v, expected %v\n", size, got, upper)
+		}
+		for i := 1; i < len(ids); i += 2 {
+			_, err = p.GetRecord(ids[i])
+			if err != nil {
+				t.Errorf("[size=%d] %s", size, err)
+			}
+		}
+	}
+}
+
 func TestPage_addRecord(t *testing.T) {
 	p := NewPage(3, P_USED)
 	_, err := addRecords(p)
@@ -126,16 +183,53 @@ func TestPage_DelRecord(t *testing.T) {
 	}
 }
 
+func TestPage_SetRecordKey(t *testing.T) {
+	p := NewPage(3, P_USED)
+	rid, err := p.AddRecord(NewRecord(R_STR, R_STR, []byte("key-1"), []byte("value")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetRecordKey(rid, []byte("key-2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := p.GetRecord(rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(r.Key()) != "key-2" || string(r.Val()) != "value" {
+		t.Errorf("got %q=%q, expected %q=%q\n", r.Key(), r.Val(), "key-2", "value")
+	}
+	// the key length cannot change
+	err = p.SetRecordKey(rid, []byte("key-10"))
+	if err != ErrBadRecKeyLen {
+		t.Errorf("got %v, expected %v\n", err, ErrBadRecKeyLen)
+	}
+	err = p.DelRecord(rid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.SetRecordKey(rid, []byte("key-3"))
+	if err != ErrRecordNotFound {
+		t.Errorf("got %v, expected %v\n", err, ErrRecordNotFound)
+	}
+}
+
 func TestPage_Sync(t *testing.T) {
 	p := NewPage(3, P_USED)
 	ids, err := addRecords(p)
 	if err != nil {
 		t.Error(err)
 	}
+	// a page does not synchronize access to itself, so we use a latch
+	// the same way a buffer pool frame would
+	var latch sync.RWMutex
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
+		latch.RLock()
 		err := getRecords(p, ids)
+		latch.RUnlock()
 		if err != nil {
 			if err != ErrRecordNotFound {
 				t.Error(err)
@@ -144,7 +238,9 @@ func TestPage_Sync(t *testing.T) {
 		wg.Done()
 	}()
 	go func() {
+		latch.Lock()
 		err := delRecords(p, ids)
+		latch.Unlock()
 		if err != nil {
 			t.Error(err)
 		}
@@ -409,6 +505,143 @@ func TestPage_Vacuum(t *testing.T) {
 	runtime.GC()
 }
 
+func TestPage_VacuumInPlace(t *testing.T) {
+	p := NewPage(7, P_USED)
+	h := p.GetPageHeader()
+	h.Prev, h.Next = 6, 8
+	p.SetPageHeader(h)
+	p.SetLSN(99)
+	// hold on to the page the same way a buffer pool frame would
+	frame := p
+	var rids []*RecordID
+	for i := 0; i < 10; i++ {
+		id, err := p.AddRecord(NewRecord(R_NUM, R_STR, []byte{byte(i)}, bytes.Repeat([]byte{'x'}, 100)))
+		if err != nil {
+			t.Fatalf("add record: %s", err)
+		}
+		rids = append(rids, id)
+	}
+	for _, id := range rids[:8] {
+		err := p.DelRecord(id)
+		if err != nil {
+			t.Fatalf("del record: %s", err)
+		}
+	}
+	frag, free := p.FragmentedSpace(), p.FreeSpace()
+	if frag == 0 {
+		t.Fatalf("fragmented space: expected some")
+	}
+	p.Vacuum()
+	if p.FragmentedSpace() != 0 || p.FreeSpace() != free+frag {
+		t.Errorf("vacuum: expected %d bytes free, got %d", free+frag, p.FreeSpace())
+	}
+	// the page should have been vacuumed in place, keeping the header
+	if frame.GetNumCells() != 2 {
+		t.Errorf("vacuum: the page was not vacuumed in place")
+	}
+	if p.GetPrev() != 6 || p.GetNext() != 8 || p.GetLSN() != 99 {
+		t.Errorf("vacuum: header was not kept: %s", p.GetPageHeader())
+	}
+	// the remaining records should keep their ids
+	for i, id := range rids[8:] {
+		rec, err := p.GetRecord(id)
+		if err != nil || rec.Key()[0] != byte(8+i) {
+			t.Errorf("get record %s: got %v (%v)", id, rec, err)
+		}
+	}
+	// and new records should never be given an id that is still in use
+	for i := 0; i < 10; i++ {
+		id, err := p.AddRecord(NewRecord(R_NUM, R_STR, []byte{byte(20 + i)}, []byte("new")))
+		if err != nil {
+			t.Fatalf("add record: %s", err)
+		}
+		for _, old := range rids[8:] {
+			if id.CellID == old.CellID {
+				t.Fatalf("add record: cell id %d is already in use", id.CellID)
+			}
+		}
+		rec, err := p.GetRecord(id)
+		if err != nil || rec.Key()[0] != byte(20+i) {
+			t.Errorf("get record %s: got %v (%v)", id, rec, err)
+		}
+	}
+}
+
+// legacyPage returns a copy of the provided page, using the legacy page format.
+func legacyPage(p Page) Page {
+	lp := make(Page, len(p))
+	copy(lp[:legacyHeaderSize], p[:legacyHeaderSize])
+	copy(lp[legacyHeaderSize:], p[pageHeaderSize:p.GetLower()])
+	copy(lp[p.GetUpper():], p[p.GetUpper():])
+	lp.setLower(p.GetLower() - (pageHeaderSize - legacyHeaderSize))
+	return lp
+}
+
+func TestPage_UpgradeLegacyPage(t *testing.T) {
+	p := NewPage(3, P_USED)
+	h := p.GetPageHeader()
+	h.Next = 4
+	p.SetPageHeader(h)
+	var rids []*RecordID
+	for i := 0; i < 10; i++ {
+		id, err := p.AddRecord(NewRecord(R_NUM, R_STR, []byte{byte(i)}, bytes.Repeat([]byte{'x'}, 100)))
+		if err != nil {
+			t.Fatalf("add record: %s", err)
+		}
+		rids = append(rids, id)
+	}
+	err := p.DelRecord(rids[9])
+	if err != nil {
+		t.Fatalf("del record: %s", err)
+	}
+	lp := legacyPage(p)
+	err = UpgradeLegacyPage(lp)
+	if err != nil {
+		t.Fatalf("upgrade: %s", err)
+	}
+	if lp.GetPageID() != 3 || lp.GetNext() != 4 || lp.GetFlags() != P_USED || lp.GetLSN() != 0 {
+		t.Errorf("upgrade: header was not kept: %s", lp.GetPageHeader())
+	}
+	if lp.Len() != 9 || lp.FragmentedSpace() != 0 {
+		t.Errorf("upgrade: expected 9 records and no fragmented space, got %d and %d", lp.Len(), lp.FragmentedSpace())
+	}
+	// the records should keep their ids
+	for i, id := range rids[:9] {
+		rec, err := lp.GetRecord(id)
+		if err != nil || rec.Key()[0] != byte(i) {
+			t.Errorf("get record %s: got %v (%v)", id, rec, err)
+		}
+	}
+	// and new records should not be given an id that has been handed out
+	id, err := lp.AddRecord(NewRecord(R_NUM, R_STR, []byte{byte(20)}, []byte("new")))
+	if err != nil || id.CellID <= rids[9].CellID {
+		t.Errorf("add record: got cell id %v (%v), expected more than %d", id, err, rids[9].CellID)
+	}
+
+	// a page that has never been written is left alone
+	zp := make(Page, PageSize)
+	err = UpgradeLegacyPage(zp)
+	if err != nil || !bytes.Equal(zp, make(Page, PageSize)) {
+		t.Errorf("upgrade: expected a zeroed page to be left alone (%v)", err)
+	}
+	// a page in the current format is not a legacy page
+	err = UpgradeLegacyPage(NewPage(5, P_USED))
+	if err != ErrLegacyPage {
+		t.Errorf("upgrade: expected %v, got %v", ErrLegacyPage, err)
+	}
+	// and a legacy page that is full cannot be upgraded, as the header is larger
+	fp := make(Page, PageSize)
+	rec := NewRecord(R_NUM, R_STR, []byte{1}, make([]byte, PageSize-legacyHeaderSize-pageCellPtrSize-recordHeaderSize-1))
+	upper := uint16(PageSize - len(rec))
+	copy(fp[upper:], rec)
+	fp.SetPageHeader(&PageHeader{ID: 6, Flags: P_USED, Cells: 1, Lower: legacyHeaderSize + pageCellPtrSize, Upper: upper})
+	encU64(fp[legacyHeaderSize:legacyHeaderSize+pageCellPtrSize], uint64(newCell(1, upper, uint16(len(rec)))))
+	err = UpgradeLegacyPage(fp)
+	if err != ErrNoRoom {
+		t.Errorf("upgrade: expected %v, got %v", ErrNoRoom, err)
+	}
+}
+
 // make sure to run with `env GODEBUG=gctrace=1 godoc -http=:6060`
 
 func TestPageGC(t *testing.T) {
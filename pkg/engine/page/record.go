@@ -22,6 +22,7 @@ const (
 	R_NUM = 0x11
 	R_STR = 0x22
 	R_PTR = 0x44
+	R_OVF = 0x88
 
 	// record flags
 	R_NUM_NUM = (R_KEY | R_VAL) & R_NUM
@@ -31,6 +32,18 @@ const (
 	R_STR_NUM = (R_KEY & R_STR) | (R_VAL & R_NUM)
 	R_NUM_PTR = (R_KEY & R_NUM) | (R_VAL & R_PTR)
 	R_STR_PTR = (R_KEY & R_STR) | (R_VAL & R_PTR)
+	R_NUM_OVF = (R_KEY & R_NUM) | (R_VAL & R_OVF)
+	R_STR_OVF = (R_KEY & R_STR) | (R_VAL & R_OVF)
+
+	// maxKeyLen is the largest key length that can be stored in a record
+	maxKeyLen = 0xff
+
+	// valLenExt is stored in the value length of the record header when the
+	// value is too large for the header to hold. It indicates that the value
+	// runs to the end of the record. Records like this are never written to
+	// a page, they are only handed out after an overflow value has been read
+	// back in.
+	valLenExt = 0xffff
 )
 
 func makeRecordFlags(kt, vt uint8) uint8 {
@@ -39,7 +52,7 @@ func makeRecordFlags(kt, vt uint8) uint8 {
 		panic("Record: bad key flag")
 	}
 	mask = vt & R_VAL
-	if mask != 0x01 && mask != 0x02 && mask != 0x04 {
+	if mask != 0x01 && mask != 0x02 && mask != 0x04 && mask != 0x08 {
 		panic("Record: bad val flag")
 	}
 	return (R_KEY & kt) | (R_VAL & vt)
@@ -49,9 +62,11 @@ var rFlags = []uint8{
 	0x11, // number types for keys and number types for values
 	0x12, // number types for keys and string types for values
 	0x14, // number types for keys and pointer types for values
+	0x18, // number types for keys and overflow types for values
 	0x21, // string types for keys and number types for values
 	0x22, // string types for keys and string types for values
 	0x24, // string types for keys and pointer types for values
+	0x28, // string types for keys and overflow types for values
 }
 
 func inSet(f uint8) bool {
@@ -66,7 +81,7 @@ func inSet(f uint8) bool {
 var (
 	ErrBadRecFlags  = errors.New("bad record flag option")
 	ErrBadRecKeyLen = errors.New("bad record key length, max length is 255")
-	ErrBadRecValLen = errors.New("bad record value length, max length is 65535")
+	ErrBadRecValLen = errors.New("bad record value length")
 )
 
 func setHiBits(flag *uint8, t uint8) {
@@ -88,15 +103,19 @@ type recordHeader struct {
 // newRecordHeader constructs and returns a Record header using the provided flags
 // along with the provided key and value data
 func newRecordHeader(flags uint8, klen, vlen int) (*recordHeader, error) {
-	if uint8(klen) > ^uint8(0) {
+	if klen < 0 || klen > maxKeyLen {
 		return nil, ErrBadRecKeyLen
 	}
-	if uint16(vlen) > ^uint16(0) {
+	if vlen < 0 {
 		return nil, ErrBadRecValLen
 	}
 	if !inSet(flags) {
 		return nil, ErrBadRecFlags
 	}
+	if vlen >= valLenExt {
+		// The value is too large for the header, so we mark it as extended
+		vlen = valLenExt
+	}
 	return &recordHeader{
 		Flags:  flags,
 		KeyLen: uint8(klen),
@@ -104,11 +123,6 @@ func newRecordHeader(flags uint8, klen, vlen int) (*recordHeader, error) {
 	}, nil
 }
 
-// size returns the size of a Record header
-func (rh *recordHeader) size() int {
-	return recordHeaderSize + int(rh.KeyLen) + int(rh.ValLen)
-}
-
 // Record is a binary type
 type Record []byte
 
@@ -119,7 +133,8 @@ func NewRecord(kflag, vflag uint8, key, val []byte) Record {
 	if err != nil {
 		panic(err)
 	}
-	rec := make(Record, rh.size(), rh.size())
+	size := recordHeaderSize + len(key) + len(val)
+	rec := make(Record, size, size)
 	rec.encRecordHeader(rh)
 	n := copy(rec[recordHeaderSize:], key)
 	copy(rec[recordHeaderSize+n:], val)
@@ -162,6 +177,10 @@ func (r *Record) Key() []byte {
 
 // Val returns the underlying slice of bytes representing the record value
 func (r *Record) Val() []byte {
+	if decU16((*r)[2:4]) == valLenExt {
+		// extended value, it runs to the end of the record
+		return (*r)[recordHeaderSize+int((*r)[1]):]
+	}
 	return (*r)[recordHeaderSize+(*r)[1] : uint16(recordHeaderSize+(*r)[1])+decU16((*r)[2:4])]
 }
 
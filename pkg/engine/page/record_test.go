@@ -20,6 +20,10 @@ func TestRecord_Flags(t *testing.T) {
 		// pointer types
 		{0x14, R_NUM_PTR, true},
 		{0x24, R_STR_PTR, true},
+
+		// overflow types
+		{0x18, R_NUM_OVF, true},
+		{0x28, R_STR_OVF, true},
 	}
 	for i, tt := range tests {
 		if tt.got != tt.want {
@@ -32,6 +36,27 @@ func TestRecord_Flags(t *testing.T) {
 	}
 }
 
+func TestRecord_Overflow(t *testing.T) {
+	rec := NewOverflowRecord(R_STR, []byte("doc"), R_STR, 1<<20, 42)
+	if !rec.IsOverflow() {
+		t.Errorf("expected an overflow record, got flags 0x%.2x", rec.Flags())
+	}
+	vt, length, head := rec.Overflow()
+	if vt != R_VAL&R_STR || length != 1<<20 || head != 42 {
+		t.Errorf("got vt=0x%.2x, length=%d, head=%d", vt, length, head)
+	}
+	// a value too large for the record header should still come back out
+	val := make([]byte, 100000)
+	val[len(val)-1] = 'x'
+	rec = NewRecord(R_STR, R_STR, []byte("doc"), val)
+	if rec.IsOverflow() || len(rec.Val()) != len(val) || rec.Val()[len(val)-1] != 'x' {
+		t.Errorf("got val length %d, expected %d", len(rec.Val()), len(val))
+	}
+	if string(rec.Key()) != "doc" {
+		t.Errorf("got key %q, expected %q", rec.Key(), "doc")
+	}
+}
+
 func makeValue(i byte) []byte {
 	return []byte{
 		't', 'h', 'i', 's',
@@ -0,0 +1,159 @@
+package engine
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	crashDirEnv   = "GO_DATA_CRASH_DIR"
+	crashStartEnv = "GO_DATA_CRASH_START"
+)
+
+// crashUser returns the user written by the crash writer for the provided id.
+// Every 7th user is large enough to be written out to overflow pages.
+func crashUser(id int) *User {
+	name := fmt.Sprintf("user #%d", id)
+	if id%7 == 6 {
+		name = strings.Repeat(name+" ", 2000)
+	}
+	return &User{uint32(id), name, fmt.Sprintf("user-%d@example.com", id), id%2 == 0}
+}
+
+// TestCrashRecovery_Writer is not a test on its own. It is run in a separate
+// process by TestCrashRecovery, and inserts users (committing after every 5th
+// one) until it is killed. It reports every insert and commit on stdout.
+func TestCrashRecovery_Writer(t *testing.T) {
+	path := os.Getenv(crashDirEnv)
+	if path == "" {
+		t.Skip("only runs as a child process of TestCrashRecovery")
+	}
+	start, err := strconv.Atoi(os.Getenv(crashStartEnv))
+	if err != nil {
+		t.Fatalf("start: %s", err)
+	}
+	db, err := OpenDB(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	docs, err := db.Create("docs.db")
+	if err != nil {
+		t.Fatalf("create: %s", err)
+	}
+	for i := start; ; i++ {
+		id, err := docs.Insert(crashUser(i))
+		if err != nil {
+			t.Fatalf("insert: %s", err)
+		}
+		fmt.Printf("insert %d %d\n", i, id)
+		if i%5 == 4 {
+			err = docs.Commit()
+			if err != nil {
+				t.Fatalf("commit: %s", err)
+			}
+			fmt.Println("commit")
+		}
+	}
+}
+
+func TestCrashRecovery(t *testing.T) {
+	if os.Getenv(crashDirEnv) != "" {
+		return
+	}
+	path := t.TempDir()
+
+	committed := make(map[int]uint64)
+	var next int
+	for round := 0; round < 8; round++ {
+		// start up a writer, and kill it at some random point
+		cmd := exec.Command(os.Args[0], "-test.run=^TestCrashRecovery_Writer$")
+		cmd.Env = append(os.Environ(), crashDirEnv+"="+path, crashStartEnv+"="+strconv.Itoa(next))
+		out, err := cmd.StdoutPipe()
+		if err != nil {
+			t.Fatalf("[round %d] stdout: %s", round, err)
+		}
+		err = cmd.Start()
+		if err != nil {
+			t.Fatalf("[round %d] start: %s", round, err)
+		}
+		time.AfterFunc(
+			time.Duration(100+rand.Intn(400))*time.Millisecond, func() {
+				_ = cmd.Process.Kill()
+			},
+		)
+		pending := make(map[int]uint64)
+		sc := bufio.NewScanner(out)
+		for sc.Scan() {
+			var i int
+			var id uint64
+			if _, err := fmt.Sscanf(sc.Text(), "insert %d %d", &i, &id); err == nil {
+				pending[i] = id
+				next = i + 1
+				continue
+			}
+			if sc.Text() == "commit" {
+				for i, id := range pending {
+					committed[i] = id
+				}
+				pending = make(map[int]uint64)
+				continue
+			}
+			if strings.HasPrefix(sc.Text(), "---") || strings.Contains(sc.Text(), ".go:") {
+				t.Errorf("[round %d] writer: %s", round, sc.Text())
+			}
+		}
+		_ = cmd.Wait()
+
+		// reopen, which recovers the collection, and check the invariants
+		db, err := OpenDB(path)
+		if err != nil {
+			t.Fatalf("[round %d] open: %s", round, err)
+		}
+		docs, err := db.Create("docs.db")
+		if err != nil {
+			t.Fatalf("[round %d] create: %s", round, err)
+		}
+		// every committed user must be there, intact
+		for i, id := range committed {
+			var u User
+			err = docs.FindOne(id, &u)
+			if err != nil {
+				t.Fatalf("[round %d] committed user %d (rid=%d) is missing: %s", round, i, id, err)
+			}
+			if u != *crashUser(i) {
+				t.Fatalf("[round %d] committed user %d (rid=%d) does not match", round, i, id)
+			}
+		}
+		// the users in the last transaction must all be there, or not at all
+		var found int
+		for i, id := range pending {
+			var u User
+			if docs.FindOne(id, &u) == nil && u == *crashUser(i) {
+				found++
+			}
+		}
+		if found != 0 && found != len(pending) {
+			t.Fatalf("[round %d] found %d of the %d users in the last transaction", round, found, len(pending))
+		}
+		if found == len(pending) {
+			// the commit made it, it just was not reported before the kill
+			for i, id := range pending {
+				committed[i] = id
+			}
+		}
+		err = db.Close()
+		if err != nil {
+			t.Fatalf("[round %d] close: %s", round, err)
+		}
+	}
+	if len(committed) == 0 {
+		t.Errorf("no users were committed")
+	}
+}
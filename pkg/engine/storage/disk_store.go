@@ -2,36 +2,120 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
 	"sync/atomic"
 
 	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/file/dio"
 )
 
 const dataFilePerm = 1466
 
+var (
+	ErrBadDiskStoreConfig = errors.New("storage: bad disk store config")
+	ErrMmapUnsupported    = errors.New("storage: memory mapped files are not supported on this platform")
+)
+
+// dataFile is the file holding the pages of a DiskStore. It is usually an
+// *os.File, but it may also be a memory mapping of the file.
+type dataFile interface {
+	io.ReaderAt
+	io.WriterAt
+	Truncate(size int64) error
+	Sync() error
+	Stat() (os.FileInfo, error)
+	Name() string
+	Close() error
+}
+
 // DiskStore is a structure responsible for creating and managing access with
 // the actual files stored on disk. The current disk manager instance is only
-// responsible for dealing with one file at a time.
+// responsible for dealing with one file at a time. The file begins with a file
+// header, which keeps track of a list of free pages that can be reused. When
+// the file is a segment of a TableSpace, it only holds some of the pages, and
+// the page IDs are mapped onto the pages in the file using a base and stride.
+// The size of the pages is chosen when the file is created, and is kept in the
+// file header.
+//
+// The file may be opened for direct IO, in which case the reads and writes skip
+// the page cache of the operating system, so the buffer pool is the only cache
+// holding the pages. Direct IO requires page buffers that are aligned in memory;
+// pages that are not aligned are copied through an aligned block.
+//
+// The file may also be memory mapped, in which case the pages are copied to and
+// from the mapping, and the writes are not synced until Sync (or Close) is
+// called, which makes reads and writes nearly free of system calls.
 type DiskStore struct {
 	sync.RWMutex
-	file    *os.File
-	nextPID page.PageID
-	size    int64
+	file     dataFile
+	header   *fileHeader
+	nextPage uint32      // index of the next page in the file to be allocated
+	base     page.PageID // page ID of the first page in the file
+	stride   uint32      // difference between the page IDs of adjacent pages in the file
+	pageSize int64       // size of the pages (and the file header) in bytes
+	direct   bool        // the file was opened for direct IO
+	mapped   bool        // the file is memory mapped, so writes are synced lazily
+	lastLSN  uint64
+	size     int64
+}
+
+// DiskStoreConfig holds the settings used when opening a DiskStore.
+type DiskStoreConfig struct {
+	// PageSize is the page size used when the file is created. If it is zero,
+	// the default page size is used (or, for an existing file, the page size
+	// it was created with.) Otherwise, the page size of an existing file must
+	// match it.
+	PageSize int
+	// DirectIO opens the file for direct IO, bypassing the page cache of the
+	// operating system.
+	DirectIO bool
+	// Mmap maps the file into memory, and reads and writes the pages using
+	// the mapping. Writes are only durable once Sync (or Close) is called.
+	// It cannot be used along with DirectIO.
+	Mmap bool
 }
 
 // Open opens an existing disk manager instance if one exists with the same
 // name, otherwise it creates a new instance and returns it along with any potential
-// errors encountered.
+// errors encountered. An existing file is opened using the page size it was
+// created with, and a new file uses the default page size.
 func Open(path string) (*DiskStore, error) {
+	return OpenWithConfig(path, nil)
+}
+
+// OpenWithPageSize opens an existing disk manager instance if one exists with the
+// same name, otherwise it creates a new instance using the provided page size. If
+// the existing file uses a different page size, ErrPageSizeMismatch is returned.
+// A page size of zero accepts whichever page size the file uses.
+func OpenWithPageSize(path string, pageSize int) (*DiskStore, error) {
+	return OpenWithConfig(path, &DiskStoreConfig{PageSize: pageSize})
+}
+
+// OpenWithConfig opens an existing disk manager instance if one exists with the
+// same name, otherwise it creates a new instance, using the provided config. An
+// existing file written in an older format (including one written before data
+// files had a file header) is upgraded to the current format first.
+func OpenWithConfig(path string, conf *DiskStoreConfig) (*DiskStore, error) {
+	if conf == nil {
+		conf = new(DiskStoreConfig)
+	}
+	pageSize := conf.PageSize
+	if pageSize != 0 && !page.ValidPageSize(pageSize) {
+		return nil, page.ErrBadPageSize
+	}
+	if conf.DirectIO && conf.Mmap {
+		return nil, fmt.Errorf("%w: direct io and mmap cannot be used together", ErrBadDiskStoreConfig)
+	}
 	// Clean path
 	path, err := filepath.Abs(filepath.ToSlash(path))
 	if err != nil {
 		return nil, err
 	}
-	var fp *os.File
 	_, err = os.Stat(path)
 	if os.IsNotExist(err) {
 		// Create a new instance
@@ -39,7 +123,7 @@ func Open(path string) (*DiskStore, error) {
 		if err != nil {
 			return nil, err
 		}
-		fp, err = os.OpenFile(path, os.O_CREATE|os.O_TRUNC, dataFilePerm)
+		fp, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC, dataFilePerm)
 		if err != nil {
 			return nil, err
 		}
@@ -47,9 +131,23 @@ func Open(path string) (*DiskStore, error) {
 		if err != nil {
 			return nil, err
 		}
+	} else {
+		// Bring an existing file written in an older format up to date
+		err = upgradeFile(path)
+		if err != nil {
+			return nil, err
+		}
 	}
 	// Open file at the fully cleaned path
-	fp, err = os.OpenFile(path, os.O_RDWR|os.O_SYNC, dataFilePerm)
+	var fp dataFile
+	switch {
+	case conf.Mmap:
+		fp, err = openMmapFile(path, dataFilePerm)
+	case conf.DirectIO:
+		fp, err = dio.OpenFile(path, os.O_RDWR|os.O_SYNC, dataFilePerm)
+	default:
+		fp, err = os.OpenFile(path, os.O_RDWR|os.O_SYNC, dataFilePerm)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -58,20 +156,18 @@ func Open(path string) (*DiskStore, error) {
 	if err != nil {
 		return nil, err
 	}
-	size := fi.Size()
-	nextPageID := page.PageID(0)
-	if size > 0 {
-		nextPageID = page.PageID(size / page.PageSize)
-	}
 	// Initialize a new DiskStore instance
 	fm := &DiskStore{
-		file:    fp,
-		nextPID: nextPageID,
-		size:    size,
+		file:   fp,
+		stride: 1,
+		direct: conf.DirectIO,
+		mapped: conf.Mmap,
+		size:   fi.Size(),
 	}
 	// Load the meta info for the DiskStore instance
-	err = fm.load()
+	err = fm.load(pageSize)
 	if err != nil {
+		_ = fp.Close()
 		return nil, err
 	}
 	// Return our instance
@@ -79,94 +175,417 @@ func Open(path string) (*DiskStore, error) {
 }
 
 // load attempts to populate our DiskStore instance with metadata about the file.
-func (s *DiskStore) load() error {
+// If the file is empty, a new file header is written using the provided page
+// size (or the default one, if it is zero.) Otherwise, the page size found in
+// the file header must match the provided one, unless it is zero.
+func (s *DiskStore) load(pageSize int) error {
+	if s.size == 0 {
+		if pageSize == 0 {
+			pageSize = page.PageSize
+		}
+		s.header = newFileHeader(pageSize)
+		s.pageSize = int64(pageSize)
+		return s.writeHeader()
+	}
+	if s.size < minHeaderSize {
+		return ErrBadFileHeader
+	}
+	buf := s.newBlock(minHeaderSize)
+	_, err := s.file.ReadAt(buf, 0)
+	if err != nil {
+		return err
+	}
+	s.header = new(fileHeader)
+	err = s.header.decode(buf)
+	if err != nil {
+		return err
+	}
+	s.pageSize = int64(s.header.PageSize)
+	if pageSize != 0 && int64(pageSize) != s.pageSize {
+		return fmt.Errorf("%w: file uses %d byte pages, expected %d", ErrPageSizeMismatch, s.pageSize, pageSize)
+	}
+	if s.size < s.pageSize {
+		return ErrBadFileHeader
+	}
+	s.nextPage = uint32((s.size - s.pageSize) / s.pageSize)
+	s.lastLSN = s.header.LastLSN
+	s.loadLayout()
+	return nil
+}
+
+// loadLayout sets up the mapping of the page IDs onto the pages in the file
+// using the table space layout found in the file header. Pages are striped
+// across sets of segments, where every set holds stripes*segPages pages, so
+// the page IDs held in a segment start at the base and grow by the stride.
+func (s *DiskStore) loadLayout() {
+	h := s.header
+	if h.Stripes == 0 {
+		// not a segment, the file holds every page
+		s.base, s.stride = 0, 1
+		return
+	}
+	set, stripe := h.Segment/h.Stripes, h.Segment%h.Stripes
+	s.base = page.PageID(set*h.Stripes*h.SegPages + stripe)
+	s.stride = h.Stripes
+}
+
+// setLayout makes the file a segment of a table space, using the provided
+// layout, and persists the layout in the file header.
+func (s *DiskStore) setLayout(segment, stripes, segPages uint32) error {
+	s.Lock()
+	defer s.Unlock()
+	s.header.Segment = segment
+	s.header.Stripes = stripes
+	s.header.SegPages = segPages
+	s.loadLayout()
+	return s.writeHeader()
+}
+
+// writeHeader encodes and writes the file header to the beginning of the file.
+func (s *DiskStore) writeHeader() error {
+	buf := s.newBlock(s.pageSize)
+	s.header.LastLSN = s.lastLSN
+	s.header.encode(buf)
+	_, err := s.file.WriteAt(buf, 0)
+	if err != nil {
+		return err
+	}
+	if s.size < s.pageSize {
+		s.size = s.pageSize
+	}
 	return nil
 }
 
 // logicalOffset checks for any out of bounds errors, and returns an error if there
 // is one. Otherwise, it takes a page ID and returns a logical page offset.
 func (s *DiskStore) logicalOffset(pid page.PageID) (int64, error) {
+	// Check to see if the requested pid is held in this file
+	if pid < s.base || (pid-s.base)%s.stride != 0 {
+		return -1, page.ErrInvalidPID
+	}
+	n := (pid - s.base) / s.stride
 	// Check to see if the requested pid falls within the set that has been distributed
-	if pid > s.nextPID {
+	if n > s.nextPage {
 		return -1, page.ErrPageIDHasNotBeenAllocated(pid)
 	}
-	// We are good, so we will calculate the logical page offset.
-	return int64(pid * page.PageSize), nil
+	// We are good, so we will calculate the logical page offset, skipping over
+	// the file header.
+	return s.pageSize + int64(n)*s.pageSize, nil
 }
 
-// AllocatePage simply returns the next logical page ID that is can be written to.
-func (s *DiskStore) AllocatePage() page.PageID {
-	// increment and return the nextpage.PageID
-	return page.PageID(atomic.SwapUint32((*uint32)(&s.nextPID), uint32(s.nextPID+1)))
+// AllocatePage returns a page ID that can be written to. If there are any pages
+// in the free page list, the first one is removed from the list and returned.
+// Otherwise, it simply returns the next logical page ID.
+func (s *DiskStore) AllocatePage() (page.PageID, error) {
+	s.Lock()
+	defer s.Unlock()
+	// Check the free page list first
+	if pid, ok := s.allocateFree(); ok {
+		return pid, nil
+	}
+	return s.allocateNext(), nil
 }
 
-// DeallocatePage writes zeros to the page located at the logical address
-// calculated using the page ID provided.
+// allocateFree removes and returns the page at the head of the free page list,
+// along with a boolean indicating true if there was one. The caller must hold
+// the lock.
+func (s *DiskStore) allocateFree() (page.PageID, bool) {
+	if s.header.FreeHead == nilPID {
+		return nilPID, false
+	}
+	pid, err := s.popFreePage()
+	if err == nil {
+		return pid, true
+	}
+	// If the free page list cannot be read, we drop it rather than risk
+	// handing out a page that is still in use, and fall back to handing
+	// out the next sequential page ID.
+	s.header.FreeHead, s.header.FreeCount = nilPID, 0
+	_ = s.writeHeader()
+	return nilPID, false
+}
+
+// allocateNext returns the page ID of the next page in the file, growing the
+// file to make room for it. The caller must hold the lock.
+func (s *DiskStore) allocateNext() page.PageID {
+	// increment and return the next page
+	n := atomic.SwapUint32(&s.nextPage, s.nextPage+1)
+	// Grow the file to make room for the page, so the page ID will still be
+	// accounted for if the file is reopened before the page is written.
+	end := s.pageSize + int64(n+1)*s.pageSize
+	if end > s.size && s.file.Truncate(end) == nil {
+		s.size = end
+	}
+	return s.base + page.PageID(n*s.stride)
+}
+
+// popFreePage removes and returns the page at the head of the free page list.
+func (s *DiskStore) popFreePage() (page.PageID, error) {
+	pid := s.header.FreeHead
+	off, err := s.logicalOffset(pid)
+	if err != nil {
+		return nilPID, err
+	}
+	// Read the header of the free page to find the next free page
+	fp := page.Page(s.newBlock(s.pageSize))
+	err = s.readPage(pid, fp, off)
+	if err != nil {
+		return nilPID, err
+	}
+	if !fp.HasFlag(page.P_FREE) || fp.GetPageID() != pid {
+		return nilPID, page.ErrInvalidPID
+	}
+	// Update the head of the list, and persist it
+	s.header.FreeHead = fp.GetNext()
+	s.header.FreeCount--
+	err = s.writeHeader()
+	if err != nil {
+		return nilPID, err
+	}
+	// Clear the page, so it no longer looks like it is in the free page list
+	// if it is deallocated again before it has been written.
+	err = s.writeAt(s.newBlock(s.pageSize), off)
+	if err != nil {
+		return nilPID, err
+	}
+	return pid, nil
+}
+
+// DeallocatePage writes an empty page marked free to the logical address
+// calculated using the page ID provided, and adds it to the front of the free
+// page list so that it can be reused. Deallocating a page that is already free
+// does nothing. Pages are cleared when they are taken out of the free page list,
+// so only the pages that are in it are marked free.
 func (s *DiskStore) DeallocatePage(pid page.PageID) error {
+	s.Lock()
+	defer s.Unlock()
 	// Calculate the logical page offset.
 	off, err := s.logicalOffset(pid)
 	if err != nil {
 		return err
 	}
-	// Next, we will create an empty page
-	ep := page.NewPage(uint32(pid), page.P_FREE)
+	// Make sure the page is not already in the free page list
+	if off+s.pageSize <= s.size {
+		cp := page.Page(s.newBlock(s.pageSize))
+		err = s.readPage(pid, cp, off)
+		if err != nil && !errors.Is(err, ErrCorruptPage) {
+			return err
+		}
+		if cp.HasFlag(page.P_FREE) && cp.GetPageID() == pid {
+			return nil
+		}
+	}
+	// Next, we will create an empty page that points to the current head of
+	// the free page list
+	ep := page.NewPageSize(uint32(pid), page.P_FREE, int(s.pageSize))
+	h := ep.GetPageHeader()
+	h.Next = s.header.FreeHead
+	ep.SetPageHeader(h)
 	// Then, we can attempt to write the contents of the empty page data directly
 	// to the calculated offset
-	_, err = s.file.WriteAt(ep, off)
+	err = s.writePage(ep, off)
 	if err != nil {
 		return err
 	}
-	// Don't forget to sync it up
-	err = s.file.Sync()
+	// Finally, make the page the new head of the free page list
+	s.header.FreeHead = pid
+	s.header.FreeCount++
+	err = s.writeHeader()
 	if err != nil {
 		return err
 	}
-	// Finally, update the index
-	// TODO: implement index and update it
-	return nil
+	// Don't forget to sync it up
+	return s.sync()
 }
 
 // ReadPage reads the page located at the logical address calculated using the
-// page ID provided.
+// page ID provided. The provided page must be the size of the pages in the
+// file. The page checksum is verified, and if the page does not
+// pass, a *CorruptPageError is returned.
 func (s *DiskStore) ReadPage(pid page.PageID, p page.Page) error {
+	s.RLock()
+	defer s.RUnlock()
+	if int64(len(p)) != s.pageSize {
+		return page.ErrBadPageSize
+	}
 	// Calculate the logical page offset.
 	off, err := s.logicalOffset(pid)
 	if err != nil {
 		return err
 	}
-	// Read page data
-	_, err = s.file.ReadAt(p, off)
+	// Read and verify page data
+	return s.readPage(pid, p, off)
+}
+
+// readPage reads the page data at the provided offset and verifies it. A page
+// that is entirely zeroed out has never been written, and is not verified.
+func (s *DiskStore) readPage(pid page.PageID, p page.Page, off int64) error {
+	err := s.readAt(p, off)
 	if err != nil {
 		return err
 	}
+	if p.GetChecksum() == 0 && isZero(p) {
+		return nil
+	}
+	if !p.VerifyChecksum() || p.GetPageID() != pid {
+		return &CorruptPageError{
+			PageID:   pid,
+			Found:    p.GetPageID(),
+			Checksum: p.GetChecksum(),
+			Computed: p.CalcChecksum(),
+		}
+	}
 	return nil
 }
 
 // WritePage writes the page located at the logical address calculated using the
-// page ID provided.
+// page ID provided. Before the page is written, it is stamped with a checksum.
 func (s *DiskStore) WritePage(pid page.PageID, p page.Page) error {
+	s.Lock()
+	defer s.Unlock()
+	if int64(len(p)) != s.pageSize {
+		return page.ErrBadPageSize
+	}
 	// Calculate the logical page offset.
 	off, err := s.logicalOffset(pid)
 	if err != nil {
 		return err
 	}
 	// Write page data
-	_, err = s.file.WriteAt(p, off)
+	err = s.writePage(p, off)
 	if err != nil {
 		return err
 	}
 	// Make sure we sync
-	err = s.file.Sync()
+	return s.sync()
+}
+
+// sync syncs the file, unless it is memory mapped, in which case the writes are
+// left to be synced by Sync. The caller must hold the lock.
+func (s *DiskStore) sync() error {
+	if s.mapped {
+		return nil
+	}
+	return s.file.Sync()
+}
+
+// Sync makes every page written so far durable. Unless the file is memory
+// mapped, the pages are synced as they are written, so there is not much left
+// to do.
+func (s *DiskStore) Sync() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.mapped {
+		// the free page list and the last log sequence number are kept in
+		// the file header, which has to make it as well
+		err := s.writeHeader()
+		if err != nil {
+			return err
+		}
+	}
+	return s.file.Sync()
+}
+
+// writePage stamps the page with a checksum, and writes the page data at the
+// provided offset. The log sequence number of the page is left alone, but the
+// largest one written is kept track of.
+func (s *DiskStore) writePage(p page.Page, off int64) error {
+	if p.GetLSN() > s.lastLSN {
+		s.lastLSN = p.GetLSN()
+	}
+	p.SetChecksum()
+	err := s.writeAt(p, off)
 	if err != nil {
 		return err
 	}
-	// Finally, update the index
-	// TODO: implement index and update it
+	if off+int64(len(p)) > s.size {
+		s.size = off + int64(len(p))
+	}
 	return nil
 }
 
+// newBlock returns a zeroed out buffer of the provided size, which is aligned
+// for direct IO if the file was opened for direct IO.
+func (s *DiskStore) newBlock(n int64) []byte {
+	if s.direct {
+		return dio.AlignedBlock(int(n))
+	}
+	return make([]byte, n)
+}
+
+// readAt reads len(b) bytes from the file at the provided offset. If the file
+// was opened for direct IO and the buffer is not aligned, the data is read into
+// an aligned block first.
+func (s *DiskStore) readAt(b []byte, off int64) error {
+	if !s.direct || dio.IsAligned(b) {
+		_, err := s.file.ReadAt(b, off)
+		return err
+	}
+	block := dio.AlignedBlock(len(b))
+	_, err := s.file.ReadAt(block, off)
+	if err != nil {
+		return err
+	}
+	copy(b, block)
+	return nil
+}
+
+// writeAt writes len(b) bytes to the file at the provided offset. If the file
+// was opened for direct IO and the buffer is not aligned, the data is copied
+// into an aligned block first.
+func (s *DiskStore) writeAt(b []byte, off int64) error {
+	if s.direct && !dio.IsAligned(b) {
+		block := dio.AlignedBlock(len(b))
+		copy(block, b)
+		b = block
+	}
+	_, err := s.file.WriteAt(b, off)
+	return err
+}
+
+// isZero returns a boolean indicating true if all the bytes are zero.
+func isZero(b []byte) bool {
+	for i := range b {
+		if b[i] != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// PageSize returns the size of the pages in the file.
+func (s *DiskStore) PageSize() int {
+	return int(s.pageSize)
+}
+
+// PageCount returns the number of page IDs that have been handed out, which is
+// one more than the largest page ID allocated so far.
+func (s *DiskStore) PageCount() uint32 {
+	s.RLock()
+	defer s.RUnlock()
+	if s.nextPage == 0 {
+		return 0
+	}
+	return s.base + (s.nextPage-1)*s.stride + 1
+}
+
+// LastLSN returns the largest log sequence number found on any of the pages
+// that have been written.
+func (s *DiskStore) LastLSN() uint64 {
+	s.RLock()
+	defer s.RUnlock()
+	return s.lastLSN
+}
+
 // Close closes the current manager instance
 func (s *DiskStore) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	// persist the last log sequence number
+	err := s.writeHeader()
+	if err != nil {
+		return err
+	}
 	// close the underlying io
 	return s.file.Close()
 }
@@ -184,14 +603,24 @@ func (s *DiskStore) JSON() string {
 		BasePath string `json:"base_path"`
 		FileName string `json:"file_name"`
 		FileSize int64  `json:"file_size"`
+		PageSize int64  `json:"page_size"`
+		DirectIO bool   `json:"direct_io"`
+		Mmap     bool   `json:"mmap"`
 		NextPID  uint32 `json:"next_pid"`
 		Size     int64  `json:"size"`
+		FreeHead uint32 `json:"free_head"`
+		NumFree  uint32 `json:"num_free"`
 	}{
 		BasePath: filepath.Dir(s.file.Name()),
 		FileName: filepath.Base(s.file.Name()),
 		FileSize: fi.Size(),
-		NextPID:  s.nextPID,
+		PageSize: s.pageSize,
+		DirectIO: s.direct,
+		Mmap:     s.mapped,
+		NextPID:  s.base + page.PageID(s.nextPage*s.stride),
 		Size:     s.size,
+		FreeHead: s.header.FreeHead,
+		NumFree:  s.header.FreeCount,
 	}
 	b, err := json.MarshalIndent(&info, "", "  ")
 	if err != nil {
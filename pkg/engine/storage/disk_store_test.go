@@ -1,12 +1,18 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"syscall"
 	"testing"
 
 	"github.com/cagnosolutions/go-data/pkg/engine/page"
+	"github.com/cagnosolutions/go-data/pkg/file/dio"
 )
 
 func TestDiskStore_Open(t *testing.T) {
@@ -50,7 +56,7 @@ func TestDiskStore_AllocatePage(t *testing.T) {
 
 	var pages []page.PageID
 	for i := 0; i < 8; i++ {
-		pages = append(pages, fm.AllocatePage())
+		pages = append(pages, allocate(t, fm))
 	}
 	if len(pages) != 8 {
 		t.Errorf("allocate: error did not allocated 8 pages, got %d", len(pages))
@@ -79,7 +85,7 @@ func TestDiskStore_WritePage(t *testing.T) {
 
 	var pages []page.PageID
 	for i := 0; i < 8; i++ {
-		pages = append(pages, fm.AllocatePage())
+		pages = append(pages, allocate(t, fm))
 	}
 	if len(pages) != 8 {
 		t.Errorf("write: error did not allocated 8 pages, got %d", len(pages))
@@ -123,7 +129,7 @@ func TestDiskStore_ReadPage(t *testing.T) {
 
 	var pages []page.PageID
 	for i := 0; i < 8; i++ {
-		pages = append(pages, fm.AllocatePage())
+		pages = append(pages, allocate(t, fm))
 	}
 	if len(pages) != 8 {
 		t.Errorf("read: error did not allocated 8 pages, got %d", len(pages))
@@ -179,7 +185,7 @@ func TestDiskStore_DeallocatePage(t *testing.T) {
 
 	var pages []page.PageID
 	for i := 0; i < 8; i++ {
-		pages = append(pages, fm.AllocatePage())
+		pages = append(pages, allocate(t, fm))
 	}
 	if len(pages) != 8 {
 		t.Errorf("read: error did not allocated 8 pages, got %d", len(pages))
@@ -237,3 +243,611 @@ func TestDiskStore_DeallocatePage(t *testing.T) {
 		t.Errorf("read: error closing io: %s", err)
 	}
 }
+
+func TestDiskStore_FreePageReuse(t *testing.T) {
+	fm, err := Open("my-test-io.txt")
+	if err != nil {
+		t.Errorf("reuse: io manager open error: %s", err)
+	}
+	defer func() {
+		err := os.Remove("my-test-io.txt")
+		if err != nil {
+			t.Errorf("reuse: error removing io: %s", err)
+		}
+	}()
+
+	for i := 0; i < 8; i++ {
+		pid := allocate(t, fm)
+		err = fm.WritePage(pid, page.NewPage(pid, page.P_USED))
+		if err != nil {
+			t.Errorf("reuse: error writing page: %s", err)
+		}
+	}
+
+	// free a few pages, and free one of them twice
+	for _, pid := range []page.PageID{3, 5, 5} {
+		err = fm.DeallocatePage(pid)
+		if err != nil {
+			t.Errorf("reuse: dealloc error %s", err)
+		}
+	}
+
+	// the most recently freed page should be handed out first
+	if pid := allocate(t, fm); pid != 5 {
+		t.Errorf("reuse: expected page %d, got %d", 5, pid)
+	}
+
+	// make sure the free page list survives a reopen
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("reuse: error closing io: %s", err)
+	}
+	fm, err = Open("my-test-io.txt")
+	if err != nil {
+		t.Errorf("reuse: io manager reopen error: %s", err)
+	}
+	if pid := allocate(t, fm); pid != 3 {
+		t.Errorf("reuse: expected page %d, got %d", 3, pid)
+	}
+	// the free page list is empty, so we should get the next sequential page
+	if pid := allocate(t, fm); pid != 8 {
+		t.Errorf("reuse: expected page %d, got %d", 8, pid)
+	}
+	// a page that is freed again before it has been written should be put
+	// back in the free page list
+	err = fm.DeallocatePage(3)
+	if err != nil {
+		t.Errorf("reuse: dealloc error %s", err)
+	}
+	if fm.header.FreeCount != 1 {
+		t.Errorf("reuse: expected %d free pages, got %d", 1, fm.header.FreeCount)
+	}
+	if pid := allocate(t, fm); pid != 3 {
+		t.Errorf("reuse: expected page %d, got %d", 3, pid)
+	}
+
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("reuse: error closing io: %s", err)
+	}
+
+	// a file without a proper header should not open
+	err = os.WriteFile("my-test-io.txt", make([]byte, page.PageSize), 0666)
+	if err != nil {
+		t.Errorf("reuse: error writing io: %s", err)
+	}
+	_, err = Open("my-test-io.txt")
+	if !errors.Is(err, ErrBadFileHeader) {
+		t.Errorf("reuse: expected %v, got %v", ErrBadFileHeader, err)
+	}
+}
+
+func TestDiskStore_CorruptPage(t *testing.T) {
+	fm, err := Open("my-test-io.txt")
+	if err != nil {
+		t.Errorf("corrupt: io manager open error: %s", err)
+	}
+	defer func() {
+		err := os.Remove("my-test-io.txt")
+		if err != nil {
+			t.Errorf("corrupt: error removing io: %s", err)
+		}
+	}()
+
+	var lsn uint64
+	for i := 0; i < 4; i++ {
+		pid := allocate(t, fm)
+		pg := page.NewPage(pid, page.P_USED)
+		rk := []byte(fmt.Sprintf("%.4d", pid))
+		rv := []byte(fmt.Sprintf("some data for page #%.4d", pid))
+		_, err = pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, rv))
+		if err != nil {
+			t.Errorf("corrupt: error writing page record: %s", err)
+		}
+		lsn += 10
+		pg.SetLSN(lsn)
+		err = fm.WritePage(pid, pg)
+		if err != nil {
+			t.Errorf("corrupt: error writing page: %s", err)
+		}
+		// the lsn of the page should be left alone
+		if pg.GetLSN() != lsn {
+			t.Errorf("corrupt: expected lsn %d, got %d", lsn, pg.GetLSN())
+		}
+	}
+	pg := make(page.Page, page.PageSize)
+	err = fm.ReadPage(2, pg)
+	if err != nil {
+		t.Errorf("corrupt: error reading page: %s", err)
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("corrupt: error closing io: %s", err)
+	}
+
+	// simulate a torn write by writing the first half of a newer version of
+	// page 2 directly into the file
+	pg.SetLSN(pg.GetLSN() + 1)
+	_, err = pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, []byte("torn"), []byte("write")))
+	if err != nil {
+		t.Errorf("corrupt: error writing page record: %s", err)
+	}
+	pg.SetChecksum()
+	fp, err := os.OpenFile("my-test-io.txt", os.O_RDWR, 0666)
+	if err != nil {
+		t.Fatalf("corrupt: error opening io: %s", err)
+	}
+	_, err = fp.WriteAt(pg[:page.PageSize/2], 3*page.PageSize)
+	if err != nil {
+		t.Errorf("corrupt: error writing io: %s", err)
+	}
+	err = fp.Close()
+	if err != nil {
+		t.Errorf("corrupt: error closing io: %s", err)
+	}
+
+	fm, err = Open("my-test-io.txt")
+	if err != nil {
+		t.Errorf("corrupt: io manager reopen error: %s", err)
+	}
+	err = fm.ReadPage(1, make(page.Page, page.PageSize))
+	if err != nil {
+		t.Errorf("corrupt: error reading page: %s", err)
+	}
+	err = fm.ReadPage(2, make(page.Page, page.PageSize))
+	var cpe *CorruptPageError
+	if !errors.Is(err, ErrCorruptPage) || !errors.As(err, &cpe) {
+		t.Errorf("corrupt: expected %v, got %v", ErrCorruptPage, err)
+	} else if cpe.PageID != 2 {
+		t.Errorf("corrupt: expected pid %d, got %d", 2, cpe.PageID)
+	}
+
+	// the last lsn should have been persisted in the file header
+	if fm.LastLSN() != lsn {
+		t.Errorf("corrupt: expected last lsn %d, got %d", lsn, fm.LastLSN())
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("corrupt: error closing io: %s", err)
+	}
+}
+
+func TestDiskStore_PageSize(t *testing.T) {
+	defer os.Remove("my-test-io.txt")
+
+	for _, size := range []int{page.MinPageSize, page.MaxPageSize} {
+		fm, err := OpenWithPageSize("my-test-io.txt", size)
+		if err != nil {
+			t.Fatalf("[size=%d] open: %s", size, err)
+		}
+		for i := 0; i < 4; i++ {
+			pid := allocate(t, fm)
+			pg := page.NewPageSize(pid, page.P_USED, size)
+			// fill the page right up to the end
+			for n := 0; ; n++ {
+				rk := []byte(fmt.Sprintf("%.4d-%.4d", pid, n))
+				_, err = pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, []byte("some data")))
+				if err == page.ErrNoRoom {
+					break
+				}
+				if err != nil {
+					t.Fatalf("[size=%d] add record: %s", size, err)
+				}
+			}
+			err = fm.WritePage(pid, pg)
+			if err != nil {
+				t.Fatalf("[size=%d] write page %d: %s", size, pid, err)
+			}
+		}
+		// pages of another size should not be written
+		err = fm.WritePage(0, page.NewPage(0, page.P_USED))
+		if size != page.PageSize && err != page.ErrBadPageSize {
+			t.Errorf("[size=%d] write page: expected %v, got %v", size, page.ErrBadPageSize, err)
+		}
+		err = fm.DeallocatePage(3)
+		if err != nil {
+			t.Errorf("[size=%d] deallocate: %s", size, err)
+		}
+		err = fm.Close()
+		if err != nil {
+			t.Fatalf("[size=%d] close: %s", size, err)
+		}
+
+		// the file should not open using another page size
+		other := size * 2
+		if other > page.MaxPageSize {
+			other = size / 2
+		}
+		_, err = OpenWithPageSize("my-test-io.txt", other)
+		if !errors.Is(err, ErrPageSizeMismatch) {
+			t.Errorf("[size=%d] reopen: expected %v, got %v", size, ErrPageSizeMismatch, err)
+		}
+		// but it should open using its own page size, or any page size
+		fm, err = Open("my-test-io.txt")
+		if err != nil {
+			t.Fatalf("[size=%d] reopen: %s", size, err)
+		}
+		if fm.PageSize() != size {
+			t.Errorf("[size=%d] expected page size %d, got %d", size, size, fm.PageSize())
+		}
+		if n := fm.PageCount(); n != 4 {
+			t.Errorf("[size=%d] expected %d pages, got %d", size, 4, n)
+		}
+		for pid := page.PageID(0); pid < 3; pid++ {
+			pg := make(page.Page, size)
+			err = fm.ReadPage(pid, pg)
+			if err != nil {
+				t.Fatalf("[size=%d] read page %d: %s", size, pid, err)
+			}
+			if pg.FreeSpace() >= 32 {
+				t.Errorf("[size=%d] read page %d: expected a full page, got %d bytes free", size, pid, pg.FreeSpace())
+			}
+		}
+		if pid := allocate(t, fm); pid != 3 {
+			t.Errorf("[size=%d] allocate: expected page %d, got %d", size, 3, pid)
+		}
+		err = fm.Close()
+		if err != nil {
+			t.Fatalf("[size=%d] close: %s", size, err)
+		}
+		err = os.Remove("my-test-io.txt")
+		if err != nil {
+			t.Fatalf("[size=%d] remove: %s", size, err)
+		}
+	}
+
+	// and page sizes that are out of range should be rejected
+	for _, size := range []int{1 << 10, 12 << 10, 128 << 10} {
+		_, err := OpenWithPageSize("my-test-io.txt", size)
+		if err != page.ErrBadPageSize {
+			t.Errorf("[size=%d] open: expected %v, got %v", size, page.ErrBadPageSize, err)
+		}
+	}
+}
+
+// legacyPage returns a copy of the provided page, using the legacy page format,
+// which has a 24 byte page header followed by the cellptrs.
+func legacyPage(p page.Page) page.Page {
+	const legacyHeaderSize, pageHeaderSize = 24, 40
+	lower := binary.LittleEndian.Uint16(p[20:22])
+	upper := binary.LittleEndian.Uint16(p[22:24])
+	lp := make(page.Page, len(p))
+	copy(lp[:legacyHeaderSize], p[:legacyHeaderSize])
+	copy(lp[legacyHeaderSize:], p[pageHeaderSize:lower])
+	copy(lp[upper:], p[upper:])
+	binary.LittleEndian.PutUint16(lp[20:22], lower-(pageHeaderSize-legacyHeaderSize))
+	return lp
+}
+
+func TestDiskStore_UpgradeVersion1(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v1.db")
+
+	// a version 1 file holds a file header, and pages using the legacy page
+	// format: page 0 holds a few records, page 1 is free, and page 2 has been
+	// allocated but never written
+	hdr := make([]byte, page.PageSize)
+	binary.LittleEndian.PutUint32(hdr[offMagic:], fileMagic)
+	binary.LittleEndian.PutUint16(hdr[offVersion:], 1)
+	binary.LittleEndian.PutUint32(hdr[offFreeHead:], 1)
+	binary.LittleEndian.PutUint32(hdr[offFreeCount:], 1)
+	pg := page.NewPage(0, page.P_USED)
+	var rids []*page.RecordID
+	for i := 0; i < 3; i++ {
+		rid, err := pg.AddRecord(page.NewRecord(page.R_NUM, page.R_STR, []byte{byte(i)}, []byte("some data")))
+		if err != nil {
+			t.Fatalf("upgrade: error adding record: %s", err)
+		}
+		rids = append(rids, rid)
+	}
+	free := page.NewPage(1, page.P_FREE)
+	h := free.GetPageHeader()
+	h.Next = nilPID
+	free.SetPageHeader(h)
+	data := append(hdr, legacyPage(pg)...)
+	data = append(data, legacyPage(free)...)
+	data = append(data, make([]byte, page.PageSize)...)
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("upgrade: error writing io: %s", err)
+	}
+
+	fm, err := Open(path)
+	if err != nil {
+		t.Fatalf("upgrade: io manager open error: %s", err)
+	}
+	if n := fm.PageCount(); n != 3 {
+		t.Errorf("upgrade: expected %d pages, got %d", 3, n)
+	}
+	got := make(page.Page, page.PageSize)
+	err = fm.ReadPage(0, got)
+	if err != nil {
+		t.Fatalf("upgrade: error reading page: %s", err)
+	}
+	for i, rid := range rids {
+		rec, err := got.GetRecord(rid)
+		if err != nil || rec.Key()[0] != byte(i) {
+			t.Errorf("upgrade: get record %s: got %v (%v)", rid, rec, err)
+		}
+	}
+	// the free page list should have been carried over
+	if pid := allocate(t, fm); pid != 1 {
+		t.Errorf("upgrade: expected page %d, got %d", 1, pid)
+	}
+	if pid := allocate(t, fm); pid != 3 {
+		t.Errorf("upgrade: expected page %d, got %d", 3, pid)
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("upgrade: error closing io: %s", err)
+	}
+	if _, err = os.Stat(path + upgradeSuffix); !os.IsNotExist(err) {
+		t.Errorf("upgrade: temporary file was left behind (%v)", err)
+	}
+
+	// a version 1 file holding a page that cannot be upgraded is refused, and
+	// left as it was
+	binary.LittleEndian.PutUint16(data[page.PageSize+20:], 1)
+	err = os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("upgrade: error writing io: %s", err)
+	}
+	_, err = Open(path)
+	if !errors.Is(err, ErrUpgrade) {
+		t.Errorf("upgrade: expected %v, got %v", ErrUpgrade, err)
+	}
+	if b, _ := os.ReadFile(path); !bytes.Equal(b, data) {
+		t.Errorf("upgrade: the file was changed")
+	}
+}
+
+func TestDiskStore_UpgradeHeaderless(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "v0.db")
+
+	// a file written before there was a file header holds nothing but pages
+	// using the legacy page format, and the free pages are not linked together
+	var data []byte
+	for pid := page.PageID(0); pid < 3; pid++ {
+		pg := page.NewPage(pid, page.P_USED)
+		if pid == 1 {
+			pg = page.NewPage(pid, page.P_FREE)
+		} else {
+			rk := []byte(fmt.Sprintf("%.4d", pid))
+			_, err := pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, []byte("some data")))
+			if err != nil {
+				t.Fatalf("upgrade: error adding record: %s", err)
+			}
+		}
+		data = append(data, legacyPage(pg)...)
+	}
+	err := os.WriteFile(path, data, 0666)
+	if err != nil {
+		t.Fatalf("upgrade: error writing io: %s", err)
+	}
+
+	fm, err := Open(path)
+	if err != nil {
+		t.Fatalf("upgrade: io manager open error: %s", err)
+	}
+	if n := fm.PageCount(); n != 3 {
+		t.Errorf("upgrade: expected %d pages, got %d", 3, n)
+	}
+	for _, pid := range []page.PageID{0, 2} {
+		pg := make(page.Page, page.PageSize)
+		err = fm.ReadPage(pid, pg)
+		if err != nil {
+			t.Fatalf("upgrade: error reading page %d: %s", pid, err)
+		}
+		_, rec, err := pg.GetRecordAt(0)
+		if err != nil || string(rec.Key()) != fmt.Sprintf("%.4d", pid) {
+			t.Errorf("upgrade: page %d: got record %v (%v)", pid, rec, err)
+		}
+	}
+	// the free page should have been added to the free page list
+	if pid := allocate(t, fm); pid != 1 {
+		t.Errorf("upgrade: expected page %d, got %d", 1, pid)
+	}
+	if pid := allocate(t, fm); pid != 3 {
+		t.Errorf("upgrade: expected page %d, got %d", 3, pid)
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Errorf("upgrade: error closing io: %s", err)
+	}
+
+	// a file without a file header that does not begin with page 0 is refused
+	err = os.WriteFile(path, data[page.PageSize:], 0666)
+	if err != nil {
+		t.Fatalf("upgrade: error writing io: %s", err)
+	}
+	_, err = Open(path)
+	if !errors.Is(err, ErrBadFileHeader) {
+		t.Errorf("upgrade: expected %v, got %v", ErrBadFileHeader, err)
+	}
+}
+
+// allocate allocates a page in the store, failing the test if it cannot.
+func allocate(tb testing.TB, s Storer) page.PageID {
+	tb.Helper()
+	pid, err := s.AllocatePage()
+	if err != nil {
+		tb.Fatalf("allocate: %s", err)
+	}
+	return pid
+}
+
+// openDirect opens the disk store for direct IO, skipping the test if the file
+// system does not support it.
+func openDirect(t *testing.T, path string) *DiskStore {
+	fm, err := OpenWithConfig(path, &DiskStoreConfig{DirectIO: true})
+	if errors.Is(err, syscall.EINVAL) {
+		os.Remove(path)
+		t.Skipf("direct io is not supported: %s", err)
+	}
+	if err != nil {
+		t.Fatalf("direct: io manager open error: %s", err)
+	}
+	return fm
+}
+
+func TestDiskStore_DirectIO(t *testing.T) {
+	defer os.Remove("my-test-io.txt")
+
+	fm := openDirect(t, "my-test-io.txt")
+	for i := 0; i < 8; i++ {
+		pid := allocate(t, fm)
+		// use both aligned and unaligned pages
+		pg := page.NewPage(pid, page.P_USED)
+		if i%2 == 0 {
+			pg = page.Page(dio.AlignedBlock(page.PageSize))
+			copy(pg, page.NewPage(pid, page.P_USED))
+		}
+		rk := []byte(fmt.Sprintf("%.4d", pid))
+		rv := []byte(fmt.Sprintf("some data for page #%.4d", pid))
+		_, err := pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, rv))
+		if err != nil {
+			t.Errorf("direct: error writing page record: %s", err)
+		}
+		err = fm.WritePage(pid, pg)
+		if err != nil {
+			t.Fatalf("direct: error writing page: %s", err)
+		}
+	}
+	err := fm.DeallocatePage(5)
+	if err != nil {
+		t.Errorf("direct: error deallocating page: %s", err)
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Fatalf("direct: error closing io: %s", err)
+	}
+
+	// the file should read back the same way, with or without direct io
+	for _, direct := range []bool{false, true} {
+		fm, err = OpenWithConfig("my-test-io.txt", &DiskStoreConfig{DirectIO: direct})
+		if err != nil {
+			t.Fatalf("direct: io manager reopen error: %s", err)
+		}
+		for pid := page.PageID(0); pid < 8; pid++ {
+			pg := make(page.Page, page.PageSize)
+			err = fm.ReadPage(pid, pg)
+			if err != nil {
+				t.Fatalf("direct: error reading page %d: %s", pid, err)
+			}
+			if pid == 5 {
+				if !pg.HasFlag(page.P_FREE) {
+					t.Errorf("direct: page %d should be free", pid)
+				}
+				continue
+			}
+			_, r, err := pg.GetRecordAt(0)
+			if err != nil {
+				t.Fatalf("direct: error reading page record: %s", err)
+			}
+			if want := fmt.Sprintf("some data for page #%.4d", pid); string(r.Val()) != want {
+				t.Errorf("direct: got %q, expected %q", r.Val(), want)
+			}
+		}
+		// the free page list should have made it as well
+		if pid := allocate(t, fm); pid != 5 {
+			t.Errorf("direct: expected page %d, got %d", 5, pid)
+		}
+		err = fm.WritePage(5, page.NewPage(5, page.P_USED))
+		if err != nil {
+			t.Errorf("direct: error writing page: %s", err)
+		}
+		err = fm.DeallocatePage(5)
+		if err != nil {
+			t.Errorf("direct: error deallocating page: %s", err)
+		}
+		err = fm.Close()
+		if err != nil {
+			t.Fatalf("direct: error closing io: %s", err)
+		}
+	}
+}
+
+// benchPages is the number of pages used by the disk store benchmarks.
+const benchPages = 1024
+
+// benchmarkDiskStore runs the provided benchmark against a disk store holding
+// benchPages pages, using buffered IO, direct IO and a memory mapping.
+func benchmarkDiskStore(b *testing.B, fn func(b *testing.B, fm *DiskStore)) {
+	confs := []struct {
+		name string
+		conf *DiskStoreConfig
+	}{
+		{"Buffered", &DiskStoreConfig{}},
+		{"Direct", &DiskStoreConfig{DirectIO: true}},
+		{"Mmap", &DiskStoreConfig{Mmap: true}},
+	}
+	for _, c := range confs {
+		conf := c.conf
+		b.Run(
+			c.name, func(b *testing.B) {
+				defer os.Remove("my-bench-io.txt")
+				fm, err := OpenWithConfig("my-bench-io.txt", conf)
+				if errors.Is(err, syscall.EINVAL) || errors.Is(err, ErrMmapUnsupported) {
+					b.Skipf("not supported: %s", err)
+				}
+				if err != nil {
+					b.Fatalf("io manager open error: %s", err)
+				}
+				// write the pages the same way the tests do
+				for i := 0; i < benchPages; i++ {
+					pid := allocate(b, fm)
+					pg := page.NewPage(pid, page.P_USED)
+					rk := []byte(fmt.Sprintf("%.4d", pid))
+					rv := []byte(fmt.Sprintf("some data for page #%.4d", pid))
+					_, err := pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, rv))
+					if err == nil {
+						err = fm.WritePage(pid, pg)
+					}
+					if err != nil {
+						b.Fatalf("error writing page: %s", err)
+					}
+				}
+				b.SetBytes(page.PageSize)
+				b.ResetTimer()
+				fn(b, fm)
+				b.StopTimer()
+				err = fm.Close()
+				if err != nil {
+					b.Fatalf("error closing io: %s", err)
+				}
+			},
+		)
+	}
+}
+
+func BenchmarkDiskStore_WritePage(b *testing.B) {
+	benchmarkDiskStore(
+		b, func(b *testing.B, fm *DiskStore) {
+			// use an aligned page, the way the buffer pool does
+			pg := page.Page(dio.AlignedBlock(page.PageSize))
+			for i := 0; i < b.N; i++ {
+				pid := page.PageID(i % benchPages)
+				copy(pg, page.NewPage(pid, page.P_USED))
+				err := fm.WritePage(pid, pg)
+				if err != nil {
+					b.Fatalf("error writing page: %s", err)
+				}
+			}
+		},
+	)
+}
+
+func BenchmarkDiskStore_ReadPage(b *testing.B) {
+	benchmarkDiskStore(
+		b, func(b *testing.B, fm *DiskStore) {
+			pg := page.Page(dio.AlignedBlock(page.PageSize))
+			for i := 0; i < b.N; i++ {
+				// jump around, so the reads are not sequential
+				pid := page.PageID((i * 7919) % benchPages)
+				err := fm.ReadPage(pid, pg)
+				if err != nil {
+					b.Fatalf("error reading page: %s", err)
+				}
+			}
+		},
+	)
+}
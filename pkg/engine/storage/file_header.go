@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+var (
+	ErrBadFileHeader    = errors.New("storage: bad file header")
+	ErrPageSizeMismatch = errors.New("storage: page size does not match the file")
+	ErrCorruptPage      = errors.New("storage: page is corrupt")
+)
+
+// CorruptPageError is returned when a page read from the disk does not pass
+// the integrity checks. It can be matched using errors.Is(err, ErrCorruptPage).
+type CorruptPageError struct {
+	PageID   page.PageID // the page ID that was requested
+	Found    page.PageID // the page ID that was found in the page header
+	Checksum uint32      // the checksum that was stored in the page header
+	Computed uint32      // the checksum that was computed from the page data
+}
+
+// Error implements the error interface.
+func (e *CorruptPageError) Error() string {
+	if e.Found != e.PageID {
+		return fmt.Sprintf("%s (pid=%d): page header holds pid=%d", ErrCorruptPage, e.PageID, e.Found)
+	}
+	return fmt.Sprintf(
+		"%s (pid=%d): checksum mismatch (stored=0x%.8x, computed=0x%.8x), possible torn write",
+		ErrCorruptPage, e.PageID, e.Checksum, e.Computed,
+	)
+}
+
+// Is reports whether the target error is ErrCorruptPage.
+func (e *CorruptPageError) Is(target error) bool {
+	return target == ErrCorruptPage
+}
+
+const (
+	// fileMagic is used to identify a data file managed by the DiskStore
+	fileMagic uint32 = 0x53444447 // "GDDS"
+
+	// fileVersion is the current version of the file format. Version 2 files
+	// do not hold the table space fields, which are read as zero, and version
+	// 2 and 3 files do not hold the page size, as they always use the default.
+	// Version 1 files hold pages using the legacy page format, so they have to
+	// be upgraded (see upgradeFile) before they can be read.
+	fileVersion uint16 = 4
+
+	// minHeaderSize is the amount of the file header that is read in order to
+	// find out the page size. The file header takes up a full page, so that
+	// all the pages that follow stay aligned on a page boundary, but all the
+	// fields fit in the smallest page size.
+	minHeaderSize = page.MinPageSize
+
+	// nilPID is used to indicate the end of the free page list
+	nilPID = ^page.PageID(0)
+
+	// offsets to be used for decoding and encoding the file header
+	offMagic     = 0  // magic=uint32		offs=0-4	(4 bytes)
+	offVersion   = 4  // version=uint16		offs=4-6	(2 bytes)
+	offFreeHead  = 8  // freeHead=uint32	offs=8-12	(4 bytes)
+	offFreeCount = 12 // freeCount=uint32	offs=12-16	(4 bytes)
+	offLastLSN   = 16 // lastLSN=uint64		offs=16-24	(8 bytes)
+	offSegment   = 24 // segment=uint32		offs=24-28	(4 bytes)
+	offStripes   = 28 // stripes=uint32		offs=28-32	(4 bytes)
+	offSegPages  = 32 // segPages=uint32	offs=32-36	(4 bytes)
+	offPageSize  = 36 // pageSize=uint32	offs=36-40	(4 bytes)
+)
+
+// fileHeader is the header stored at the beginning of every data file. It
+// holds the head of the free page list. The free page list is a chain of free
+// pages (marked with the page.P_FREE flag) linked together using the next
+// pointer in the page header. It also holds the largest log sequence number
+// found on any page that was written before the header was written, and the
+// size of the pages in the file. If the file is a segment of a TableSpace, it
+// holds the layout of the table space, otherwise the layout fields are all zero.
+type fileHeader struct {
+	Magic     uint32
+	Version   uint16
+	FreeHead  page.PageID
+	FreeCount uint32
+	LastLSN   uint64
+	Segment   uint32 // index of this segment in the table space
+	Stripes   uint32 // number of segments the pages are striped across
+	SegPages  uint32 // maximum number of pages in a segment
+	PageSize  uint32 // size of the pages (and the header) in bytes
+}
+
+// newFileHeader returns a new fileHeader with an empty free page list, using
+// the provided page size.
+func newFileHeader(pageSize int) *fileHeader {
+	return &fileHeader{
+		Magic:     fileMagic,
+		Version:   fileVersion,
+		FreeHead:  nilPID,
+		FreeCount: 0,
+		PageSize:  uint32(pageSize),
+	}
+}
+
+// encode encodes the fileHeader into the provided buffer.
+func (h *fileHeader) encode(b []byte) {
+	binary.LittleEndian.PutUint32(b[offMagic:offMagic+4], h.Magic)
+	binary.LittleEndian.PutUint16(b[offVersion:offVersion+2], h.Version)
+	binary.LittleEndian.PutUint32(b[offFreeHead:offFreeHead+4], h.FreeHead)
+	binary.LittleEndian.PutUint32(b[offFreeCount:offFreeCount+4], h.FreeCount)
+	binary.LittleEndian.PutUint64(b[offLastLSN:offLastLSN+8], h.LastLSN)
+	binary.LittleEndian.PutUint32(b[offSegment:offSegment+4], h.Segment)
+	binary.LittleEndian.PutUint32(b[offStripes:offStripes+4], h.Stripes)
+	binary.LittleEndian.PutUint32(b[offSegPages:offSegPages+4], h.SegPages)
+	binary.LittleEndian.PutUint32(b[offPageSize:offPageSize+4], h.PageSize)
+}
+
+// decode decodes the fileHeader from the provided buffer and checks it.
+func (h *fileHeader) decode(b []byte) error {
+	h.Magic = binary.LittleEndian.Uint32(b[offMagic : offMagic+4])
+	h.Version = binary.LittleEndian.Uint16(b[offVersion : offVersion+2])
+	h.FreeHead = binary.LittleEndian.Uint32(b[offFreeHead : offFreeHead+4])
+	h.FreeCount = binary.LittleEndian.Uint32(b[offFreeCount : offFreeCount+4])
+	h.LastLSN = binary.LittleEndian.Uint64(b[offLastLSN : offLastLSN+8])
+	h.Segment = binary.LittleEndian.Uint32(b[offSegment : offSegment+4])
+	h.Stripes = binary.LittleEndian.Uint32(b[offStripes : offStripes+4])
+	h.SegPages = binary.LittleEndian.Uint32(b[offSegPages : offSegPages+4])
+	h.PageSize = binary.LittleEndian.Uint32(b[offPageSize : offPageSize+4])
+	if h.Magic != fileMagic {
+		return fmt.Errorf("%w: magic number mismatch (0x%.8x)", ErrBadFileHeader, h.Magic)
+	}
+	if h.Version <= legacyPagesVersion || h.Version > fileVersion {
+		return fmt.Errorf("%w: unsupported version (%d)", ErrBadFileHeader, h.Version)
+	}
+	if h.Version < 4 {
+		// the page size was not stored, so it must be the default
+		h.PageSize = page.PageSize
+	}
+	if !page.ValidPageSize(int(h.PageSize)) {
+		return fmt.Errorf("%w: bad page size (%d)", ErrBadFileHeader, h.PageSize)
+	}
+	// older versions are upgraded the next time the header is written
+	h.Version = fileVersion
+	if h.Stripes > 0 && h.SegPages == 0 {
+		return fmt.Errorf("%w: bad table space layout", ErrBadFileHeader)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+//go:build (linux || windows) && amd64
+
+package storage
+
+import (
+	"io"
+	"os"
+
+	"github.com/cagnosolutions/go-data/pkg/mmap"
+)
+
+// mmapChunkSize is the number of bytes the memory mapping is grown by. The
+// mapping may reach past the end of the file, as long as nothing past the end
+// of the file is touched, so it only has to be remapped once in a while.
+const mmapChunkSize = 4 << 20
+
+// mmapFile is a data file that is read and written through a memory mapping of
+// the file. Reads and writes are simple copies to and from the mapping, so they
+// do not make any system calls, and the writes are not durable until Sync is
+// called. It is not safe for concurrent use; the DiskStore using it takes care
+// of that.
+type mmapFile struct {
+	file *os.File
+	m    *mmap.Mapping
+	size int64 // size of the file, which may be smaller than the mapping
+}
+
+// openMmapFile opens the file located at the provided path and maps it into
+// memory.
+func openMmapFile(path string, perm os.FileMode) (dataFile, error) {
+	fp, err := os.OpenFile(path, os.O_RDWR, perm)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fp.Stat()
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	f := &mmapFile{
+		file: fp,
+		size: fi.Size(),
+	}
+	err = f.remap()
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// remap makes sure the mapping covers the whole file, growing it by as many
+// chunks as it takes. An empty file is not mapped at all.
+func (f *mmapFile) remap() error {
+	if f.size == 0 || (f.m != nil && int64(f.m.Length()) >= f.size) {
+		return nil
+	}
+	length := (f.size + mmapChunkSize - 1) / mmapChunkSize * mmapChunkSize
+	if f.m != nil {
+		// Close syncs the old mapping before it is unmapped
+		err := f.m.Close()
+		f.m = nil
+		if err != nil {
+			return err
+		}
+	}
+	m, err := mmap.Open(f.file.Fd(), 0, uintptr(length), mmap.ModeReadWrite, 0)
+	if err != nil {
+		return err
+	}
+	f.m = m
+	return nil
+}
+
+// ReadAt copies len(b) bytes from the mapping at the provided offset. It returns
+// io.EOF if that reaches past the end of the file.
+func (f *mmapFile) ReadAt(b []byte, off int64) (int, error) {
+	want := len(b)
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	if end := off + int64(len(b)); end > f.size {
+		b = b[:f.size-off]
+	}
+	n, err := f.m.ReadAt(b, off)
+	if err == nil && n < want {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// WriteAt copies len(b) bytes into the mapping at the provided offset, growing
+// the file (and the mapping) if that reaches past the end of the file.
+func (f *mmapFile) WriteAt(b []byte, off int64) (int, error) {
+	if end := off + int64(len(b)); end > f.size {
+		err := f.Truncate(end)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return f.m.WriteAt(b, off)
+}
+
+// Truncate changes the size of the file, growing the mapping if the file has
+// outgrown it. The mapping is never shrunk.
+func (f *mmapFile) Truncate(size int64) error {
+	err := f.file.Truncate(size)
+	if err != nil {
+		return err
+	}
+	f.size = size
+	return f.remap()
+}
+
+// Sync flushes the mapping out to the file (msync), and then syncs the file so
+// that its size is durable as well.
+func (f *mmapFile) Sync() error {
+	if f.m != nil {
+		err := f.m.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return f.file.Sync()
+}
+
+// Stat returns the FileInfo of the file.
+func (f *mmapFile) Stat() (os.FileInfo, error) {
+	return f.file.Stat()
+}
+
+// Name returns the name of the file.
+func (f *mmapFile) Name() string {
+	return f.file.Name()
+}
+
+// Close syncs and unmaps the mapping, and closes the file.
+func (f *mmapFile) Close() error {
+	var err error
+	if f.m != nil {
+		err = f.m.Close()
+		f.m = nil
+	}
+	if cerr := f.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
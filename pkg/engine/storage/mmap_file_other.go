@@ -0,0 +1,13 @@
+//go:build !((linux || windows) && amd64)
+
+package storage
+
+import (
+	"os"
+)
+
+// openMmapFile returns an error, since memory mapped files are not supported
+// on this platform.
+func openMmapFile(path string, perm os.FileMode) (dataFile, error) {
+	return nil, ErrMmapUnsupported
+}
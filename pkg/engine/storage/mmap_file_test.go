@@ -0,0 +1,117 @@
+//go:build (linux || windows) && amd64
+
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+func TestDiskStore_Mmap(t *testing.T) {
+	defer os.Remove("my-test-io.txt")
+
+	fm, err := OpenWithConfig("my-test-io.txt", &DiskStoreConfig{Mmap: true})
+	if err != nil {
+		t.Fatalf("mmap: io manager open error: %s", err)
+	}
+	// enough pages that the mapping has to grow a couple of times
+	count := 2*mmapChunkSize/page.PageSize + 8
+	for i := 0; i < count; i++ {
+		pid := allocate(t, fm)
+		if pid != page.PageID(i) {
+			t.Fatalf("mmap: expected page %d, got %d", i, pid)
+		}
+		pg := page.NewPage(pid, page.P_USED)
+		rk := []byte(fmt.Sprintf("%.4d", pid))
+		rv := []byte(fmt.Sprintf("some data for page #%.4d", pid))
+		_, err = pg.AddRecord(page.NewRecord(page.R_STR, page.R_STR, rk, rv))
+		if err != nil {
+			t.Errorf("mmap: error writing page record: %s", err)
+		}
+		err = fm.WritePage(pid, pg)
+		if err != nil {
+			t.Fatalf("mmap: error writing page: %s", err)
+		}
+	}
+	err = fm.DeallocatePage(7)
+	if err != nil {
+		t.Errorf("mmap: error deallocating page: %s", err)
+	}
+	// the freed page is handed out again, and a page that has not been
+	// allocated cannot be read at all
+	pid := allocate(t, fm)
+	err = fm.ReadPage(pid, make(page.Page, page.PageSize))
+	if pid != 7 || err != nil {
+		t.Errorf("mmap: expected page %d (nil error), got %d (%v)", 7, pid, err)
+	}
+	err = fm.DeallocatePage(7)
+	if err != nil {
+		t.Errorf("mmap: error deallocating page: %s", err)
+	}
+	err = fm.ReadPage(page.PageID(count+1), make(page.Page, page.PageSize))
+	if err == nil {
+		t.Errorf("mmap: read page %d: expected an error", count+1)
+	}
+
+	// once synced, the pages should be in the file
+	err = fm.Sync()
+	if err != nil {
+		t.Fatalf("mmap: error syncing: %s", err)
+	}
+	raw, err := os.ReadFile("my-test-io.txt")
+	if err != nil {
+		t.Fatalf("mmap: error reading io: %s", err)
+	}
+	if len(raw) != (count+1)*page.PageSize {
+		t.Errorf("mmap: expected a file of %d bytes, got %d", (count+1)*page.PageSize, len(raw))
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Fatalf("mmap: error closing io: %s", err)
+	}
+
+	// the file should read back the same way, with or without the mapping
+	for _, mapped := range []bool{false, true} {
+		fm, err = OpenWithConfig("my-test-io.txt", &DiskStoreConfig{Mmap: mapped})
+		if err != nil {
+			t.Fatalf("mmap: io manager reopen error: %s", err)
+		}
+		if n := fm.PageCount(); n != page.PageID(count) {
+			t.Errorf("mmap: expected %d pages, got %d", count, n)
+		}
+		for pid := page.PageID(0); pid < page.PageID(count); pid++ {
+			pg := make(page.Page, page.PageSize)
+			err = fm.ReadPage(pid, pg)
+			if err != nil {
+				t.Fatalf("mmap: error reading page %d: %s", pid, err)
+			}
+			if pid == 7 {
+				if !pg.HasFlag(page.P_FREE) {
+					t.Errorf("mmap: page %d should be free", pid)
+				}
+				continue
+			}
+			_, r, err := pg.GetRecordAt(0)
+			if err != nil {
+				t.Fatalf("mmap: error reading page record: %s", err)
+			}
+			if want := fmt.Sprintf("some data for page #%.4d", pid); string(r.Val()) != want {
+				t.Errorf("mmap: got %q, expected %q", r.Val(), want)
+			}
+		}
+		err = fm.Close()
+		if err != nil {
+			t.Fatalf("mmap: error closing io: %s", err)
+		}
+	}
+
+	// direct io and mmap do not mix
+	_, err = OpenWithConfig("my-test-io.txt", &DiskStoreConfig{DirectIO: true, Mmap: true})
+	if !errors.Is(err, ErrBadDiskStoreConfig) {
+		t.Errorf("mmap: expected %v, got %v", ErrBadDiskStoreConfig, err)
+	}
+}
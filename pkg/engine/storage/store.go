@@ -10,8 +10,9 @@ import (
 type Storer interface {
 	// AllocatePage allocates and returns the next sequential page.PageID.
 	// in some cases, if there are a lot of empty fragmented pages, it may
-	// return a non-sequential page.PageID.
-	AllocatePage() page.PageID
+	// return a non-sequential page.PageID. It returns an error if no more
+	// pages can be allocated.
+	AllocatePage() (page.PageID, error)
 	// DeallocatePage takes a page.PageID and attempts to locate and mark
 	// the associated page status as free to use in the future. The data
 	// may be wiped, so this is a destructive call and should be used with
@@ -23,8 +24,21 @@ type Storer interface {
 	// WritePage takes a page.PageID, as well as a page.Page, attempts to locate
 	// and copy and flush the contents of p onto the io.
 	WritePage(pid page.PageID, p page.Page) error
+	// PageSize returns the size (in bytes) of the pages held by the storage
+	// layer. Every page.Page passed to ReadPage and WritePage must be this size.
+	PageSize() int
+	// PageCount returns the number of page IDs that have been handed out,
+	// which is one more than the largest page.PageID allocated so far.
+	PageCount() uint32
 	// Close closes the io manager.
 	Close() error
 
 	String() string
 }
+
+// Syncer is implemented by a Storer that does not make the pages durable as they
+// are written (for example, because it writes them into a memory mapping.) Sync
+// makes every page written so far durable.
+type Syncer interface {
+	Sync() error
+}
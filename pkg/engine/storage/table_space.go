@@ -0,0 +1,432 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+const (
+	// defaultMaxFileSize is the default maximum size of a segment file (1GB)
+	defaultMaxFileSize = 1 << 30
+
+	// segmentSuffix is the format used for the suffix of every segment file
+	// following the first one.
+	segmentSuffix = ".%.4d"
+)
+
+var (
+	ErrBadTableSpaceConfig = errors.New("storage: bad table space config")
+	ErrTableSpaceFull      = errors.New("storage: table space is full")
+)
+
+// TableSpaceConfig holds the settings used when creating a TableSpace.
+type TableSpaceConfig struct {
+	// MaxFileSize is the maximum size (in bytes) of every segment file. It
+	// must be large enough to hold the file header and at least one page.
+	MaxFileSize int64
+	// Stripes is the number of segment files the pages are striped across.
+	// When it is one (or less), the pages are written to one segment file
+	// until it is full, and the table space is then extended using another.
+	Stripes int
+	// PageSize is the size (in bytes) of the pages in every segment file. If
+	// it is zero, new table spaces use the default page size, and existing
+	// ones use the page size they were created with. Otherwise, opening an
+	// existing table space using a different page size fails.
+	PageSize int
+	// DirectIO opens every segment file for direct IO, bypassing the page
+	// cache of the operating system.
+	DirectIO bool
+	// Mmap maps every segment file into memory. Writes are only durable once
+	// Sync (or Close) is called.
+	Mmap bool
+}
+
+// DefaultTableSpaceConfig is the config used when none is provided.
+var DefaultTableSpaceConfig = &TableSpaceConfig{
+	MaxFileSize: defaultMaxFileSize,
+	Stripes:     1,
+}
+
+// TableSpace is a Storer that spreads the pages of a single page ID space
+// across several segment files, each one of them holding at most a set number
+// of pages. The segments are grouped into sets of stripes; the pages in a set
+// are handed out round-robin across the segments in the set, and a new set is
+// started once the set is full. With a single stripe, this simply extends the
+// table space one segment at a time.
+//
+// The first segment is located at the path of the table space, and every other
+// segment is located at the same path with the segment number as a suffix (for
+// example "users.db", "users.db.0001", "users.db.0002".) The layout is kept in
+// the header of every segment, so the table space can be reopened using any
+// config (as long as it does not ask for another page size.) A data file
+// written by a plain DiskStore is picked up as the first segment of a table
+// space. Every segment uses the same page size.
+type TableSpace struct {
+	sync.RWMutex
+	path     string
+	pageSize int
+	direct   bool
+	mapped   bool
+	stripes  uint32
+	segPages uint32
+	segs     []*DiskStore
+	nextPID  page.PageID
+}
+
+// OpenTableSpace opens the table space located at the provided path, creating
+// it using the provided config if it does not exist.
+func OpenTableSpace(path string, conf *TableSpaceConfig) (*TableSpace, error) {
+	if conf == nil {
+		conf = DefaultTableSpaceConfig
+	}
+	if conf.PageSize != 0 && !page.ValidPageSize(conf.PageSize) {
+		return nil, fmt.Errorf("%w: %s", ErrBadTableSpaceConfig, page.ErrBadPageSize)
+	}
+	pageSize := int64(conf.PageSize)
+	if pageSize == 0 {
+		pageSize = page.PageSize
+	}
+	// the file header takes up one page
+	if conf.MaxFileSize < 2*pageSize {
+		return nil, fmt.Errorf("%w: max file size is too small (%d)", ErrBadTableSpaceConfig, conf.MaxFileSize)
+	}
+	stripes := uint32(1)
+	if conf.Stripes > 1 {
+		stripes = uint32(conf.Stripes)
+	}
+	ts := &TableSpace{
+		path:    filepath.ToSlash(path),
+		direct:  conf.DirectIO,
+		mapped:  conf.Mmap,
+		stripes: stripes,
+	}
+	// Open the first segment, which tells us the page size and the layout of
+	// the table space
+	s, err := OpenWithConfig(ts.path, ts.diskStoreConfig(conf.PageSize))
+	if err != nil {
+		return nil, err
+	}
+	ts.pageSize = s.PageSize()
+	segPages := conf.MaxFileSize/int64(ts.pageSize) - 1
+	if segPages < 1 {
+		segPages = 1
+	}
+	if segPages > int64(^uint32(0)/stripes) {
+		segPages = int64(^uint32(0) / stripes)
+	}
+	ts.segPages = uint32(segPages)
+	err = ts.adopt(s)
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	ts.segs = append(ts.segs, s)
+	// Open the rest of the segments
+	for i := uint32(1); ; i++ {
+		_, err = os.Stat(ts.segmentPath(i))
+		if os.IsNotExist(err) {
+			break
+		}
+		_, err = ts.openSegment(i)
+		if err != nil {
+			_ = ts.Close()
+			return nil, err
+		}
+	}
+	// Pick up where the last allocated page left off
+	for _, s := range ts.segs {
+		if next := s.PageCount(); next > ts.nextPID {
+			ts.nextPID = next
+		}
+	}
+	return ts, nil
+}
+
+// adopt takes the layout from the first segment, or writes the layout to it
+// if it does not have one yet. A plain data file is picked up as the first
+// segment, which may mean its maximum size has to be raised to fit it.
+func (ts *TableSpace) adopt(s *DiskStore) error {
+	h := s.header
+	if h.Stripes > 0 {
+		if h.Segment != 0 {
+			return fmt.Errorf("%w: %q is segment %d", ErrBadFileHeader, ts.path, h.Segment)
+		}
+		ts.stripes, ts.segPages = h.Stripes, h.SegPages
+		return nil
+	}
+	if s.nextPage > 0 {
+		// Pages are already laid out one after the other in this file, so it
+		// cannot be striped.
+		ts.stripes = 1
+		if s.nextPage > ts.segPages {
+			ts.segPages = s.nextPage
+		}
+	}
+	return s.setLayout(0, ts.stripes, ts.segPages)
+}
+
+// segmentPath returns the path of the segment file matching the provided
+// segment number.
+func (ts *TableSpace) segmentPath(i uint32) string {
+	if i == 0 {
+		return ts.path
+	}
+	return ts.path + fmt.Sprintf(segmentSuffix, i)
+}
+
+// openSegment opens (or creates) the segment file matching the provided
+// segment number and adds it to the table space. The segments are always
+// opened in order.
+func (ts *TableSpace) openSegment(i uint32) (*DiskStore, error) {
+	s, err := OpenWithConfig(ts.segmentPath(i), ts.diskStoreConfig(ts.pageSize))
+	if err != nil {
+		return nil, err
+	}
+	h := s.header
+	if h.Stripes == 0 && s.nextPage == 0 {
+		// It is a fresh segment, so write the layout to it
+		err = s.setLayout(i, ts.stripes, ts.segPages)
+		if err != nil {
+			_ = s.Close()
+			return nil, err
+		}
+	}
+	if h.Segment != i || h.Stripes != ts.stripes || h.SegPages != ts.segPages {
+		_ = s.Close()
+		return nil, fmt.Errorf("%w: segment %d does not match the table space layout", ErrBadFileHeader, i)
+	}
+	ts.segs = append(ts.segs, s)
+	return s, nil
+}
+
+// diskStoreConfig returns the config used to open the segment files, using the
+// provided page size.
+func (ts *TableSpace) diskStoreConfig(pageSize int) *DiskStoreConfig {
+	return &DiskStoreConfig{
+		PageSize: pageSize,
+		DirectIO: ts.direct,
+		Mmap:     ts.mapped,
+	}
+}
+
+// segmentOf returns the segment number of the segment holding the provided
+// page ID.
+func (ts *TableSpace) segmentOf(pid page.PageID) uint32 {
+	set := pid / (ts.stripes * ts.segPages)
+	return set*ts.stripes + pid%ts.stripes
+}
+
+// segment returns the segment holding the provided page ID, or an error if
+// the page ID has not been allocated. The caller must hold the lock.
+func (ts *TableSpace) segment(pid page.PageID) (*DiskStore, error) {
+	i := ts.segmentOf(pid)
+	if pid >= ts.nextPID || int(i) >= len(ts.segs) {
+		return nil, page.ErrPageIDHasNotBeenAllocated(pid)
+	}
+	return ts.segs[i], nil
+}
+
+// AllocatePage returns a page ID that can be written to. If there are any pages
+// in the free page list of any of the segments, one of them is returned.
+// Otherwise, it returns the next logical page ID, creating a new segment file if
+// the page belongs in one that does not exist yet. If the table space is full
+// it returns ErrTableSpaceFull, and if the segment cannot be created it returns
+// the error that occurred creating it.
+func (ts *TableSpace) AllocatePage() (page.PageID, error) {
+	ts.Lock()
+	defer ts.Unlock()
+	// Check the free page lists first
+	for _, s := range ts.segs {
+		s.Lock()
+		pid, ok := s.allocateFree()
+		s.Unlock()
+		if ok {
+			return pid, nil
+		}
+	}
+	return ts.allocateNext()
+}
+
+// allocateNext returns the next logical page ID. The caller must hold the lock.
+func (ts *TableSpace) allocateNext() (page.PageID, error) {
+	pid := ts.nextPID
+	if pid == nilPID {
+		return nilPID, ErrTableSpaceFull
+	}
+	i := ts.segmentOf(pid)
+	if int(i) >= len(ts.segs) {
+		// the page belongs in the next segment, which we have to create
+		_, err := ts.openSegment(i)
+		if err != nil {
+			return nilPID, err
+		}
+	}
+	s := ts.segs[i]
+	s.Lock()
+	defer s.Unlock()
+	if got := s.allocateNext(); got != pid {
+		// this should never happen, as long as the segments are only ever
+		// allocated through the table space
+		return nilPID, fmt.Errorf("%w: segment %d allocated pid=%d, expected pid=%d", ErrBadFileHeader, i, got, pid)
+	}
+	ts.nextPID++
+	return pid, nil
+}
+
+// DeallocatePage adds the page to the free page list of the segment holding it,
+// so that it can be reused.
+func (ts *TableSpace) DeallocatePage(pid page.PageID) error {
+	ts.RLock()
+	defer ts.RUnlock()
+	s, err := ts.segment(pid)
+	if err != nil {
+		return err
+	}
+	return s.DeallocatePage(pid)
+}
+
+// ReadPage reads the page matching the page ID provided from the segment holding
+// it. The page checksum is verified, and if the page does not pass, a
+// *CorruptPageError is returned.
+func (ts *TableSpace) ReadPage(pid page.PageID, p page.Page) error {
+	ts.RLock()
+	defer ts.RUnlock()
+	s, err := ts.segment(pid)
+	if err != nil {
+		return err
+	}
+	return s.ReadPage(pid, p)
+}
+
+// WritePage writes the page matching the page ID provided to the segment holding
+// it. Before the page is written, it is stamped with a checksum.
+func (ts *TableSpace) WritePage(pid page.PageID, p page.Page) error {
+	ts.RLock()
+	defer ts.RUnlock()
+	s, err := ts.segment(pid)
+	if err != nil {
+		return err
+	}
+	return s.WritePage(pid, p)
+}
+
+// PageSize returns the size of the pages in the table space.
+func (ts *TableSpace) PageSize() int {
+	return ts.pageSize
+}
+
+// PageCount returns the number of page IDs that have been handed out, which is
+// one more than the largest page ID allocated so far.
+func (ts *TableSpace) PageCount() uint32 {
+	ts.RLock()
+	defer ts.RUnlock()
+	return ts.nextPID
+}
+
+// LastLSN returns the largest log sequence number found on any of the pages
+// that have been written to any of the segments.
+func (ts *TableSpace) LastLSN() uint64 {
+	ts.RLock()
+	defer ts.RUnlock()
+	var lsn uint64
+	for _, s := range ts.segs {
+		if n := s.LastLSN(); n > lsn {
+			lsn = n
+		}
+	}
+	return lsn
+}
+
+// Sync makes every page written to any of the segments so far durable.
+func (ts *TableSpace) Sync() error {
+	ts.RLock()
+	defer ts.RUnlock()
+	for _, s := range ts.segs {
+		err := s.Sync()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Segments returns the paths of all the segment files in the table space.
+func (ts *TableSpace) Segments() []string {
+	ts.RLock()
+	defer ts.RUnlock()
+	paths := make([]string, len(ts.segs))
+	for i := range ts.segs {
+		paths[i] = ts.segmentPath(uint32(i))
+	}
+	return paths
+}
+
+// Close closes all the segments in the table space.
+func (ts *TableSpace) Close() error {
+	ts.Lock()
+	defer ts.Unlock()
+	var err error
+	for _, s := range ts.segs {
+		if cerr := s.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	ts.segs = nil
+	return err
+}
+
+// RemoveTableSpace removes all the segment files of the table space located at
+// the provided path. The table space should be closed first.
+func RemoveTableSpace(path string) error {
+	path = filepath.ToSlash(path)
+	err := os.Remove(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := uint32(1); ; i++ {
+		err = os.Remove(path + fmt.Sprintf(segmentSuffix, i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (ts *TableSpace) String() string {
+	return ts.JSON()
+}
+
+func (ts *TableSpace) JSON() string {
+	ts.RLock()
+	defer ts.RUnlock()
+	info := struct {
+		Path     string            `json:"path"`
+		PageSize int               `json:"page_size"`
+		Stripes  uint32            `json:"stripes"`
+		SegPages uint32            `json:"seg_pages"`
+		NextPID  uint32            `json:"next_pid"`
+		Segments []json.RawMessage `json:"segments"`
+	}{
+		Path:     ts.path,
+		PageSize: ts.pageSize,
+		Stripes:  ts.stripes,
+		SegPages: ts.segPages,
+		NextPID:  ts.nextPID,
+	}
+	for _, s := range ts.segs {
+		info.Segments = append(info.Segments, json.RawMessage(s.JSON()))
+	}
+	b, err := json.MarshalIndent(&info, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(b)
+}
@@ -0,0 +1,247 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+func TestTableSpace(t *testing.T) {
+	testDir := "ts-testing"
+	defer os.RemoveAll(testDir)
+
+	for _, stripes := range []int{1, 3} {
+		path := filepath.Join(testDir, fmt.Sprintf("stripes-%d.db", stripes))
+		// every segment holds 4 pages
+		conf := &TableSpaceConfig{
+			MaxFileSize: 5 * page.PageSize,
+			Stripes:     stripes,
+		}
+		ts, err := OpenTableSpace(path, conf)
+		if err != nil {
+			t.Fatalf("[stripes=%d] open: %s", stripes, err)
+		}
+		for i := 0; i < 24; i++ {
+			pid := allocate(t, ts)
+			if pid != page.PageID(i) {
+				t.Fatalf("[stripes=%d] allocate: expected page %d, got %d", stripes, i, pid)
+			}
+			err = ts.WritePage(pid, page.NewPage(pid, page.P_USED))
+			if err != nil {
+				t.Fatalf("[stripes=%d] write page %d: %s", stripes, pid, err)
+			}
+		}
+		// 24 pages at 4 pages per segment
+		if n := len(ts.Segments()); n != 6 {
+			t.Errorf("[stripes=%d] expected 6 segments, got %d", stripes, n)
+		}
+		for i, seg := range ts.Segments() {
+			fi, err := os.Stat(seg)
+			if err != nil {
+				t.Fatalf("[stripes=%d] stat segment %d: %s", stripes, i, err)
+			}
+			if fi.Size() > conf.MaxFileSize {
+				t.Errorf("[stripes=%d] segment %d is larger than the max file size (%d)", stripes, i, fi.Size())
+			}
+		}
+		// the pages should map onto the proper segments
+		if stripes == 3 {
+			for pid, seg := range map[page.PageID]uint32{0: 0, 1: 1, 2: 2, 3: 0, 11: 2, 12: 3, 13: 4, 23: 5} {
+				if got := ts.segmentOf(pid); got != seg {
+					t.Errorf("[stripes=%d] page %d should be in segment %d, got %d", stripes, pid, seg, got)
+				}
+			}
+		}
+		err = ts.DeallocatePage(13)
+		if err != nil {
+			t.Fatalf("[stripes=%d] deallocate: %s", stripes, err)
+		}
+		err = ts.Close()
+		if err != nil {
+			t.Fatalf("[stripes=%d] close: %s", stripes, err)
+		}
+
+		// reopen using the default config, the layout should be picked up
+		// from the segments
+		ts, err = OpenTableSpace(path, nil)
+		if err != nil {
+			t.Fatalf("[stripes=%d] reopen: %s", stripes, err)
+		}
+		for i := 0; i < 24; i++ {
+			pid := page.PageID(i)
+			p := make(page.Page, page.PageSize)
+			err = ts.ReadPage(pid, p)
+			if err != nil {
+				t.Fatalf("[stripes=%d] read page %d: %s", stripes, pid, err)
+			}
+			if p.GetPageID() != pid {
+				t.Errorf("[stripes=%d] read page %d: got page %d", stripes, pid, p.GetPageID())
+			}
+			if pid == 13 && !p.HasFlag(page.P_FREE) {
+				t.Errorf("[stripes=%d] read page %d: page should be free", stripes, pid)
+			}
+		}
+		// the freed page is handed out first, and then the next sequential one
+		if pid := allocate(t, ts); pid != 13 {
+			t.Errorf("[stripes=%d] allocate: expected page %d, got %d", stripes, 13, pid)
+		}
+		if pid := allocate(t, ts); pid != 24 {
+			t.Errorf("[stripes=%d] allocate: expected page %d, got %d", stripes, 24, pid)
+		}
+		// and pages that have not been allocated cannot be read
+		err = ts.ReadPage(100, make(page.Page, page.PageSize))
+		if err == nil {
+			t.Errorf("[stripes=%d] read page 100: expected an error", stripes)
+		}
+		err = ts.Close()
+		if err != nil {
+			t.Fatalf("[stripes=%d] close: %s", stripes, err)
+		}
+		err = RemoveTableSpace(path)
+		if err != nil {
+			t.Fatalf("[stripes=%d] remove: %s", stripes, err)
+		}
+		if matches, _ := filepath.Glob(path + "*"); len(matches) > 0 {
+			t.Errorf("[stripes=%d] remove: files left behind: %v", stripes, matches)
+		}
+	}
+}
+
+func TestTableSpace_PlainFile(t *testing.T) {
+	testDir := "ts-testing"
+	defer os.RemoveAll(testDir)
+	path := filepath.Join(testDir, "plain.db")
+
+	// write a few pages using a plain disk store
+	fm, err := Open(path)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	for i := 0; i < 6; i++ {
+		pid := allocate(t, fm)
+		err = fm.WritePage(pid, page.NewPage(pid, page.P_USED))
+		if err != nil {
+			t.Fatalf("write page %d: %s", pid, err)
+		}
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// it should be picked up as the first segment, even though it holds more
+	// pages than a segment should
+	conf := &TableSpaceConfig{
+		MaxFileSize: 5 * page.PageSize,
+		Stripes:     2,
+	}
+	ts, err := OpenTableSpace(path, conf)
+	if err != nil {
+		t.Fatalf("open table space: %s", err)
+	}
+	for i := 0; i < 6; i++ {
+		err = ts.ReadPage(page.PageID(i), make(page.Page, page.PageSize))
+		if err != nil {
+			t.Errorf("read page %d: %s", i, err)
+		}
+	}
+	if pid := allocate(t, ts); pid != 6 {
+		t.Errorf("allocate: expected page %d, got %d", 6, pid)
+	}
+	if n := len(ts.Segments()); n != 2 {
+		t.Errorf("expected 2 segments, got %d", n)
+	}
+	err = ts.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// a segment cannot be opened as the first segment of a table space
+	_, err = OpenTableSpace(path+".0001", nil)
+	if !errors.Is(err, ErrBadFileHeader) {
+		t.Errorf("expected %v, got %v", ErrBadFileHeader, err)
+	}
+}
+
+func TestTableSpace_PageSize(t *testing.T) {
+	testDir := "ts-testing"
+	defer os.RemoveAll(testDir)
+	path := filepath.Join(testDir, "small.db")
+
+	// every segment holds 4 pages of 4KB
+	conf := &TableSpaceConfig{
+		MaxFileSize: 5 * page.MinPageSize,
+		Stripes:     2,
+		PageSize:    page.MinPageSize,
+	}
+	ts, err := OpenTableSpace(path, conf)
+	if err != nil {
+		t.Fatalf("open: %s", err)
+	}
+	for i := 0; i < 12; i++ {
+		pid := allocate(t, ts)
+		err = ts.WritePage(pid, page.NewPageSize(pid, page.P_USED, page.MinPageSize))
+		if err != nil {
+			t.Fatalf("write page %d: %s", pid, err)
+		}
+	}
+	if n := len(ts.Segments()); n != 4 {
+		t.Errorf("expected 4 segments, got %d", n)
+	}
+	err = ts.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// the table space should not open using another page size
+	_, err = OpenTableSpace(path, &TableSpaceConfig{MaxFileSize: defaultMaxFileSize, PageSize: 8 << 10})
+	if !errors.Is(err, ErrPageSizeMismatch) {
+		t.Errorf("expected %v, got %v", ErrPageSizeMismatch, err)
+	}
+	// but the default config picks up the page size from the segments
+	ts, err = OpenTableSpace(path, nil)
+	if err != nil {
+		t.Fatalf("reopen: %s", err)
+	}
+	if ts.PageSize() != page.MinPageSize {
+		t.Errorf("expected page size %d, got %d", page.MinPageSize, ts.PageSize())
+	}
+	for i := 0; i < 12; i++ {
+		err = ts.ReadPage(page.PageID(i), make(page.Page, page.MinPageSize))
+		if err != nil {
+			t.Errorf("read page %d: %s", i, err)
+		}
+	}
+	err = ts.Close()
+	if err != nil {
+		t.Fatalf("close: %s", err)
+	}
+
+	// a segment that uses another page size is rejected
+	err = os.Remove(path + ".0003")
+	if err != nil {
+		t.Fatalf("remove: %s", err)
+	}
+	fm, err := OpenWithPageSize(path+".0003", page.PageSize)
+	if err != nil {
+		t.Fatalf("open segment: %s", err)
+	}
+	err = fm.Close()
+	if err != nil {
+		t.Fatalf("close segment: %s", err)
+	}
+	_, err = OpenTableSpace(path, nil)
+	if !errors.Is(err, ErrPageSizeMismatch) {
+		t.Errorf("expected %v, got %v", ErrPageSizeMismatch, err)
+	}
+
+	// as is a page size that is out of range
+	_, err = OpenTableSpace(path, &TableSpaceConfig{MaxFileSize: defaultMaxFileSize, PageSize: 1 << 10})
+	if !errors.Is(err, ErrBadTableSpaceConfig) {
+		t.Errorf("expected %v, got %v", ErrBadTableSpaceConfig, err)
+	}
+}
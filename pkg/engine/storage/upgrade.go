@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/page"
+)
+
+var ErrUpgrade = errors.New("storage: cannot upgrade the file")
+
+// legacyPagesVersion is the last version of the file format that held pages
+// using the legacy page format, which has a smaller page header without a log
+// sequence number or checksum. Those files always use the default page size.
+// Files that were written before there was a file header (version 0) hold
+// nothing but pages using the legacy page format.
+const legacyPagesVersion uint16 = 1
+
+// upgradeSuffix is added to the path of a data file while it is being upgraded
+const upgradeSuffix = ".upgrade"
+
+// upgradeFile upgrades the data file located at the provided path if it uses
+// an older format that cannot be read as is. The upgraded file is written next
+// to the old one, and then renamed over it, so a crash during the upgrade leaves
+// the old file as it was.
+func upgradeFile(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, minHeaderSize)
+	_, err = fp.ReadAt(buf, 0)
+	if err != nil {
+		_ = fp.Close()
+		if err == io.EOF {
+			// too small to hold a header, which load takes care of
+			return nil
+		}
+		return err
+	}
+	magic := binary.LittleEndian.Uint32(buf[offMagic : offMagic+4])
+	version := binary.LittleEndian.Uint16(buf[offVersion : offVersion+2])
+	h := newFileHeader(page.PageSize)
+	var base int64
+	switch {
+	case magic == fileMagic && version <= legacyPagesVersion:
+		// the legacy pages follow the file header
+		h.FreeHead = binary.LittleEndian.Uint32(buf[offFreeHead : offFreeHead+4])
+		h.FreeCount = binary.LittleEndian.Uint32(buf[offFreeCount : offFreeCount+4])
+		base = page.PageSize
+	case magic == 0 && isLegacyFile(fp):
+		// there is no file header, the first page is page 0
+		version = 0
+	default:
+		return fp.Close()
+	}
+	tmp := path + upgradeSuffix
+	err = rewriteLegacyPages(tmp, fp, h, base)
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("%w %s (version %d): %s", ErrUpgrade, path, version, err)
+	}
+	return nil
+}
+
+// isLegacyFile returns a boolean indicating true if the file begins with page 0
+// in the legacy page format, rather than a file header, which is the way data
+// files were laid out before they had one.
+func isLegacyFile(fp *os.File) bool {
+	fi, err := fp.Stat()
+	if err != nil || fi.Size() == 0 || fi.Size()%page.PageSize != 0 {
+		return false
+	}
+	p := make(page.Page, page.PageSize)
+	_, err = fp.ReadAt(p, 0)
+	if err != nil || p.GetPageID() != 0 || p.GetFlags()&(page.P_FREE|page.P_USED) == 0 {
+		return false
+	}
+	return page.UpgradeLegacyPage(p) == nil
+}
+
+// rewriteLegacyPages writes a copy of the data file, which holds pages using the
+// legacy page format starting at the provided offset, to the provided path. The
+// copy holds the provided file header, followed by the pages using the current
+// page format. Any free pages that are not in the free page list yet are added
+// to it.
+func rewriteLegacyPages(path string, fp *os.File, h *fileHeader, base int64) error {
+	fi, err := fp.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size()%page.PageSize != 0 {
+		return fmt.Errorf("file size %d is not a multiple of the page size", fi.Size())
+	}
+	tmp, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, dataFilePerm)
+	if err != nil {
+		return err
+	}
+	defer tmp.Close()
+	// The free page list is made up of the pages that are linked together
+	// by the file header, so we need to know which ones are in it already.
+	inList := make(map[page.PageID]bool)
+	p := make(page.Page, page.PageSize)
+	for pid := h.FreeHead; pid != nilPID && !inList[pid]; pid = p.GetNext() {
+		inList[pid] = true
+		_, err = fp.ReadAt(p, base+int64(pid)*page.PageSize)
+		if err != nil {
+			return fmt.Errorf("free page %d: %w", pid, err)
+		}
+	}
+	for off := base; off < fi.Size(); off += page.PageSize {
+		_, err = fp.ReadAt(p, off)
+		if err != nil {
+			return err
+		}
+		pid := page.PageID((off - base) / page.PageSize)
+		err = page.UpgradeLegacyPage(p)
+		if err != nil {
+			return fmt.Errorf("page %d: %w", pid, err)
+		}
+		if !isZero(p) {
+			if p.GetPageID() != pid {
+				return fmt.Errorf("page %d: page header holds pid=%d", pid, p.GetPageID())
+			}
+			if p.HasFlag(page.P_FREE) && !inList[pid] {
+				ph := p.GetPageHeader()
+				ph.Next = h.FreeHead
+				p.SetPageHeader(ph)
+				h.FreeHead = pid
+				h.FreeCount++
+			}
+			p.SetChecksum()
+		}
+		_, err = tmp.WriteAt(p, page.PageSize+off-base)
+		if err != nil {
+			return err
+		}
+	}
+	buf := make([]byte, page.PageSize)
+	h.encode(buf)
+	_, err = tmp.WriteAt(buf, 0)
+	if err != nil {
+		return err
+	}
+	err = tmp.Sync()
+	if err != nil {
+		return err
+	}
+	return tmp.Close()
+}
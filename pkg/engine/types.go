@@ -2,6 +2,8 @@ package engine
 
 import (
 	"encoding"
+
+	"github.com/cagnosolutions/go-data/pkg/engine/buffer"
 )
 
 type OpenEngine func(path string) (Engine, error)
@@ -36,13 +38,20 @@ type Record interface {
 }
 
 type Namespace interface {
-	Find(func(Record) bool) ([]Record, error)
+	Find(ptr Record, fn func(Record) bool) ([]Record, error)
 	FindOne(id uint64, ptr Record) error
-	FindAll() ([]Record, error)
+	FindAll(ptr Record) ([]Record, error)
 	Insert(data Record) (uint64, error)
 	Update(id uint64, data Record) (uint64, error)
 	Delete(id uint64) error
 	Commit() error
+	Vacuum() (*VacuumStats, error)
+	Begin() (*Tx, error)
+	Stats() *buffer.Stats
+	CreateIndex(name string, ptr Record, fn IndexFunc) error
+	DropIndex(name string) error
+	FindBy(name string, key []byte) ([]uint64, error)
+	FindRange(name string, lo, hi []byte) ([]uint64, error)
 
 	destroy() error
 	close() error
@@ -8,7 +8,11 @@ import (
 // alignTo returns an integer representing a byte offset for a region that
 // is aligned on a boundary that is consistent with the provided size.
 func alignTo(block []byte, size int) int {
-	return int(uintptr(unsafe.Pointer(&block[0])) & uintptr(AlignSize-1))
+	align := uintptr(size)
+	if align == 0 {
+		return 0
+	}
+	return int(uintptr(unsafe.Pointer(&block[0])) & (align - 1))
 }
 
 // isAligned returns a boolean indicating true if the provided slice is
@@ -17,6 +21,15 @@ func isAligned(block []byte) bool {
 	return alignTo(block, AlignSize) == 0
 }
 
+// IsAligned returns a boolean indicating true if the provided slice can be used
+// for direct IO as it is, without having to be copied into an aligned block.
+func IsAligned(block []byte) bool {
+	if AlignSize == 0 || len(block) == 0 {
+		return true
+	}
+	return isAligned(block)
+}
+
 // AlignedBlock allocates and returns a slice of []byte that has the length
 // and capacity provided by size.
 func AlignedBlock(BlockSize int) []byte {
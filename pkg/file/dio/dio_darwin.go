@@ -1,15 +1,21 @@
-//go:build !windows && !openbsd && !plan9 && !linux && !unix
+//go:build darwin
 
 package dio
 
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
 const (
 	AlignSize = 0    // OSX doesn't need any alignment
 	BlockSize = 4096 // Minimum block size
 )
 
 // OpenFile is the OSX function used to open and return a file for direct IO.
-func OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
-	file, err := os.OpenFile(name, flag, perm)
+func OpenFile(name string, flag int, perm os.FileMode) (file *os.File, err error) {
+	file, err = os.OpenFile(name, flag, perm)
 	if err != nil {
 		return
 	}
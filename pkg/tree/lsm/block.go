@@ -0,0 +1,221 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// A block is a run of entries sorted by key. Keys share as much of their prefix
+// as they can with the key before them, so only the rest of the key is stored:
+//
+//	[Shared][Unshared][ValLen][Key suffix][Val]
+//	 varint   varint   varint     *         *
+//
+// The lowest bit of ValLen marks a tombstone, and the value length is stored
+// in the rest of it. Every restartInterval-th entry is a restart point, which
+// stores its whole key, so a block can be searched without decoding all of it.
+// The block ends with the offsets of the restart points:
+//
+//	[Entries][Restart][Restart] ... [NumRestarts]
+//	    *     uint32   uint32          uint32
+//
+// On disk, every block is followed by a trailer holding the CRC32C checksum of
+// the block.
+const (
+	blockSize       = 4 << 10 // blocks are cut once they grow past this size
+	restartInterval = 16
+	trailerSize     = 4
+)
+
+// crc32cTable is the CRC32C (Castagnoli) table used for the block checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// blockBuilder builds a single block
+type blockBuilder struct {
+	buf      []byte
+	restarts []uint32
+	interval int    // interval is the number of entries between restart points
+	count    int    // count is the number of entries in the block
+	last     string // last is the key of the last entry added
+}
+
+func newBlockBuilder(interval int) *blockBuilder {
+	return &blockBuilder{interval: interval}
+}
+
+// add appends the entry to the block. Entries must be added in key order.
+func (b *blockBuilder) add(e *Entry) {
+	shared := 0
+	if b.count%b.interval == 0 {
+		b.restarts = append(b.restarts, uint32(len(b.buf)))
+	} else {
+		shared = sharedPrefixLen(b.last, e.Key)
+	}
+	vlen := uint64(len(e.Val)) << 1
+	if e.Tombstone {
+		vlen = 1
+	}
+	b.buf = appendUvarint(b.buf, uint64(shared))
+	b.buf = appendUvarint(b.buf, uint64(len(e.Key)-shared))
+	b.buf = appendUvarint(b.buf, vlen)
+	b.buf = append(b.buf, e.Key[shared:]...)
+	if !e.Tombstone {
+		b.buf = append(b.buf, e.Val...)
+	}
+	b.last = e.Key
+	b.count++
+}
+
+// size returns the size the block would be if it were finished now
+func (b *blockBuilder) size() int {
+	return len(b.buf) + 4*len(b.restarts) + 4
+}
+
+// finish appends the restart points to the block, and returns it. The block
+// is only good until the builder is reset.
+func (b *blockBuilder) finish() []byte {
+	for _, r := range b.restarts {
+		b.buf = appendUint32(b.buf, r)
+	}
+	return appendUint32(b.buf, uint32(len(b.restarts)))
+}
+
+// reset empties the builder, so it can build the next block
+func (b *blockBuilder) reset() {
+	b.buf, b.restarts, b.count, b.last = b.buf[:0], b.restarts[:0], 0, ""
+}
+
+// sharedPrefixLen returns the length of the prefix shared by a and b
+func sharedPrefixLen(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
+
+// appendUvarint appends the varint encoding of v to the provided buffer
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	return append(b, buf[:n]...)
+}
+
+// appendBlock appends the block, along with its trailer, to the provided buffer
+func appendBlock(b []byte, block []byte) []byte {
+	b = append(b, block...)
+	return appendUint32(b, crc32.Checksum(block, crc32cTable))
+}
+
+// checkBlock checks the trailer of the block, and returns the block without it
+func checkBlock(b []byte) ([]byte, error) {
+	if len(b) < trailerSize {
+		return nil, ErrBadTable
+	}
+	block, sum := b[:len(b)-trailerSize], binary.BigEndian.Uint32(b[len(b)-trailerSize:])
+	if crc32.Checksum(block, crc32cTable) != sum {
+		return nil, ErrBadChecksum
+	}
+	return block, nil
+}
+
+// blockIter iterates the entries of a block in key order
+type blockIter struct {
+	data     []byte // data holds the entries of the block
+	restarts []byte // restarts holds the offsets of the restart points
+	off      int    // off is the offset of the next entry
+	key      []byte // key is the key of the current entry
+	entry    Entry  // entry is the current entry
+	err      error  // err is set if the block turns out to be corrupt
+}
+
+// newBlockIter returns an iterator over the block, positioned before the first
+// entry.
+func newBlockIter(block []byte) (*blockIter, error) {
+	if len(block) < 4 {
+		return nil, ErrBadTable
+	}
+	n := uint64(binary.BigEndian.Uint32(block[len(block)-4:]))
+	if n == 0 || 4*(n+1) > uint64(len(block)) {
+		return nil, ErrBadTable
+	}
+	end := len(block) - 4*int(n+1)
+	return &blockIter{
+		data:     block[:end],
+		restarts: block[end : len(block)-4],
+	}, nil
+}
+
+// numRestarts returns the number of restart points in the block
+func (it *blockIter) numRestarts() int {
+	return len(it.restarts) / 4
+}
+
+// restart returns the offset of the i-th restart point
+func (it *blockIter) restart(i int) int {
+	return int(binary.BigEndian.Uint32(it.restarts[4*i:]))
+}
+
+// next moves on to the next entry, returning false once there are no more
+// entries, or if the block is corrupt (in which case err is set).
+func (it *blockIter) next() bool {
+	if it.err != nil || it.off >= len(it.data) {
+		return false
+	}
+	p := it.data[it.off:]
+	var fields [3]uint64
+	for i := range fields {
+		v, n := binary.Uvarint(p)
+		if n <= 0 {
+			it.err = ErrBadTable
+			return false
+		}
+		fields[i], p = v, p[n:]
+	}
+	shared, unshared, vlen := fields[0], fields[1], fields[2]
+	tomb := vlen&1 == 1
+	vlen >>= 1
+	if shared > uint64(len(it.key)) || unshared+vlen > uint64(len(p)) {
+		it.err = ErrBadTable
+		return false
+	}
+	it.key = append(it.key[:shared], p[:unshared]...)
+	it.entry = Entry{
+		Key:       string(it.key),
+		Val:       string(p[unshared : unshared+vlen]),
+		Tombstone: tomb,
+	}
+	it.off = len(it.data) - len(p) + int(unshared+vlen)
+	return true
+}
+
+// seek moves to the first entry with a key greater than or equal to the key,
+// returning false if there is no such entry.
+func (it *blockIter) seek(key string) bool {
+	// find the last restart point with a key before the key, the
+	// entries at the restart points do not share any of their key
+	left, right := 0, it.numRestarts()-1
+	for left < right {
+		mid := (left + right + 1) / 2
+		it.off, it.key = it.restart(mid), it.key[:0]
+		if !it.next() {
+			return false
+		}
+		if it.entry.Key < key {
+			left = mid
+		} else {
+			right = mid - 1
+		}
+	}
+	it.off, it.key = it.restart(left), it.key[:0]
+	for it.next() {
+		if it.entry.Key >= key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package lsm
+
+import (
+	"encoding/binary"
+
+	"github.com/cagnosolutions/go-data/pkg/hash/xxhash"
+)
+
+const (
+	bloomBitsPerKey = 10 // about a 1% false positive rate
+	bloomHashes     = 7  // the best number of hashes for ten bits per key
+)
+
+// bloomFilter is a Bloom filter over the keys of a table. It never says a key
+// is missing when it is there, but sometimes says a key may be there when it
+// is not. It is encoded as:
+//
+//	[Hashes][Bits]
+//	 uint32   *
+type bloomFilter struct {
+	bits   []byte
+	hashes uint32
+}
+
+// newBloomFilter returns an empty filter sized to hold the provided number of
+// keys.
+func newBloomFilter(keys int) *bloomFilter {
+	nbits := keys * bloomBitsPerKey
+	if nbits < 64 {
+		nbits = 64
+	}
+	return &bloomFilter{
+		bits:   make([]byte, (nbits+7)/8),
+		hashes: bloomHashes,
+	}
+}
+
+// bloomLocations returns the two hashes the bit locations of a key are derived
+// from, using double hashing. The second hash is a rotation of the first one,
+// so the key only has to be hashed once.
+func bloomLocations(key string) (uint64, uint64) {
+	h := xxhash.Sum64([]byte(key))
+	return h, (h>>17 | h<<47) | 1
+}
+
+// add adds the key to the filter
+func (f *bloomFilter) add(key string) {
+	h1, h2 := bloomLocations(key)
+	nbits := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % nbits
+		f.bits[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// mayContain returns a boolean indicating false if the key is definitely not
+// in the filter, and true if it may be.
+func (f *bloomFilter) mayContain(key string) bool {
+	h1, h2 := bloomLocations(key)
+	nbits := uint64(len(f.bits)) * 8
+	for i := uint64(0); i < uint64(f.hashes); i++ {
+		bit := (h1 + i*h2) % nbits
+		if f.bits[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTo appends the encoded filter to the provided buffer
+func (f *bloomFilter) appendTo(b []byte) []byte {
+	b = appendUint32(b, f.hashes)
+	return append(b, f.bits...)
+}
+
+// decodeBloomFilter decodes a filter encoded using appendTo
+func decodeBloomFilter(b []byte) (*bloomFilter, error) {
+	if len(b) < 5 {
+		return nil, ErrBadTable
+	}
+	f := &bloomFilter{
+		hashes: binary.BigEndian.Uint32(b[0:4]),
+		bits:   b[4:],
+	}
+	if f.hashes == 0 || f.hashes > 32 {
+		return nil, ErrBadTable
+	}
+	return f, nil
+}
@@ -0,0 +1,481 @@
+package lsm
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// CompactionStrategy decides which sstables are merged together, and when
+type CompactionStrategy uint8
+
+const (
+	// NoCompaction never merges the sstables
+	NoCompaction CompactionStrategy = iota
+	// LeveledCompaction keeps the sstables in levels that each hold ten
+	// times as much as the level above them. Apart from level 0, the
+	// tables in a level never overlap, so a read checks at most one table
+	// per level. Tables are merged into the next level as soon as a level
+	// grows too large.
+	LeveledCompaction
+	// SizeTieredCompaction merges tables of a similar size together once
+	// there are enough of them. It writes less than leveled compaction,
+	// but reads have to check more tables.
+	SizeTieredCompaction
+)
+
+const (
+	defaultL0Tables    = 4
+	defaultLevelSize   = 10 << 20
+	defaultTableSize   = 2 << 20
+	defaultTierTables  = 4
+	levelSizeGrowth    = 10       // each level holds this many times more than the one above it
+	maxTierTables      = 32       // the most tables merged by a size-tiered compaction
+	throttleChunkBytes = 64 << 10 // how many bytes are merged between throttle checks
+)
+
+// errCompactionStopped is returned when a compaction is interrupted because
+// the tree is being closed. It never makes it out to the caller.
+var errCompactionStopped = errors.New("lsm: compaction stopped")
+
+type CompactionOptions struct {
+	Strategy    CompactionStrategy // compaction strategy, compaction is off by default
+	L0Tables    int                // number of level 0 tables that triggers a leveled compaction
+	LevelSize   int64              // max bytes held by level 1 before it is compacted (leveled)
+	TableSize   int64              // size at which the output of a leveled compaction is split up
+	TierTables  int                // number of similarly sized tables that are merged (size-tiered)
+	BytesPerSec int64              // rate at which entries are compacted, zero means unlimited
+}
+
+func checkCompactionOptions(opts *CompactionOptions) {
+	if opts.L0Tables < 2 {
+		opts.L0Tables = defaultL0Tables
+	}
+	if opts.LevelSize < 1 {
+		opts.LevelSize = defaultLevelSize
+	}
+	if opts.TableSize < 1 {
+		opts.TableSize = defaultTableSize
+	}
+	if opts.TierTables < 2 {
+		opts.TierTables = defaultTierTables
+	}
+	if opts.TierTables > maxTierTables {
+		opts.TierTables = maxTierTables
+	}
+}
+
+// compactor runs the compactions in the background
+type compactor struct {
+	mu   sync.Mutex    // mu makes sure only one compaction runs at a time
+	wake chan struct{} // wake signals there may be something to compact
+	stop chan struct{} // stop is closed when the tree is closed
+	done chan struct{} // done is closed once the compactor has stopped
+	err  error         // err is the error that stopped the compactor
+}
+
+// compaction is a set of tables to merge together, and where to put the result
+type compaction struct {
+	inputs []*tableFile // inputs are the tables to merge, oldest first
+	level  int          // level is the level the merged tables go in
+	split  int64        // split is the size at which the output is split up, zero for never
+	move   bool         // move is set if the single input can simply change level
+	drop   bool         // drop is set if the tombstones can be thrown away
+}
+
+// startCompactor starts the background compaction, if there is a compaction
+// strategy configured.
+func (t *LSMTree) startCompactor() {
+	if t.conf.Compaction.Strategy == NoCompaction {
+		return
+	}
+	t.compact.wake = make(chan struct{}, 1)
+	t.compact.stop = make(chan struct{})
+	t.compact.done = make(chan struct{})
+	go t.runCompactor(t.compact.wake, t.compact.stop, t.compact.done)
+	// the tables that were just loaded may need compacting
+	t.wakeCompactor()
+}
+
+// wakeCompactor lets the background compaction know there may be something to
+// compact. It never blocks.
+func (t *LSMTree) wakeCompactor() {
+	select {
+	case t.compact.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stopCompactor stops the background compaction, interrupting any compaction
+// that is running, and returns the error that stopped it early (if any).
+func (t *LSMTree) stopCompactor() error {
+	if t.compact.stop == nil {
+		return nil
+	}
+	close(t.compact.stop)
+	<-t.compact.done
+	t.compact.stop = nil
+	return t.compact.err
+}
+
+// runCompactor compacts the tables every time it is woken up, until the tree
+// is closed or a compaction fails.
+func (t *LSMTree) runCompactor(wake, stop, done chan struct{}) {
+	defer close(done)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-wake:
+		}
+		err := t.compactAll(stop)
+		if err != nil {
+			if err != errCompactionStopped {
+				t.compact.err = err
+			}
+			return
+		}
+	}
+}
+
+// Compact runs the configured compaction strategy until there is nothing left
+// to compact. It does nothing if the compaction strategy is NoCompaction.
+func (t *LSMTree) Compact() error {
+	return t.compactAll(nil)
+}
+
+// compactAll runs compactions until there is nothing left to compact, or until
+// the stop channel is closed.
+func (t *LSMTree) compactAll(stop <-chan struct{}) error {
+	for {
+		done, err := t.compactOnce(stop)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// compactOnce picks and runs a single compaction. It returns true if there was
+// nothing to compact.
+func (t *LSMTree) compactOnce(stop <-chan struct{}) (bool, error) {
+	t.compact.mu.Lock()
+	defer t.compact.mu.Unlock()
+	// only compactions change the tables that are already there, so
+	// the plan stays good while the lock is not held
+	t.lock.RLock()
+	c := t.pickCompaction()
+	t.lock.RUnlock()
+	if c == nil {
+		return true, nil
+	}
+	return false, t.runCompaction(c, stop)
+}
+
+// pickCompaction returns the next compaction to run, or nil if there is nothing
+// to compact. The caller must hold the lock.
+func (t *LSMTree) pickCompaction() *compaction {
+	var c *compaction
+	switch t.conf.Compaction.Strategy {
+	case LeveledCompaction:
+		c = t.pickLeveled()
+	case SizeTieredCompaction:
+		c = t.pickSizeTiered()
+	}
+	if c != nil {
+		c.drop = t.canDropTombstones(c.inputs)
+	}
+	return c
+}
+
+// pickLeveled picks a leveled compaction. Level 0 is merged into level 1 once
+// it holds too many tables, and any other level is merged into the next one
+// (one table at a time) once it holds too many bytes.
+func (t *LSMTree) pickLeveled() *compaction {
+	opts := &t.conf.Compaction
+	var levels [][]*tableFile
+	for _, tf := range t.tables {
+		for len(levels) <= tf.level {
+			levels = append(levels, nil)
+		}
+		levels[tf.level] = append(levels[tf.level], tf)
+	}
+	if len(levels) == 0 {
+		return nil
+	}
+	if len(levels[0]) >= opts.L0Tables {
+		// the level 0 tables overlap, so all of them have to go
+		return t.levelCompaction(levels[0], 1)
+	}
+	limit := opts.LevelSize
+	for level := 1; level < len(levels); level++ {
+		var size int64
+		for _, tf := range levels[level] {
+			size += tf.Size()
+		}
+		if size > limit {
+			// the tables in a level are sorted oldest first
+			return t.levelCompaction(levels[level][:1], level+1)
+		}
+		limit *= levelSizeGrowth
+	}
+	return nil
+}
+
+// levelCompaction returns a compaction merging the provided tables along with
+// all the tables they overlap in the level below them.
+func (t *LSMTree) levelCompaction(tables []*tableFile, level int) *compaction {
+	first, last := tables[0].Bounds()
+	for _, tf := range tables[1:] {
+		f, l := tf.Bounds()
+		if f < first {
+			first = f
+		}
+		if l > last {
+			last = l
+		}
+	}
+	c := &compaction{level: level, split: t.conf.Compaction.TableSize}
+	for _, tf := range t.tables {
+		if tf.level == level && tf.overlaps(first, last) {
+			c.inputs = append(c.inputs, tf)
+		}
+	}
+	c.move = len(tables) == 1 && len(c.inputs) == 0
+	// the deeper level holds the older data
+	c.inputs = append(c.inputs, tables...)
+	return c
+}
+
+// pickSizeTiered picks a size-tiered compaction. It looks for a run of level 0
+// tables, next to each other in age, that are all about the same size.
+func (t *LSMTree) pickSizeTiered() *compaction {
+	opts := &t.conf.Compaction
+	var tables []*tableFile
+	for _, tf := range t.tables {
+		if tf.level == 0 {
+			tables = append(tables, tf)
+		}
+	}
+	for i := 0; i+opts.TierTables <= len(tables); i++ {
+		total := tables[i].Size()
+		j := i + 1
+		for ; j < len(tables) && j-i < maxTierTables; j++ {
+			avg, size := total/int64(j-i), tables[j].Size()
+			if size > 2*avg || 2*size < avg {
+				break
+			}
+			total += size
+		}
+		if j-i >= opts.TierTables {
+			return &compaction{inputs: tables[i:j]}
+		}
+	}
+	return nil
+}
+
+// canDropTombstones returns a boolean indicating true if the tombstones in the
+// provided tables do not hide anything outside of them, which is the case when
+// none of the older tables overlap them. The caller must hold the lock.
+func (t *LSMTree) canDropTombstones(inputs []*tableFile) bool {
+	first, last := inputs[0].Bounds()
+	isInput := make(map[*tableFile]bool, len(inputs))
+	for _, tf := range inputs {
+		isInput[tf] = true
+		f, l := tf.Bounds()
+		if f < first {
+			first = f
+		}
+		if l > last {
+			last = l
+		}
+	}
+	for _, tf := range t.tables {
+		if len(isInput) == 0 {
+			break // the rest are all newer
+		}
+		if isInput[tf] {
+			delete(isInput, tf)
+			continue
+		}
+		if tf.overlaps(first, last) {
+			return false
+		}
+	}
+	return true
+}
+
+// runCompaction merges the input tables into new tables, and swaps them in.
+func (t *LSMTree) runCompaction(c *compaction, stop <-chan struct{}) error {
+	if c.move {
+		// lock
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		tf, level := c.inputs[0], c.inputs[0].level
+		tf.level = c.level
+		t.sortTables()
+		err := t.writeManifest()
+		if err != nil {
+			tf.level = level
+			t.sortTables()
+		}
+		return err
+	}
+	var seq int64
+	for _, tf := range c.inputs {
+		if tf.seq > seq {
+			seq = tf.seq
+		}
+	}
+	var outputs []*tableFile
+	var batch []Entry
+	var size int64
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		tf, err := t.writeTable(t.newTableID(), batch)
+		if err != nil {
+			return err
+		}
+		tf.seq, tf.level = seq, c.level
+		outputs = append(outputs, tf)
+		batch, size = nil, 0
+		return nil
+	}
+	th := &throttle{rate: t.conf.Compaction.BytesPerSec, stop: stop, start: time.Now()}
+	err := mergeTables(
+		c.inputs, func(e *Entry) error {
+			n := int64(8 + len(e.Key) + len(e.Val))
+			err := th.add(n)
+			if err != nil {
+				return err
+			}
+			if e.Tombstone && c.drop {
+				return nil
+			}
+			batch = append(batch, *e)
+			size += n
+			if c.split > 0 && size >= c.split {
+				return flush()
+			}
+			return nil
+		},
+	)
+	if err == nil {
+		err = flush()
+	}
+	if err == nil {
+		err = t.swapTables(c.inputs, outputs)
+	}
+	if err != nil {
+		for _, tf := range outputs {
+			_ = removeTable(tf)
+		}
+		return err
+	}
+	// the inputs are removed once nobody is reading them
+	for _, tf := range c.inputs {
+		if rerr := tf.unref(); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// newTableID hands out the next table id
+func (t *LSMTree) newTableID() int64 {
+	// lock
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.nextID++
+	return t.nextID
+}
+
+// swapTables replaces the inputs of a compaction with its outputs, and writes
+// the new set of tables to the manifest.
+func (t *LSMTree) swapTables(inputs, outputs []*tableFile) error {
+	// lock
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	isInput := make(map[*tableFile]bool, len(inputs))
+	for _, tf := range inputs {
+		isInput[tf] = true
+	}
+	tables := make([]*tableFile, 0, len(t.tables)-len(inputs)+len(outputs))
+	for _, tf := range t.tables {
+		if !isInput[tf] {
+			tables = append(tables, tf)
+		}
+	}
+	old := t.tables
+	t.tables = append(tables, outputs...)
+	t.sortTables()
+	err := t.writeManifest()
+	if err != nil {
+		t.tables = old
+		return err
+	}
+	for _, tf := range inputs {
+		tf.obsolete = true
+	}
+	return nil
+}
+
+// mergeTables does a k-way merge of the provided tables, which must be ordered
+// oldest first, calling fn with the newest version of every key in key order.
+// Any older versions of a key are skipped.
+func mergeTables(tables []*tableFile, fn func(e *Entry) error) error {
+	iters := make([]Iterator, len(tables))
+	for i, tf := range tables {
+		iters[i] = tf.Iter()
+	}
+	it := newMergeIter(iters, false)
+	defer it.Close()
+	for ok := it.First(); ok; ok = it.Next() {
+		err := fn(it.Entry())
+		if err != nil {
+			return err
+		}
+	}
+	return it.Err()
+}
+
+// throttle limits the rate at which a compaction works through the entries
+type throttle struct {
+	rate    int64           // rate is the number of bytes per second, zero for unlimited
+	stop    <-chan struct{} // stop interrupts the compaction
+	start   time.Time       // start is when the compaction started
+	total   int64           // total is the number of bytes so far
+	pending int64           // pending is the number of bytes since the last check
+}
+
+// add counts n more bytes, and sleeps if the compaction is ahead of the rate.
+// It returns errCompactionStopped if the compaction has to stop.
+func (th *throttle) add(n int64) error {
+	th.total += n
+	th.pending += n
+	if th.pending < throttleChunkBytes {
+		return nil
+	}
+	th.pending = 0
+	if th.rate <= 0 {
+		select {
+		case <-th.stop:
+			return errCompactionStopped
+		default:
+			return nil
+		}
+	}
+	wait := time.Duration(float64(th.total)/float64(th.rate)*float64(time.Second)) - time.Since(th.start)
+	if wait <= 0 {
+		wait = 0
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-th.stop:
+		return errCompactionStopped
+	case <-timer.C:
+		return nil
+	}
+}
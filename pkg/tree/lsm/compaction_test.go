@@ -0,0 +1,178 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestLSMTree_Compaction(t *testing.T) {
+	for _, strategy := range []CompactionStrategy{LeveledCompaction, SizeTieredCompaction} {
+		conf := &LSMConfig{
+			BasePath:     "lsm_compaction_test",
+			MemtableSize: 100,
+			Compaction: CompactionOptions{
+				Strategy:  strategy,
+				LevelSize: 8 << 10,
+				TableSize: 2 << 10,
+			},
+		}
+		os.RemoveAll(conf.BasePath)
+
+		tree, err := OpenLSMTree(conf)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		want := make(map[string]string)
+
+		// Write the keys a few times over, deleting some of them along
+		// the way, so there are plenty of shadowed versions.
+		for round := 0; round < 4; round++ {
+			for i := 0; i < 1000; i++ {
+				key := fmt.Sprintf("key-%04d", (i*7)%1000)
+				if (i+round)%5 == 0 {
+					err = tree.Del(key)
+					delete(want, key)
+				} else {
+					val := fmt.Sprintf("val-%04d-%d", i, round)
+					err = tree.Put(key, val)
+					want[key] = val
+				}
+				if err != nil {
+					t.Fatalf("write %q: %v", key, err)
+				}
+			}
+		}
+		err = tree.Compact()
+		if err != nil {
+			t.Fatalf("compact: %v", err)
+		}
+		if len(tree.tables) >= 40 {
+			t.Errorf("strategy %d: expected fewer tables than flushes, got %d", strategy, len(tree.tables))
+		}
+		checkTree(t, tree, want, 1000)
+		if strategy == LeveledCompaction {
+			checkLevels(t, tree)
+		}
+
+		// Close and reopen, the tables should come back from the manifest.
+		tables := len(tree.tables)
+		err = tree.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		tree, err = OpenLSMTree(conf)
+		if err != nil {
+			t.Fatalf("reopen: %v", err)
+		}
+		if len(tree.tables) != tables {
+			t.Errorf("tables: got %d, want %d", len(tree.tables), tables)
+		}
+		checkTree(t, tree, want, 1000)
+		err = tree.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		os.RemoveAll(conf.BasePath)
+	}
+}
+
+func TestLSMTree_CompactionTombstones(t *testing.T) {
+	conf := &LSMConfig{
+		BasePath:     "lsm_compaction_test",
+		MemtableSize: 100,
+		Compaction: CompactionOptions{
+			Strategy: LeveledCompaction,
+			L0Tables: 4,
+		},
+	}
+	os.RemoveAll(conf.BasePath)
+	defer os.RemoveAll(conf.BasePath)
+
+	tree, err := OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	// Fill level 1, and then delete everything in it. Nothing is older
+	// than level 1, so the tombstones go away along with the values.
+	for i := 0; i < 400; i++ {
+		err = tree.Put(fmt.Sprintf("key-%04d", i), "val")
+		if err != nil {
+			t.Fatalf("put: %v", err)
+		}
+	}
+	err = tree.Compact()
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if len(tree.tables) != 1 || tree.tables[0].level != 1 || tree.tables[0].Len() != 400 {
+		t.Fatalf("expected a single level 1 table holding everything")
+	}
+	for i := 0; i < 400; i++ {
+		err = tree.Del(fmt.Sprintf("key-%04d", i))
+		if err != nil {
+			t.Fatalf("del: %v", err)
+		}
+	}
+	err = tree.Compact()
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if len(tree.tables) != 0 {
+		t.Errorf("tables: got %d, want %d", len(tree.tables), 0)
+	}
+	checkTree(t, tree, nil, 400)
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A table that never made it into the manifest is thrown away.
+	err = os.MkdirAll(conf.BasePath+"/"+sstPrefix+"9999999999", 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	tree, err = OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if _, err = os.Stat(conf.BasePath + "/" + sstPrefix + "9999999999"); !os.IsNotExist(err) {
+		t.Errorf("expected the orphaned table to be removed, got %v", err)
+	}
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+// checkTree makes sure every key holds the value we expect, and that the
+// deleted keys are nowhere to be found
+func checkTree(t *testing.T, tree *LSMTree, want map[string]string, count int) {
+	t.Helper()
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key-%04d", i)
+		val, found, err := tree.Get(key)
+		if err != nil {
+			t.Fatalf("get %q: %v", key, err)
+		}
+		wval, wfound := want[key]
+		if found != wfound || val != wval {
+			t.Fatalf("get %q: got (%q, %v), want (%q, %v)", key, val, found, wval, wfound)
+		}
+	}
+}
+
+// checkLevels makes sure none of the tables below level 0 overlap the other
+// tables in their level
+func checkLevels(t *testing.T, tree *LSMTree) {
+	t.Helper()
+	tree.lock.RLock()
+	defer tree.lock.RUnlock()
+	for i, a := range tree.tables {
+		for _, b := range tree.tables[i+1:] {
+			if a.level > 0 && a.level == b.level && a.overlaps(b.Bounds()) {
+				t.Errorf("level %d: tables %d and %d overlap", a.level, a.id, b.id)
+			}
+		}
+	}
+}
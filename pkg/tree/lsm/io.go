@@ -21,7 +21,7 @@ func initBasePath(base string) (string, error) {
 	// sanitize any path separators
 	path = filepath.ToSlash(path)
 	// create any directories if they are not there
-	err = os.MkdirAll(path, os.ModeDir)
+	err = os.MkdirAll(path, os.ModeDir|0755)
 	if err != nil {
 		return "", err
 	}
@@ -0,0 +1,332 @@
+package lsm
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Iterator iterates entries in key order, forwards or backwards. A new iterator
+// is not positioned on any entry, so one of First, Last, Seek or SeekLT has to
+// be called before Entry, Next or Prev. All the methods that move the iterator
+// return a boolean indicating true if the iterator ended up on an entry. Once
+// the iterator moves past either end, or runs into an error, it has to be
+// positioned again before it can be used.
+type Iterator interface {
+	// First moves to the first entry.
+	First() bool
+	// Last moves to the last entry.
+	Last() bool
+	// Seek moves to the first entry with a key greater than or equal to
+	// the provided key.
+	Seek(key string) bool
+	// SeekLT moves to the last entry with a key less than the provided key.
+	SeekLT(key string) bool
+	// Next moves to the next entry.
+	Next() bool
+	// Prev moves to the previous entry.
+	Prev() bool
+	// Entry returns the current entry. It is only good until the iterator
+	// is moved again.
+	Entry() *Entry
+	// Err returns the error the iterator ran into, if any.
+	Err() error
+	// Close releases anything held by the iterator.
+	Close() error
+}
+
+// sliceIter iterates a slice of entries sorted by key
+type sliceIter struct {
+	entries []Entry
+	i       int // i is the position of the current entry
+}
+
+func newSliceIter(entries []Entry) *sliceIter {
+	return &sliceIter{entries: entries, i: -1}
+}
+
+func (it *sliceIter) valid() bool {
+	return it.i >= 0 && it.i < len(it.entries)
+}
+
+func (it *sliceIter) First() bool {
+	it.i = 0
+	return it.valid()
+}
+
+func (it *sliceIter) Last() bool {
+	it.i = len(it.entries) - 1
+	return it.valid()
+}
+
+func (it *sliceIter) Seek(key string) bool {
+	it.i = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].Key >= key })
+	return it.valid()
+}
+
+func (it *sliceIter) SeekLT(key string) bool {
+	it.i = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].Key >= key }) - 1
+	return it.valid()
+}
+
+func (it *sliceIter) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	it.i++
+	return it.valid()
+}
+
+func (it *sliceIter) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	it.i--
+	return it.valid()
+}
+
+func (it *sliceIter) Entry() *Entry {
+	return &it.entries[it.i]
+}
+
+func (it *sliceIter) Err() error   { return nil }
+func (it *sliceIter) Close() error { return nil }
+
+// mergeSource is one of the iterators being merged
+type mergeSource struct {
+	it   Iterator
+	rank int // rank is the age of the source, newer sources rank higher
+}
+
+// mergeHeap orders the sources by their current key (smallest first, or
+// largest first when reversed), and then by their rank so the newest version
+// of a key comes first.
+type mergeHeap struct {
+	sources []*mergeSource
+	reverse bool
+}
+
+func (h *mergeHeap) Len() int { return len(h.sources) }
+func (h *mergeHeap) Less(i, j int) bool {
+	a, b := h.sources[i].it.Entry().Key, h.sources[j].it.Entry().Key
+	if a != b {
+		return (a < b) != h.reverse
+	}
+	return h.sources[i].rank > h.sources[j].rank
+}
+func (h *mergeHeap) Swap(i, j int)      { h.sources[i], h.sources[j] = h.sources[j], h.sources[i] }
+func (h *mergeHeap) Push(x interface{}) { h.sources = append(h.sources, x.(*mergeSource)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := h.sources
+	x := old[len(old)-1]
+	h.sources = old[:len(old)-1]
+	return x
+}
+
+// mergeIter merges iterators over overlapping sets of entries. When more than
+// one of them holds a key, only the newest version of the key is returned. If
+// tombstones are hidden, the keys that were deleted are skipped altogether.
+type mergeIter struct {
+	sources []*mergeSource
+	heap    mergeHeap // heap holds the sources that are positioned on an entry
+	hide    bool      // hide is set if the tombstones are skipped
+	err     error
+}
+
+// newMergeIter returns an iterator merging the provided iterators, which must
+// be ordered from the oldest to the newest.
+func newMergeIter(iters []Iterator, hideTombstones bool) *mergeIter {
+	m := &mergeIter{hide: hideTombstones}
+	for i, it := range iters {
+		m.sources = append(m.sources, &mergeSource{it: it, rank: i})
+	}
+	return m
+}
+
+// position positions every source using the provided function, and builds the
+// heap out of the ones that end up on an entry.
+func (m *mergeIter) position(reverse bool, fn func(it Iterator) bool) bool {
+	m.heap = mergeHeap{sources: m.heap.sources[:0], reverse: reverse}
+	m.err = nil
+	for _, src := range m.sources {
+		if fn(src.it) {
+			m.heap.sources = append(m.heap.sources, src)
+		} else if err := src.it.Err(); err != nil {
+			m.err = err
+			return false
+		}
+	}
+	heap.Init(&m.heap)
+	return m.skipTombstones()
+}
+
+// advance moves every source that is on the current key past it, in the
+// direction of the heap.
+func (m *mergeIter) advance() bool {
+	key := m.Entry().Key
+	for m.heap.Len() > 0 && m.heap.sources[0].it.Entry().Key == key {
+		src := m.heap.sources[0]
+		var ok bool
+		if m.heap.reverse {
+			ok = src.it.Prev()
+		} else {
+			ok = src.it.Next()
+		}
+		if ok {
+			heap.Fix(&m.heap, 0)
+			continue
+		}
+		if err := src.it.Err(); err != nil {
+			m.err = err
+			return false
+		}
+		heap.Pop(&m.heap)
+	}
+	return m.heap.Len() > 0
+}
+
+// skipTombstones skips any deleted keys, if tombstones are hidden
+func (m *mergeIter) skipTombstones() bool {
+	for m.heap.Len() > 0 {
+		if !m.hide || !m.Entry().Tombstone {
+			return true
+		}
+		if !m.advance() {
+			return false
+		}
+	}
+	return false
+}
+
+func (m *mergeIter) valid() bool {
+	return m.err == nil && m.heap.Len() > 0
+}
+
+func (m *mergeIter) First() bool {
+	return m.position(false, func(it Iterator) bool { return it.First() })
+}
+
+func (m *mergeIter) Last() bool {
+	return m.position(true, func(it Iterator) bool { return it.Last() })
+}
+
+func (m *mergeIter) Seek(key string) bool {
+	return m.position(false, func(it Iterator) bool { return it.Seek(key) })
+}
+
+func (m *mergeIter) SeekLT(key string) bool {
+	return m.position(true, func(it Iterator) bool { return it.SeekLT(key) })
+}
+
+func (m *mergeIter) Next() bool {
+	if !m.valid() {
+		return false
+	}
+	if m.heap.reverse {
+		// changing direction, so every source has to be moved to
+		// just past the current key
+		key := m.Entry().Key
+		return m.position(
+			false, func(it Iterator) bool {
+				ok := it.Seek(key)
+				for ok && it.Entry().Key == key {
+					ok = it.Next()
+				}
+				return ok
+			},
+		)
+	}
+	return m.advance() && m.skipTombstones()
+}
+
+func (m *mergeIter) Prev() bool {
+	if !m.valid() {
+		return false
+	}
+	if !m.heap.reverse {
+		// changing direction, so every source has to be moved to
+		// just before the current key
+		key := m.Entry().Key
+		return m.position(true, func(it Iterator) bool { return it.SeekLT(key) })
+	}
+	return m.advance() && m.skipTombstones()
+}
+
+func (m *mergeIter) Entry() *Entry {
+	return m.heap.sources[0].it.Entry()
+}
+
+func (m *mergeIter) Err() error {
+	return m.err
+}
+
+// Close closes all the merged iterators
+func (m *mergeIter) Close() error {
+	var err error
+	for _, src := range m.sources {
+		if cerr := src.it.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// rangeIter limits an iterator to the keys from lo (inclusive) to hi
+// (exclusive). An empty hi means there is no upper limit.
+type rangeIter struct {
+	it     Iterator
+	lo, hi string
+}
+
+// check returns a boolean indicating true if the iterator is positioned on an
+// entry that is within range.
+func (r *rangeIter) check(ok bool) bool {
+	if !ok {
+		return false
+	}
+	key := r.it.Entry().Key
+	return key >= r.lo && (r.hi == "" || key < r.hi)
+}
+
+func (r *rangeIter) First() bool {
+	return r.check(r.it.Seek(r.lo))
+}
+
+func (r *rangeIter) Last() bool {
+	if r.hi == "" {
+		return r.check(r.it.Last())
+	}
+	return r.check(r.it.SeekLT(r.hi))
+}
+
+func (r *rangeIter) Seek(key string) bool {
+	if key < r.lo {
+		key = r.lo
+	}
+	return r.check(r.it.Seek(key))
+}
+
+func (r *rangeIter) SeekLT(key string) bool {
+	if r.hi != "" && key > r.hi {
+		key = r.hi
+	}
+	return r.check(r.it.SeekLT(key))
+}
+
+func (r *rangeIter) Next() bool    { return r.check(r.it.Next()) }
+func (r *rangeIter) Prev() bool    { return r.check(r.it.Prev()) }
+func (r *rangeIter) Entry() *Entry { return r.it.Entry() }
+func (r *rangeIter) Err() error    { return r.it.Err() }
+func (r *rangeIter) Close() error  { return r.it.Close() }
+
+// prefixEnd returns the smallest key that is greater than every key starting
+// with the prefix, or an empty string if there is no such key.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,251 @@
+package lsm
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// checkIter makes sure the iterator moves through the entries the same way a
+// plain slice of the wanted entries does, in both directions, and when seeking
+// to keys that are there and keys that are not.
+func checkIter(t *testing.T, it Iterator, want []Entry) {
+	t.Helper()
+	model := newSliceIter(want)
+	// same makes sure both iterators are in the same spot
+	same := func(op string, got, ok bool) {
+		t.Helper()
+		if err := it.Err(); err != nil {
+			t.Fatalf("%s: %v", op, err)
+		}
+		if got != ok {
+			t.Fatalf("%s: got %v, want %v", op, got, ok)
+		}
+		if ok && *it.Entry() != *model.Entry() {
+			t.Fatalf("%s: got %+v, want %+v", op, *it.Entry(), *model.Entry())
+		}
+	}
+	n := 0
+	for ok := it.First(); ok; ok = it.Next() {
+		n++
+	}
+	if n != len(want) {
+		t.Fatalf("forward: got %d entries, want %d", n, len(want))
+	}
+	same("first", it.First(), model.First())
+	for i := 0; i < len(want); i++ {
+		same("next", it.Next(), model.Next())
+		if i%7 == 3 {
+			// turn around for a bit
+			same("prev", it.Prev(), model.Prev())
+			same("next", it.Next(), model.Next())
+		}
+	}
+	same("last", it.Last(), model.Last())
+	for i := 0; i < len(want); i++ {
+		same("prev", it.Prev(), model.Prev())
+	}
+	var keys []string
+	for _, e := range want {
+		keys = append(keys, e.Key, e.Key+"0", e.Key[:len(e.Key)-1])
+	}
+	keys = append(keys, "", "~")
+	for _, key := range keys {
+		same("seek "+key, it.Seek(key), model.Seek(key))
+		if model.valid() {
+			same("seek and prev", it.Prev(), model.Prev())
+		}
+		same("seek-lt "+key, it.SeekLT(key), model.SeekLT(key))
+		if model.valid() {
+			same("seek-lt and next", it.Next(), model.Next())
+		}
+	}
+}
+
+func TestSSTable_Iter(t *testing.T) {
+	path := "sstable_iter_test"
+	os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	var entries []Entry
+	for i := 0; i < 2000; i++ {
+		e := Entry{Key: fmt.Sprintf("series-%02d/%06d", i%7, i*13)}
+		if i%9 == 0 {
+			e.Tombstone = true
+		} else {
+			e.Val = fmt.Sprintf("point %d", i)
+		}
+		entries = append(entries, e)
+	}
+	sort.Sort(Entries(entries))
+
+	// both table formats
+	sst, err := OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	err = sst.WriteBatch(entries)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	checkIter(t, sst.Iter(), entries)
+	_ = sst.Close()
+	os.RemoveAll(path)
+	writeOldTable(t, path, entries)
+	sst, err = OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	checkIter(t, sst.Iter(), entries)
+	_ = sst.Close()
+
+	// and the memtable
+	mem := NewMemtable(len(entries))
+	for _, e := range entries {
+		mem.put(e)
+	}
+	checkIter(t, mem.Iter(), entries)
+}
+
+func TestMergeIter(t *testing.T) {
+	// Three sources, oldest first, that overwrite and delete each other.
+	rnd := rand.New(rand.NewSource(1))
+	want := make(map[string]Entry)
+	var iters []Iterator
+	for src := 0; src < 3; src++ {
+		byKey := make(map[string]Entry)
+		for i := 0; i < 300; i++ {
+			e := Entry{Key: fmt.Sprintf("key-%04d", rnd.Intn(500))}
+			if rnd.Intn(4) == 0 {
+				e.Tombstone = true
+			} else {
+				e.Val = fmt.Sprintf("val-%d-%d", src, i)
+			}
+			byKey[e.Key] = e
+		}
+		var entries []Entry
+		for _, e := range byKey {
+			entries = append(entries, e)
+			want[e.Key] = e
+		}
+		sort.Sort(Entries(entries))
+		iters = append(iters, newSliceIter(entries))
+	}
+	var live, all []Entry
+	for _, e := range want {
+		all = append(all, e)
+		if !e.Tombstone {
+			live = append(live, e)
+		}
+	}
+	sort.Sort(Entries(live))
+	sort.Sort(Entries(all))
+	checkIter(t, newMergeIter(iters, true), live)
+	checkIter(t, newMergeIter(iters, false), all)
+}
+
+func TestLSMTree_Scan(t *testing.T) {
+	conf := &LSMConfig{
+		BasePath:     "lsm_scan_test",
+		MemtableSize: 100,
+		Compaction: CompactionOptions{
+			Strategy: LeveledCompaction,
+		},
+	}
+	os.RemoveAll(conf.BasePath)
+	defer os.RemoveAll(conf.BasePath)
+	tree, err := OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+
+	// Time series keys, written a few times over and with some of them
+	// deleted, so the newest version is spread all over the tables.
+	rnd := rand.New(rand.NewSource(1))
+	want := make(map[string]string)
+	for i := 0; i < 3000; i++ {
+		key := fmt.Sprintf("cpu/host-%d/%05d", rnd.Intn(4), rnd.Intn(1000))
+		if rnd.Intn(5) == 0 {
+			err = tree.Del(key)
+			delete(want, key)
+		} else {
+			val := fmt.Sprintf("%d", i)
+			err = tree.Put(key, val)
+			want[key] = val
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+	}
+	// expect returns the entries that should be found in the range
+	expect := func(lo, hi string) []Entry {
+		var entries []Entry
+		for k, v := range want {
+			if k >= lo && (hi == "" || k < hi) {
+				entries = append(entries, Entry{Key: k, Val: v})
+			}
+		}
+		sort.Sort(Entries(entries))
+		return entries
+	}
+
+	ranges := [][2]string{
+		{"", ""},
+		{"cpu/host-1/", "cpu/host-2/"},
+		{"cpu/host-2/00100", "cpu/host-2/00200"},
+		{"cpu/host-3/00500", ""},
+		{"cpu/host-0/00300", "cpu/host-0/00300"},
+		{"mem/", ""},
+	}
+	for _, r := range ranges {
+		it := tree.Scan(r[0], r[1])
+		checkIter(t, it, expect(r[0], r[1]))
+		err = it.Close()
+		if err != nil {
+			t.Fatalf("close: %v", err)
+		}
+	}
+	it := tree.Prefix("cpu/host-2/")
+	checkIter(t, it, expect("cpu/host-2/", "cpu/host-20"))
+	_ = it.Close()
+
+	// An iterator keeps seeing the tree as it was, even if the tables
+	// it reads get compacted away.
+	it = tree.Scan("", "")
+	snapshot := expect("", "")
+	for i := 0; i < 1000; i++ {
+		err = tree.Del(fmt.Sprintf("cpu/host-%d/%05d", i%4, i))
+		if err != nil {
+			t.Fatalf("del: %v", err)
+		}
+	}
+	err = tree.Compact()
+	if err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	checkIter(t, it, snapshot)
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	// the tables are only let go of once the iterator is closed
+	checkIter(t, it, snapshot)
+	err = it.Close()
+	if err != nil {
+		t.Fatalf("close iterator: %v", err)
+	}
+	m, err := readManifest(tree.base)
+	if err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	dirs, err := filepath.Glob(filepath.Join(tree.base, sstPrefix+"*"))
+	if err != nil {
+		t.Fatalf("glob: %v", err)
+	}
+	if len(dirs) != len(m.tables) {
+		t.Errorf("expected the compacted tables to be removed, got %d tables and %d in the manifest", len(dirs), len(m.tables))
+	}
+}
@@ -0,0 +1,135 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+const (
+	manifestName    = "MANIFEST"
+	manifestMagic   = 0x4c534d4d // "LSMM"
+	manifestVersion = 1
+)
+
+var ErrBadManifest = errors.New("lsm: manifest is corrupt")
+
+// The manifest lists the sstables that make up the tree. It is replaced as a
+// whole every time the set of tables changes (by writing a new one and renaming
+// it over the old one) so the set of tables can be swapped out atomically. Any
+// table that is not listed in the manifest is left over from a flush or a
+// compaction that never finished, and is removed when the tree is opened.
+//
+//	[Magic][Version][Count][NextID] [ID][Seq][Level] ... [CRC]
+//	 uint32  uint32  uint32  uint64  uint64 uint64 uint32  uint32
+const (
+	manifestHeaderSize = 20
+	manifestTableSize  = 20
+)
+
+// manifestTable is the entry of a single table in the manifest
+type manifestTable struct {
+	id    int64 // id is the number the table files are named after
+	seq   int64 // seq orders the tables, tables holding newer data have higher ones
+	level int   // level is the level of the table
+}
+
+// manifest is the decoded contents of the manifest file
+type manifest struct {
+	nextID int64 // nextID is the last id handed out to a table
+	tables []manifestTable
+}
+
+// readManifest reads the manifest in the base path. It returns a nil manifest
+// if there is none.
+func readManifest(base string) (*manifest, error) {
+	b, err := os.ReadFile(filepath.Join(base, manifestName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if len(b) < manifestHeaderSize+4 {
+		return nil, ErrBadManifest
+	}
+	body, sum := b[:len(b)-4], binary.BigEndian.Uint32(b[len(b)-4:])
+	if crc32.ChecksumIEEE(body) != sum ||
+		binary.BigEndian.Uint32(body[0:4]) != manifestMagic {
+		return nil, ErrBadManifest
+	}
+	if binary.BigEndian.Uint32(body[4:8]) != manifestVersion {
+		return nil, ErrBadManifest
+	}
+	count := int(binary.BigEndian.Uint32(body[8:12]))
+	if len(body) != manifestHeaderSize+count*manifestTableSize {
+		return nil, ErrBadManifest
+	}
+	m := &manifest{
+		nextID: int64(binary.BigEndian.Uint64(body[12:20])),
+		tables: make([]manifestTable, count),
+	}
+	for i := range m.tables {
+		p := body[manifestHeaderSize+i*manifestTableSize:]
+		m.tables[i] = manifestTable{
+			id:    int64(binary.BigEndian.Uint64(p[0:8])),
+			seq:   int64(binary.BigEndian.Uint64(p[8:16])),
+			level: int(binary.BigEndian.Uint32(p[16:20])),
+		}
+	}
+	return m, nil
+}
+
+// writeManifest writes the manifest to the base path, replacing the current one.
+func writeManifest(base string, m *manifest) error {
+	b := make([]byte, manifestHeaderSize, manifestHeaderSize+len(m.tables)*manifestTableSize+4)
+	binary.BigEndian.PutUint32(b[0:4], manifestMagic)
+	binary.BigEndian.PutUint32(b[4:8], manifestVersion)
+	binary.BigEndian.PutUint32(b[8:12], uint32(len(m.tables)))
+	binary.BigEndian.PutUint64(b[12:20], uint64(m.nextID))
+	for _, mt := range m.tables {
+		var p [manifestTableSize]byte
+		binary.BigEndian.PutUint64(p[0:8], uint64(mt.id))
+		binary.BigEndian.PutUint64(p[8:16], uint64(mt.seq))
+		binary.BigEndian.PutUint32(p[16:20], uint32(mt.level))
+		b = append(b, p[:]...)
+	}
+	b = appendUint32(b, crc32.ChecksumIEEE(b))
+	// write the new manifest next to the old one, and swap it in
+	tmp := filepath.Join(base, manifestName+".tmp")
+	fp, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = fp.Write(b)
+	if err == nil {
+		err = fp.Sync()
+	}
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(base, manifestName))
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return syncDir(base)
+}
+
+// syncDir syncs the directory at the provided path, so that any files created,
+// renamed or removed within it are durable.
+func syncDir(path string) error {
+	fp, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	err = fp.Sync()
+	if cerr := fp.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -1,22 +1,23 @@
 package lsm
 
 import (
-	"encoding/binary"
-	"io"
-
 	"github.com/cagnosolutions/go-data/pkg/tree/rbt/generic"
 )
 
+// Memtable holds the most recent writes in memory, sorted by key, until there
+// are enough of them to be flushed to an SSTable. Deletes are kept around as
+// tombstones, so they hide the older values in the SSTables. It is not safe for
+// concurrent use.
 type Memtable struct {
-	tree     *generic.RBTree[string, string]
-	keySpace int
-	valSpace int
-	max      int
+	tree *generic.RBTree[string, Entry]
+	size int // size is the number of bytes of keys and values held
+	max  int
 }
 
+// NewMemtable returns a new Memtable that is full once it holds max entries.
 func NewMemtable(max int) *Memtable {
 	return &Memtable{
-		tree: generic.NewTree[string, string](),
+		tree: generic.NewTree[string, Entry](),
 		max:  max,
 	}
 }
@@ -25,68 +26,83 @@ func (m *Memtable) isFull() (full bool) {
 	return m.tree.Len() >= m.max
 }
 
-func (m *Memtable) Flush(w *io.OffsetWriter) (off int64, err error) {
-	// seek to the end of the file
-	// off, err = w.Seek(0, io.SeekEnd)
-	// if err != nil {
-	// 	return off, err
-	// }
-	valOffs := m.keySpace
-	// write the contents of each entry in the table
-	m.tree.Scan(
-		func(key string, val string) bool {
-			// write key index
-			buf := make([]byte, 2)
-			binary.BigEndian.PutUint16(buf, uint16(len(key)))
-			_, err = w.Write(buf)
-			if err != nil {
-				return false
-			}
-			_, err = w.Write([]byte(key))
-			if err != nil {
-				return false
-			}
-			// write value pointer for key
-			binary.BigEndian.PutUint16(buf, uint16(valOffs))
-			_, err = w.Write(buf)
-			if err != nil {
-				return false
-			}
-			// write value
-			binary.BigEndian.PutUint16(buf, uint16(len(val)))
-			_, err = w.WriteAt(buf, int64(valOffs))
-			if err != nil {
-				return false
-			}
-			valOffs += len(buf)
-			_, err = w.WriteAt([]byte(val), int64(valOffs))
-			if err != nil {
-				return false
-			}
-			valOffs += len(val)
+// Flush writes all the entries in the memtable (including the tombstones) to
+// the provided SSTable, which must be empty.
+func (m *Memtable) Flush(sst *SSTable) error {
+	return sst.WriteBatch(m.Entries())
+}
 
+// Entries returns all the entries in the memtable in key order.
+func (m *Memtable) Entries() []Entry {
+	entries := make([]Entry, 0, m.tree.Len())
+	m.tree.Scan(
+		func(key string, e Entry) bool {
+			entries = append(entries, e)
 			return true
 		},
 	)
-	return
+	return entries
 }
 
-func (m *Memtable) Put(key string, val string) bool {
-	if m.isFull() {
+// Iter returns an iterator over the entries in the memtable, including the
+// tombstones. The iterator works on a copy of the entries, so the memtable can
+// be changed while the iterator is in use.
+func (m *Memtable) Iter() Iterator {
+	return newSliceIter(m.Entries())
+}
+
+// rangeIter returns an iterator over a copy of the entries with keys from lo
+// (inclusive) to hi (exclusive), where an empty hi means there is no upper
+// limit.
+func (m *Memtable) rangeIter(lo, hi string) Iterator {
+	var entries []Entry
+	add := func(key string, e Entry) bool {
+		if key >= lo {
+			entries = append(entries, e)
+		}
 		return true
 	}
-	m.keySpace += len(key) + 4
-	m.valSpace += len(val) + 4
-	m.tree.Put(key, val)
-	return false
+	if hi == "" {
+		m.tree.Scan(add)
+	} else if lo < hi {
+		m.tree.ScanRange(lo, hi, add)
+	}
+	return newSliceIter(entries)
+}
+
+// Put adds or replaces the value of the provided key.
+func (m *Memtable) Put(key string, val string) {
+	m.put(Entry{Key: key, Val: val})
+}
+
+// Get returns the entry of the provided key, and a boolean indicating true if
+// the memtable has one. The entry may be a tombstone.
+func (m *Memtable) Get(key string) (e Entry, found bool) {
+	return m.tree.Get(key)
+}
+
+// Del adds a tombstone for the provided key.
+func (m *Memtable) Del(key string) {
+	m.put(Entry{Key: key, Tombstone: true})
+}
+
+func (m *Memtable) put(e Entry) {
+	old, found := m.tree.Get(e.Key)
+	if found {
+		m.size -= len(old.Key) + len(old.Val)
+	}
+	m.size += len(e.Key) + len(e.Val)
+	m.tree.Put(e.Key, e)
 }
 
-func (m *Memtable) Get(key string) (val string, found bool) {
-	return "", false
+// Len returns the number of entries in the memtable, including the tombstones.
+func (m *Memtable) Len() int {
+	return m.tree.Len()
 }
 
-func (m *Memtable) Del(key string) (val string, removed bool) {
-	return "", false
+// Size returns the number of bytes of keys and values held in the memtable.
+func (m *Memtable) Size() int {
+	return m.size
 }
 
 // type Entry struct {
@@ -2,21 +2,77 @@ package lsm
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
 	"sort"
 )
 
+var (
+	ErrTableNotEmpty = errors.New("lsm: sstable is not empty")
+	ErrTableReadOnly = errors.New("lsm: sstable uses the older format, and is read only")
+	ErrBadTable      = errors.New("lsm: sstable is corrupt")
+)
+
+// Tables are written as a single file using the block format (see table.go).
+// Tables written before the block format was added are made up of an index
+// file and a data file, and are still read.
+//
+// The index file starts with a small header holding the number of entries in
+// the table, followed by the offset of every entry in the data file, in key
+// order. The data file holds the entries themselves:
+//
+//	[KeyLen][Key][ValLen][Val]
+//	 uint32   *   uint32   *
+//
+// A deleted key is written using a ValLen of tombstone, with no value at all.
+//
+// The index file ends with a footer, holding a Bloom filter over the keys and a
+// sparse index with the key of every blockEntries-th entry. Both are small
+// enough to keep in memory, so most lookups for keys that are not in the table
+// never touch the disk, and the ones that do only have to search one block of
+// the index. The footer ends with the length of the filter and the sparse index:
+//
+//	[Filter][SparseIndex][FilterLen][SparseLen][Magic]
+//	    *         *        uint32     uint32   uint32
+//
+// where the sparse index is:
+//
+//	[BlockEntries][Count][KeyLen][Key] ...
+//	    uint32    uint32  uint32   *
+//
+// Tables written before the footer was added are searched without the help of
+// the filter.
+const (
+	indexHeaderSize = 8
+	tombstone       = ^uint32(0)
+	blockEntries    = 16
+	footerSize      = 12
+	footerMagic     = 0x4c535346 // "LSSF"
+)
+
 // SSTable represents a Sorted String Table using the WiscKey format
 type SSTable struct {
-	index *os.File
-	data  *os.File
+	table *blockTable // table is set for tables using the block format
+	index *os.File    // index is the index file of a table using the older format
+	data  *os.File    // data is the data file of a table using the older format
+	count uint32      // count is the number of entries in the table
+	size  int64       // size is the number of bytes used by the table files
+	first string      // first is the smallest key in the table
+	last  string      // last is the largest key in the table
+
+	filter *bloomFilter // filter is the Bloom filter over the keys, if there is one
+	sparse []string     // sparse holds the first key of every block of entries (older format)
+	stride uint32       // stride is the number of entries in a block (older format)
 }
 
+// Entry is a key and value pair. A Tombstone entry marks the key as deleted,
+// hiding any older value of the key.
 type Entry struct {
-	Key string
-	Val string
+	Key       string
+	Val       string
+	Tombstone bool
 }
 
 type IndexEntry struct {
@@ -42,20 +98,29 @@ func OpenSSTable(baseDir string) (*SSTable, error) {
 		if err != nil {
 			return nil, err
 		}
-		// create new files
-		indexFile, dataFile, err = createIndexAndDataFiles(baseDir)
+		// create a new table file
+		fp, err := os.Create(filepath.Join(baseDir, tableFileName))
 		if err != nil {
 			return nil, err
 		}
 		//	existed = false
-	} else {
-		// baseDir does exist, so we need to open
-		indexFile, dataFile, err = openIndexAndDataFiles(filepath.ToSlash(baseDir))
+		return openBlockSSTable(fp)
+	}
+	baseDir = filepath.ToSlash(baseDir)
+	if _, err := os.Stat(filepath.Join(baseDir, "index.sst")); os.IsNotExist(err) {
+		// no index file, so the table uses the block format
+		fp, err := os.OpenFile(filepath.Join(baseDir, tableFileName), os.O_RDWR|os.O_CREATE, 0666)
 		if err != nil {
 			return nil, err
 		}
-		//	existed = true
+		return openBlockSSTable(fp)
+	}
+	// baseDir does exist, so we need to open
+	indexFile, dataFile, err := openIndexAndDataFiles(baseDir)
+	if err != nil {
+		return nil, err
 	}
+	//	existed = true
 
 	// // Sort the entries by key to maintain order in the SSTable
 	// sort.Stable(Entries(entries))
@@ -113,82 +178,182 @@ func OpenSSTable(baseDir string) (*SSTable, error) {
 	// 	}
 	// }
 
-	return &SSTable{
+	// read the number of entries, if the table has been written
+	var hdr [indexHeaderSize]byte
+	_, err = indexFile.ReadAt(hdr[:], 0)
+	if err != nil && err != io.EOF {
+		_ = indexFile.Close()
+		_ = dataFile.Close()
+		return nil, err
+	}
+
+	s := &SSTable{
 		index: indexFile,
 		data:  dataFile,
-	}, nil
+		count: binary.BigEndian.Uint32(hdr[0:4]),
+	}
+	err = s.loadBounds()
+	if err == nil {
+		err = s.loadFooter()
+	}
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
 
 }
 
-func (s *SSTable) WriteBatch(entries []Entry) error {
-	// Sort the entries by key to maintain order in the SSTable
-	sort.Stable(Entries(entries))
-
-	var index []uint32
-
-	// Build the data file
-	for _, entry := range entries {
-
-		// Get the current offset
-		offset, err := s.data.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-		// Store the offset in the index for the current key
-		index = append(index, uint32(offset))
-
-		// Write the key length, key, value length and value to the data file
-		// keyLen, valLen := uint32(len(entry.Key)), uint32(len(entry.Val))
+// openBlockSSTable opens a table using the block format
+func openBlockSSTable(fp *os.File) (*SSTable, error) {
+	t, err := openBlockTable(fp)
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	s := &SSTable{table: t}
+	err = s.loadTable()
+	if err != nil {
+		_ = s.Close()
+		return nil, err
+	}
+	return s, nil
+}
 
-		// Write key length
-		err = binary.Write(s.data, binary.BigEndian, uint32(len(entry.Key)))
-		if err != nil {
-			return err
-		}
+// loadTable loads the number of entries, the size, the bounds and the filter
+// of a table using the block format.
+func (s *SSTable) loadTable() error {
+	s.count, s.size, s.filter = s.table.count(), s.table.size, s.table.filter
+	var err error
+	s.first, s.last, err = s.table.bounds()
+	return err
+}
 
-		// Write key
-		_, err = s.data.WriteString(entry.Key)
+// loadBounds reads the size of the table, along with its smallest and largest
+// keys, so they do not have to be looked up every time they are needed.
+func (s *SSTable) loadBounds() error {
+	s.size = 0
+	for _, fp := range []*os.File{s.index, s.data} {
+		fi, err := fp.Stat()
 		if err != nil {
 			return err
 		}
-
-		// Write value length
-		err = binary.Write(s.data, binary.BigEndian, uint32(len(entry.Val)))
-		if err != nil {
-			return err
+		s.size += fi.Size()
+	}
+	if s.count == 0 {
+		return nil
+	}
+	var err error
+	for i, key := range []*string{&s.first, &s.last} {
+		var offset int64
+		offset, err = s.readIndexOffset(uint32(i) * (s.count - 1))
+		if err == nil {
+			*key, err = s.readDataKey(offset)
 		}
-
-		// Write value
-		_, err = s.data.WriteString(entry.Val)
 		if err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	// Write the index to the index file
-	for _, offset := range index {
-		// Write key offsets
-		err := binary.Write(s.index, binary.BigEndian, offset)
-		if err != nil {
-			return err
-		}
+// WriteBatch sorts the entries and writes them to the table. A table can only
+// be written once, so the table must be empty.
+func (s *SSTable) WriteBatch(entries []Entry) error {
+	if s.count > 0 {
+		return ErrTableNotEmpty
+	}
+	if s.table == nil {
+		return ErrTableReadOnly
 	}
 
-	return nil
+	// Sort the entries by key to maintain order in the SSTable
+	sort.Stable(Entries(entries))
+
+	err := s.table.write(entries)
+	if err != nil {
+		return err
+	}
+	return s.loadTable()
 }
 
-func createIndexAndDataFiles(basePath string) (*os.File, *os.File, error) {
-	// Create files
-	indexFile, err := os.Create(filepath.Join(basePath, "index.sst"))
+// loadFooter reads the Bloom filter and the sparse index from the footer of
+// the index file. Tables written without a footer are left without them.
+func (s *SSTable) loadFooter() error {
+	s.filter, s.sparse, s.stride = nil, nil, 0
+	if s.count == 0 {
+		return nil
+	}
+	fi, err := s.index.Stat()
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	end := indexHeaderSize + 4*int64(s.count)
+	if fi.Size() == end {
+		return nil // no footer
+	}
+	if fi.Size() < end+footerSize {
+		return ErrBadTable
 	}
-	dataFile, err := os.Create(filepath.Join(basePath, "data.sst"))
+	b := make([]byte, fi.Size()-end)
+	_, err = s.index.ReadAt(b, end)
 	if err != nil {
-		return nil, nil, err
+		return err
+	}
+	footer := b[len(b)-footerSize:]
+	flen := int64(binary.BigEndian.Uint32(footer[0:4]))
+	slen := int64(binary.BigEndian.Uint32(footer[4:8]))
+	if binary.BigEndian.Uint32(footer[8:12]) != footerMagic ||
+		flen+slen+footerSize != int64(len(b)) {
+		return ErrBadTable
 	}
+	filter, err := decodeBloomFilter(b[:flen])
+	if err != nil {
+		return err
+	}
+	stride, blocks, err := decodeSparseIndex(b[flen : flen+slen])
+	if err != nil {
+		return err
+	}
+	if stride == 0 || uint32(len(blocks)) != (s.count+stride-1)/stride {
+		return ErrBadTable
+	}
+	s.filter, s.sparse, s.stride = filter, blocks, stride
+	return nil
+}
 
-	return indexFile, dataFile, nil
+// decodeSparseIndex decodes the sparse index, returning the number of entries
+// in every block along with the first key of every block.
+func decodeSparseIndex(b []byte) (uint32, []string, error) {
+	if len(b) < 8 {
+		return 0, nil, ErrBadTable
+	}
+	stride, count := binary.BigEndian.Uint32(b[0:4]), binary.BigEndian.Uint32(b[4:8])
+	b = b[8:]
+	if uint64(count)*4 > uint64(len(b)) {
+		return 0, nil, ErrBadTable
+	}
+	blocks := make([]string, count)
+	for i := range blocks {
+		if len(b) < 4 {
+			return 0, nil, ErrBadTable
+		}
+		n := binary.BigEndian.Uint32(b[0:4])
+		if uint64(n) > uint64(len(b)-4) {
+			return 0, nil, ErrBadTable
+		}
+		blocks[i] = string(b[4 : 4+n])
+		b = b[4+n:]
+	}
+	if len(b) != 0 {
+		return 0, nil, ErrBadTable
+	}
+	return stride, blocks, nil
+}
+
+// appendUint32 appends the big endian encoding of v to the provided buffer
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
 }
 
 func openIndexAndDataFiles(basePath string) (*os.File, *os.File, error) {
@@ -205,109 +370,284 @@ func openIndexAndDataFiles(basePath string) (*os.File, *os.File, error) {
 	return indexFile, dataFile, nil
 }
 
-func (s *SSTable) GetBinary(key string) (string, bool, error) {
-	// Read the index file to find the offset for the given key.
-	offset, err := s.findIndexOffsetBinary(key)
-	if err != nil {
-		return "", false, err
+// Len returns the number of entries in the table, including the tombstones.
+func (s *SSTable) Len() int {
+	return int(s.count)
+}
+
+// Size returns the number of bytes used by the table files.
+func (s *SSTable) Size() int64 {
+	return s.size
+}
+
+// Bounds returns the smallest and the largest key in the table.
+func (s *SSTable) Bounds() (first, last string) {
+	return s.first, s.last
+}
+
+// mayContain returns a boolean indicating false if the table definitely does
+// not hold the key, and true if it may.
+func (s *SSTable) mayContain(key string) bool {
+	if s.count == 0 || key < s.first || key > s.last {
+		return false
 	}
-	if offset == -1 {
-		return "", false, nil // Key not found.
+	return s.filter == nil || s.filter.mayContain(key)
+}
+
+// overlaps returns a boolean indicating true if the table holds any keys that
+// fall within the inclusive range first to last.
+func (s *SSTable) overlaps(first, last string) bool {
+	return s.count > 0 && s.first <= last && first <= s.last
+}
+
+// inRange returns a boolean indicating true if the table holds any keys that
+// fall within the range lo (inclusive) to hi (exclusive), where an empty hi
+// means there is no upper limit.
+func (s *SSTable) inRange(lo, hi string) bool {
+	return s.count > 0 && lo <= s.last && (hi == "" || s.first < hi)
+}
+
+// Iter returns an iterator over the entries of the table, including the
+// tombstones.
+func (s *SSTable) Iter() Iterator {
+	if s.table != nil {
+		return &blockTableIter{t: s.table, block: -1}
 	}
+	return &oldTableIter{s: s, i: -1}
+}
 
-	// Read the entry from the data file using the offset.
-	entry, err := s.readDataEntry(offset)
-	if err != nil {
-		return "", false, err
+// oldTableIter iterates the entries of a table using the older format. The
+// index holds the offset of every entry, so the iterator can move to any entry
+// by its position.
+type oldTableIter struct {
+	s     *SSTable
+	i     int    // i is the position of the current entry
+	entry *Entry // entry is the current entry
+	err   error
+}
+
+// load reads the i-th entry, returning false if there is no such entry.
+func (it *oldTableIter) load(i int) bool {
+	it.i, it.entry, it.err = i, nil, nil
+	if i < 0 || i >= int(it.s.count) {
+		return false
+	}
+	offset, err := it.s.readIndexOffset(uint32(i))
+	if err == nil {
+		it.entry, err = it.s.readDataEntry(offset)
 	}
+	it.err = err
+	return err == nil
+}
+
+// search returns the position of the first entry with a key greater than or
+// equal to the provided key.
+func (it *oldTableIter) search(key string) int {
+	it.err = nil
+	return sort.Search(
+		int(it.s.count), func(i int) bool {
+			if it.err != nil {
+				return true
+			}
+			offset, err := it.s.readIndexOffset(uint32(i))
+			var k string
+			if err == nil {
+				k, err = it.s.readDataKey(offset)
+			}
+			it.err = err
+			return err != nil || k >= key
+		},
+	)
+}
+
+func (it *oldTableIter) First() bool { return it.load(0) }
+func (it *oldTableIter) Last() bool  { return it.load(int(it.s.count) - 1) }
+
+func (it *oldTableIter) Seek(key string) bool {
+	i := it.search(key)
+	return it.err == nil && it.load(i)
+}
+
+func (it *oldTableIter) SeekLT(key string) bool {
+	i := it.search(key)
+	return it.err == nil && it.load(i-1)
+}
+
+func (it *oldTableIter) Next() bool {
+	return it.entry != nil && it.load(it.i+1)
+}
 
+func (it *oldTableIter) Prev() bool {
+	return it.entry != nil && it.load(it.i-1)
+}
+
+func (it *oldTableIter) Entry() *Entry { return it.entry }
+func (it *oldTableIter) Err() error    { return it.err }
+func (it *oldTableIter) Close() error  { return nil }
+
+// GetBinary returns the value of the key using a binary search over the whole
+// index, without the help of the filter or the sparse index.
+func (s *SSTable) GetBinary(key string) (string, bool, error) {
+	var entry *Entry
+	var err error
+	if s.table != nil {
+		entry, err = s.table.get(key)
+	} else {
+		// Read the index file to find the offset for the given key.
+		var offset int64
+		offset, err = s.findIndexOffsetBinary(key)
+		if err == nil && offset != -1 {
+			entry, err = s.readDataEntry(offset)
+		}
+	}
+	if err != nil || entry == nil || entry.Tombstone {
+		return "", false, err // Key not found, or deleted.
+	}
 	return entry.Val, true, nil
 }
 
+// Get returns the value of the key, and a boolean indicating true if the key
+// was found.
 func (s *SSTable) Get(key string) (string, bool, error) {
-	// Read the index file to find the offset for the given key.
-	offset, err := s.findIndexOffset(key)
-	if err != nil {
-		return "", false, err
-	}
-	if offset == -1 {
-		return "", false, nil // Key not found.
+	// Find the entry using the filter and the sparse index.
+	entry, err := s.lookup(key)
+	if err != nil || entry == nil || entry.Tombstone {
+		return "", false, err // Key not found.
 	}
+	return entry.Val, true, nil
+}
 
-	// Read the entry from the data file using the offset.
-	entry, err := s.readDataEntry(offset)
-	if err != nil {
-		return "", false, err
+// lookup returns the entry for the given key, which may be a tombstone, or
+// nil if the table holds no entry for the key. Keys that the filter rules out
+// are never searched for, and the rest are only searched for within the block
+// of the index that could hold them.
+func (s *SSTable) lookup(key string) (*Entry, error) {
+	if !s.mayContain(key) {
+		return nil, nil
+	}
+	if s.table != nil {
+		return s.table.get(key)
+	}
+	left, right := 0, int(s.count)-1
+	if s.sparse != nil {
+		// the last block starting at or before the key, which is
+		// never before the first block as the key is in bounds
+		i := sort.Search(len(s.sparse), func(i int) bool { return s.sparse[i] > key }) - 1
+		left = i * int(s.stride)
+		if end := left + int(s.stride) - 1; end < right {
+			right = end
+		}
 	}
+	offset, err := s.searchIndex(key, left, right)
+	if err != nil || offset == -1 {
+		return nil, err
+	}
+	return s.readDataEntry(offset)
+}
 
-	return entry.Val, true, nil
+// Scan calls the provided function for every entry in the table (including
+// the tombstones) in key order, until it returns false.
+func (s *SSTable) Scan(fn func(e *Entry) bool) error {
+	it := s.Iter()
+	for ok := it.First(); ok; ok = it.Next() {
+		if !fn(it.Entry()) {
+			return nil
+		}
+	}
+	return it.Err()
 }
 
 func (s *SSTable) findIndexOffset(key string) (int64, error) {
 	// Read the index file to find the offset for the given key.
-	var offset uint32
-
-	for {
-		if err := binary.Read(s.index, binary.BigEndian, &offset); err != nil {
-			if err == io.EOF {
-				// End of index file, key not found.
-				return -1, nil
-			}
+	for i := uint32(0); i < s.count; i++ {
+		offset, err := s.readIndexOffset(i)
+		if err != nil {
 			return -1, err
 		}
 
 		// Read the key at the current offset.
-		_, err := s.data.Seek(int64(offset), io.SeekStart)
+		entryKey, err := s.readDataKey(offset)
 		if err != nil {
 			return -1, err
 		}
-		var entryKeyLen uint32
-		if err := binary.Read(s.data, binary.BigEndian, &entryKeyLen); err != nil {
-			return -1, err
-		}
 
-		entryKeyBytes := make([]byte, entryKeyLen)
-		if _, err := s.data.Read(entryKeyBytes); err != nil {
-			return -1, err
+		if entryKey == key {
+			// Key found.
+			return offset, nil
 		}
+	}
+	// End of index file, key not found.
+	return -1, nil
+}
 
-		entryKey := string(entryKeyBytes)
+// readIndexOffset returns the offset in the data file of the i-th entry.
+func (s *SSTable) readIndexOffset(i uint32) (int64, error) {
+	var buf [4]byte
+	_, err := s.index.ReadAt(buf[:], indexHeaderSize+int64(i)*4)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
+		}
+		return -1, err
+	}
+	return int64(binary.BigEndian.Uint32(buf[:])), nil
+}
 
-		if entryKey == key {
-			// Key found.
-			return int64(offset), nil
+// readDataKey reads the key of the entry at the given offset in the data file.
+func (s *SSTable) readDataKey(offset int64) (string, error) {
+	var buf [4]byte
+	_, err := s.data.ReadAt(buf[:], offset)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
+		}
+		return "", err
+	}
+	keyBytes := make([]byte, binary.BigEndian.Uint32(buf[:]))
+	_, err = s.data.ReadAt(keyBytes, offset+4)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
 		}
+		return "", err
 	}
+	return string(keyBytes), nil
 }
 
 func (s *SSTable) readDataEntry(offset int64) (*Entry, error) {
-	// Read the data file at the given offset to get the entry.
+	// Read the data file at the given offset to get the entry. It only uses
+	// ReadAt, so the table can be read by many goroutines at once.
 	var entry Entry
 
-	_, err := s.data.Seek(offset, io.SeekStart)
+	key, err := s.readDataKey(offset)
 	if err != nil {
-		return &entry, err
+		return nil, err
 	}
+	entry.Key = key
+	offset += 4 + int64(len(key))
 
-	var keyLen, valueLen uint32
-	if err := binary.Read(s.data, binary.BigEndian, &keyLen); err != nil {
-		return &entry, err
-	}
-
-	keyBytes := make([]byte, keyLen)
-	if _, err := s.data.Read(keyBytes); err != nil {
-		return &entry, err
+	var buf [4]byte
+	_, err = s.data.ReadAt(buf[:], offset)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
+		}
+		return nil, err
 	}
 
-	entry.Key = string(keyBytes)
-
-	if err := binary.Read(s.data, binary.BigEndian, &valueLen); err != nil {
-		return &entry, err
+	valueLen := binary.BigEndian.Uint32(buf[:])
+	if valueLen == tombstone {
+		entry.Tombstone = true
+		return &entry, nil
 	}
 
 	valueBytes := make([]byte, valueLen)
-	if _, err := s.data.Read(valueBytes); err != nil {
-		return &entry, err
+	_, err = s.data.ReadAt(valueBytes, offset+4)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
+		}
+		return nil, err
 	}
 
 	entry.Val = string(valueBytes)
@@ -316,48 +656,33 @@ func (s *SSTable) readDataEntry(offset int64) (*Entry, error) {
 }
 
 func (s *SSTable) findIndexOffsetBinary(key string) (int64, error) {
-	// Read the index file to find the offset for the given key using binary search.
-	var offset uint32
-
-	// Read the total number of entries from the index file.
-	var numEntries uint32
-	if err := binary.Read(s.index, binary.BigEndian, &numEntries); err != nil {
-		return -1, err
-	}
+	return s.searchIndex(key, 0, int(s.count)-1)
+}
 
-	// Perform binary search on the index.
-	left, right := uint32(0), numEntries-1
+// searchIndex does a binary search of the entries left to right (inclusive)
+// of the index, returning the offset of the entry for the key, or -1 if none
+// of them are for the key.
+func (s *SSTable) searchIndex(key string, left, right int) (int64, error) {
+	// Perform binary search on the index to find the offset for the given key.
 	for left <= right {
 		mid := (left + right) / 2
 
 		// Read the offset at the middle index.
-		if _, err := s.index.Seek(int64(mid*4+8), io.SeekStart); err != nil {
-			return -1, err
-		}
-		if err := binary.Read(s.index, binary.BigEndian, &offset); err != nil {
+		offset, err := s.readIndexOffset(uint32(mid))
+		if err != nil {
 			return -1, err
 		}
 
 		// Read the key at the current offset.
-		if _, err := s.data.Seek(int64(offset), io.SeekStart); err != nil {
-			return -1, err
-		}
-		var entryKeyLen uint32
-		if err := binary.Read(s.data, binary.BigEndian, &entryKeyLen); err != nil {
-			return -1, err
-		}
-
-		entryKeyBytes := make([]byte, entryKeyLen)
-		if _, err := s.data.Read(entryKeyBytes); err != nil {
+		entryKey, err := s.readDataKey(offset)
+		if err != nil {
 			return -1, err
 		}
 
-		entryKey := string(entryKeyBytes)
-
 		// Compare the entry key with the given key.
 		if entryKey == key {
 			// Key found.
-			return int64(offset), nil
+			return offset, nil
 		} else if entryKey < key {
 			left = mid + 1
 		} else {
@@ -370,6 +695,9 @@ func (s *SSTable) findIndexOffsetBinary(key string) (int64, error) {
 }
 
 func (s *SSTable) Close() error {
+	if s.table != nil {
+		return s.table.fp.Close()
+	}
 	err := s.index.Close()
 	if err != nil {
 		return err
@@ -1,7 +1,10 @@
 package lsm
 
 import (
+	"encoding/binary"
 	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -166,3 +169,158 @@ func BenchmarkGetAndGetBinary(b *testing.B) {
 	}
 
 }
+
+func TestSSTable_Filter(t *testing.T) {
+	path := "sstable_filter_test"
+	defer os.RemoveAll(path)
+
+	// Write every other key, so the rest can be looked for.
+	var entries []Entry
+	for i := 0; i < 2000; i += 2 {
+		entries = append(entries, Entry{Key: fmt.Sprintf("key-%04d", i), Val: fmt.Sprintf("val-%04d", i)})
+	}
+	sst, err := OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	err = sst.WriteBatch(entries)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	// check makes sure every key is found, and every missing key is not,
+	// returning the number of missing keys the filter let through
+	check := func(sst *SSTable) int {
+		var positives int
+		for i := 0; i < 2000; i++ {
+			key := fmt.Sprintf("key-%04d", i)
+			val, found, err := sst.Get(key)
+			if err != nil {
+				t.Fatalf("get %q: %v", key, err)
+			}
+			if i%2 == 0 && (!found || val != fmt.Sprintf("val-%04d", i)) {
+				t.Fatalf("get %q: got (%q, %v)", key, val, found)
+			}
+			if i%2 == 1 {
+				if found {
+					t.Fatalf("get %q: found a key that is not there", key)
+				}
+				if sst.mayContain(key) {
+					positives++
+				}
+			}
+		}
+		return positives
+	}
+	if positives := check(sst); positives > 50 {
+		t.Errorf("filter: %d false positives out of 1000", positives)
+	}
+	err = sst.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// The filter and the index are loaded when the table is opened.
+	sst, err = OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if sst.filter == nil || len(sst.table.blocks) == 0 {
+		t.Fatalf("expected the filter and the index to be loaded")
+	}
+	check(sst)
+	err = sst.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A table using the older format, without a footer, is still
+	// searched, just without any help.
+	os.RemoveAll(path)
+	writeOldTable(t, path, entries)
+	sst, err = OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if sst.table != nil || sst.filter != nil || sst.sparse != nil {
+		t.Fatalf("expected a table using the older format")
+	}
+	check(sst)
+	err = sst.WriteBatch(entries)
+	if err != ErrTableNotEmpty {
+		t.Errorf("write: got %v, want %v", err, ErrTableNotEmpty)
+	}
+	err = sst.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
+
+// writeOldTable writes the sorted entries as a table using the older format,
+// with an index file and a data file
+func writeOldTable(t *testing.T, path string, entries []Entry) {
+	t.Helper()
+	index := make([]byte, indexHeaderSize)
+	binary.BigEndian.PutUint32(index[0:4], uint32(len(entries)))
+	var data []byte
+	for _, e := range entries {
+		index = appendUint32(index, uint32(len(data)))
+		data = appendUint32(data, uint32(len(e.Key)))
+		data = append(data, e.Key...)
+		if e.Tombstone {
+			data = appendUint32(data, tombstone)
+			continue
+		}
+		data = appendUint32(data, uint32(len(e.Val)))
+		data = append(data, e.Val...)
+	}
+	err := os.MkdirAll(path, 0755)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(path, "index.sst"), index, 0666)
+	}
+	if err == nil {
+		err = os.WriteFile(filepath.Join(path, "data.sst"), data, 0666)
+	}
+	if err != nil {
+		t.Fatalf("write old table: %v", err)
+	}
+}
+
+func BenchmarkSSTable_GetMissing(b *testing.B) {
+	path := "sstable_bench_test"
+	defer os.RemoveAll(path)
+	var entries []Entry
+	for i := 0; i < 100000; i += 2 {
+		entries = append(entries, Entry{Key: fmt.Sprintf("key-%06d", i), Val: fmt.Sprintf("val-%06d", i)})
+	}
+	sst, err := OpenSSTable(path)
+	if err != nil {
+		b.Fatalf("open: %v", err)
+	}
+	defer sst.Close()
+	err = sst.WriteBatch(entries)
+	if err != nil {
+		b.Fatalf("write: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(key string) (string, bool, error)
+	}{
+		{"get", sst.Get},
+		{"getBin", sst.GetBinary},
+	}
+	for _, test := range tests {
+		b.Run(
+			test.name, func(b *testing.B) {
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					_, found, err := test.fn(fmt.Sprintf("key-%06d", (i*2+1)%100000))
+					if err != nil || found {
+						b.Fatalf("got (%v, %v)", found, err)
+					}
+				}
+			},
+		)
+	}
+}
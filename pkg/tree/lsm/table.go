@@ -0,0 +1,520 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+var (
+	ErrBadChecksum  = errors.New("lsm: sstable block checksum mismatch")
+	ErrTableVersion = errors.New("lsm: unsupported sstable version")
+)
+
+// A table file is made up of data blocks holding the entries, followed by a
+// filter block holding the Bloom filter over the keys, and an index block. The
+// index block has an entry for every data block, keyed by the last key in the
+// data block, with the offset and size of the data block (not counting the
+// trailer) as the value:
+//
+//	[Offset][Size]
+//	 varint varint
+//
+// The file ends with a fixed size footer:
+//
+//	[IndexOffset][IndexSize][FilterOffset][FilterSize][Count][Version][CRC][Magic]
+//	   uint64      uint32      uint64       uint32    uint32  uint32 uint32 uint32
+//
+// where the CRC is the CRC32C checksum of everything in the footer before it.
+const (
+	tableFileName      = "table.sst"
+	tableVersion       = 1
+	tableMagic         = 0x4c534d54 // "LSMT"
+	tableFooterSize    = 40
+	indexRestartPoints = 1 // every index entry is a restart point, so it can be searched
+)
+
+// blockHandle is where a block lives in the table file
+type blockHandle struct {
+	last   string // last is the last key in the block
+	offset int64  // offset is the offset of the block in the file
+	size   int    // size is the size of the block, not counting the trailer
+}
+
+// tableFooter is the decoded footer of a table file
+type tableFooter struct {
+	index   blockHandle
+	filter  blockHandle
+	count   uint32
+	version uint32
+}
+
+// buildTable encodes the sorted entries as a table file
+func buildTable(entries []Entry) []byte {
+	var b []byte
+	data := newBlockBuilder(restartInterval)
+	index := newBlockBuilder(indexRestartPoints)
+	filter := newBloomFilter(len(entries))
+	// flush finishes the data block, and adds it to the index
+	flush := func() {
+		if data.count == 0 {
+			return
+		}
+		block := data.finish()
+		h := &Entry{Key: data.last, Val: string(appendBlockHandle(nil, int64(len(b)), len(block)))}
+		b = appendBlock(b, block)
+		index.add(h)
+		data.reset()
+	}
+	for i := range entries {
+		data.add(&entries[i])
+		filter.add(entries[i].Key)
+		if data.size() >= blockSize {
+			flush()
+		}
+	}
+	flush()
+	var f tableFooter
+	f.filter = blockHandle{offset: int64(len(b))}
+	fb := filter.appendTo(nil)
+	f.filter.size = len(fb)
+	b = appendBlock(b, fb)
+	f.index = blockHandle{offset: int64(len(b))}
+	ib := index.finish()
+	f.index.size = len(ib)
+	b = appendBlock(b, ib)
+	f.count, f.version = uint32(len(entries)), tableVersion
+	return appendFooter(b, &f)
+}
+
+// appendBlockHandle appends the encoded offset and size of a block
+func appendBlockHandle(b []byte, offset int64, size int) []byte {
+	b = appendUvarint(b, uint64(offset))
+	return appendUvarint(b, uint64(size))
+}
+
+// decodeBlockHandle decodes the offset and size of a block
+func decodeBlockHandle(b []byte) (int64, int, error) {
+	offset, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, 0, ErrBadTable
+	}
+	size, m := binary.Uvarint(b[n:])
+	if m <= 0 || n+m != len(b) {
+		return 0, 0, ErrBadTable
+	}
+	return int64(offset), int(size), nil
+}
+
+// appendFooter appends the encoded footer
+func appendFooter(b []byte, f *tableFooter) []byte {
+	var p [tableFooterSize]byte
+	binary.BigEndian.PutUint64(p[0:8], uint64(f.index.offset))
+	binary.BigEndian.PutUint32(p[8:12], uint32(f.index.size))
+	binary.BigEndian.PutUint64(p[12:20], uint64(f.filter.offset))
+	binary.BigEndian.PutUint32(p[20:24], uint32(f.filter.size))
+	binary.BigEndian.PutUint32(p[24:28], f.count)
+	binary.BigEndian.PutUint32(p[28:32], f.version)
+	binary.BigEndian.PutUint32(p[32:36], crc32.Checksum(p[0:32], crc32cTable))
+	binary.BigEndian.PutUint32(p[36:40], tableMagic)
+	return append(b, p[:]...)
+}
+
+// decodeFooter decodes and checks the footer of a table file of the provided
+// size.
+func decodeFooter(p []byte, size int64) (*tableFooter, error) {
+	if len(p) != tableFooterSize || binary.BigEndian.Uint32(p[36:40]) != tableMagic {
+		return nil, ErrBadTable
+	}
+	if crc32.Checksum(p[0:32], crc32cTable) != binary.BigEndian.Uint32(p[32:36]) {
+		return nil, ErrBadChecksum
+	}
+	f := &tableFooter{
+		index: blockHandle{
+			offset: int64(binary.BigEndian.Uint64(p[0:8])),
+			size:   int(binary.BigEndian.Uint32(p[8:12])),
+		},
+		filter: blockHandle{
+			offset: int64(binary.BigEndian.Uint64(p[12:20])),
+			size:   int(binary.BigEndian.Uint32(p[20:24])),
+		},
+		count:   binary.BigEndian.Uint32(p[24:28]),
+		version: binary.BigEndian.Uint32(p[28:32]),
+	}
+	if f.version != tableVersion {
+		return nil, ErrTableVersion
+	}
+	// the filter is followed by the index, which is followed by the footer
+	end := size - tableFooterSize
+	if f.filter.offset < 0 || f.filter.offset+int64(f.filter.size+trailerSize) != f.index.offset ||
+		f.index.offset+int64(f.index.size+trailerSize) != end {
+		return nil, ErrBadTable
+	}
+	return f, nil
+}
+
+// blockTable reads a table file. It only uses ReadAt, so the table can be read
+// by many goroutines at once.
+type blockTable struct {
+	fp     *os.File
+	size   int64         // size is the size of the file
+	footer *tableFooter  // footer is nil if the table has not been written
+	blocks []blockHandle // blocks holds the data blocks, in key order
+	filter *bloomFilter
+}
+
+// openBlockTable reads the footer, the index and the filter of the table file.
+// An empty file is a table that has not been written yet.
+func openBlockTable(fp *os.File) (*blockTable, error) {
+	fi, err := fp.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t := &blockTable{fp: fp, size: fi.Size()}
+	if t.size == 0 {
+		return t, nil
+	}
+	if t.size < tableFooterSize {
+		return nil, ErrBadTable
+	}
+	p := make([]byte, tableFooterSize)
+	_, err = fp.ReadAt(p, t.size-tableFooterSize)
+	if err != nil {
+		return nil, err
+	}
+	t.footer, err = decodeFooter(p, t.size)
+	if err != nil {
+		return nil, err
+	}
+	fb, err := t.readBlock(t.footer.filter)
+	if err != nil {
+		return nil, err
+	}
+	t.filter, err = decodeBloomFilter(fb)
+	if err != nil {
+		return nil, err
+	}
+	ib, err := t.readBlock(t.footer.index)
+	if err != nil {
+		return nil, err
+	}
+	it, err := newBlockIter(ib)
+	if err != nil {
+		return nil, err
+	}
+	for it.next() {
+		h := blockHandle{last: it.entry.Key}
+		h.offset, h.size, err = decodeBlockHandle([]byte(it.entry.Val))
+		if err != nil {
+			return nil, err
+		}
+		if h.offset < 0 || h.offset+int64(h.size+trailerSize) > t.footer.filter.offset {
+			return nil, ErrBadTable
+		}
+		t.blocks = append(t.blocks, h)
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	if (len(t.blocks) == 0) != (t.footer.count == 0) {
+		return nil, ErrBadTable
+	}
+	return t, nil
+}
+
+// readBlock reads the block, and checks it against its checksum
+func (t *blockTable) readBlock(h blockHandle) ([]byte, error) {
+	b := make([]byte, h.size+trailerSize)
+	_, err := t.fp.ReadAt(b, h.offset)
+	if err != nil {
+		if err == io.EOF {
+			err = ErrBadTable
+		}
+		return nil, err
+	}
+	return checkBlock(b)
+}
+
+// count returns the number of entries in the table
+func (t *blockTable) count() uint32 {
+	if t.footer == nil {
+		return 0
+	}
+	return t.footer.count
+}
+
+// bounds returns the smallest and the largest key in the table
+func (t *blockTable) bounds() (string, string, error) {
+	if len(t.blocks) == 0 {
+		return "", "", nil
+	}
+	b, err := t.readBlock(t.blocks[0])
+	if err != nil {
+		return "", "", err
+	}
+	it, err := newBlockIter(b)
+	if err != nil {
+		return "", "", err
+	}
+	if !it.next() {
+		if it.err != nil {
+			return "", "", it.err
+		}
+		return "", "", ErrBadTable
+	}
+	return it.entry.Key, t.blocks[len(t.blocks)-1].last, nil
+}
+
+// get returns the entry for the key, or nil if the table does not hold it
+func (t *blockTable) get(key string) (*Entry, error) {
+	// the first block with a last key that is not before the key
+	i := sort.Search(len(t.blocks), func(i int) bool { return t.blocks[i].last >= key })
+	if i == len(t.blocks) {
+		return nil, nil
+	}
+	b, err := t.readBlock(t.blocks[i])
+	if err != nil {
+		return nil, err
+	}
+	it, err := newBlockIter(b)
+	if err != nil {
+		return nil, err
+	}
+	if !it.seek(key) || it.entry.Key != key {
+		return nil, it.err
+	}
+	e := it.entry
+	return &e, nil
+}
+
+// write writes the entries to the empty table file
+func (t *blockTable) write(entries []Entry) error {
+	b := buildTable(entries)
+	_, err := t.fp.WriteAt(b, 0)
+	if err != nil {
+		return err
+	}
+	err = t.fp.Sync()
+	if err != nil {
+		return err
+	}
+	nt, err := openBlockTable(t.fp)
+	if err != nil {
+		return err
+	}
+	*t = *nt
+	return nil
+}
+
+// decodeBlock reads and decodes all the entries of the i-th data block
+func (t *blockTable) decodeBlock(i int) ([]Entry, error) {
+	b, err := t.readBlock(t.blocks[i])
+	if err != nil {
+		return nil, err
+	}
+	it, err := newBlockIter(b)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for it.next() {
+		entries = append(entries, it.entry)
+	}
+	if it.err != nil {
+		return nil, it.err
+	}
+	if len(entries) == 0 {
+		return nil, ErrBadTable
+	}
+	return entries, nil
+}
+
+// blockTableIter iterates the entries of a table, one data block at a time.
+// The current data block is decoded as a whole, so the iterator can move back
+// through it as easily as forward.
+type blockTableIter struct {
+	t       *blockTable
+	block   int     // block is the position of the current data block
+	entries []Entry // entries holds the entries of the current data block
+	i       int     // i is the position of the current entry in the block
+	err     error
+}
+
+// load loads the entries of the i-th data block, returning false if there is
+// no such block.
+func (it *blockTableIter) load(i int) bool {
+	it.err = nil
+	if i < 0 || i >= len(it.t.blocks) {
+		it.block, it.entries = -1, nil
+		return false
+	}
+	if i == it.block && it.entries != nil {
+		return true
+	}
+	it.block = i
+	it.entries, it.err = it.t.decodeBlock(i)
+	return it.err == nil
+}
+
+func (it *blockTableIter) valid() bool {
+	return it.err == nil && it.i >= 0 && it.i < len(it.entries)
+}
+
+func (it *blockTableIter) First() bool {
+	it.i = 0
+	return it.load(0) && it.valid()
+}
+
+func (it *blockTableIter) Last() bool {
+	if !it.load(len(it.t.blocks) - 1) {
+		return false
+	}
+	it.i = len(it.entries) - 1
+	return it.valid()
+}
+
+func (it *blockTableIter) Seek(key string) bool {
+	// the first block with a last key that is not before the key
+	i := sort.Search(len(it.t.blocks), func(i int) bool { return it.t.blocks[i].last >= key })
+	if !it.load(i) {
+		return false
+	}
+	it.i = sort.Search(len(it.entries), func(i int) bool { return it.entries[i].Key >= key })
+	return it.valid()
+}
+
+func (it *blockTableIter) SeekLT(key string) bool {
+	if !it.Seek(key) {
+		if it.err != nil {
+			return false
+		}
+		return it.Last() && it.Entry().Key < key
+	}
+	it.i--
+	if it.i >= 0 {
+		return true
+	}
+	if !it.load(it.block - 1) {
+		return false
+	}
+	it.i = len(it.entries) - 1
+	return it.valid()
+}
+
+func (it *blockTableIter) Next() bool {
+	if !it.valid() {
+		return false
+	}
+	it.i++
+	if it.i < len(it.entries) {
+		return true
+	}
+	it.i = 0
+	return it.load(it.block+1) && it.valid()
+}
+
+func (it *blockTableIter) Prev() bool {
+	if !it.valid() {
+		return false
+	}
+	it.i--
+	if it.i >= 0 {
+		return true
+	}
+	if !it.load(it.block - 1) {
+		return false
+	}
+	it.i = len(it.entries) - 1
+	return it.valid()
+}
+
+func (it *blockTableIter) Entry() *Entry {
+	return &it.entries[it.i]
+}
+
+func (it *blockTableIter) Err() error   { return it.err }
+func (it *blockTableIter) Close() error { return nil }
+
+// TableInfo describes the layout of a table file
+type TableInfo struct {
+	Version      int         // Version is the version of the table format
+	Entries      int         // Entries is the number of entries, including the tombstones
+	Size         int64       // Size is the size of the table file
+	IndexOffset  int64       // IndexOffset is the offset of the index block
+	IndexSize    int         // IndexSize is the size of the index block
+	FilterOffset int64       // FilterOffset is the offset of the filter block
+	FilterSize   int         // FilterSize is the size of the filter block
+	Blocks       []BlockInfo // Blocks describes the data blocks
+}
+
+// BlockInfo describes a single data block of a table file
+type BlockInfo struct {
+	Offset   int64  // Offset is the offset of the block in the file
+	Size     int    // Size is the size of the block, not counting the trailer
+	Entries  int    // Entries is the number of entries in the block
+	Restarts int    // Restarts is the number of restart points in the block
+	First    string // First is the first key in the block
+	Last     string // Last is the last key in the block
+}
+
+// InspectTable reads the table in the provided directory and checks all of it:
+// the footer, the checksum of every block, that the keys are in order, and that
+// the number of entries matches the footer. It returns a description of the
+// layout of the table.
+func InspectTable(path string) (*TableInfo, error) {
+	fp, err := os.Open(filepath.Join(path, tableFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer fp.Close()
+	t, err := openBlockTable(fp)
+	if err != nil {
+		return nil, err
+	}
+	info := &TableInfo{Size: t.size}
+	if t.footer == nil {
+		return info, nil
+	}
+	info.Version = int(t.footer.version)
+	info.IndexOffset, info.IndexSize = t.footer.index.offset, t.footer.index.size
+	info.FilterOffset, info.FilterSize = t.footer.filter.offset, t.footer.filter.size
+	var last string
+	for _, h := range t.blocks {
+		b, err := t.readBlock(h)
+		if err != nil {
+			return nil, err
+		}
+		it, err := newBlockIter(b)
+		if err != nil {
+			return nil, err
+		}
+		bi := BlockInfo{Offset: h.offset, Size: h.size, Restarts: it.numRestarts()}
+		for it.next() {
+			if info.Entries > 0 && it.entry.Key <= last {
+				return nil, ErrBadTable // out of order
+			}
+			if bi.Entries == 0 {
+				bi.First = it.entry.Key
+			}
+			last = it.entry.Key
+			bi.Entries++
+			info.Entries++
+		}
+		if it.err != nil {
+			return nil, it.err
+		}
+		if bi.Entries == 0 || last != h.last {
+			return nil, ErrBadTable
+		}
+		bi.Last = last
+		info.Blocks = append(info.Blocks, bi)
+	}
+	if info.Entries != int(t.footer.count) {
+		return nil, ErrBadTable
+	}
+	return info, nil
+}
@@ -0,0 +1,172 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTable_Format(t *testing.T) {
+	path := "table_format_test"
+	os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	// Keys that share long prefixes, with some of them deleted.
+	var entries []Entry
+	var raw int
+	for i := 0; i < 3000; i++ {
+		e := Entry{Key: fmt.Sprintf("users/%06d/profile", i)}
+		if i%10 == 0 {
+			e.Tombstone = true
+		} else {
+			e.Val = fmt.Sprintf("value of user %d", i)
+		}
+		raw += len(e.Key) + len(e.Val)
+		entries = append(entries, e)
+	}
+	sst, err := OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	err = sst.WriteBatch(entries)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	err = sst.WriteBatch(entries)
+	if err != ErrTableNotEmpty {
+		t.Errorf("write again: got %v, want %v", err, ErrTableNotEmpty)
+	}
+	if sst.Size() >= int64(raw) {
+		t.Errorf("size: got %d bytes, expected less than the %d raw bytes", sst.Size(), raw)
+	}
+
+	// Every entry can be found, including the tombstones, and they
+	// come back out in order.
+	for i := range entries {
+		e, err := sst.lookup(entries[i].Key)
+		if err != nil {
+			t.Fatalf("lookup %q: %v", entries[i].Key, err)
+		}
+		if e == nil || *e != entries[i] {
+			t.Fatalf("lookup %q: got %+v, want %+v", entries[i].Key, e, entries[i])
+		}
+		e, err = sst.lookup(entries[i].Key + "/missing")
+		if err != nil || e != nil {
+			t.Fatalf("lookup missing key: got (%+v, %v)", e, err)
+		}
+	}
+	var i int
+	err = sst.Scan(
+		func(e *Entry) bool {
+			if *e != entries[i] {
+				t.Fatalf("scan: got %+v, want %+v", e, entries[i])
+			}
+			i++
+			return true
+		},
+	)
+	if err != nil || i != len(entries) {
+		t.Fatalf("scan: got %d entries (%v), want %d", i, err, len(entries))
+	}
+	err = sst.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// The layout checks out.
+	info, err := InspectTable(path)
+	if err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if info.Version != tableVersion || info.Entries != len(entries) || len(info.Blocks) < 2 {
+		t.Fatalf("inspect: got version %d, %d entries in %d blocks", info.Version, info.Entries, len(info.Blocks))
+	}
+	for _, b := range info.Blocks {
+		if b.Restarts != (b.Entries+restartInterval-1)/restartInterval {
+			t.Errorf("block at %d: got %d restarts for %d entries", b.Offset, b.Restarts, b.Entries)
+		}
+	}
+}
+
+func TestTable_Corruption(t *testing.T) {
+	path := "table_corruption_test"
+	os.RemoveAll(path)
+	defer os.RemoveAll(path)
+
+	var entries []Entry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, Entry{Key: fmt.Sprintf("key-%04d", i), Val: fmt.Sprintf("val-%04d", i)})
+	}
+	sst, err := OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	err = sst.WriteBatch(entries)
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	last := sst.table.blocks[len(sst.table.blocks)-1]
+	err = sst.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	file := filepath.Join(path, tableFileName)
+	good, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// corrupt writes a copy of the table with the change applied
+	corrupt := func(fn func(b []byte)) {
+		b := append([]byte(nil), good...)
+		fn(b)
+		err := os.WriteFile(file, b, 0666)
+		if err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	// A flipped bit in the last data block is caught when it is read.
+	corrupt(func(b []byte) { b[last.offset+10] ^= 0x01 })
+	sst, err = OpenSSTable(path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	_, _, err = sst.Get(entries[len(entries)-1].Key)
+	if err != ErrBadChecksum {
+		t.Errorf("get: got %v, want %v", err, ErrBadChecksum)
+	}
+	_, _, err = sst.Get(entries[0].Key)
+	if err != nil {
+		t.Errorf("get from a good block: %v", err)
+	}
+	_ = sst.Close()
+	if _, err = InspectTable(path); err != ErrBadChecksum {
+		t.Errorf("inspect: got %v, want %v", err, ErrBadChecksum)
+	}
+
+	// A table from the future is turned away.
+	corrupt(
+		func(b []byte) {
+			p := b[len(b)-tableFooterSize:]
+			binary.BigEndian.PutUint32(p[28:32], tableVersion+1)
+			binary.BigEndian.PutUint32(p[32:36], crc32.Checksum(p[0:32], crc32cTable))
+		},
+	)
+	if _, err = OpenSSTable(path); err != ErrTableVersion {
+		t.Errorf("open: got %v, want %v", err, ErrTableVersion)
+	}
+
+	// So is a table that was cut short.
+	corrupt(func(b []byte) {})
+	err = os.Truncate(file, int64(len(good)-1))
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	if _, err = OpenSSTable(path); err != ErrBadTable {
+		t.Errorf("open: got %v, want %v", err, ErrBadTable)
+	}
+}
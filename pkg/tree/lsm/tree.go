@@ -1 +1,533 @@
 package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	wal "github.com/cagnosolutions/go-data/pkg/wal"
+)
+
+const (
+	defaultBasePath     = "lsm"
+	defaultMemtableSize = 4096
+	walDir              = "wal"
+	sstPrefix           = "sst-"
+	tmpPrefix           = "tmp-"
+)
+
+var (
+	ErrEmptyKey  = errors.New("lsm: empty key")
+	ErrBadRecord = errors.New("lsm: bad log record")
+)
+
+// The write-ahead log records every write made to the memtable, so that the
+// memtable can be rebuilt after a crash:
+//
+//	[Op][KeyLen][Key][Val]
+//	 u8  uint32   *    *
+const (
+	opPut = 'p'
+	opDel = 'd'
+)
+
+var defaultLSMConfig = &LSMConfig{
+	BasePath:     defaultBasePath,
+	MemtableSize: defaultMemtableSize,
+}
+
+type LSMConfig struct {
+	BasePath     string            // base storage path
+	MemtableSize int               // number of entries the memtable holds before it is flushed
+	SyncOnWrite  bool              // sync the write-ahead log every time an entry is written
+	Compaction   CompactionOptions // how (and if) the sstables are compacted
+}
+
+func checkLSMConfig(conf *LSMConfig) *LSMConfig {
+	if conf == nil {
+		return defaultLSMConfig
+	}
+	if conf.BasePath == "" {
+		conf.BasePath = defaultBasePath
+	}
+	if conf.MemtableSize < 1 {
+		conf.MemtableSize = defaultMemtableSize
+	}
+	checkCompactionOptions(&conf.Compaction)
+	return conf
+}
+
+// tableFile is an SSTable along with where it lives on disk, and where it fits
+// in the tree
+type tableFile struct {
+	*SSTable
+	id    int64  // id is the number the table files are named after
+	seq   int64  // seq orders the tables, tables holding newer data have higher ones
+	level int    // level is the level of the table
+	path  string // path is the directory holding the table files
+
+	refs     int32 // refs is the number of references to the table, the tree holds one
+	obsolete bool  // obsolete is set once the table has been replaced by a compaction
+}
+
+// ref takes a reference to the table, so it stays open until unref is called
+func (tf *tableFile) ref() {
+	atomic.AddInt32(&tf.refs, 1)
+}
+
+// unref drops a reference to the table. The table is closed once the last
+// reference is dropped, and its files are removed if it is obsolete.
+func (tf *tableFile) unref() error {
+	if atomic.AddInt32(&tf.refs, -1) > 0 {
+		return nil
+	}
+	if tf.obsolete {
+		return removeTable(tf)
+	}
+	return tf.Close()
+}
+
+// LSMTree is a log structured merge tree. Writes go to a memtable that is
+// protected by a write-ahead log, and once the memtable is full it is flushed
+// to a new SSTable in level 0. Reads check the memtable first, and then the
+// SSTables from the newest one to the oldest one. Deletes are written as
+// tombstones, which hide any older values of the key. If a compaction strategy
+// is configured, the SSTables are merged together in the background.
+type LSMTree struct {
+	lock    sync.RWMutex
+	conf    *LSMConfig
+	base    string
+	wal     *wal.WAL
+	mem     *Memtable
+	tables  []*tableFile // tables is the list of sstables, oldest first
+	nextID  int64        // nextID is the last id handed out to a table
+	compact compactor    // compact runs the background compaction
+}
+
+// OpenLSMTree opens and returns a log structured merge tree, loading any of the
+// SSTables found in the base path, and replaying the write-ahead log into the
+// memtable.
+func OpenLSMTree(c *LSMConfig) (*LSMTree, error) {
+	// check config
+	conf := checkLSMConfig(c)
+	base, err := initBasePath(conf.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	t := &LSMTree{
+		conf: conf,
+		base: base,
+		mem:  NewMemtable(conf.MemtableSize),
+	}
+	err = t.loadTables()
+	if err == nil {
+		err = t.openWAL()
+	}
+	if err == nil {
+		err = t.replayWAL()
+	}
+	if err != nil {
+		_ = t.closeTables()
+		if t.wal != nil {
+			_ = t.wal.Close()
+		}
+		return nil, err
+	}
+	t.startCompactor()
+	return t, nil
+}
+
+// loadTables opens all the sstables listed in the manifest, and removes any
+// that were left behind by a flush or compaction that never finished. If there
+// is no manifest, all the sstables in the base path are loaded into level 0.
+func (t *LSMTree) loadTables() error {
+	m, err := readManifest(t.base)
+	if err != nil {
+		return err
+	}
+	listed := make(map[int64]manifestTable)
+	if m != nil {
+		t.nextID = m.nextID
+		for _, mt := range m.tables {
+			listed[mt.id] = mt
+		}
+	}
+	files, err := os.ReadDir(t.base)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			continue
+		}
+		path := filepath.ToSlash(filepath.Join(t.base, file.Name()))
+		// a flush that never finished, so the memtable is still in the log
+		if strings.HasPrefix(file.Name(), tmpPrefix) {
+			err = os.RemoveAll(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !strings.HasPrefix(file.Name(), sstPrefix) {
+			continue
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(file.Name(), sstPrefix), 10, 64)
+		if err != nil {
+			continue // not one of ours
+		}
+		mt, found := listed[id]
+		if m == nil {
+			// no manifest yet, so every table is in level 0
+			mt, found = manifestTable{id: id, seq: id}, true
+			if id > t.nextID {
+				t.nextID = id
+			}
+		}
+		if !found {
+			// written by a compaction that never finished, or
+			// replaced by one that did finish
+			err = os.RemoveAll(path)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		delete(listed, id)
+		sst, err := OpenSSTable(path)
+		if err != nil {
+			return err
+		}
+		t.tables = append(t.tables, &tableFile{SSTable: sst, id: id, seq: mt.seq, level: mt.level, path: path, refs: 1})
+	}
+	if m != nil && len(listed) > 0 {
+		return ErrBadManifest // a table is missing
+	}
+	t.sortTables()
+	if m == nil {
+		return t.writeManifest()
+	}
+	return nil
+}
+
+// sortTables sorts the tables from the oldest to the newest. The deepest level
+// holds the oldest data, and within a level the tables with lower sequence
+// numbers hold older data.
+func (t *LSMTree) sortTables() {
+	sort.Slice(
+		t.tables, func(i, j int) bool {
+			if t.tables[i].level != t.tables[j].level {
+				return t.tables[i].level > t.tables[j].level
+			}
+			return t.tables[i].seq < t.tables[j].seq
+		},
+	)
+}
+
+// writeManifest writes the current set of tables to the manifest. The caller
+// must hold the lock.
+func (t *LSMTree) writeManifest() error {
+	m := &manifest{nextID: t.nextID}
+	for _, tf := range t.tables {
+		m.tables = append(m.tables, manifestTable{id: tf.id, seq: tf.seq, level: tf.level})
+	}
+	return writeManifest(t.base, m)
+}
+
+// writeTable writes the entries to a new table with the provided id. The table
+// is written using a temporary name and renamed once it is complete, so a crash
+// never leaves a partial table behind. The table does not become part of the
+// tree until it is listed in the manifest.
+func (t *LSMTree) writeTable(id int64, entries []Entry) (*tableFile, error) {
+	name := fmt.Sprintf("%010d", id)
+	tmp := filepath.ToSlash(filepath.Join(t.base, tmpPrefix+name))
+	path := filepath.ToSlash(filepath.Join(t.base, sstPrefix+name))
+	sst, err := OpenSSTable(tmp)
+	if err != nil {
+		return nil, err
+	}
+	err = sst.WriteBatch(entries)
+	if cerr := sst.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		_ = os.RemoveAll(tmp)
+		return nil, err
+	}
+	sst, err = OpenSSTable(path)
+	if err != nil {
+		return nil, err
+	}
+	return &tableFile{SSTable: sst, id: id, seq: id, path: path, refs: 1}, nil
+}
+
+// removeTable closes the table and removes its files
+func removeTable(tf *tableFile) error {
+	err := tf.Close()
+	if rerr := os.RemoveAll(tf.path); err == nil {
+		err = rerr
+	}
+	return err
+}
+
+// openWAL opens the write-ahead log protecting the memtable
+func (t *LSMTree) openWAL() error {
+	var err error
+	t.wal, err = wal.OpenWAL(
+		&wal.WALConfig{
+			BasePath:    filepath.ToSlash(filepath.Join(t.base, walDir)),
+			SyncOnWrite: t.conf.SyncOnWrite,
+		},
+	)
+	return err
+}
+
+// replayWAL rebuilds the memtable using the records in the write-ahead log
+func (t *LSMTree) replayWAL() error {
+	var rerr error
+	err := t.wal.Scan(
+		func(rec []byte) bool {
+			if rerr != nil {
+				return false
+			}
+			var e *Entry
+			e, rerr = decodeRecord(rec)
+			if rerr == nil {
+				t.mem.put(*e)
+			}
+			return rerr == nil
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if rerr != nil {
+		return rerr
+	}
+	if t.mem.isFull() {
+		return t.flush()
+	}
+	return nil
+}
+
+// encodeRecord encodes the entry as a write-ahead log record
+func encodeRecord(e *Entry) []byte {
+	op := byte(opPut)
+	if e.Tombstone {
+		op = opDel
+	}
+	rec := make([]byte, 1, 5+len(e.Key)+len(e.Val))
+	rec[0] = op
+	rec = appendUint32(rec, uint32(len(e.Key)))
+	rec = append(rec, e.Key...)
+	return append(rec, e.Val...)
+}
+
+// decodeRecord decodes a write-ahead log record into an entry
+func decodeRecord(rec []byte) (*Entry, error) {
+	if len(rec) < 5 || (rec[0] != opPut && rec[0] != opDel) {
+		return nil, ErrBadRecord
+	}
+	n := int(binary.BigEndian.Uint32(rec[1:5]))
+	if n > len(rec)-5 {
+		return nil, ErrBadRecord
+	}
+	return &Entry{
+		Key:       string(rec[5 : 5+n]),
+		Val:       string(rec[5+n:]),
+		Tombstone: rec[0] == opDel,
+	}, nil
+}
+
+// Put adds or replaces the value of the provided key
+func (t *LSMTree) Put(key string, val string) error {
+	return t.write(&Entry{Key: key, Val: val})
+}
+
+// Del deletes the provided key
+func (t *LSMTree) Del(key string) error {
+	return t.write(&Entry{Key: key, Tombstone: true})
+}
+
+// write logs the entry and adds it to the memtable, flushing the memtable if
+// it is full
+func (t *LSMTree) write(e *Entry) error {
+	if e.Key == "" {
+		return ErrEmptyKey
+	}
+	// lock
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// the log comes first
+	_, err := t.wal.Write(encodeRecord(e))
+	if err != nil {
+		return err
+	}
+	t.mem.put(*e)
+	if t.mem.isFull() {
+		return t.flush()
+	}
+	return nil
+}
+
+// Get returns the value of the provided key, and a boolean indicating true if
+// the key was found
+func (t *LSMTree) Get(key string) (string, bool, error) {
+	// read lock
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	// check the memtable first
+	if e, found := t.mem.Get(key); found {
+		if e.Tombstone {
+			return "", false, nil
+		}
+		return e.Val, true, nil
+	}
+	// then check the sstables, starting with the newest one
+	for i := len(t.tables) - 1; i >= 0; i-- {
+		e, err := t.tables[i].lookup(key)
+		if err != nil {
+			return "", false, err
+		}
+		if e != nil {
+			if e.Tombstone {
+				return "", false, nil
+			}
+			return e.Val, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+// Scan returns an iterator over the keys from lo (inclusive) to hi (exclusive),
+// where an empty hi means there is no upper limit. Only the newest value of
+// every key is returned, and deleted keys are skipped. The iterator sees the
+// tree as it was when Scan was called, and it must be closed once it is no
+// longer needed.
+func (t *LSMTree) Scan(lo, hi string) Iterator {
+	// read lock
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	// the sources go from the oldest to the newest, so the newest version
+	// of a key wins
+	var iters []Iterator
+	var tables []*tableFile
+	for _, tf := range t.tables {
+		if !tf.inRange(lo, hi) {
+			continue
+		}
+		tf.ref()
+		tables = append(tables, tf)
+		iters = append(iters, tf.Iter())
+	}
+	iters = append(iters, t.mem.rangeIter(lo, hi))
+	return &treeIter{
+		rangeIter: rangeIter{it: newMergeIter(iters, true), lo: lo, hi: hi},
+		tables:    tables,
+	}
+}
+
+// Prefix returns an iterator over the keys starting with the provided prefix.
+// It works the same way as Scan.
+func (t *LSMTree) Prefix(prefix string) Iterator {
+	return t.Scan(prefix, prefixEnd(prefix))
+}
+
+// treeIter iterates a range of the tree. It holds on to the tables it reads,
+// so they are not closed or removed while it is in use.
+type treeIter struct {
+	rangeIter
+	tables []*tableFile
+}
+
+// Close releases the tables held by the iterator
+func (it *treeIter) Close() error {
+	err := it.rangeIter.Close()
+	for _, tf := range it.tables {
+		if uerr := tf.unref(); err == nil {
+			err = uerr
+		}
+	}
+	it.tables = nil
+	return err
+}
+
+// Flush writes the memtable out to a new SSTable, and starts over using an
+// empty memtable and write-ahead log
+func (t *LSMTree) Flush() error {
+	// lock
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.flush()
+}
+
+// flush writes the memtable out to a new SSTable in level 0. The caller must
+// hold the lock.
+func (t *LSMTree) flush() error {
+	if t.mem.Len() == 0 {
+		return nil
+	}
+	tf, err := t.writeTable(t.nextID+1, t.mem.Entries())
+	if err != nil {
+		return err
+	}
+	t.nextID = tf.id
+	t.tables = append(t.tables, tf)
+	t.sortTables()
+	err = t.writeManifest()
+	if err != nil {
+		return err
+	}
+	// the memtable is safely on disk, so the log can go
+	err = t.wal.CloseAndRemove()
+	if err != nil {
+		return err
+	}
+	t.mem = NewMemtable(t.conf.MemtableSize)
+	err = t.openWAL()
+	if err != nil {
+		return err
+	}
+	t.wakeCompactor()
+	return nil
+}
+
+// closeTables closes all the sstables, apart from the ones still being read
+// by an iterator, which are closed once the iterator is closed.
+func (t *LSMTree) closeTables() error {
+	var err error
+	for _, tf := range t.tables {
+		if cerr := tf.unref(); err == nil {
+			err = cerr
+		}
+	}
+	t.tables = nil
+	return err
+}
+
+// Close closes the write-ahead log and the sstables. The memtable does not
+// have to be flushed, it is rebuilt from the log when the tree is opened again.
+func (t *LSMTree) Close() error {
+	// stop the compaction first, it needs the lock to finish up
+	err := t.stopCompactor()
+	// lock
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if werr := t.wal.Close(); err == nil {
+		err = werr
+	}
+	if cerr := t.closeTables(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package lsm
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestLSMTree(t *testing.T) {
+	conf := &LSMConfig{
+		BasePath:     "lsm_test",
+		MemtableSize: 100,
+	}
+	defer os.RemoveAll(conf.BasePath)
+
+	// Open the tree.
+	tree, err := OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	want := make(map[string]string)
+
+	// Write enough to flush a bunch of sstables.
+	for i := 0; i < 1000; i++ {
+		key, val := fmt.Sprintf("key-%04d", i), fmt.Sprintf("val-%04d", i)
+		err = tree.Put(key, val)
+		if err != nil {
+			t.Fatalf("put %q: %v", key, err)
+		}
+		want[key] = val
+	}
+	if len(tree.tables) != 10 {
+		t.Errorf("tables: got %d, want %d", len(tree.tables), 10)
+	}
+	checkTree(t, tree, want, 1000)
+
+	// Overwrite and delete keys that live in older sstables, leaving
+	// some of the changes in the memtable.
+	for i := 0; i < 1000; i += 7 {
+		key := fmt.Sprintf("key-%04d", i)
+		if i%2 == 0 {
+			err = tree.Del(key)
+			delete(want, key)
+		} else {
+			val := fmt.Sprintf("new-val-%04d", i)
+			err = tree.Put(key, val)
+			want[key] = val
+		}
+		if err != nil {
+			t.Fatalf("write %q: %v", key, err)
+		}
+	}
+	if tree.mem.Len() == 0 {
+		t.Fatalf("expected some of the writes to still be in the memtable")
+	}
+	checkTree(t, tree, want, 1000)
+
+	// Deleting a key that was never there is fine.
+	err = tree.Del("key-9999")
+	if err != nil {
+		t.Fatalf("del: %v", err)
+	}
+	err = tree.Put("", "nope")
+	if err != ErrEmptyKey {
+		t.Errorf("put empty key: got %v, want %v", err, ErrEmptyKey)
+	}
+
+	// Close and reopen, the memtable should be rebuilt from the log.
+	mem := tree.mem.Len()
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	tree, err = OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if tree.mem.Len() != mem {
+		t.Errorf("memtable: got %d entries, want %d", tree.mem.Len(), mem)
+	}
+	checkTree(t, tree, want, 1000)
+
+	// Flush the rest, and put a deleted key back.
+	err = tree.Flush()
+	if err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	err = tree.Put("key-0000", "back again")
+	if err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	want["key-0000"] = "back again"
+	checkTree(t, tree, want, 1000)
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	// A flush that never finished is thrown away.
+	err = os.MkdirAll(conf.BasePath+"/"+tmpPrefix+"9999999999", 0755)
+	if err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	tree, err = OpenLSMTree(conf)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	checkTree(t, tree, want, 1000)
+	if _, err = os.Stat(conf.BasePath + "/" + tmpPrefix + "9999999999"); !os.IsNotExist(err) {
+		t.Errorf("expected the partial table to be removed, got %v", err)
+	}
+	err = tree.Close()
+	if err != nil {
+		t.Fatalf("close: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package v2
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Compression is the algorithm used to compress the entry data
+type Compression uint8
+
+const (
+	NoCompression    Compression = iota // entry data is stored as is
+	FlateCompression                    // entry data is compressed using DEFLATE
+)
+
+// compressMinSize is the size below which entries are not worth compressing
+const compressMinSize = 64
+
+// ErrBadCompression is returned when an entry uses an unknown compression
+// algorithm, or when the compressed entry data cannot be decompressed.
+var ErrBadCompression = errors.New("binary: bad entry compression")
+
+// Setting up a flate writer allocates a fair amount of memory, so the writers
+// (and readers) are pooled, and reset every time they are used.
+var (
+	flateWriters = sync.Pool{
+		New: func() any {
+			w, _ := flate.NewWriter(nil, flate.BestSpeed)
+			return w
+		},
+	}
+	flateReaders = sync.Pool{
+		New: func() any {
+			return flate.NewReader(nil)
+		},
+	}
+)
+
+// compressEntry compresses the entry using the provided algorithm. A compressed
+// entry starts with the uncompressed length, so it can be decompressed in one
+// go. If compression does not make the entry any smaller, the entry is passed
+// through unchanged. It returns the entry data along with the algorithm that
+// was actually used.
+func compressEntry(c Compression, e []byte) ([]byte, Compression, error) {
+	if c == NoCompression || len(e) < compressMinSize {
+		return e, NoCompression, nil
+	}
+	if c != FlateCompression {
+		return nil, c, ErrBadCompression
+	}
+	buf := bytes.NewBuffer(make([]byte, 4, len(e)))
+	binary.LittleEndian.PutUint32(buf.Bytes()[0:4], uint32(len(e)))
+	w := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(w)
+	w.Reset(buf)
+	_, err := w.Write(e)
+	if err == nil {
+		err = w.Close()
+	}
+	if err != nil {
+		return nil, c, err
+	}
+	if buf.Len() >= len(e) {
+		// did not shrink, so it is not worth decompressing later on
+		return e, NoCompression, nil
+	}
+	return buf.Bytes(), c, nil
+}
+
+// decompressEntry decompresses the entry data that was compressed using the
+// provided algorithm.
+func decompressEntry(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case NoCompression:
+		return data, nil
+	case FlateCompression:
+		if len(data) < 4 {
+			return nil, ErrBadCompression
+		}
+		e := make([]byte, binary.LittleEndian.Uint32(data[0:4]))
+		r := flateReaders.Get().(io.ReadCloser)
+		defer flateReaders.Put(r)
+		err := r.(flate.Resetter).Reset(bytes.NewReader(data[4:]), nil)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.ReadFull(r, e)
+		if err != nil {
+			return nil, ErrBadCompression
+		}
+		return e, nil
+	}
+	return nil, ErrBadCompression
+}
@@ -0,0 +1,125 @@
+package v2
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrTruncated is returned by a Reader when the entry it was going to return
+// next (or one it has already returned) has been truncated out of the log.
+var ErrTruncated = errors.New("error: entry has been truncated")
+
+// Reader follows the write-ahead log, returning the entries in order once they
+// are appended and synced, so it never returns an entry that a failed sync can
+// still lose. It holds no file handles or offsets across calls, and looks every
+// entry up again by index, so it is not bothered by segments being cycled or
+// rewritten underneath it. A Reader is not safe for concurrent use, but any
+// number of them can follow the same log.
+type Reader struct {
+	l     *WAL
+	index int64 // index is the index of the next entry to return
+	cuts  int64 // cuts is the number of TruncateBack calls the Reader has seen
+}
+
+// Follow returns a Reader that starts at the entry matching the provided index.
+// The index can be one past the last index, in which case the Reader starts
+// with the next entry written.
+func (l *WAL) Follow(index int64) (*Reader, error) {
+	// read lock
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+	// error checking
+	if len(l.segments) == 0 {
+		return nil, ErrFileClosed
+	}
+	if index < l.firstIndex || index > l.lastIndex+1 {
+		return nil, ErrOutOfBounds
+	}
+	return &Reader{l: l, index: index, cuts: l.cuts}, nil
+}
+
+// Index returns the index of the next entry the Reader will return.
+func (r *Reader) Index() int64 {
+	return r.index
+}
+
+// Next returns the next entry in the write-ahead log along with its index. If
+// there is no next entry yet, it blocks until one is written, or until the
+// context is done, in which case it returns the context error. It returns
+// ErrTruncated if the entries have been truncated out from under the Reader,
+// and ErrFileClosed if the write-ahead log is closed. Once TruncateBack has
+// removed an entry the Reader already returned, the Reader keeps returning
+// ErrTruncated, even after new entries are written in place of the old ones;
+// use Follow to start over from an index that is known to be good.
+func (r *Reader) Next(ctx context.Context) (int64, []byte, error) {
+	l := r.l
+	for {
+		// lock (we may have to set up the notify channel)
+		l.lock.Lock()
+		if len(l.segments) == 0 {
+			l.lock.Unlock()
+			return -1, nil, ErrFileClosed
+		}
+		// the next entry was removed by TruncateFront, or the
+		// entries before it were removed by TruncateBack
+		if r.index < l.firstIndex || r.index > l.lastIndex+1 || !r.checkCuts() {
+			l.lock.Unlock()
+			return -1, nil, ErrTruncated
+		}
+		if r.index <= l.synced {
+			e, err := l.read(r.index)
+			l.lock.Unlock()
+			if err != nil {
+				return -1, nil, err
+			}
+			r.index++
+			return r.index - 1, e, nil
+		}
+		// nothing new yet, so wait for the log to change
+		if l.notify == nil {
+			l.notify = make(chan struct{})
+		}
+		notify := l.notify
+		l.lock.Unlock()
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return -1, nil, ctx.Err()
+		}
+	}
+}
+
+// checkCuts returns a boolean indicating true if none of the entries the Reader
+// has returned were removed by TruncateBack since it last checked. If the log
+// was truncated back more than once in the meantime, there is no telling which
+// entries were removed, so it has to assume the worst. The caller must hold the
+// lock.
+func (r *Reader) checkCuts() bool {
+	l := r.l
+	if r.cuts == l.cuts {
+		return true
+	}
+	if r.cuts+1 == l.cuts && l.cutFrom >= r.index {
+		// only entries the Reader has not gotten to yet were removed
+		r.cuts = l.cuts
+		return true
+	}
+	return false
+}
+
+// markSynced makes every entry written so far visible to the Readers, and wakes
+// up any of them waiting for the log to change. It is called once the entries
+// are synced (or written using O_SYNC.) The caller must hold the lock.
+func (l *WAL) markSynced() {
+	l.synced = l.lastIndex
+	l.wakeFollowers()
+}
+
+// wakeFollowers wakes up any Readers waiting for the log to change. The caller
+// must hold the lock.
+func (l *WAL) wakeFollowers() {
+	if l.notify != nil {
+		close(l.notify)
+		l.notify = nil
+	}
+}
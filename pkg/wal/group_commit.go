@@ -0,0 +1,129 @@
+package v2
+
+import (
+	"sync"
+	"time"
+)
+
+// groupWriter is a single call to Write waiting in the group commit queue
+type groupWriter struct {
+	data  []byte // data is the entry to be written
+	index int64  // index is the index assigned to the entry once written
+	err   error  // err is any error that occurred writing the group
+	done  bool   // done is set once the group holding the entry is written
+}
+
+// groupQueue is the queue of writers waiting for a group commit. The writer at
+// the head of the queue is the leader: it takes as many of the writers queued
+// behind it as fit in a group, writes all of them, syncs once, and then hands
+// the lead over to whoever is at the head of the queue next.
+type groupQueue struct {
+	lock    sync.Mutex     // lock protects the rest of the queue
+	cond    *sync.Cond     // cond is signaled when the queue changes
+	writers []*groupWriter // writers is the list of queued writers
+	bytes   int64          // bytes is the entry data queued up in writers
+	window  chan struct{}  // window, if set, is closed once bytes fills a group
+}
+
+// groupWrite queues the entry up to be written as part of a group, and returns
+// the index of the entry once the group has been written (and synced.)
+func (l *WAL) groupWrite(e []byte) (int64, error) {
+	if e == nil {
+		return -1, ErrBadEntry
+	}
+	q := &l.group
+	w := &groupWriter{data: e}
+	// join the queue
+	q.lock.Lock()
+	q.writers = append(q.writers, w)
+	q.bytes += int64(len(e))
+	if q.window != nil && q.bytes >= l.conf.GroupCommitBytes {
+		// the group is full, so the leader can stop waiting
+		close(q.window)
+		q.window = nil
+	}
+	// wait until some other leader has written the entry, or
+	// until we make it to the head of the queue and take the lead
+	for !w.done && q.writers[0] != w {
+		q.cond.Wait()
+	}
+	if w.done {
+		q.lock.Unlock()
+		return w.index, w.err
+	}
+	// we are the leader, so wait a little for more writers to join the group
+	if l.conf.GroupCommitDelay > 0 && q.bytes < l.conf.GroupCommitBytes {
+		window := make(chan struct{})
+		q.window = window
+		q.lock.Unlock()
+		timer := time.NewTimer(l.conf.GroupCommitDelay)
+		select {
+		case <-timer.C:
+		case <-window:
+		}
+		timer.Stop()
+		q.lock.Lock()
+		if q.window == window {
+			q.window = nil
+		}
+	}
+	// take as many writers as fit in the group (but always at least ourselves)
+	n, size := 1, int64(len(e))
+	for ; n < len(q.writers); n++ {
+		if size+int64(len(q.writers[n].data)) > l.conf.GroupCommitBytes {
+			break
+		}
+		size += int64(len(q.writers[n].data))
+	}
+	group := q.writers[:n]
+	q.lock.Unlock()
+	// write the group, without holding the queue lock so more writers can join
+	l.commitGroup(group)
+	// remove the group from the queue, and wake up everyone waiting on it
+	q.lock.Lock()
+	for i := range group {
+		group[i].done = true
+	}
+	q.writers = q.writers[n:]
+	q.bytes -= size
+	q.cond.Broadcast()
+	q.lock.Unlock()
+	return w.index, w.err
+}
+
+// commitGroup writes every entry in the group and syncs once at the end. If a
+// write fails, that writer and every writer after it in the group get the error,
+// and whatever was written before it is still synced.
+func (l *WAL) commitGroup(group []*groupWriter) {
+	// lock
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	var err error
+	written := 0
+	for _, w := range group {
+		if err == nil {
+			w.index, err = l.write(w.data)
+		}
+		if err != nil {
+			w.index, w.err = -1, err
+			continue
+		}
+		written++
+	}
+	if written == 0 {
+		return
+	}
+	// check for sync
+	if l.conf.SyncOnWrite {
+		err = l.file.Sync()
+		if err != nil {
+			// nothing in the group is durable
+			for _, w := range group {
+				w.index, w.err = -1, err
+			}
+			return
+		}
+	}
+	// let any followers know there are new entries
+	l.markSynced()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
 	"os"
@@ -12,15 +13,17 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
-	FilePrefix               = "dat-"
-	FileSuffix               = ".seg"
-	defaultMaxFileSize int64 = 16 << 10 // 16 KB
-	defaultBasePath          = "log"
-	defaultSyncOnWrite       = false
-	remainingTrigger         = 64
+	FilePrefix                    = "dat-"
+	FileSuffix                    = ".seg"
+	defaultMaxFileSize      int64 = 16 << 10 // 16 KB
+	defaultBasePath               = "log"
+	defaultSyncOnWrite            = false
+	defaultGroupCommitBytes int64 = 1 << 20 // 1 MB
+	remainingTrigger              = 64
 )
 
 var (
@@ -36,11 +39,41 @@ var (
 var (
 	// ErrFileClosed    = errors.New("binary: file closed")
 	ErrBadEntry      = errors.New("binary: bad entry")
+	ErrBadChecksum   = errors.New("binary: bad entry checksum")
+	ErrEntryVersion  = errors.New("binary: unsupported entry version")
+	ErrSegmentFormat = errors.New("binary: segment is not in a known entry format")
 	ErrEntryNotFound = errors.New("binary: entry not found")
 	ErrKeyTooLarge   = errors.New("binary: key too large")
 	ErrValueTooLarge = errors.New("binary: value too large")
 )
 
+// Every entry is framed using a small header followed by the entry data. The
+// header holds a checksum, so that a partially written or otherwise damaged
+// entry can be detected when the log is opened again.
+//
+//	crc=uint32     offs=0-4   (4 bytes) CRC32C of the rest of the header and the data
+//	magic=uint8    offs=4     (1 byte)  always entryMagic
+//	version=uint8  offs=5     (1 byte)  version of the entry framing
+//	algo=uint8     offs=6     (1 byte)  compression used on the entry data (always 0 in version 1)
+//	reserved       offs=7     (1 byte)
+//	length=uint32  offs=8-12  (4 bytes) length of the (possibly compressed) entry data
+//
+// Older versions wrote entries without any framing (version 0), just the length
+// of the entry followed by the entry data. Segments holding those entries can
+// still be read, but they are never written to again.
+//
+//	length=uint64  offs=0-8   (8 bytes) length of the entry data
+const (
+	entryMagic            = 0xEA
+	entryVersion          = 2
+	entryHeaderSize       = 12
+	legacyEntryHeaderSize = 8
+	maxEntrySize          = 1<<32 - 1
+)
+
+// crc32cTable is the CRC32C (Castagnoli) table used for the entry checksums.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
 // segEntry contains the metadata for a single segEntry within the file segment
 type segEntry struct {
 	index  int64 // index is the "id" of this segEntry
@@ -58,6 +91,7 @@ type segment struct {
 	index     int64      // starting index of the segment
 	entries   []segEntry // entries is an index of the entries in the segment
 	remaining int64      // remaining is the bytes left after max file size minus segEntry data
+	legacy    bool       // legacy is set if the segment holds unframed (version 0) entries
 }
 
 // String is the stringer method for a segment
@@ -85,12 +119,22 @@ func (s *segment) getFirstIndex() int64 {
 	return s.index
 }
 
-// getLastIndex returns the last index in the entries list
+// getLastIndex returns the last index in the entries list. If the segment
+// has no entries, it returns the index before the starting index.
 func (s *segment) getLastIndex() int64 {
 	if len(s.entries) > 0 {
 		return s.entries[len(s.entries)-1].index
 	}
-	return s.index
+	return s.index - 1
+}
+
+// decodeEntryAt decodes the entry at the offset provided, using the entry
+// format the segment was written in
+func (s *segment) decodeEntryAt(r io.ReaderAt, off int64) ([]byte, error) {
+	if s.legacy {
+		return decodeLegacyEntryAt(r, off)
+	}
+	return decodeEntryAt(r, off)
 }
 
 // findEntryIndex performs binary search to find the segEntry containing provided index
@@ -119,6 +163,15 @@ type WALConfig struct {
 	BasePath    string // base storage path
 	MaxFileSize int64  // memtable flush threshold in KB
 	SyncOnWrite bool   // perform sync every time an entry is write
+	// Compression is the algorithm used to compress the entries. Entries that
+	// do not get any smaller are written uncompressed.
+	Compression Compression
+	// GroupCommit makes concurrent calls to Write queue up and get written
+	// as a group, with a single sync for the whole group. It is only really
+	// worth it along with SyncOnWrite.
+	GroupCommit      bool
+	GroupCommitDelay time.Duration // how long a group waits for more writers to join it
+	GroupCommitBytes int64         // max bytes of entry data written in a single group
 }
 
 func checkWALConfig(conf *WALConfig) *WALConfig {
@@ -131,6 +184,9 @@ func checkWALConfig(conf *WALConfig) *WALConfig {
 	if conf.MaxFileSize < 1 {
 		conf.MaxFileSize = defaultMaxFileSize
 	}
+	if conf.GroupCommitBytes < 1 {
+		conf.GroupCommitBytes = defaultGroupCommitBytes
+	}
 	return conf
 }
 
@@ -141,10 +197,15 @@ type WAL struct {
 	// r          *binenc.Reader // r is a binary reader
 	// w          *binenc.Writer // w is a binary writer
 	file       *os.File
-	firstIndex int64      // firstIndex is the index of the first segEntry
-	lastIndex  int64      // lastIndex is the index of the last segEntry
-	segments   []*segment // segments is an index of the current file segments
-	active     *segment   // active is the current active segment
+	firstIndex int64         // firstIndex is the index of the first segEntry
+	lastIndex  int64         // lastIndex is the index of the last segEntry
+	synced     int64         // synced is the index of the last segEntry known to be synced
+	segments   []*segment    // segments is an index of the current file segments
+	active     *segment      // active is the current active segment
+	group      groupQueue    // group is the queue of writers waiting for a group commit
+	notify     chan struct{} // notify, if set, is closed the next time the log changes
+	cuts       int64         // cuts is the number of times TruncateBack removed entries
+	cutFrom    int64         // cutFrom is the first index removed by the last TruncateBack
 }
 
 // OpenWAL opens and returns a new write-ahead log structure
@@ -159,7 +220,7 @@ func OpenWAL(c *WALConfig) (*WAL, error) {
 	// sanitize any path separators
 	base = filepath.ToSlash(base)
 	// create any directories if they are not there
-	err = os.MkdirAll(base, os.ModeDir)
+	err = os.MkdirAll(base, os.ModeDir|0755)
 	if err != nil {
 		return nil, err
 	}
@@ -167,9 +228,10 @@ func OpenWAL(c *WALConfig) (*WAL, error) {
 	l := &WAL{
 		conf:       conf,
 		firstIndex: 0,
-		lastIndex:  1,
+		lastIndex:  0,
 		segments:   make([]*segment, 0),
 	}
+	l.group.cond = sync.NewCond(&l.group.lock)
 	// attempt to load segments
 	err = l.loadIndex()
 	if err != nil {
@@ -196,7 +258,10 @@ func (l *WAL) CloseAndRemove() error {
 	l.segments = make([]*segment, 0)
 	// reset first and last index
 	l.firstIndex = 0
-	l.lastIndex = 1
+	l.lastIndex = 0
+	l.synced = 0
+	// let any followers know the log is gone
+	l.wakeFollowers()
 	// erase all files
 	err = os.RemoveAll(l.conf.BasePath)
 	if err != nil {
@@ -219,6 +284,7 @@ func (l *WAL) loadIndex() error {
 		return err
 	}
 	// list the files in the base directory path and attempt to index the entries
+	var paths []string
 	for _, file := range files {
 		// skip non data files
 		if file.IsDir() ||
@@ -240,18 +306,28 @@ func (l *WAL) loadIndex() error {
 			}
 			continue // make sure we skip to next segment
 		}
-		// attempt to load segment (and index entries in segment)
-		s, err := l.loadSegmentFile(fullPath)
+		paths = append(paths, fullPath)
+	}
+	for i, path := range paths {
+		// attempt to load segment (and index entries in segment). Only the
+		// last segment can end in a torn write, so only that one is repaired.
+		s, err := l.loadSegmentFile(path, i == len(paths)-1)
 		if err != nil {
 			return err
 		}
 		// segment has been loaded successfully, append to the segments list
 		l.segments = append(l.segments, s)
 	}
-	// check to see if any segments were found. If not, initialize a new one
-	if len(l.segments) == 0 {
+	// check to see if any segments were found. If not, initialize a new one. A
+	// new one is also needed if the last segment holds unframed entries, as
+	// those are never appended to.
+	if len(l.segments) == 0 || l.getLastSegment().legacy {
+		next := l.lastIndex + 1
+		if len(l.segments) > 0 {
+			next = l.getLastSegment().getLastIndex() + 1
+		}
 		// create a new segment file
-		s, err := l.makeSegmentFile(l.lastIndex)
+		s, err := l.makeSegmentFile(next)
 		if err != nil {
 			return err
 		}
@@ -264,7 +340,7 @@ func (l *WAL) loadIndex() error {
 	l.active = l.getLastSegment()
 	// we should be good to go, lets attempt to open a file to work
 	// with the active segment.
-	l.file, err = os.OpenFile(l.active.path, os.O_WRONLY|os.O_SYNC, 0644)
+	l.file, err = l.openWriter(l.active.path)
 	if err != nil {
 		return err
 	}
@@ -277,17 +353,22 @@ func (l *WAL) loadIndex() error {
 	l.firstIndex = l.segments[0].index
 	// and update last index
 	l.lastIndex = l.getLastSegment().getLastIndex()
+	// everything that made it into the segment files is as synced as it gets
+	l.synced = l.lastIndex
 	return nil
 }
 
 // loadSegment attempts to open the segment file at the path provided
 // and index the entries within the segment. It will return an os.PathError
-// if the file does not exist, an io.ErrUnexpectedEOF if the file exists
-// but is empty and has no data to read, and ErrSegmentFull if the file
-// has met the maxFileSize. It will return the segment and nil error on success.
-func (l *WAL) loadSegmentFile(path string) (*segment, error) {
+// if the file does not exist, and an error if any of the entries in the
+// segment are damaged. If repair is set, a damaged or partially written
+// final entry is treated as a torn write instead: it is truncated off the
+// end of the file. Segments written in the older, unframed, format are loaded
+// as legacy segments, which are never repaired. It will return the segment
+// and nil error on success.
+func (l *WAL) loadSegmentFile(path string, repair bool) (*segment, error) {
 	// check to make sure path exists before continuing
-	_, err := os.Stat(path)
+	fi, err := os.Stat(path)
 	if err != nil {
 		return nil, err
 	}
@@ -310,22 +391,38 @@ func (l *WAL) loadSegmentFile(path string) (*segment, error) {
 	if err != nil {
 		return nil, err
 	}
-	for {
-		// get the current offset of the
-		// reader for the segEntry later
-		offset, err := fd.Seek(0, io.SeekCurrent)
+	s.index = index
+	// offset is the offset of the next segEntry
+	var offset int64
+	for offset < fi.Size() {
+		// read and decode segEntry, making sure it does not
+		// claim to be any larger than what is left of the file
+		n, err := readEntry(fd, fi.Size()-offset, nil)
 		if err != nil {
-			return nil, err
-		}
-		// read and decode segEntry
-		_, err = decodeEntry(fd)
-		if err != nil {
-			if err == io.EOF || err == io.ErrUnexpectedEOF {
-				break
+			if err == ErrEntryVersion {
+				return nil, err
 			}
-			return nil, err
+			// only the final frame of the file can be a torn write,
+			// anything damaged before that is real corruption
+			torn, terr := isTornTail(fd, offset, fi.Size())
+			if terr != nil {
+				return nil, terr
+			}
+			if !torn || !repair {
+				if offset == 0 && err == ErrBadEntry && !torn {
+					// the very first entry is not framed, so the
+					// segment was written by an older version
+					return loadLegacySegment(fd, s, fi.Size())
+				}
+				return nil, err
+			}
+			// torn write, drop the tail of the file
+			err = os.Truncate(path, offset)
+			if err != nil {
+				return nil, err
+			}
+			break
 		}
-		// get current offset
 		// add segEntry index to segment entries list
 		s.entries = append(
 			s.entries, segEntry{
@@ -334,20 +431,89 @@ func (l *WAL) loadSegmentFile(path string) (*segment, error) {
 			},
 		)
 		// continue to process the next segEntry
+		offset += n
 		index++
 	}
 	// make sure to fill out the segment index from the first segEntry index
-	s.index = s.entries[0].index
-	// get the offset of the reader to calculate bytes remaining
-	offset, err := fd.Seek(0, io.SeekCurrent)
-	if err != nil {
-		return nil, err
+	if len(s.entries) > 0 {
+		s.index = s.entries[0].index
 	}
 	// update the segment remaining bytes
 	s.remaining = l.conf.MaxFileSize - offset
 	return s, nil
 }
 
+// loadLegacySegment indexes the entries of a segment file written in the older,
+// unframed, format. Every entry has to be whole, otherwise ErrSegmentFormat is
+// returned. Legacy segments are read-only, so the segment is returned full.
+func loadLegacySegment(fd *os.File, s *segment, size int64) (*segment, error) {
+	var hdr [legacyEntryHeaderSize]byte
+	index := s.index
+	var offset int64
+	for offset < size {
+		if size-offset < legacyEntryHeaderSize {
+			return nil, ErrSegmentFormat
+		}
+		_, err := fd.ReadAt(hdr[:], offset)
+		if err != nil {
+			return nil, err
+		}
+		length := binary.LittleEndian.Uint64(hdr[:])
+		if length > uint64(size-offset-legacyEntryHeaderSize) {
+			return nil, ErrSegmentFormat
+		}
+		s.entries = append(
+			s.entries, segEntry{
+				index:  index,
+				offset: offset,
+			},
+		)
+		offset += legacyEntryHeaderSize + int64(length)
+		index++
+	}
+	s.legacy = true
+	s.remaining = 0
+	return s, nil
+}
+
+// isTornTail returns a boolean indicating true if the damaged entry at the
+// offset provided can be the result of a torn write. That is the case if the
+// entry is the final frame in the file (its header claims it runs up to or past
+// the end of the file), or if everything from the offset on is zeroed out.
+func isTornTail(fd *os.File, offset, size int64) (bool, error) {
+	rest := make([]byte, size-offset)
+	_, err := fd.ReadAt(rest, offset)
+	if err != nil {
+		return false, err
+	}
+	if len(rest) < entryHeaderSize {
+		return true, nil
+	}
+	if rest[4] == entryMagic {
+		length := int64(binary.LittleEndian.Uint32(rest[8:12]))
+		if entryHeaderSize+length >= int64(len(rest)) {
+			return true, nil
+		}
+	}
+	for _, b := range rest {
+		if b != 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// openWriter opens the segment file at the path provided for writing. If every
+// write is followed by a sync anyway, the file is not opened using O_SYNC,
+// otherwise it would be synced twice (and group commits would not save any.)
+func (l *WAL) openWriter(path string) (*os.File, error) {
+	flag := os.O_WRONLY
+	if !l.conf.SyncOnWrite {
+		flag |= os.O_SYNC
+	}
+	return os.OpenFile(path, flag, 0644)
+}
+
 // makeSegment attempts to make a new segment automatically using the timestamp
 // as the segment name. On success, it will simply return a new segment and a nil error
 func (l *WAL) makeSegmentFile(index int64) (*segment, error) {
@@ -365,7 +531,7 @@ func (l *WAL) makeSegmentFile(index int64) (*segment, error) {
 	// create and return new segment
 	s := &segment{
 		path:      path,
-		index:     l.lastIndex,
+		index:     index,
 		entries:   make([]segEntry, 0),
 		remaining: l.conf.MaxFileSize,
 	}
@@ -405,7 +571,7 @@ func (l *WAL) cycleSegment() error {
 		return err
 	}
 	// create a new segment file
-	s, err := l.makeSegmentFile(l.lastIndex)
+	s, err := l.makeSegmentFile(l.lastIndex + 1)
 	if err != nil {
 		return err
 	}
@@ -414,7 +580,7 @@ func (l *WAL) cycleSegment() error {
 	// update the active segment pointer
 	l.active = l.getLastSegment()
 	// open file writer associated with active segment
-	l.file, err = os.OpenFile(l.active.path, os.O_WRONLY|os.O_SYNC, 0644)
+	l.file, err = l.openWriter(l.active.path)
 	if err != nil {
 		return err
 	}
@@ -426,37 +592,33 @@ func (l *WAL) Read(index int64) ([]byte, error) {
 	// read lock
 	l.lock.RLock()
 	defer l.lock.RUnlock()
+	return l.read(index)
+}
+
+// read reads an segEntry from the write-ahead log at the specified index. The
+// caller must hold the lock.
+func (l *WAL) read(index int64) ([]byte, error) {
 	// error checking
 	if index < l.firstIndex || index > l.lastIndex {
 		return nil, ErrOutOfBounds
 	}
-	var err error
 	// find the segment containing the provided index
 	s := l.segments[l.findSegmentIndex(index)]
 	// find the offset for the segEntry containing the provided index
 	offset := s.entries[s.findEntryIndex(index)].offset
-	// check to see if we need to be reading from a different file
-	if l.file.Name() == s.path {
-		// different file, let's open it
-		tmpf, err := os.Open(s.path)
-		if err != nil {
-			return nil, err
-		}
-		// read and decode entry at offset
-		e, err := decodeEntryAt(tmpf, offset)
-		if err != nil {
-			return nil, err
-		}
-		// close reader
-		err = tmpf.Close()
-		if err != nil {
-			return nil, err
-		}
-		// return
-		return e, nil
+	// the writer is write only, so we always open the segment for reading
+	tmpf, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	// read and decode entry at offset
+	e, err := s.decodeEntryAt(tmpf, offset)
+	if err != nil {
+		_ = tmpf.Close()
+		return nil, err
 	}
-	// otherwise, we are reading from the same file, so just decode at
-	e, err := decodeEntryAt(l.file, offset)
+	// close reader
+	err = tmpf.Close()
 	if err != nil {
 		return nil, err
 	}
@@ -465,11 +627,15 @@ func (l *WAL) Read(index int64) ([]byte, error) {
 
 // Write writes an segEntry to the write-ahead log in an append-only fashion
 func (l *WAL) Write(e []byte) (int64, error) {
+	// hand it off to the group commit queue, if enabled
+	if l.conf.GroupCommit {
+		return l.groupWrite(e)
+	}
 	// lock
 	l.lock.Lock()
 	defer l.lock.Unlock()
-	// first, encode entry
-	offset, err := encodeEntry(l.file, e)
+	// write the entry
+	index, err := l.write(e)
 	if err != nil {
 		return -1, err
 	}
@@ -480,6 +646,23 @@ func (l *WAL) Write(e []byte) (int64, error) {
 			return -1, err
 		}
 	}
+	// let any followers know there is a new entry
+	l.markSynced()
+	return index, nil
+}
+
+// write encodes and appends an entry to the active segment, cycling the
+// segment if it is full, and returns the index of the entry. Readers do not
+// see the entry until the caller syncs it and calls markSynced. The caller
+// must hold the lock.
+func (l *WAL) write(e []byte) (int64, error) {
+	// first, encode entry
+	offset, err := encodeEntry(l.file, e, l.conf.Compression)
+	if err != nil {
+		return -1, err
+	}
+	// update lastIndex
+	l.lastIndex++
 	// add new segEntry to the segment index
 	l.active.entries = append(
 		l.active.entries, segEntry{
@@ -487,12 +670,10 @@ func (l *WAL) Write(e []byte) (int64, error) {
 			offset: offset,
 		},
 	)
-	// update lastIndex
-	l.lastIndex++
 	// grab the current offset written
 	offset2, err := l.file.Seek(0, io.SeekCurrent)
 	if err != nil {
-		return 0, err
+		return -1, err
 	}
 	// update segment remaining
 	l.active.remaining -= offset2 - offset
@@ -500,16 +681,32 @@ func (l *WAL) Write(e []byte) (int64, error) {
 	if l.active.remaining < remainingTrigger {
 		err = l.cycleSegment()
 		if err != nil {
-			return 0, err
+			return -1, err
 		}
 	}
-	return l.lastIndex - 1, nil
+	return l.lastIndex, nil
 }
 
+// Batch is a list of entries that are written to the write-ahead log together
 type Batch struct {
 	data [][]byte
 }
 
+// Write adds an entry to the batch
+func (b *Batch) Write(e []byte) {
+	b.data = append(b.data, e)
+}
+
+// Len returns the number of entries in the batch
+func (b *Batch) Len() int {
+	return len(b.data)
+}
+
+// Clear removes all the entries from the batch so that it can be reused
+func (b *Batch) Clear() {
+	b.data = b.data[:0]
+}
+
 // WriteBatch writes a batch of entries performing no syncing until the end of the batch
 func (l *WAL) WriteBatch(batch *Batch) error {
 	// lock
@@ -517,42 +714,19 @@ func (l *WAL) WriteBatch(batch *Batch) error {
 	defer l.lock.Unlock()
 	// iterate batch
 	for i := range batch.data {
-		// entry
-		e := batch.data[i]
 		// write entry to data file
-		offset, err := l.Write(e)
+		_, err := l.write(batch.data[i])
 		if err != nil {
 			return err
 		}
-		// add new segEntry to the segment index
-		l.active.entries = append(
-			l.active.entries, segEntry{
-				index:  l.lastIndex,
-				offset: offset,
-			},
-		)
-		// update lastIndex
-		l.lastIndex++
-		// grab the current offset written
-		offset2, err := l.file.Seek(0, io.SeekCurrent)
-		if err != nil {
-			return err
-		}
-		// update segment remaining
-		l.active.remaining -= offset2 - offset
-		// check to see if the active segment needs to be cycled
-		if l.active.remaining < remainingTrigger {
-			err = l.cycleSegment()
-			if err != nil {
-				return err
-			}
-		}
 	}
 	// after batch has been written, do sync
 	err := l.file.Sync()
 	if err != nil {
 		return err
 	}
+	// let any followers know there are new entries
+	l.markSynced()
 	return nil
 }
 
@@ -575,7 +749,7 @@ func (l *WAL) Scan(iter func(e []byte) bool) error {
 		// range the segment entries index
 		for _, eidx := range sidx.entries {
 			// read and decode entry at offset
-			e, err := decodeEntryAt(tmpf, eidx.offset)
+			e, err := sidx.decodeEntryAt(tmpf, eidx.offset)
 			if err != nil {
 				if err == io.EOF || err == io.ErrUnexpectedEOF {
 					break
@@ -632,6 +806,8 @@ func (l *WAL) TruncateFront(index int64) error {
 	l.segments = l.segments[:len(l.segments)-j+i]
 	// update firstIndex
 	l.firstIndex = l.segments[0].index
+	// let any followers know entries have been removed
+	defer l.wakeFollowers()
 	// prepare to re-write partial segment
 	var err error
 	var entries []segEntry
@@ -664,12 +840,12 @@ func (l *WAL) TruncateFront(index int64) error {
 				continue // skip
 			}
 			// read segEntry
-			e, err := decodeEntryAt(rd, ent.offset)
+			e, err := l.segments[0].decodeEntryAt(rd, ent.offset)
 			if err != nil {
 				return err
 			}
 			// write segEntry to temp file
-			ent.offset, err = encodeEntry(tmpfd, e)
+			ent.offset, err = encodeEntry(tmpfd, e, l.conf.Compression)
 			if err != nil {
 				return err
 			}
@@ -706,13 +882,101 @@ func (l *WAL) TruncateFront(index int64) error {
 		// update segment
 		l.segments[0].entries = entries
 		l.segments[0].index = entries[0].index
+		l.segments[0].legacy = false
+		l.firstIndex = l.segments[0].index
 	}
 	// re-open file writer associated with active segment
 	l.active = l.getLastSegment()
-	l.file, err = os.OpenFile(l.active.path, os.O_WRONLY|os.O_SYNC, 0644)
+	l.file, err = l.openWriter(l.active.path)
+	if err != nil {
+		return err
+	}
+	// don't forget to seek to the end of the file.
+	_, err = l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// TruncateBack removes all segments and entries after specified index. The
+// entry at the specified index is kept, and becomes the last entry in the
+// write-ahead log, so the next entry written gets the index following it.
+func (l *WAL) TruncateBack(index int64) error {
+	// lock
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	// perform bounds check
+	if l.lastIndex == 0 ||
+		index < l.firstIndex || index > l.lastIndex {
+		return ErrOutOfBounds
+	}
+	if index == l.lastIndex {
+		return nil // nothing to truncate
+	}
+	// let any followers know which entries are going away
+	l.cuts++
+	l.cutFrom = index + 1
+	// sync and close current file pointer
+	err := l.file.Sync()
+	if err != nil {
+		return err
+	}
+	err = l.file.Close()
+	if err != nil {
+		return err
+	}
+	// locate segment in segment index list containing specified index
+	sidx := l.findSegmentIndex(index)
+	// remove the whole segments following it, starting with the last one so
+	// the segments left behind are always contiguous if we happen to crash
+	for i := len(l.segments) - 1; i > sidx; i-- {
+		err = os.Remove(filepath.ToSlash(l.segments[i].path))
+		if err != nil {
+			return err
+		}
+		l.segments[i] = nil
+	}
+	l.segments = l.segments[:sidx+1]
+	// cut the entries following the specified index off of the partial segment
+	s := l.segments[sidx]
+	eidx := s.findEntryIndex(index)
+	if eidx+1 < len(s.entries) {
+		err = os.Truncate(s.path, s.entries[eidx+1].offset)
+		if err != nil {
+			return err
+		}
+		s.entries = s.entries[:eidx+1]
+	}
+	// the partial segment becomes the active segment
+	fi, err := os.Stat(s.path)
+	if err != nil {
+		return err
+	}
+	s.remaining = l.conf.MaxFileSize - fi.Size()
+	l.active = s
+	// re-open file writer associated with active segment
+	l.file, err = l.openWriter(l.active.path)
 	if err != nil {
 		return err
 	}
+	// don't forget to seek to the end of the file.
+	_, err = l.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	// update lastIndex
+	l.lastIndex = index
+	if l.synced > index {
+		l.synced = index
+	}
+	// let any followers know entries have been removed
+	l.wakeFollowers()
+	// check to see if the active segment needs to be cycled, which is always
+	// the case if it holds unframed entries, as those are never appended to
+	if l.active.legacy || l.active.remaining < remainingTrigger {
+		return l.cycleSegment()
+	}
 	return nil
 }
 
@@ -731,6 +995,8 @@ func (l *WAL) Sync() error {
 	if err != nil {
 		return err
 	}
+	// let any followers know about entries that were not synced before
+	l.markSynced()
 	return nil
 }
 
@@ -756,7 +1022,7 @@ func (l *WAL) FirstIndex() int64 {
 	return l.firstIndex
 }
 
-// LastIndex returns the write-ahead logs first index
+// LastIndex returns the write-ahead logs last index
 func (l *WAL) LastIndex() int64 {
 	// lock
 	l.lock.Lock()
@@ -782,8 +1048,11 @@ func (l *WAL) Close() error {
 	l.file = nil
 	l.firstIndex = 0
 	l.lastIndex = 0
+	l.synced = 0
 	l.segments = nil
 	l.active = nil
+	// let any followers know the log is closed
+	l.wakeFollowers()
 	// force gc for good measure
 	runtime.GC()
 	return nil
@@ -813,27 +1082,39 @@ func (l *WAL) String() string {
 	return ss
 }
 
-// encodeEntry writes the provided entry to the writer provided
-func encodeEntry(w io.WriteSeeker, e []byte) (int64, error) {
+// encodeEntry writes the provided entry to the writer provided, compressing
+// it using the provided algorithm if that makes it any smaller
+func encodeEntry(w io.WriteSeeker, e []byte, c Compression) (int64, error) {
 	// error check
 	if e == nil {
 		return -1, ErrBadEntry
 	}
-	// get the file pointer offset for the entry
-	offset, err := w.Seek(0, io.SeekCurrent)
+	if int64(len(e)) > maxEntrySize {
+		return -1, ErrValueTooLarge
+	}
+	// compress entry
+	e, c, err := compressEntry(c, e)
 	if err != nil {
 		return -1, err
 	}
-	// make buffer
-	buf := make([]byte, 8)
-	// encode and write entry length
-	binary.LittleEndian.PutUint64(buf, uint64(len(e)))
-	_, err = w.Write(buf)
+	// get the file pointer offset for the entry
+	offset, err := w.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return -1, err
 	}
-	// write entry
-	_, err = w.Write(e)
+	// make buffer to hold the header and the entry, so
+	// the whole thing is written using a single write
+	buf := make([]byte, entryHeaderSize+len(e))
+	// encode the header
+	buf[4] = entryMagic
+	buf[5] = entryVersion
+	buf[6] = byte(c)
+	binary.LittleEndian.PutUint32(buf[8:12], uint32(len(e)))
+	// copy in the entry, and checksum everything after the checksum
+	copy(buf[entryHeaderSize:], e)
+	binary.LittleEndian.PutUint32(buf[0:4], crc32.Checksum(buf[4:], crc32cTable))
+	// write header and entry
+	_, err = w.Write(buf)
 	if err != nil {
 		return -1, err
 	}
@@ -841,46 +1122,84 @@ func encodeEntry(w io.WriteSeeker, e []byte) (int64, error) {
 	return offset, nil
 }
 
-// decodeEntry encodes the next entry from the reader provided
-func decodeEntry(r io.Reader) ([]byte, error) {
-	// make buffer
-	buf := make([]byte, 8)
-	// read entry length
-	_, err := r.Read(buf)
+// readEntry reads and verifies the next entry from the reader provided. The
+// limit is the number of bytes that can be read from the reader; an entry that
+// claims to be larger than that is reported as partially written. If e is not
+// nil, the entry data is stored in it. It returns the number of bytes read.
+func readEntry(r io.Reader, limit int64, e *[]byte) (int64, error) {
+	// read entry header
+	var hdr [entryHeaderSize]byte
+	_, err := io.ReadFull(r, hdr[:])
 	if err != nil {
-		return nil, err
+		return 0, err
+	}
+	// check the framing
+	if hdr[4] != entryMagic {
+		return 0, ErrBadEntry
+	}
+	if hdr[5] < 1 || hdr[5] > entryVersion {
+		return 0, ErrEntryVersion
 	}
 	// decode entry length
-	size := binary.LittleEndian.Uint64(buf)
+	size := int64(binary.LittleEndian.Uint32(hdr[8:12]))
+	if size > limit-entryHeaderSize {
+		return 0, io.ErrUnexpectedEOF
+	}
 	// make entry slice to read data into
-	e := make([]byte, size)
+	data := make([]byte, size)
 	// read from data into entry
-	_, err = r.Read(e)
+	_, err = io.ReadFull(r, data)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	// verify the checksum
+	crc := crc32.Update(crc32.Checksum(hdr[4:], crc32cTable), crc32cTable, data)
+	if crc != binary.LittleEndian.Uint32(hdr[0:4]) {
+		return 0, ErrBadChecksum
+	}
+	// only decompress the entry data if the caller wants it
+	if e != nil {
+		*e, err = decompressEntry(Compression(hdr[6]), data)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return entryHeaderSize + size, nil
+}
+
+// decodeEntry decodes the next entry from the reader provided
+func decodeEntry(r io.Reader) ([]byte, error) {
+	var e []byte
+	_, err := readEntry(r, entryHeaderSize+maxEntrySize, &e)
 	if err != nil {
 		return nil, err
 	}
-	// return entry
 	return e, nil
 }
 
-// decodeEntryAt encodes the next entry from the reader provided
+// decodeEntryAt decodes the entry at the offset provided
 func decodeEntryAt(r io.ReaderAt, off int64) ([]byte, error) {
-	// make buffer
-	buf := make([]byte, 8)
-	// read entry length
-	n, err := r.ReadAt(buf, off)
+	return decodeEntry(io.NewSectionReader(r, off, entryHeaderSize+maxEntrySize))
+}
+
+// decodeLegacyEntryAt decodes the unframed (version 0) entry at the offset provided
+func decodeLegacyEntryAt(r io.ReaderAt, off int64) ([]byte, error) {
+	var hdr [legacyEntryHeaderSize]byte
+	_, err := r.ReadAt(hdr[:], off)
 	if err != nil {
 		return nil, err
 	}
-	// decode entry length
-	size := binary.LittleEndian.Uint64(buf)
-	// make entry slice to read data into
+	size := binary.LittleEndian.Uint64(hdr[:])
+	if size > maxEntrySize {
+		return nil, ErrBadEntry
+	}
 	e := make([]byte, size)
-	// read from data into entry
-	_, err = r.ReadAt(e, off+int64(n))
+	_, err = r.ReadAt(e, off+legacyEntryHeaderSize)
 	if err != nil {
 		return nil, err
 	}
-	// return entry
 	return e, nil
 }
@@ -130,3 +130,45 @@ func reset(b *testing.B) {
 	b.ResetTimer()
 	b.ReportAllocs()
 }
+
+func BenchmarkWAL_GroupCommit(b *testing.B) {
+	for _, group := range []bool{false, true} {
+		name := "Single"
+		if group {
+			name = "Group"
+		}
+		b.Run(
+			name, func(b *testing.B) {
+				wal, err := OpenWAL(
+					&WALConfig{
+						BasePath:    "wal-testing-bench",
+						MaxFileSize: 4 << 20,
+						SyncOnWrite: true,
+						GroupCommit: group,
+					},
+				)
+				if err != nil {
+					b.Fatalf("open: %v\n", err)
+				}
+				defer func() {
+					err := wal.CloseAndRemove()
+					if err != nil {
+						b.Fatalf("close and remove: %v\n", err)
+					}
+				}()
+				b.SetParallelism(16)
+				reset(b)
+				b.RunParallel(
+					func(pb *testing.PB) {
+						for i := 0; pb.Next(); i++ {
+							_, err := wal.Write(makeEntry(i))
+							if err != nil {
+								b.Errorf("write: %v\n", err)
+							}
+						}
+					},
+				)
+			},
+		)
+	}
+}
@@ -1,8 +1,15 @@
 package v2
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
+	"io"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
@@ -198,6 +205,845 @@ func TestLog_TruncateFront(t *testing.T) {
 	}
 }
 
+func TestLog_TornTail(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:    "wal-testing-torn",
+		MaxFileSize: -1,
+	}
+	defer os.RemoveAll(tconf.BasePath)
+	//
+	// open log and do some writing
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	for i := 0; i < 100; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("key-%04d-my-value-%06d", i+1, i+1)))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+	}
+	count := wal.Count()
+	path := wal.active.path
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	size := fi.Size()
+	//
+	// simulate a torn write by appending part of an entry
+	fd, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	_, err = encodeEntry(fd, []byte("this entry never made it all the way to disk"), NoCompression)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = fd.Truncate(size + entryHeaderSize + 8)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = fd.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	//
+	// reopen, the partial entry should be gone
+	wal, err = OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("opening with torn tail: %v\n", err)
+	}
+	if got := wal.Count(); got != count {
+		t.Errorf("count: got %d, want %d\n", got, count)
+	}
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if fi.Size() != size {
+		t.Errorf("size: got %d, want %d\n", fi.Size(), size)
+	}
+	//
+	// flip a bit in the data of the last entry, which should be dropped too
+	last := wal.active.entries[len(wal.active.entries)-1].offset
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	fd, err = os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	b := make([]byte, 1)
+	_, err = fd.ReadAt(b, last+entryHeaderSize)
+	if err == nil {
+		b[0] ^= 0x01
+		_, err = fd.WriteAt(b, last+entryHeaderSize)
+	}
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = fd.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	_, err = decodeEntryAt(bytesReaderAt(path, t), last)
+	if err != ErrBadChecksum {
+		t.Errorf("decoding corrupt entry: got %v, want %v\n", err, ErrBadChecksum)
+	}
+	wal, err = OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("opening with corrupt tail: %v\n", err)
+	}
+	if got := wal.Count(); got != count-1 {
+		t.Errorf("count: got %d, want %d\n", got, count-1)
+	}
+	//
+	// the log should still be usable
+	data := []byte("written after the repair")
+	_, err = wal.Write(data)
+	if err != nil {
+		t.Fatalf("error writing: %v\n", err)
+	}
+	var found bool
+	err = wal.Scan(
+		func(e []byte) bool {
+			found = found || string(e) == string(data)
+			return true
+		},
+	)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if !found {
+		t.Errorf("entry written after the repair was not found\n")
+	}
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+}
+
+// bytesReaderAt returns a reader over the contents of the file at the provided path
+func bytesReaderAt(path string, t *testing.T) io.ReaderAt {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	return bytes.NewReader(b)
+}
+
+func TestLog_NotRepaired(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:    "wal-testing-not-repaired",
+		MaxFileSize: -1,
+	}
+	defer os.RemoveAll(tconf.BasePath)
+	//
+	// damage an entry in the middle of the last segment, which is not a
+	// torn write either, so the segment should be left alone
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	for i := 0; i < 10; i++ {
+		_, err := wal.Write([]byte(fmt.Sprintf("key-%04d-my-value-%06d", i+1, i+1)))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+	}
+	path := wal.active.path
+	middle := wal.active.entries[5].offset
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	fd, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	_, err = fd.WriteAt([]byte{'X'}, middle+entryHeaderSize)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = fd.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	_, err = OpenWAL(tconf)
+	if err != ErrBadChecksum {
+		t.Fatalf("opening with corrupt entry: got %v, want %v\n", err, ErrBadChecksum)
+	}
+	size := fi.Size()
+	fi, err = os.Stat(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if fi.Size() != size {
+		t.Errorf("size: got %d, want %d\n", fi.Size(), size)
+	}
+}
+
+func TestLog_LegacySegment(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:    "wal-testing-legacy",
+		MaxFileSize: -1,
+	}
+	defer os.RemoveAll(tconf.BasePath)
+	err := os.MkdirAll(tconf.BasePath, 0755)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	entry := func(i int64) []byte {
+		return []byte(fmt.Sprintf("key-%04d-my-value-%06d", i, i))
+	}
+	//
+	// write a segment in the older format (just the length, and then the
+	// entry), which should still be readable
+	var buf bytes.Buffer
+	for i := int64(1); i <= 3; i++ {
+		var hdr [8]byte
+		binary.LittleEndian.PutUint64(hdr[:], uint64(len(entry(i))))
+		buf.Write(hdr[:])
+		buf.Write(entry(i))
+	}
+	path := filepath.Join(tconf.BasePath, MakeFileNameFromIndex(1))
+	err = os.WriteFile(path, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("opening older segment: %v\n", err)
+	}
+	if got := wal.LastIndex(); got != 3 {
+		t.Fatalf("last index: got %d, want %d\n", got, 3)
+	}
+	//
+	// new entries go in a new segment, using the framed format
+	for i := int64(4); i <= 6; i++ {
+		n, err := wal.Write(entry(i))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+		if n != i {
+			t.Fatalf("index: got %d, want %d\n", n, i)
+		}
+	}
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if !bytes.Equal(b, buf.Bytes()) {
+		t.Errorf("older segment was changed\n")
+	}
+	wal, err = OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if len(wal.segments) != 2 || !wal.segments[0].legacy || wal.segments[1].legacy {
+		t.Fatalf("segments: %s\n", wal)
+	}
+	for i := int64(1); i <= 6; i++ {
+		e, err := wal.Read(i)
+		if err != nil {
+			t.Fatalf("reading %d: %v\n", i, err)
+		}
+		if !bytes.Equal(e, entry(i)) {
+			t.Errorf("entry %d: got %q, want %q\n", i, e, entry(i))
+		}
+	}
+	//
+	// cutting back into the older segment does not make it writable
+	err = wal.TruncateBack(2)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	n, err := wal.Write(entry(3))
+	if err != nil {
+		t.Fatalf("error writing: %v\n", err)
+	}
+	if n != 3 || wal.active.legacy {
+		t.Fatalf("index: got %d, want %d (active: %s)\n", n, 3, wal.active)
+	}
+	//
+	// and neither does cutting the front off of it, which rewrites it framed
+	err = wal.TruncateFront(2)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	wal, err = OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	defer wal.Close()
+	if wal.segments[0].legacy {
+		t.Errorf("segment rewritten by TruncateFront is still legacy\n")
+	}
+	for i := int64(2); i <= 3; i++ {
+		e, err := wal.Read(i)
+		if err != nil {
+			t.Fatalf("reading %d: %v\n", i, err)
+		}
+		if !bytes.Equal(e, entry(i)) {
+			t.Errorf("entry %d: got %q, want %q\n", i, e, entry(i))
+		}
+	}
+}
+
+func TestLog_TruncateBack(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:    "wal-testing-back",
+		MaxFileSize: 1 << 10,
+	}
+	defer os.RemoveAll(tconf.BasePath)
+	entry := func(i int64, gen int) []byte {
+		return []byte(fmt.Sprintf("entry-%06d-gen-%d-%s", i, gen, smVal))
+	}
+	//
+	// check verifies the index bounds, and that every entry holds what we expect
+	check := func(wal *WAL, last int64, gen func(i int64) int) {
+		if got := wal.FirstIndex(); got != 1 {
+			t.Errorf("first index: got %d, want %d\n", got, 1)
+		}
+		if got := wal.LastIndex(); got != last {
+			t.Errorf("last index: got %d, want %d\n", got, last)
+		}
+		if got := wal.Count(); got != int(last) {
+			t.Errorf("count: got %d, want %d\n", got, last)
+		}
+		for i := int64(1); i <= last; i++ {
+			e, err := wal.Read(i)
+			if err != nil {
+				t.Fatalf("reading %d: %v\n", i, err)
+			}
+			if want := entry(i, gen(i)); !bytes.Equal(e, want) {
+				t.Fatalf("reading %d: got %q, want %q\n", i, e[:20], want[:20])
+			}
+		}
+		_, err := wal.Read(last + 1)
+		if err != ErrOutOfBounds {
+			t.Errorf("reading %d: got %v, want %v\n", last+1, err, ErrOutOfBounds)
+		}
+	}
+	//
+	// open log
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	//
+	// write some single entries, then a batch, then some more single entries
+	var i int64
+	for i = 1; i <= 50; i++ {
+		n, err := wal.Write(entry(i, 0))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+		if n != i {
+			t.Fatalf("index: got %d, want %d\n", n, i)
+		}
+	}
+	batch := new(Batch)
+	for ; i <= 100; i++ {
+		batch.Write(entry(i, 0))
+	}
+	err = wal.WriteBatch(batch)
+	if err != nil {
+		t.Fatalf("error writing batch: %v\n", err)
+	}
+	for ; i <= 150; i++ {
+		_, err := wal.Write(entry(i, 0))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+	}
+	segments := len(wal.segments)
+	if segments < 10 {
+		t.Fatalf("expected the log to span a bunch of segments, got %d\n", segments)
+	}
+	check(wal, 150, func(int64) int { return 0 })
+	//
+	// bounds checks
+	err = wal.TruncateBack(151)
+	if err != ErrOutOfBounds {
+		t.Errorf("truncating past the end: got %v, want %v\n", err, ErrOutOfBounds)
+	}
+	err = wal.TruncateBack(150)
+	if err != nil {
+		t.Errorf("truncating at the end: %v\n", err)
+	}
+	//
+	// truncate the single entries at the end, and write a batch in their place
+	err = wal.TruncateBack(120)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if len(wal.segments) >= segments {
+		t.Errorf("expected segments to be removed, still have %d\n", len(wal.segments))
+	}
+	check(wal, 120, func(int64) int { return 0 })
+	batch.Clear()
+	for i = 121; i <= 140; i++ {
+		batch.Write(entry(i, 1))
+	}
+	err = wal.WriteBatch(batch)
+	if err != nil {
+		t.Fatalf("error writing batch: %v\n", err)
+	}
+	gen := func(i int64) int {
+		if i > 120 {
+			return 1
+		}
+		return 0
+	}
+	check(wal, 140, gen)
+	//
+	// truncate back into the middle of the first batch
+	err = wal.TruncateBack(75)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	check(wal, 75, gen)
+	for i = 76; i <= 90; i++ {
+		n, err := wal.Write(entry(i, 2))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+		if n != i {
+			t.Fatalf("index: got %d, want %d\n", n, i)
+		}
+	}
+	gen = func(i int64) int {
+		if i > 75 {
+			return 2
+		}
+		return 0
+	}
+	check(wal, 90, gen)
+	//
+	// close and reopen, everything should still be there
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	wal, err = OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	check(wal, 90, gen)
+	//
+	// truncate all the way back to the first entry
+	err = wal.TruncateBack(1)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	check(wal, 1, gen)
+	_, err = wal.Write(entry(2, 3))
+	if err != nil {
+		t.Fatalf("error writing: %v\n", err)
+	}
+	check(wal, 2, func(i int64) int { return int(i-1) * 3 })
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+}
+
+func TestLog_GroupCommit(t *testing.T) {
+	for _, delay := range []time.Duration{0, time.Millisecond} {
+		tconf := &WALConfig{
+			BasePath:         "wal-testing-group",
+			MaxFileSize:      4 << 10,
+			SyncOnWrite:      true,
+			GroupCommit:      true,
+			GroupCommitDelay: delay,
+			GroupCommitBytes: 1 << 10,
+		}
+		//
+		// open log
+		wal, err := OpenWAL(tconf)
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		//
+		// do some writing from a bunch of writers at once
+		const writers, count = 8, 50
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		written := make(map[int64]string)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < count; i++ {
+					data := fmt.Sprintf("writer-%02d-entry-%04d-%s", w, i, smVal)
+					n, err := wal.Write([]byte(data))
+					if err != nil {
+						t.Errorf("error writing: %v\n", err)
+						return
+					}
+					mu.Lock()
+					if _, found := written[n]; found {
+						t.Errorf("index %d was handed out twice\n", n)
+					}
+					written[n] = data
+					mu.Unlock()
+				}
+			}(w)
+		}
+		wg.Wait()
+		//
+		// every writer should have gotten its own index
+		if len(written) != writers*count {
+			t.Fatalf("written: got %d, want %d\n", len(written), writers*count)
+		}
+		if got := wal.LastIndex(); got != writers*count {
+			t.Errorf("last index: got %d, want %d\n", got, writers*count)
+		}
+		//
+		// close and reopen, and make sure every entry is where it should be
+		err = wal.Close()
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		wal, err = OpenWAL(tconf)
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		for n, data := range written {
+			e, err := wal.Read(n)
+			if err != nil {
+				t.Fatalf("reading %d: %v\n", n, err)
+			}
+			if string(e) != data {
+				t.Fatalf("reading %d: got %q, want %q\n", n, e[:24], data[:24])
+			}
+		}
+		err = wal.CloseAndRemove()
+		if err != nil {
+			t.Fatalf("close and remove: %v\n", err)
+		}
+	}
+}
+
+func TestLog_GroupCommitError(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:         "wal-testing-group-error",
+		MaxFileSize:      -1,
+		SyncOnWrite:      true,
+		GroupCommit:      true,
+		GroupCommitBytes: 1 << 10,
+	}
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	defer wal.CloseAndRemove()
+	//
+	// the second write in the group fails, so the first one should
+	// still be written (and synced), and the rest should not
+	group := []*groupWriter{
+		{data: []byte("first")},
+		{data: nil},
+		{data: []byte("third")},
+	}
+	wal.commitGroup(group)
+	if group[0].err != nil || group[0].index != 1 {
+		t.Errorf("first: got index %d and error %v, want index 1\n", group[0].index, group[0].err)
+	}
+	for _, w := range group[1:] {
+		if w.err != ErrBadEntry || w.index != -1 {
+			t.Errorf("got index %d and error %v, want %v\n", w.index, w.err, ErrBadEntry)
+		}
+	}
+	e, err := wal.Read(1)
+	if err != nil || string(e) != "first" {
+		t.Errorf("reading 1: got %q and %v\n", e, err)
+	}
+	if got := wal.LastIndex(); got != 1 {
+		t.Errorf("last index: got %d, want 1\n", got)
+	}
+}
+
+func TestLog_Follow(t *testing.T) {
+	tconf := &WALConfig{
+		BasePath:    "wal-testing-follow",
+		MaxFileSize: 1 << 10,
+	}
+	defer os.RemoveAll(tconf.BasePath)
+	entry := func(i int64) []byte {
+		return []byte(fmt.Sprintf("entry-%06d-%s", i, smVal))
+	}
+	//
+	// open log and do some writing
+	wal, err := OpenWAL(tconf)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	var i int64
+	for i = 1; i <= 10; i++ {
+		_, err := wal.Write(entry(i))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+	}
+	//
+	// next reads the next entry, and checks it is the one we want
+	next := func(r *Reader, want int64) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		n, e, err := r.Next(ctx)
+		if err != nil {
+			t.Fatalf("following %d: %v\n", want, err)
+		}
+		if n != want || !bytes.Equal(e, entry(want)) {
+			t.Fatalf("following: got %d (%q), want %d\n", n, e[:12], want)
+		}
+	}
+	_, err = wal.Follow(12)
+	if err != ErrOutOfBounds {
+		t.Errorf("following past the end: got %v, want %v\n", err, ErrOutOfBounds)
+	}
+	r, err := wal.Follow(1)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	for i = 1; i <= 10; i++ {
+		next(r, i)
+	}
+	//
+	// follow entries as they are written, cycling through a bunch of segments
+	segments := len(wal.segments)
+	go func() {
+		for i := int64(11); i <= 50; i++ {
+			time.Sleep(time.Millisecond)
+			_, err := wal.Write(entry(i))
+			if err != nil {
+				t.Errorf("error writing: %v\n", err)
+				return
+			}
+		}
+	}()
+	for i = 11; i <= 50; i++ {
+		next(r, i)
+	}
+	if len(wal.segments) <= segments {
+		t.Errorf("expected the segments to be cycled, still have %d\n", len(wal.segments))
+	}
+	//
+	// waiting for an entry that never shows up
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, _, err = r.Next(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("waiting: got %v, want %v\n", err, context.DeadlineExceeded)
+	}
+	//
+	// truncate the front out from under a couple of readers
+	behind, err := wal.Follow(10)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = wal.TruncateFront(30)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	_, _, err = behind.Next(context.Background())
+	if err != ErrTruncated {
+		t.Errorf("following truncated entry: got %v, want %v\n", err, ErrTruncated)
+	}
+	_, err = wal.Write(entry(51))
+	if err != nil {
+		t.Fatalf("error writing: %v\n", err)
+	}
+	next(r, 51)
+	//
+	// truncate the back out from under the reader, and write the entries
+	// again, which should not go unnoticed either
+	ahead, err := wal.Follow(46)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	err = wal.TruncateBack(45)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	for i = 46; i <= 55; i++ {
+		_, err = wal.Write(entry(i))
+		if err != nil {
+			t.Fatalf("error writing: %v\n", err)
+		}
+	}
+	_, _, err = r.Next(context.Background())
+	if err != ErrTruncated {
+		t.Errorf("following truncated entry: got %v, want %v\n", err, ErrTruncated)
+	}
+	// a reader that had not gotten to the removed entries yet just
+	// carries on with the new ones
+	next(ahead, 46)
+	//
+	// an entry is not returned until it has been synced
+	r, err = wal.Follow(56)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	wal.lock.Lock()
+	_, err = wal.write(entry(56))
+	wal.lock.Unlock()
+	if err != nil {
+		t.Fatalf("error writing: %v\n", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	_, _, err = r.Next(ctx)
+	cancel()
+	if err != context.DeadlineExceeded {
+		t.Errorf("following unsynced entry: got %v, want %v\n", err, context.DeadlineExceeded)
+	}
+	err = wal.Sync()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	next(r, 56)
+	//
+	// closing the log wakes up a waiting reader
+	r, err = wal.Follow(57)
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	errs := make(chan error)
+	go func() {
+		_, _, err := r.Next(context.Background())
+		errs <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	err = wal.Close()
+	if err != nil {
+		t.Fatalf("got error: %v\n", err)
+	}
+	if err = <-errs; err != ErrFileClosed {
+		t.Errorf("following closed log: got %v, want %v\n", err, ErrFileClosed)
+	}
+}
+
+func TestLog_Compression(t *testing.T) {
+	// a mix of entries that compress well, entries that do not compress
+	// at all, and entries that are too small to bother compressing
+	rnd := rand.New(rand.NewSource(1))
+	var entries [][]byte
+	for i := 0; i < 200; i++ {
+		var e []byte
+		switch i % 3 {
+		case 0:
+			e = []byte(fmt.Sprintf(`{"id":%d,"name":"user-%d","email":"user-%d@example.com","tags":["a","b","c"],"bio":%q}`, i, i, i, smVal))
+		case 1:
+			e = make([]byte, 256)
+			rnd.Read(e)
+		case 2:
+			e = []byte(fmt.Sprintf("small-%d", i))
+		}
+		entries = append(entries, e)
+	}
+	// write writes all the entries to a log using the provided compression,
+	// checks they all read back the same, and returns the size of the log
+	write := func(c Compression) int64 {
+		tconf := &WALConfig{
+			BasePath:    "wal-testing-compress",
+			MaxFileSize: 4 << 10,
+			Compression: c,
+		}
+		defer os.RemoveAll(tconf.BasePath)
+		wal, err := OpenWAL(tconf)
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		for _, e := range entries {
+			_, err := wal.Write(e)
+			if err != nil {
+				t.Fatalf("error writing: %v\n", err)
+			}
+		}
+		// close and reopen, to make sure the log can be indexed again
+		err = wal.Close()
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		wal, err = OpenWAL(tconf)
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		for i, e := range entries {
+			got, err := wal.Read(int64(i + 1))
+			if err != nil {
+				t.Fatalf("reading %d: %v\n", i+1, err)
+			}
+			if !bytes.Equal(got, e) {
+				t.Fatalf("reading %d: got %q, want %q\n", i+1, got, e)
+			}
+		}
+		var i int
+		err = wal.Scan(
+			func(e []byte) bool {
+				if !bytes.Equal(e, entries[i]) {
+					t.Fatalf("scanning %d: got %q, want %q\n", i, e, entries[i])
+				}
+				i++
+				return true
+			},
+		)
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		if i != len(entries) {
+			t.Errorf("scanned: got %d, want %d\n", i, len(entries))
+		}
+		// incompressible entries should be passed through unchanged
+		var size int64
+		for _, seg := range wal.segments {
+			b, err := os.ReadFile(seg.path)
+			if err != nil {
+				t.Fatalf("got error: %v\n", err)
+			}
+			size += int64(len(b))
+			for _, ent := range seg.entries {
+				if ent.index%3 == 2 && Compression(b[ent.offset+6]) != NoCompression {
+					t.Errorf("entry %d should not have been compressed\n", ent.index)
+				}
+				if ent.index%3 == 1 && Compression(b[ent.offset+6]) != c {
+					t.Errorf("entry %d should have been compressed\n", ent.index)
+				}
+			}
+		}
+		err = wal.Close()
+		if err != nil {
+			t.Fatalf("got error: %v\n", err)
+		}
+		return size
+	}
+	raw := write(NoCompression)
+	compressed := write(FlateCompression)
+	if compressed >= raw {
+		t.Errorf("expected compression to save space, went from %d to %d\n", raw, compressed)
+	}
+}
+
 var smVal = `Praesent efficitur, ante eget eleifend scelerisque, neque erat malesuada neque, vel euismod 
 dui leo a nisl. Donec a eleifend dui. Maecenas necleo odio. In maximus convallis ligula eget sodales.`
 